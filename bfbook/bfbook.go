@@ -5,13 +5,24 @@ package main
 import (
 	"bitfx/bitfinex"
 	"bitfx/exchange"
+	"bitfx/terminal"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"time"
 )
 
-var bf = bitfinex.New("", "", "ltc", "usd", 0, 0, 0, 0)
+var (
+	bf         *bitfinex.Client
+	book       exchange.Book // Last good book, trimmed for display
+	status     = "waiting"   // Feed status shown in the header
+	lastUpdate time.Time     // Arrival time of the last good book
+)
+
+const (
+	displayDepth = 10               // Levels shown on each side
+	staleAfter   = 10 * time.Second // Time without a book before the feed shows as stale
+)
 
 func main() {
 	filename := "bfbook.log"
@@ -21,20 +32,38 @@ func main() {
 	}
 	log.SetOutput(logFile)
 	log.Println("Starting new run")
+	if bf, err = bitfinex.New("", "", "ltc", "usd", 0, 0, 0, 0); err != nil {
+		log.Fatal(err)
+	}
 
 	bookChan := make(chan exchange.Book)
-	if book := bf.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book := bf.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		log.Fatal(book.Error)
 	}
 	inputChan := make(chan rune)
 	go checkStdin(inputChan)
+	// Refresh the update age while the feed is quiet
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
 Loop:
 	for {
 		select {
-		case book := <-bookChan:
-			printBook(book)
+		case newBook := <-bookChan:
+			if newBook.Error != nil {
+				log.Println(newBook.Error)
+				status = fmt.Sprintf("error: %s", newBook.Error)
+			} else {
+				book = newBook.TrimDepth(displayDepth)
+				status = "connected"
+				lastUpdate = time.Now()
+			}
+			printBook()
+		case <-ticker.C:
+			printBook()
 		case <-inputChan:
+			doneChan <- true
 			bf.Done()
 			break Loop
 		}
@@ -49,29 +78,33 @@ func checkStdin(inputChan chan<- rune) {
 	inputChan <- ch
 }
 
-// Print book data from each exchange
-func printBook(book exchange.Book) {
-	clearScreen()
-	if book.Error != nil {
-		log.Println(book.Error)
-	} else {
-		fmt.Println("----------------------------")
-		fmt.Printf("%-10s%-10s%8s\n", " Bid", "  Ask", "Size ")
-		fmt.Println("----------------------------")
-		for i := range book.Asks {
-			item := book.Asks[len(book.Asks)-1-i]
-			fmt.Printf("%-10s%-10.4f%8.2f\n", "", item.Price, item.Amount)
-		}
-		for _, item := range book.Bids {
-			fmt.Printf("%-10.4f%-10.2s%8.2f\n", item.Price, "", item.Amount)
-		}
-		fmt.Println("----------------------------")
+// Print the feed status header and the last good book
+func printBook() {
+	terminal.Clear()
+	printHeader()
+	fmt.Println("----------------------------")
+	fmt.Printf("%-10s%-10s%8s\n", " Bid", "  Ask", "Size ")
+	fmt.Println("----------------------------")
+	for i := range book.Asks {
+		item := book.Asks[len(book.Asks)-1-i]
+		fmt.Printf("%-10s%-10.4f%8.2f\n", "", item.Price, item.Amount)
+	}
+	for _, item := range book.Bids {
+		fmt.Printf("%-10.4f%-10.2s%8.2f\n", item.Price, "", item.Amount)
 	}
+	fmt.Println("----------------------------")
 }
 
-// Clear the terminal between prints
-func clearScreen() {
-	c := exec.Command("clear")
-	c.Stdout = os.Stdout
-	c.Run()
+// Print connection status, last update age, and the forex rate in use
+// Bitfinex quotes in USD, so no conversion applies
+func printHeader() {
+	age := "no book yet"
+	if !lastUpdate.IsZero() {
+		elapsed := time.Since(lastUpdate)
+		age = fmt.Sprintf("updated %.1fs ago", elapsed.Seconds())
+		if elapsed > staleAfter && status == "connected" {
+			age += ", stale"
+		}
+	}
+	fmt.Printf("%s | %s | %s | USD, no forex\n", bf, status, age)
 }
@@ -0,0 +1,93 @@
+// Periodic spread and depth diagnostics
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Log filtered markets, the best arb, and net position for each symbol, must be called from the trading goroutine
+// Book data is requested from handleData, and nothing more is logged once it has stopped
+func logAnalytics(books bookRequester) {
+	order, groups := groupBySymbol(exchanges)
+	for _, symbol := range order {
+		prefix := "ANALYTICS " + symbolPrefix(symbol)
+		markets := make(map[exchange.Interface]filteredBook)
+		for _, exg := range groups[symbol] {
+			fb, ok := books.book(exg)
+			if !ok {
+				return
+			}
+			age := clock.Now().Sub(fb.time)
+			logger.Infof("ANALYTICS %s Bid: %.4f for %.4f, Ask: %.4f for %.4f, Position: %.4f, Age: %.1fs\n",
+				exchangeKey(exg), fb.bid.adjPrice, fb.bid.amount, fb.ask.adjPrice, fb.ask.amount, exg.Position(), age.Seconds())
+			// Stale data is excluded as in considerTrade
+			if age < time.Minute {
+				markets[exg] = fb
+			}
+		}
+
+		// Report the best tradable arb, or else the best spread below the needed arb
+		if bestBid, bestAsk, exists := findBestArb(markets); exists {
+			logger.Infof("%sBest arb: %.4f, needed %.4f, on %s vs %s\n",
+				prefix, bestBid.adjPrice-bestAsk.adjPrice, calcNeededArb(bestAsk.exg, bestBid.exg), bestAsk.exg, bestBid.exg)
+		} else if bestBid, bestAsk := findBestBid(markets), findBestAsk(markets); bestBid.exg != nil && bestAsk.exg != nil && bestBid.exg != bestAsk.exg {
+			logger.Infof("%sBest spread: %.4f, needed %.4f, on %s vs %s\n",
+				prefix, bestBid.adjPrice-bestAsk.adjPrice, calcNeededArb(bestAsk.exg, bestBid.exg), bestAsk.exg, bestBid.exg)
+		} else {
+			logger.Infof("%sNo spread available\n", prefix)
+		}
+		logger.Infof("%sNet Position: %.4f\n", prefix, netPositions[symbol])
+	}
+}
+
+// Returns a channel ticking at the configured analytics interval, nil if disabled
+func analyticsTicker() (<-chan time.Time, func()) {
+	if cfg.Sec.AnalyticsInterval <= 0 {
+		return nil, func() {}
+	}
+	ticker := clock.NewTicker(time.Duration(cfg.Sec.AnalyticsInterval * float64(time.Second)))
+	return ticker.Chan(), ticker.Stop
+}
+
+// Find the pair of exchanges whose spread comes closest to its needed arb
+// Returns the gap, the spread less the needed arb, and false if no pair can trade
+// Exchanges are left out as in findBestArb, for tripped breakers, position limits, and short books
+func nearestArb(markets map[exchange.Interface]filteredBook) (market, market, float64, bool) {
+	var bestBid, bestAsk market
+	bestGap := math.Inf(-1)
+	for bidExg, bidFB := range markets {
+		if tripped(bidExg) || bidFB.bid.short || ableToSell(bidExg) < minOrder(bidExg) {
+			continue
+		}
+		for askExg, askFB := range markets {
+			if askExg == bidExg || tripped(askExg) || askFB.ask.short || ableToBuy(askExg, askFB.ask) < minOrder(askExg) {
+				continue
+			}
+			gap := bidFB.bid.adjPrice - askFB.ask.adjPrice - calcNeededArb(askExg, bidExg)
+			if gap > bestGap {
+				bestBid, bestAsk, bestGap = bidFB.bid, askFB.ask, gap
+			}
+		}
+	}
+	return bestBid, bestAsk, bestGap, bestBid.exg != nil
+}
+
+// Log the spread closest to an arb in a snapshot without one, unless it's unchanged since last logged
+func (state *tradeState) logNearMiss(symbol string, markets map[exchange.Interface]filteredBook) {
+	bestBid, bestAsk, gap, exists := nearestArb(markets)
+	if !exists {
+		return
+	}
+	message := fmt.Sprintf("%sNear miss: spread %.4f, needed %.4f, short by %.4f on %s vs %s",
+		symbolPrefix(symbol), bestBid.adjPrice-bestAsk.adjPrice, calcNeededArb(bestAsk.exg, bestBid.exg), -gap, bestAsk.exg, bestBid.exg)
+	if message == state.lastNearMiss {
+		return
+	}
+	state.lastNearMiss = message
+	logger.Infof("%s\n", message)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogAnalytics(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 100, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 100, 10000, nil)
+	testExchanges(t, exg1, exg2)
+	for _, exg := range exchanges {
+		exg.SetMaxPos(100)
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Answer book requests as handleData would, with Test1 bidding bid over Test2's ask of 2
+	var bid float64
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	go func() {
+		for exg := range requestBook {
+			fb := filteredBook{
+				bid:  market{exg: exg, orderPrice: 1.9, adjPrice: 1.9, amount: 50},
+				ask:  market{exg: exg, orderPrice: 2.1, adjPrice: 2.1, amount: 50},
+				time: time.Now(),
+			}
+			if exg == exg1 {
+				fb.bid = market{exg: exg, orderPrice: bid, adjPrice: bid, amount: 50}
+			} else {
+				fb.ask = market{exg: exg, orderPrice: 2, adjPrice: 2, amount: 50}
+			}
+			receiveBook <- fb
+		}
+	}()
+	defer close(requestBook)
+	stopped := make(chan bool)
+	books := bookRequester{requestBook, receiveBook, stopped}
+
+	bid = 2.05
+	logAnalytics(books)
+	if !strings.Contains(buf.String(), "Best arb: 0.0500") {
+		t.Fatalf("Expected best arb in log:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "ANALYTICS SimTest1(usd) Bid: 2.0500 for 50.0000") || !strings.Contains(buf.String(), "Net Position") {
+		t.Fatalf("Expected markets and net position in log:\n%s", buf.String())
+	}
+
+	// Spreads below the needed arb are still reported
+	buf.Reset()
+	bid = 2.001
+	logAnalytics(books)
+	if !strings.Contains(buf.String(), "Best spread: 0.0010, needed 0.0050") {
+		t.Fatalf("Expected best spread in log:\n%s", buf.String())
+	}
+
+	// Nothing is logged once handleData has stopped
+	buf.Reset()
+	close(stopped)
+	logAnalytics(bookRequester{make(chan exchange.Interface), receiveBook, stopped})
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no analytics after handleData stopped:\n%s", buf.String())
+	}
+}
+
+func TestLogNearMiss(t *testing.T) {
+	defer func(e []exchange.Interface, m float64, l bool) {
+		exchanges, cfg.Sec.MinNetPos, cfg.Sec.LogNearMiss = e, m, l
+	}(exchanges, cfg.Sec.MinNetPos, cfg.Sec.LogNearMiss)
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 100, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 100, 10000, nil)
+	for _, exg := range []*sim.Client{exg1, exg2} {
+		exg.SetMaxPos(100)
+	}
+	exchanges = []exchange.Interface{exg1, exg2}
+	cfg.Sec.MinNetPos = .1
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Test1 bids bid against Test2's ask of 2, with the needed arb at .005
+	snapshot := func(bid float64) map[exchange.Interface]filteredBook {
+		return map[exchange.Interface]filteredBook{
+			exg1: {bid: market{exg: exg1, orderPrice: bid, adjPrice: bid, amount: 50}, ask: market{exg: exg1, orderPrice: 2.1, adjPrice: 2.1, amount: 50}, time: time.Now()},
+			exg2: {bid: market{exg: exg2, orderPrice: 1.9, adjPrice: 1.9, amount: 50}, ask: market{exg: exg2, orderPrice: 2, adjPrice: 2, amount: 50}, time: time.Now()},
+		}
+	}
+	state := &tradeState{}
+	cfg.Sec.LogNearMiss = false
+	state.trade("", snapshot(2.001))
+	if strings.Contains(buf.String(), "Near miss") {
+		t.Fatalf("Near miss logged with LogNearMiss unset:\n%s", buf.String())
+	}
+
+	cfg.Sec.LogNearMiss = true
+	state.trade("", snapshot(2.001))
+	if !strings.Contains(buf.String(), "Near miss: spread 0.0010, needed 0.0050, short by 0.0040 on SimTest2(usd) vs SimTest1(usd)") {
+		t.Fatalf("Expected near miss in log:\n%s", buf.String())
+	}
+
+	// An unchanged near miss is logged once, a changed one again
+	state.trade("", snapshot(2.001))
+	if n := strings.Count(buf.String(), "Near miss"); n != 1 {
+		t.Fatalf("Unchanged near miss logged %d times", n)
+	}
+	state.trade("", snapshot(2.002))
+	if !strings.Contains(buf.String(), "short by 0.0030") {
+		t.Fatalf("Expected changed near miss in log:\n%s", buf.String())
+	}
+}
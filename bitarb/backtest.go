@@ -0,0 +1,400 @@
+// Backtesting against recorded book and FX data
+// Runs the live trading code on simulated exchanges, stepping through recorded time
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/forex"
+	"bitfx/sim"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags for backtest mode
+var (
+	backtestDir    = flag.String("backtest", "", "Directory of recorded books and fx.csv to backtest instead of trading")
+	sweepMaxArb    = flag.String("maxArb", "", "Comma separated MaxArb values to backtest, config value if empty")
+	sweepMinArb    = flag.String("minArb", "", "Comma separated MinArb values to backtest, config value if empty")
+	sweepFXPremium = flag.String("fxPremium", "", "Comma separated FXPremium values to backtest, config value if empty")
+)
+
+// Recorded FX quote
+type recordedQuote struct {
+	time  time.Time
+	quote forex.Quote
+}
+
+// Parameters varied between backtest runs
+type backtestParams struct {
+	maxArb, minArb, fxPremium float64
+}
+
+// P&L after a book update
+type plPoint struct {
+	time            time.Time
+	pl, netPosition float64
+}
+
+// Outcome of a backtest run
+type backtestResult struct {
+	params   backtestParams
+	trades   []Trade
+	curve    []plPoint
+	drawdown float64 // Largest fall from a P&L peak
+}
+
+// In-memory trade record for backtests
+type tradeList []Trade
+
+// Append adds a trade to the list
+func (list *tradeList) Append(t Trade) error {
+	*list = append(*list, t)
+	return nil
+}
+
+// Close implements tradeRecorder
+func (list *tradeList) Close() error {
+	return nil
+}
+
+// Run backtests over recorded data in dir for every combination of swept parameters
+// Only the first configured symbol is backtested
+// Writes each run's trades and P&L curve to CSV files and prints a summary
+func runBacktests(dir string) {
+	symbols := parseSymbols(cfg.Sec.Symbol)
+	if len(symbols) == 0 {
+		log.Fatal("No symbol configured")
+	}
+	venues := simVenues()
+	books, quotes, err := readBacktestData(dir, symbols[0], venues)
+	if err != nil {
+		log.Fatal(err)
+	}
+	maxArbs, err := parseSweep(*sweepMaxArb, cfg.Sec.MaxArb)
+	if err != nil {
+		log.Fatal(err)
+	}
+	minArbs, err := parseSweep(*sweepMinArb, cfg.Sec.MinArb)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fxPremiums, err := parseSweep(*sweepFXPremium, cfg.Sec.FXPremium)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%4s %8s %8s %10s %7s %10s %10s\n", "Run", "MaxArb", "MinArb", "FXPremium", "Trades", "P&L", "Drawdown")
+	run := 0
+	for _, maxArb := range maxArbs {
+		for _, minArb := range minArbs {
+			for _, fxPremium := range fxPremiums {
+				run++
+				result := runBacktest(backtestParams{maxArb, minArb, fxPremium}, symbols[0], venues, books, quotes)
+				isError(writeBacktest(fmt.Sprintf("backtest-%d", run), result))
+				var finalPL float64
+				if len(result.curve) > 0 {
+					finalPL = result.curve[len(result.curve)-1].pl
+				}
+				fmt.Printf("%4d %8.4f %8.4f %10.4f %7d %10.2f %10.2f\n", run, maxArb, minArb, fxPremium, len(result.trades), finalPL, result.drawdown)
+			}
+		}
+	}
+}
+
+// Run a backtest over recorded data with the supplied parameters
+// Each run starts flat with fresh simulated exchanges and trades through the live code paths
+func runBacktest(params backtestParams, symbol string, venues []simVenue, books [][]exchange.Book, quotes []recordedQuote) backtestResult {
+	// Global state is restored after the run
+	savedCfg, savedExchanges, savedSymbols, savedTrades, savedClock := cfg, exchanges, symbols, trades, clock
+	savedNet, savedPL, savedSymbolPL, savedExchangePL, savedLastTrade := netPositions, pl, symbolPL, exchangePL, lastTrade
+	savedMarks := markPrices
+	defer func() {
+		cfg, exchanges, symbols, trades, clock = savedCfg, savedExchanges, savedSymbols, savedTrades, savedClock
+		netPositions, pl, symbolPL, exchangePL, lastTrade = savedNet, savedPL, savedSymbolPL, savedExchangePL, savedLastTrade
+		markPrices = savedMarks
+	}()
+	cfg.Sec.MaxArb, cfg.Sec.MinArb, cfg.Sec.FXPremium = params.maxArb, params.minArb, params.fxPremium
+	// Simulated orders fill immediately, so there is nothing to wait for
+	cfg.Sec.DryRun, cfg.Sec.PrintOn, cfg.Sec.StatusPollDelay, cfg.Sec.MakerWait, cfg.Sec.TimeInForce = false, false, 0, 0, 0
+
+	var now time.Time
+	simClock := exchange.NewManualClock(now)
+	clock = simClock
+
+	clients := make([]*sim.Client, len(venues))
+	exchanges, symbols = nil, nil
+	for i, venue := range venues {
+		clients[i] = venue.newClient(symbol, books[i])
+		exchanges = append(exchanges, clients[i])
+	}
+	netPositions, pl, symbolPL, exchangePL = nil, 0, make(map[string]float64), make(map[string]venuePL)
+	markPrices = make(map[exchange.Interface]float64)
+	var list tradeList
+	trades = &list
+
+	result := backtestResult{params: params}
+	var (
+		state     tradeState
+		peak      float64
+		nextQuote int
+	)
+	markets := make(map[exchange.Interface]filteredBook)
+	latest := make(map[string]recordedQuote)
+	next := make([]int, len(venues)) // Index of each venue's next book
+	for {
+		// Step to the earliest unused book
+		first := -1
+		for i := range venues {
+			if next[i] < len(books[i]) && (first < 0 || books[i][next[i]].Time.Before(books[first][next[first]].Time)) {
+				first = i
+			}
+		}
+		if first < 0 {
+			break
+		}
+		now = simClock.Set(books[first][next[first]].Time)
+		for nextQuote < len(quotes) && !quotes[nextQuote].time.After(now) {
+			latest[quotes[nextQuote].quote.Symbol] = quotes[nextQuote]
+			nextQuote++
+		}
+
+		// Filter every book at this time as handleData would
+		for i, client := range clients {
+			if next[i] >= len(books[i]) || books[i][next[i]].Time.After(now) {
+				continue
+			}
+			// Keep the simulated exchange filling against the same book
+			if next[i] > 0 {
+				client.Advance()
+			}
+			book := books[i][next[i]]
+			book.Exg = client
+			next[i]++
+			if isError(book.Validate()) {
+				continue
+			}
+			markets[client] = fxFilterBook(book, quoteAt(latest, client.Currency(), now))
+		}
+
+		// Trade on the snapshot as considerTrade would
+		snapshot := make(map[exchange.Interface]filteredBook)
+		for _, exg := range exchanges {
+			if fb, ok := markets[exg]; ok {
+				addToSnapshot(snapshot, exg, fb)
+			}
+		}
+		// The clients aren't added with a symbol, so their net position has the empty key
+		state.trade("", snapshot)
+
+		var net float64
+		for _, client := range clients {
+			net += client.Position()
+		}
+		result.curve = append(result.curve, plPoint{now, pl, net})
+		peak = math.Max(peak, pl)
+		result.drawdown = math.Max(result.drawdown, peak-pl)
+	}
+	result.trades = list
+
+	return result
+}
+
+// Read recorded data for symbol in dir, from recording files if any exist or else from book CSV files and fx.csv
+func readBacktestData(dir, symbol string, venues []simVenue) ([][]exchange.Book, []recordedQuote, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "record-*.jsonl"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) > 0 {
+		return readRecordings(files, symbol, venues)
+	}
+
+	books, err := readVenueBooks(dir, venues)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, "fx.csv"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	quotes, err := readQuotes(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return books, quotes, nil
+}
+
+// Read books of symbol for each venue and FX quotes from recording files in date order
+// Books are matched to venues by exchange name, books from other exchanges or symbols are skipped
+func readRecordings(files []string, symbol string, venues []simVenue) ([][]exchange.Book, []recordedQuote, error) {
+	books := make([][]exchange.Book, len(venues))
+	var quotes []recordedQuote
+	for _, filename := range files {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoder := json.NewDecoder(file)
+		for {
+			var rc record
+			if err := decoder.Decode(&rc); err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return nil, nil, fmt.Errorf("%s: %s", filename, err)
+			}
+			switch rc.Type {
+			case "book":
+				if rc.Symbol != symbol {
+					continue
+				}
+				for i, venue := range venues {
+					if rc.Exchange == venue.label() {
+						books[i] = append(books[i], rc.book())
+					}
+				}
+			case "fx":
+				quotes = append(quotes, recordedQuote{
+					time:  rc.Received.Add(-time.Duration(rc.Age * float64(time.Second))),
+					quote: forex.Quote{Price: rc.Price, Symbol: rc.Symbol},
+				})
+			}
+		}
+		file.Close()
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].time.Before(quotes[j].time) })
+
+	return books, quotes, nil
+}
+
+// Name of the live exchange a simulated venue stands in for
+func (venue simVenue) label() string {
+	return fmt.Sprintf("%s(%s)", venue.name, venue.currency)
+}
+
+// Convert a recorded book to an exchange.Book dated when it was received
+func (rc record) book() exchange.Book {
+	book := exchange.Book{Time: rc.Received}
+	for _, bid := range rc.Bids {
+		book.Bids = append(book.Bids, exchange.BidItems{{Price: bid[0], Amount: bid[1]}}...)
+	}
+	for _, ask := range rc.Asks {
+		book.Asks = append(book.Asks, exchange.AskItems{{Price: ask[0], Amount: ask[1]}}...)
+	}
+	return book
+}
+
+// Quote for a currency as of now, as handleFX would send it
+func quoteAt(latest map[string]recordedQuote, symbol string, now time.Time) forex.Quote {
+	if symbol == exchange.USD.Symbol() {
+		return forex.Quote{Price: 1, Symbol: symbol}
+	}
+	recorded, ok := latest[symbol]
+	if !ok {
+		return forex.Quote{Symbol: symbol, Error: fmt.Errorf("No %s quote available", symbol)}
+	}
+	quote := recorded.quote
+	quote.Age = now.Sub(recorded.time)
+	return quote
+}
+
+// Read FX quotes from CSV data, sorted by time
+// Each row is: unix time, symbol, price
+func readQuotes(r io.Reader) ([]recordedQuote, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var quotes []recordedQuote
+	for i, record := range records {
+		unixTime, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		price, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		quotes = append(quotes, recordedQuote{
+			time:  time.Unix(unixTime, 0),
+			quote: forex.Quote{Price: price, Symbol: strings.ToLower(record[1])},
+		})
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].time.Before(quotes[j].time) })
+
+	return quotes, nil
+}
+
+// Parse comma separated values to sweep, returning def if empty
+func parseSweep(values string, def float64) ([]float64, error) {
+	if values == "" {
+		return []float64{def}, nil
+	}
+	var sweep []float64
+	for _, value := range strings.Split(values, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, err
+		}
+		sweep = append(sweep, f)
+	}
+	return sweep, nil
+}
+
+// Write a run's trades and P&L curve to CSV files named from prefix
+func writeBacktest(prefix string, result backtestResult) error {
+	// Ledgers append, so start from an empty file
+	tradesFile := prefix + "-trades.csv"
+	if err := os.Remove(tradesFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := openLedger(tradesFile)
+	if err != nil {
+		return err
+	}
+	for _, t := range result.trades {
+		if err := l.Append(t); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	if err := l.Close(); err != nil {
+		return err
+	}
+
+	file, err := os.Create(prefix + "-pl.csv")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"timestamp", "pl", "net_position"})
+	for _, point := range result.curve {
+		writer.Write([]string{
+			point.time.UTC().Format(time.RFC3339),
+			fmt.Sprintf("%f", point.pl),
+			fmt.Sprintf("%f", point.netPosition),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a one level book at unix time sec
+func levelBook(sec int64, bid, ask float64) exchange.Book {
+	return exchange.Book{
+		Time: time.Unix(sec, 0),
+		Bids: exchange.BidItems{{Price: bid, Amount: 50}},
+		Asks: exchange.AskItems{{Price: ask, Amount: 50}},
+	}
+}
+
+func TestRunBacktest(t *testing.T) {
+	venues := []simVenue{
+		{name: "A", currency: "usd", availShort: 100, availFunds: 100000},
+		{name: "B", currency: "usd", availShort: 100, availFunds: 100000},
+		{name: "C", currency: "cny", availShort: 100, availFunds: 700000},
+	}
+	// A's bid jumps over B's ask at 1001, C is flat in CNY
+	books := [][]exchange.Book{
+		{levelBook(1000, 9.9, 10.1), levelBook(1001, 10.5, 10.6), levelBook(1002, 9.9, 10.1)},
+		{levelBook(1000, 9.9, 10), levelBook(1003, 9.9, 10)},
+		{levelBook(1000, 69.3, 70.7), levelBook(1003, 69.3, 70.7)},
+	}
+	quotes, err := readQuotes(strings.NewReader("999,CNY,7\n"))
+	cfg.Sec.MinNetPos = .1
+	defer func() { cfg.Sec.MinNetPos = 0 }()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runBacktest(backtestParams{.02, -.01, .01}, "btc", venues, books, quotes)
+	if len(result.curve) != 4 {
+		t.Fatalf("Expected a P&L point per book time, got %d", len(result.curve))
+	}
+	if len(result.trades) != 2 {
+		t.Fatalf("Expected 2 trades, got %d", len(result.trades))
+	}
+	for _, trade := range result.trades {
+		if !trade.Time.Equal(time.Unix(1001, 0)) || trade.Filled != 50 {
+			t.Fatalf("Expected fills of 50 at recorded time, got %v", trade)
+		}
+	}
+	if finalPL := result.curve[3].pl; math.Abs(finalPL-25) > .000001 {
+		t.Errorf("Expected P&L of 25, got %v", finalPL)
+	}
+	// Global state is left as it was
+	if pl != 0 || netPositions != nil || exchanges != nil {
+		t.Error("Backtest should restore global state")
+	}
+
+	// A wider range finds nothing to trade
+	result = runBacktest(backtestParams{2, 0, .01}, "btc", venues, books, quotes)
+	if len(result.trades) != 0 || result.curve[3].pl != 0 {
+		t.Errorf("Expected no trades, got %d", len(result.trades))
+	}
+}
+
+func TestParseSweep(t *testing.T) {
+	if sweep, err := parseSweep("", 1.5); err != nil || len(sweep) != 1 || sweep[0] != 1.5 {
+		t.Fatal("Expected default value for empty sweep")
+	}
+	if sweep, err := parseSweep("1, 2,-.5", 0); err != nil || len(sweep) != 3 || sweep[2] != -.5 {
+		t.Fatal("Expected 3 values")
+	}
+	if _, err := parseSweep("1,x", 0); err == nil {
+		t.Fatal("Expected error")
+	}
+}
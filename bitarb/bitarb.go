@@ -7,15 +7,24 @@ import (
 	"bitfx/btcchina"
 	"bitfx/exchange"
 	"bitfx/forex"
+	"bitfx/gdax"
+	"bitfx/kraken"
+	"bitfx/logger"
+	"bitfx/notify"
 	"bitfx/okcoin"
-	"encoding/csv"
+	"bitfx/sim"
+	"bitfx/terminal"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
-	"os/exec"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"code.google.com/p/gcfg"
@@ -24,53 +33,152 @@ import (
 // Config stores user configuration
 type Config struct {
 	Sec struct {
-		Symbol             string  // Symbol to trade
+		Symbol             string  // Symbol to trade, or comma separated symbols to trade together
+		TickersBitfinex    string  // Bitfinex tickers for symbols it names differently, as comma separated symbol:ticker pairs
+		TickersOKCoin      string  // OKCoin tickers for symbols it names differently, as comma separated symbol:ticker pairs
+		TickersBTC         string  // BTCChina tickers for symbols it names differently, as comma separated symbol:ticker pairs
+		TickersKraken      string  // Kraken tickers for symbols it names differently, as comma separated symbol:ticker pairs
+		TickersGDAX        string  // GDAX tickers for symbols it names differently, as comma separated symbol:ticker pairs
 		MaxArb             float64 // Top limit for position entry
 		MinArb             float64 // Bottom limit for position exit
 		FXPremium          float64 // Amount added to arb for taking FX risk
+		CurrencyBitfinex   string  // Fiat currency traded on Bitfinex: usd, eur, gbp, or jpy, usd if empty
 		AvailShortBitfinex float64 // Max short position size
 		AvailFundsBitfinex float64 // Fiat available for trading
+		MarginBitfinex     bool    // Trade Bitfinex on margin, so it can go net short up to AvailShortBitfinex
+		SpotBitfinex       bool    // Send Bitfinex orders as exchange wallet orders rather than margin orders, when not on margin
 		AvailShortOKusd    float64 // Max short position size
 		AvailFundsOKusd    float64 // Fiat available for trading
 		AvailShortOKcny    float64 // Max short position size
 		AvailFundsOKcny    float64 // Fiat available for trading
+		BorrowOKusd        float64 // Max cryptocurrency borrowed on OKCoin USD to sell short beyond AvailShortOKusd, zero to disable
+		BorrowOKcny        float64 // Max cryptocurrency borrowed on OKCoin CNY to sell short beyond AvailShortOKcny, zero to disable
+		BorrowRate         float64 // Daily interest rate offered for OKCoin loans
 		AvailShortBTC      float64 // Max short position size
 		AvailFundsBTC      float64 // Fiat available for trading
+		AvailShortKraken   float64 // Max short position size
+		AvailFundsKraken   float64 // Fiat available for trading
+		AvailShortGDAX     float64 // Max short position size
+		AvailFundsGDAX     float64 // Fiat available for trading
 		MinNetPos          float64 // Min acceptable net position
-		MinOrder           float64 // Min order size for arb trade
+		MinSecondLeg       float64 // Min first leg fill hedged by sending the second leg, zero to always hedge, negative to use MinNetPos
+		HedgeTimeout       float64 // Seconds a net position can exceed MinNetPos before a forced market hedge, zero to disable
+		ExitSlippage       float64 // Fraction past the top price a net position exit sweeps book levels to, zero for one level at a time
+		MinOrder           float64 // Min order size for arb trade, raised to each exchange's minimum
 		MaxOrder           float64 // Max order size for arb trade
+		SampleDepth        float64 // Book amount sampled for liquidity past the order amount, zero to disable
+		BookDepth          int     // Book levels kept from every exchange before filtering, zero to keep all
+		MaxSlices          int     // Max MaxOrder slices traded per arb opportunity, re-checking books between them
+		PairCooldown       float64 // Seconds before the same buy and sell exchanges are traded again, zero to disable
+		SliceDelay         float64 // Seconds between slices before books are re-checked
+		SlippageTicks      int     // Price steps to pad arb order prices by, within the arb's margin over the needed arb
+		PostOnlyMargin     float64 // Arb margin over the needed arb at which the second leg rests post-only, zero to disable
+		JoinMargin         float64 // Arb margin over the needed arb at which the priority leg joins the book post-only, zero to always cross
+		MakerWait          float64 // Seconds a post-only order rests before it is cancelled
+		TimeInForce        float64 // Seconds a limit order rests before it is cancelled, zero to cancel at the first live status
+		OrderTimeout       float64 // Seconds before abandoning an order, zero for no limit
+		OrderRetries       int     // Times to retry sending a failed order
+		MaxOrderRate       float64 // Orders and cancels per second on each exchange, zero for no limit
+		StatusPollDelay    float64 // Seconds between order status checks
+		MaxStatusWait      float64 // Seconds to wait for an order to finish, zero for no limit
+		FXCacheTTL         float64 // Seconds to use the last good FX quote after errors
+		PriorityInterval   float64 // Seconds between priority updates from measured latency and fill rate, zero for static priority
+		MetricsPort        int     // Port for serving /metrics, zero to disable
+		StatusPort         int     // Port for serving /status, zero to disable
+		AnalyticsInterval  float64 // Seconds between spread and depth logs, zero to disable
+		LogNearMiss        bool    // Log the spread closest to the needed arb when there is no arb, each time it changes
+		FeeInterval        float64 // Seconds between fee tier updates from exchange accounts, zero to disable
+		ClockSyncInterval  float64 // Seconds between exchange clock offset checks after the one at startup, zero to disable
+		FeedTimeout        float64 // Seconds without a book before an exchange feed is reported down, zero to disable
+		BreakerErrors      int     // Errors from an exchange within BreakerWindow that disable it for BreakerCooldown, zero to disable
+		BreakerWindow      float64 // Seconds over which exchange errors are counted
+		BreakerCooldown    float64 // Seconds a disabled exchange is left out of trading before it's probed again
+		WebhookURL         string  // URL posted JSON notifications of trades and errors, empty to disable
+		MaxNotional        float64 // Max position value per exchange in USD, zero for coin limits only
+		MaxDrawdown        float64 // Loss at which new arb positions stop, zero for no limit
+		PositionTolerance  float64 // Max difference between loaded and actual positions, zero to skip the check
+		HaltOnMismatch     bool    // Refuse to start if positions differ by more than PositionTolerance
+		DryRun             bool    // Log orders and simulate full fills instead of trading
+		LogLevel           string  // Minimum level written to the log: debug, info, warn, or error
 		PrintOn            bool    // Display results in terminal
+		PlainOutput        bool    // Append results without clearing the screen, as is done when stdout isn't a terminal
+		OutputFormat       string  // Format of displayed results: text, or json for one line per update
 	}
 }
 
 // Used for filtered book data
 type filteredBook struct {
 	bid, ask market
+	book     exchange.Book // Book trimmed to BookDepth, for net position exits sweeping past bid and ask
 	time     time.Time
+	bookTime time.Time // Time of the exchange book, identifying it when time is set by FX
+	maxPos   float64   // Position limit from MaxNotional, zero if not set
 }
 type market struct {
 	exg                          exchange.Interface
 	orderPrice, amount, adjPrice float64
+	topPrice                     float64 // Best price on the book side
+	fxPrice                      float64 // Exchange currency units per USD that adjPrice was converted at
+	availBeyond                  float64 // Book amount past amount within SampleDepth, zero if not sampled
+	short                        bool    // Book side too shallow to reach the minimum order, leaving it without a price or amount
+}
+
+// P&L on one exchange at adjusted prices, in USD and in the exchange's currency
+type venuePL struct {
+	USD    float64 `json:"usd"`
+	Native float64 `json:"native"`
 }
 
 // Global variables
 var (
-	logFile     os.File              // Log printed to file
-	cfg         Config               // Configuration struct
-	exchanges   []exchange.Interface // Slice of exchanges in use
-	currencies  []string             // Slice of forein currencies in use
-	netPosition float64              // Net position accross exchanges
-	pl          float64              // Net P&L for current run
+	logFile    os.File                    // Log printed to file
+	cfg        Config                     // Configuration struct
+	exchanges  []exchange.Interface       // Slice of exchanges in use
+	currencies []string                   // Slice of foreign currencies in use
+	pl         float64                    // Net P&L for current run across symbols
+	exchangePL = make(map[string]venuePL) // P&L by exchange key, summing to pl
+	trades     tradeRecorder              // Record of every trade
+	lastTrade  time.Time                  // Time of the last fill
 )
 
+// Current time and tickers, replaced by a manual clock in tests and backtests
+var clock exchange.Clock = exchange.SystemClock{}
+
 // Set config info
 func setConfig() {
 	configFile := flag.String("config", "bitarb.gcfg", "Configuration file")
 	flag.Parse()
+	// Unset unless in the file, since zero is a valid setting
+	cfg.Sec.MinSecondLeg = -1
 	err := gcfg.ReadFileInto(&cfg, *configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if format := cfg.Sec.OutputFormat; format != "" && format != "text" && format != "json" {
+		log.Fatalf("Unknown output format %q, expected text or json", format)
+	}
+	if err := checkOrderWaits(); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Sec.MarginBitfinex && cfg.Sec.SpotBitfinex {
+		log.Fatal("marginBitfinex and spotBitfinex can't both be set")
+	}
+}
+
+// Check that order deadlines outlast the time orders rest before their cancel
+// Otherwise every resting order would be abandoned at the deadline instead of cancelled
+func checkOrderWaits() error {
+	rest := cfg.Sec.TimeInForce
+	if cfg.Sec.PostOnlyMargin > 0 || cfg.Sec.JoinMargin > 0 {
+		rest = math.Max(rest, cfg.Sec.MakerWait)
+	}
+	if cfg.Sec.MaxStatusWait > 0 && cfg.Sec.MaxStatusWait <= rest {
+		return fmt.Errorf("maxStatusWait of %vs must exceed the %vs orders rest for", cfg.Sec.MaxStatusWait, rest)
+	}
+	if cfg.Sec.OrderTimeout > 0 && cfg.Sec.OrderTimeout <= rest {
+		return fmt.Errorf("orderTimeout of %vs must exceed the %vs orders rest for", cfg.Sec.OrderTimeout, rest)
+	}
+	return nil
 }
 
 // Set file for logging
@@ -80,54 +188,285 @@ func setLog() {
 		log.Fatal(err)
 	}
 	log.SetOutput(logFile)
-	log.Println("Starting new run")
+	level, err := logger.ParseLevel(cfg.Sec.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Default().SetLevel(level)
+	logger.Infof("Starting new run")
+}
+
+// Set file for recording trades
+// Dry run trades are kept separate from real ones
+func setLedger() {
+	name := "trades.csv"
+	if cfg.Sec.DryRun {
+		name = "dryrun.csv"
+		logger.Infof("Dry run: orders will be logged but not sent")
+	}
+	var err error
+	trades, err = openLedger(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Receives trade and error notifications, discarded unless WebhookURL is set
+var notifier notify.Notifier = notify.Discard{}
+
+// Set webhook for notifications if configured
+func setNotifier() {
+	if cfg.Sec.WebhookURL == "" {
+		return
+	}
+	webhook, err := notify.NewWebhook(cfg.Sec.WebhookURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	notifier = webhook
 }
 
-// Initialize exchanges
+// Send queued notifications before exit
+func closeNotifier() {
+	if webhook, ok := notifier.(*notify.Webhook); ok {
+		webhook.Close()
+	}
+}
+
+// Initialize exchanges, one set for each symbol
+// Simulated exchanges replaying book files are used if BITARB_SIM names a directory
 func setExchanges() {
-	exchanges = []exchange.Interface{
-		bitfinex.New(os.Getenv("BITFINEX_KEY"), os.Getenv("BITFINEX_SECRET"), cfg.Sec.Symbol, "usd", 1, 0.001, cfg.Sec.AvailShortBitfinex, cfg.Sec.AvailFundsBitfinex),
-		okcoin.New(os.Getenv("OKUSD_KEY"), os.Getenv("OKUSD_SECRET"), cfg.Sec.Symbol, "usd", 1, 0.002, cfg.Sec.AvailShortOKusd, cfg.Sec.AvailFundsOKusd),
-		okcoin.New(os.Getenv("OKCNY_KEY"), os.Getenv("OKCNY_SECRET"), cfg.Sec.Symbol, "cny", 1, 0.000, cfg.Sec.AvailShortOKcny, cfg.Sec.AvailFundsOKcny),
-		btcchina.New(os.Getenv("BTC_KEY"), os.Getenv("BTC_SECRET"), cfg.Sec.Symbol, "cny", 1, 0.000, cfg.Sec.AvailShortBTC, cfg.Sec.AvailFundsBTC),
+	symbols = parseSymbols(cfg.Sec.Symbol)
+	if len(symbols) == 0 {
+		log.Fatal("No symbol configured")
+	}
+	if dir := os.Getenv("BITARB_SIM"); dir != "" {
+		setSimExchanges(dir)
+		return
+	}
+	for _, symbol := range symbols {
+		exgs, err := newExchanges(symbol)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, exg := range exgs {
+			addExchange(symbol, exg)
+		}
 	}
 	for _, exg := range exchanges {
-		log.Printf("Using exchange %s with priority %d and fee of %.4f", exg, exg.Priority(), exg.Fee())
+		logger.Infof("Using exchange %s with priority %d and fees of %.4f maker, %.4f taker", exchangeKey(exg), exg.Priority(), exg.MakerFee(), exg.TakerFee())
+		if limiter, ok := exg.(exchange.OrderRateLimiter); ok && cfg.Sec.MaxOrderRate > 0 {
+			limiter.SetRateLimit(cfg.Sec.MaxOrderRate)
+		}
 	}
-	currencies = append(currencies, "cny")
+	// Correct signed request nonces for clock skew before the first, checking positions
+	syncClocks(exchanges)
+	currencies = foreignCurrencies(exchanges)
 }
 
-// Set status from previous run if file exists
-func setStatus() {
-	if file, err := os.Open("status.csv"); err == nil {
-		defer file.Close()
-		reader := csv.NewReader(file)
-		status, err := reader.Read()
+// Fiat currency traded on Bitfinex, USD unless configured
+func bitfinexCurrency() string {
+	if cfg.Sec.CurrencyBitfinex == "" {
+		return exchange.USD.Symbol()
+	}
+	return strings.ToLower(cfg.Sec.CurrencyBitfinex)
+}
+
+// Currencies other than USD traded on exchanges, each needing FX quotes
+func foreignCurrencies(exgs []exchange.Interface) []string {
+	var foreign []string
+	seen := map[string]bool{exchange.USD.Symbol(): true}
+	for _, exg := range exgs {
+		if currency := exg.Currency(); !seen[currency] {
+			seen[currency] = true
+			foreign = append(foreign, currency)
+		}
+	}
+	return foreign
+}
+
+// Create the live exchanges for a symbol, each given its own ticker for it
+// Clients already created are closed if a constructor fails
+func newExchanges(symbol string) ([]exchange.Interface, error) {
+	var bitfinexTicker, okcoinTicker, btcTicker, krakenTicker, gdaxTicker string
+	for _, venue := range []struct {
+		pairs  string
+		ticker *string
+	}{
+		{cfg.Sec.TickersBitfinex, &bitfinexTicker},
+		{cfg.Sec.TickersOKCoin, &okcoinTicker},
+		{cfg.Sec.TickersBTC, &btcTicker},
+		{cfg.Sec.TickersKraken, &krakenTicker},
+		{cfg.Sec.TickersGDAX, &gdaxTicker},
+	} {
+		ticker, err := venueTicker(venue.pairs, symbol)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		for i, exg := range exchanges {
-			position, err := strconv.ParseFloat(status[i], 64)
+		*venue.ticker = ticker
+	}
+
+	constructors := []func() (exchange.Interface, error){
+		func() (exchange.Interface, error) {
+			client, err := bitfinex.New(os.Getenv("BITFINEX_KEY"), os.Getenv("BITFINEX_SECRET"), bitfinexTicker, bitfinexCurrency(), 1, 0.001, cfg.Sec.AvailShortBitfinex, cfg.Sec.AvailFundsBitfinex, bitfinex.WithClock(clock))
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
-			exg.SetPosition(position)
+			client.SetMargin(cfg.Sec.MarginBitfinex)
+			client.SetExchangeOrders(cfg.Sec.SpotBitfinex)
+			return client, nil
+		},
+		func() (exchange.Interface, error) {
+			client, err := okcoin.New(os.Getenv("OKUSD_KEY"), os.Getenv("OKUSD_SECRET"), okcoinTicker, "usd", 1, 0.002, cfg.Sec.AvailShortOKusd, cfg.Sec.AvailFundsOKusd, okcoin.WithClock(clock))
+			if err != nil {
+				return nil, err
+			}
+			client.SetBorrowLimit(cfg.Sec.BorrowOKusd, cfg.Sec.BorrowRate)
+			return client, nil
+		},
+		func() (exchange.Interface, error) {
+			client, err := okcoin.New(os.Getenv("OKCNY_KEY"), os.Getenv("OKCNY_SECRET"), okcoinTicker, "cny", 1, 0.000, cfg.Sec.AvailShortOKcny, cfg.Sec.AvailFundsOKcny, okcoin.WithClock(clock))
+			if err != nil {
+				return nil, err
+			}
+			client.SetBorrowLimit(cfg.Sec.BorrowOKcny, cfg.Sec.BorrowRate)
+			return client, nil
+		},
+		func() (exchange.Interface, error) {
+			return btcchina.New(os.Getenv("BTC_KEY"), os.Getenv("BTC_SECRET"), btcTicker, "cny", 1, 0.000, cfg.Sec.AvailShortBTC, cfg.Sec.AvailFundsBTC, btcchina.WithClock(clock))
+		},
+	}
+	// Kraken is only traded when credentials are supplied
+	if key := os.Getenv("KRAKEN_KEY"); key != "" {
+		constructors = append(constructors, func() (exchange.Interface, error) {
+			client, err := kraken.New(key, os.Getenv("KRAKEN_SECRET"), krakenTicker, "usd", 1, 0.0026, cfg.Sec.AvailShortKraken, cfg.Sec.AvailFundsKraken)
+			if err != nil {
+				return nil, err
+			}
+			client.SetClock(clock)
+			return client, nil
+		})
+	}
+	// GDAX is only traded when credentials are supplied
+	if key := os.Getenv("GDAX_KEY"); key != "" {
+		constructors = append(constructors, func() (exchange.Interface, error) {
+			client, err := gdax.New(key, os.Getenv("GDAX_SECRET"), os.Getenv("GDAX_PASSPHRASE"), gdaxTicker, "usd", 1, 0.0025, cfg.Sec.AvailShortGDAX, cfg.Sec.AvailFundsGDAX)
+			if err != nil {
+				return nil, err
+			}
+			client.SetClock(clock)
+			return client, nil
+		})
+	}
+
+	var exgs []exchange.Interface
+	for _, newExchange := range constructors {
+		exg, err := newExchange()
+		if err != nil {
+			for _, exg := range exgs {
+				exg.Done()
+			}
+			return nil, err
 		}
-		pl, err = strconv.ParseFloat(status[len(status)-1], 64)
+		exgs = append(exgs, exg)
+	}
+	return exgs, nil
+}
+
+// Simulated exchange settings and book file
+type simVenue struct {
+	file, name, currency   string
+	fee                    float64
+	availShort, availFunds float64
+}
+
+// Simulated counterparts of the live exchanges
+func simVenues() []simVenue {
+	return []simVenue{
+		{"bitfinex.csv", "Bitfinex", bitfinexCurrency(), 0.001, cfg.Sec.AvailShortBitfinex, cfg.Sec.AvailFundsBitfinex},
+		{"okusd.csv", "OKCoin", "usd", 0.002, cfg.Sec.AvailShortOKusd, cfg.Sec.AvailFundsOKusd},
+		{"okcny.csv", "OKCoin", "cny", 0.000, cfg.Sec.AvailShortOKcny, cfg.Sec.AvailFundsOKcny},
+		{"btc.csv", "BTCChina", "cny", 0.000, cfg.Sec.AvailShortBTC, cfg.Sec.AvailFundsBTC},
+	}
+}
+
+// Read the book file for each venue in dir
+func readVenueBooks(dir string, venues []simVenue) ([][]exchange.Book, error) {
+	var books [][]exchange.Book
+	for _, venue := range venues {
+		file, err := os.Open(filepath.Join(dir, venue.file))
+		if err != nil {
+			return nil, err
+		}
+		venueBooks, err := sim.ReadBooks(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", venue.file, err)
+		}
+		books = append(books, venueBooks)
+	}
+	return books, nil
+}
+
+// Create a simulated exchange for a venue replaying books of symbol on the current clock
+func (venue simVenue) newClient(symbol string, books []exchange.Book) *sim.Client {
+	client := sim.New(venue.name, symbol, venue.currency, 1, venue.fee, venue.availShort, venue.availFunds, books)
+	client.SetClock(clock)
+	return client
+}
+
+// Initialize simulated exchanges from book files in dir
+// With several symbols, each symbol's files are in a subdirectory named for it
+func setSimExchanges(dir string) {
+	venues := simVenues()
+	exchanges = nil
+	for _, symbol := range symbols {
+		symbolDir := dir
+		if len(symbols) > 1 {
+			symbolDir = filepath.Join(dir, symbol)
+		}
+		books, err := readVenueBooks(symbolDir, venues)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("Loaded positions %v\n", status[0:len(status)-1])
-		log.Printf("Loaded P&L %f\n", pl)
+		for i, venue := range venues {
+			addExchange(symbol, venue.newClient(symbol, books[i]))
+		}
+	}
+	for _, exg := range exchanges {
+		logger.Infof("Using simulated exchange %s with priority %d and fees of %.4f maker, %.4f taker", exchangeKey(exg), exg.Priority(), exg.MakerFee(), exg.TakerFee())
+	}
+	currencies = foreignCurrencies(exchanges)
+}
+
+// Set status from previous run if file exists
+// Falls back to the legacy CSV file written by older versions
+func setStatus() {
+	state, err := readState("status.json")
+	if os.IsNotExist(err) {
+		state, err = readLegacyState("status.csv")
+		if os.IsNotExist(err) {
+			return
+		}
+		logger.Infof("Loading legacy status.csv, status.json will be written on exit")
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
+	state.apply()
+	plGauge.Set(pl)
 }
 
-// Calculate total position across exchanges
+// Calculate total position across exchanges for each symbol
 func calcNetPosition() {
-	netPosition = 0
+	netPositions = make(map[string]float64)
 	for _, exg := range exchanges {
-		netPosition += exg.Position()
-		log.Printf("%s Position: %.2f\n", exg, exg.Position())
+		netPositions[symbolOf(exg)] += exg.Position()
+		positionGauge.Set(exchangeKey(exg), exg.Position())
+		logger.Infof("%s Position: %.2f\n", exchangeKey(exg), exg.Position())
+	}
+	for symbol, net := range netPositions {
+		netPositionGauge.Set(symbol, net)
 	}
 }
 
@@ -137,8 +476,23 @@ func main() {
 	// Initialization
 	setConfig()
 	setLog()
+	if *backtestDir != "" {
+		runBacktests(*backtestDir)
+		closeLogFile()
+		return
+	}
+	if *watchMode {
+		runWatch()
+		closeLogFile()
+		return
+	}
+	setLedger()
+	setNotifier()
+	setRecorder()
 	setExchanges()
 	setStatus()
+	checkPositions()
+	setMetrics()
 	calcNetPosition()
 
 	// Terminate on user input
@@ -149,13 +503,43 @@ func main() {
 	requestBook := make(chan exchange.Interface)
 	receiveBook := make(chan filteredBook)
 	newBook := make(chan bool)
-	go handleData(requestBook, receiveBook, newBook, doneChan)
+	booksStopped := make(chan bool)
+	go func() {
+		handleData(requestBook, receiveBook, newBook, doneChan)
+		close(booksStopped)
+	}()
+	books := bookRequester{requestBook, receiveBook, booksStopped}
+
+	// Serve status requests
+	requestStatus := make(chan chan liveStatus)
+	setStatusServer(requestStatus)
+
+	// Keep fees at the current tier
+	stopFees := startFeeUpdates()
+
+	// Warn of exchanges no longer sending books
+	stopFeeds := startFeedMonitor()
+
+	// Keep nonces in step with exchange clocks
+	stopClockSync := startClockSync()
 
 	// Check for opportunities
-	considerTrade(requestBook, receiveBook, newBook)
+	considerTrade(books, newBook, requestStatus)
+	stopClockSync()
+	stopFeeds()
+	stopFees()
 
-	// Finish
-	saveStatus()
+	// Finish, leaving saved positions untouched by a dry run
+	if !cfg.Sec.DryRun {
+		cancelOpenOrders()
+		saveStatus()
+	}
+	for _, exg := range exchanges {
+		exg.Done()
+	}
+	isError(trades.Close())
+	rec.Close()
+	closeNotifier()
 	closeLogFile()
 	fmt.Println("~~~ Fini ~~~")
 }
@@ -171,23 +555,38 @@ func checkStdin(doneChan chan<- bool) {
 func handleData(requestBook <-chan exchange.Interface, receiveBook chan<- filteredBook, newBook chan<- bool, doneChan <-chan bool) {
 	// Communicate forex
 	requestFX := make(chan string)
-	receiveFX := make(chan float64)
+	receiveFX := make(chan forex.Quote)
 	fxDoneChan := make(chan bool, 1)
-	go handleFX(requestFX, receiveFX, fxDoneChan)
+	fxStopped := make(chan bool)
+	go func() {
+		handleFX(requestFX, receiveFX, fxDoneChan)
+		close(fxStopped)
+	}()
+	fx := fxRequester{requestFX, receiveFX, fxStopped}
 
 	// Filtered book data for each exchange
 	markets := make(map[exchange.Interface]filteredBook)
+	// Exchanges with a short book side
+	short := make(map[exchange.Interface]bool)
 	// Channel to receive book data from exchanges
 	bookChan := make(chan exchange.Book)
+	// Channel to stop book communication, closed on termination
+	exgDoneChan := make(chan bool)
 
 	// Initiate communication with each exchange and initialize markets map
 	for _, exg := range exchanges {
-		book := exg.CommunicateBook(bookChan)
+		book := exg.CommunicateBook(bookChan, exgDoneChan)
 		if book.Error != nil {
 			log.Fatal(book.Error)
 		}
-		requestFX <- exg.Currency()
-		markets[exg] = filterBook(book, <-receiveFX)
+		rec.Book(book)
+		// A malformed initial book is left stale until good data arrives
+		if isError(book.Validate()) {
+			markets[exg] = filteredBook{}
+			continue
+		}
+		markets[exg] = fxFilterBook(book, fx.quote(exg.Currency()))
+		logShortBook(short, exg, markets[exg])
 	}
 
 	// Handle data until notified of termination
@@ -195,9 +594,11 @@ func handleData(requestBook <-chan exchange.Interface, receiveBook chan<- filter
 		select {
 		// Incoming data from an exchange
 		case book := <-bookChan:
-			if !isError(book.Error) {
-				requestFX <- book.Exg.Currency()
-				markets[book.Exg] = filterBook(book, <-receiveFX)
+			rec.Book(book)
+			// Crossed or malformed books are dropped
+			if !exchangeError(book.Exg, book.Error) && !exchangeError(book.Exg, book.Validate()) {
+				markets[book.Exg] = fxFilterBook(book, fx.quote(book.Exg.Currency()))
+				logShortBook(short, book.Exg, markets[book.Exg])
 				// Notify of new data if receiver is not busy
 				select {
 				case newBook <- true:
@@ -211,27 +612,91 @@ func handleData(requestBook <-chan exchange.Interface, receiveBook chan<- filter
 		case <-doneChan:
 			close(newBook)
 			fxDoneChan <- true
-			for _, exg := range exchanges {
-				exg.Done()
-			}
+			close(exgDoneChan)
 			return
 		}
 	}
 }
 
+// Channels for requesting filtered books from handleData
+type bookRequester struct {
+	requestBook chan<- exchange.Interface
+	receiveBook <-chan filteredBook
+	stopped     <-chan bool // Closed when handleData returns
+}
+
+// Request the latest filtered book for exg from handleData
+// Returns false if handleData has stopped, so a request made during shutdown can't block
+func (books bookRequester) book(exg exchange.Interface) (filteredBook, bool) {
+	select {
+	case books.requestBook <- exg:
+		// handleData answers every request it takes
+		return <-books.receiveBook, true
+	case <-books.stopped:
+		return filteredBook{}, false
+	}
+}
+
+// Max wait for handleFX to answer a quote request
+var fxRequestTimeout = 5 * time.Second
+
+// Channels for requesting quotes from handleFX
+type fxRequester struct {
+	requestFX chan<- string
+	receiveFX <-chan forex.Quote
+	stopped   <-chan bool // Closed when handleFX returns
+}
+
+// Request a quote for symbol from handleFX
+// Returns a quote with an error if handleFX has stopped or doesn't answer within fxRequestTimeout,
+// so a book arriving during shutdown can't block
+func (fx fxRequester) quote(symbol string) forex.Quote {
+	timeout := time.NewTimer(fxRequestTimeout)
+	defer timeout.Stop()
+	requestFX := fx.requestFX
+	for {
+		select {
+		case requestFX <- symbol:
+			// Sent, so only wait for the answer
+			requestFX = nil
+		case quote := <-fx.receiveFX:
+			// Answers to earlier timed out requests are dropped
+			if requestFX == nil && quote.Symbol == symbol {
+				return quote
+			}
+		case <-fx.stopped:
+			return forex.Quote{Symbol: symbol, Error: fmt.Errorf("No %s quote available, FX handling stopped", symbol)}
+		case <-timeout.C:
+			return forex.Quote{Symbol: symbol, Error: fmt.Errorf("No %s quote available, FX request timed out", symbol)}
+		}
+	}
+}
+
 // Handle FX quotes
-func handleFX(requestFX <-chan string, receiveFX chan<- float64, doneChan <-chan bool) {
-	prices := make(map[string]float64)
-	prices["usd"] = 1
+// Quotes are sent with their age, or with an error if no good quote has been received
+func handleFX(requestFX <-chan string, receiveFX chan<- forex.Quote, doneChan <-chan bool) {
+	quotes := make(map[string]forex.Quote)
+	fetched := make(map[string]time.Time)
 	fxChan := make(chan forex.Quote)
 	fxDoneChan := make(chan bool)
-	// Initiate communication and initialize prices map
-	for _, symbol := range currencies {
-		quote := forex.CommunicateFX(symbol, fxChan, fxDoneChan)
-		if quote.Error != nil {
-			log.Fatal(quote.Error)
+	// OpenExchangeRates is used if an app ID is supplied
+	if key := os.Getenv("OPENEXCHANGE_KEY"); key != "" {
+		forex.SetProvider(forex.NewOpenExchangeRates(key))
+	}
+	if cfg.Sec.FXCacheTTL > 0 {
+		forex.SetCacheTTL(time.Duration(cfg.Sec.FXCacheTTL * float64(time.Second)))
+	}
+	// Store a good quote with the time it was fetched
+	store := func(quote forex.Quote) {
+		rec.Quote(quote)
+		if !isError(quote.Error) {
+			quotes[quote.Symbol] = quote
+			fetched[quote.Symbol] = clock.Now().Add(-quote.Age)
 		}
-		prices[symbol] = quote.Price
+	}
+	// Initiate communication and initialize quotes, markets are stale until a good quote arrives
+	for _, symbol := range currencies {
+		store(forex.CommunicateFX(symbol, fxChan, fxDoneChan))
 	}
 
 	// Handle data until notified of termination
@@ -239,139 +704,461 @@ func handleFX(requestFX <-chan string, receiveFX chan<- float64, doneChan <-chan
 		select {
 		// Incoming forex quote
 		case quote := <-fxChan:
-			if !isError(quote.Error) {
-				prices[quote.Symbol] = quote.Price
-			}
+			store(quote)
 		// New request for price
 		case symbol := <-requestFX:
-			receiveFX <- prices[symbol]
-		// Termination
+			// FX prices are per USD
+			if symbol == exchange.USD.Symbol() {
+				receiveFX <- forex.Quote{Price: 1, Symbol: symbol}
+			} else if quote, ok := quotes[symbol]; ok {
+				quote.Age = clock.Now().Sub(fetched[symbol])
+				receiveFX <- quote
+			} else {
+				receiveFX <- forex.Quote{Symbol: symbol, Error: fmt.Errorf("No %s quote available", symbol)}
+			}
+		// Termination, closing to stop the loop for every currency
 		case <-doneChan:
-			fxDoneChan <- true
+			close(fxDoneChan)
 			return
 		}
 	}
 }
 
+// Filter book using an FX quote
+// The filtered book is dated by the older of the book and the quote
+func fxFilterBook(book exchange.Book, quote forex.Quote) filteredBook {
+	if quote.Error != nil {
+		return filteredBook{bid: market{exg: book.Exg}, ask: market{exg: book.Exg, adjPrice: math.MaxFloat64}}
+	}
+	fb := filterBook(book, quote.Price)
+	// Scale the position limit to current price
+	if mid := book.MidPrice() / quote.Price; cfg.Sec.MaxNotional > 0 && mid > 0 {
+		fb.maxPos = cfg.Sec.MaxNotional / mid
+	}
+	if fxTime := clock.Now().Add(-quote.Age); fxTime.Before(fb.time) {
+		fb.time = fxTime
+	}
+	return fb
+}
+
 // Filter book down to relevant data for trading decisions
 // Adjusts market amounts according to MaxOrder
+// Books are cut to BookDepth levels so every exchange is filtered on the same depth. A side that doesn't
+// reach the minimum order within them is short: it keeps the default zero amount and is never traded
 func filterBook(book exchange.Book, fxPrice float64) filteredBook {
+	book = book.TrimDepth(cfg.Sec.BookDepth)
 	// Default with a high ask.adjPrice in case sufficient size doesn't exist
 	fb := filteredBook{
-		time: book.Time,
-		bid:  market{exg: book.Exg},
-		ask:  market{exg: book.Exg, adjPrice: math.MaxFloat64},
+		time:     book.Time,
+		bookTime: book.Time,
+		book:     book,
+		bid:      market{exg: book.Exg, short: true},
+		ask:      market{exg: book.Exg, adjPrice: math.MaxFloat64, short: true},
 	}
 
-	// Loop through bids and aggregate amounts until required size
-	var amount, aggPrice float64
+	// Orders are expected to take liquidity, a resting leg is repriced by makerPrice
+	// Read the fee once so both sides use the same tier. A negative fee is a rebate, raising bids and lowering asks
+	fee := book.Exg.TakerFee()
+
+	// Loop through bids until the aggregate amount reaches required size
+	minAmount := minOrder(book.Exg)
+	var amount float64
 	for _, bid := range book.Bids {
-		aggPrice += bid.Price * math.Min(cfg.Sec.MaxOrder-amount, bid.Amount)
-		amount += math.Min(cfg.Sec.MaxOrder-amount, bid.Amount)
-		if amount >= cfg.Sec.MinOrder {
+		amount = math.Min(cfg.Sec.MaxOrder, amount+bid.Amount)
+		if amount >= minAmount {
 			// Amount-weighted average subject to MaxOrder, adjusted for fees and currency
-			adjPrice := (aggPrice / amount) * (1 - book.Exg.Fee()) / fxPrice
-			fb.bid = market{book.Exg, bid.Price, amount, adjPrice}
+			vwap, _ := book.BidVWAP(amount)
+			fb.bid = market{exg: book.Exg, orderPrice: bid.Price, amount: amount, adjPrice: vwap * (1 - fee) / fxPrice, topPrice: book.Bids[0].Price, fxPrice: fxPrice}
 			break
 		}
 	}
+	if fb.bid.amount > 0 {
+		var total float64
+		for _, bid := range book.Bids {
+			total += bid.Amount
+		}
+		fb.bid.availBeyond = availBeyond(total, fb.bid.amount)
+	}
 
-	// Loop through asks and aggregate amounts until required size
-	amount, aggPrice = 0, 0
+	// Loop through asks until the aggregate amount reaches required size
+	amount = 0
 	for _, ask := range book.Asks {
-		aggPrice += ask.Price * math.Min(cfg.Sec.MaxOrder-amount, ask.Amount)
-		amount += math.Min(cfg.Sec.MaxOrder-amount, ask.Amount)
-		if amount >= cfg.Sec.MinOrder {
+		amount = math.Min(cfg.Sec.MaxOrder, amount+ask.Amount)
+		if amount >= minAmount {
 			// Amount-weighted average subject to MaxOrder, adjusted for fees and currency
-			adjPrice := (aggPrice / amount) * (1 + book.Exg.Fee()) / fxPrice
-			fb.ask = market{book.Exg, ask.Price, amount, adjPrice}
+			vwap, _ := book.AskVWAP(amount)
+			fb.ask = market{exg: book.Exg, orderPrice: ask.Price, amount: amount, adjPrice: vwap * (1 + fee) / fxPrice, topPrice: book.Asks[0].Price, fxPrice: fxPrice}
 			break
 		}
 	}
+	if fb.ask.amount > 0 {
+		var total float64
+		for _, ask := range book.Asks {
+			total += ask.Amount
+		}
+		fb.ask.availBeyond = availBeyond(total, fb.ask.amount)
+	}
 
 	return fb
 }
 
-// Trade on net position exits and arb opportunities
-func considerTrade(requestBook chan<- exchange.Interface, receiveBook <-chan filteredBook, newBook <-chan bool) {
-	// Local data copy
-	var markets map[exchange.Interface]filteredBook
-	// For tracking last trade, to prevent false repeats on slow exchange updates
-	var lastArb, lastAmount float64
+// Amount of a book side past the order amount, counting at most SampleDepth of the side
+// Shows liquidity left after an order capped at MaxOrder, zero unless SampleDepth exceeds MaxOrder
+func availBeyond(sideTotal, amount float64) float64 {
+	if cfg.Sec.SampleDepth <= cfg.Sec.MaxOrder {
+		return 0
+	}
+	return math.Max(0, math.Min(sideTotal, cfg.Sec.SampleDepth)-amount)
+}
+
+// Log when an exchange's book becomes too shallow on either side for the minimum order, and when it recovers
+// short holds the last state of each exchange
+func logShortBook(short map[exchange.Interface]bool, exg exchange.Interface, fb filteredBook) {
+	isShort := fb.bid.short || fb.ask.short
+	if isShort == short[exg] {
+		return
+	}
+	short[exg] = isShort
+	if isShort {
+		logger.Infof("%s book too shallow for the minimum order of %.4f, bid short: %t, ask short: %t\n", exchangeKey(exg), minOrder(exg), fb.bid.short, fb.ask.short)
+	} else {
+		logger.Infof("%s book deep enough for the minimum order again\n", exchangeKey(exg))
+	}
+}
+
+// Trade on net position exits and arb opportunities, separately for each symbol
+// Status requests and analytics logs are handled between trades
+func considerTrade(books bookRequester, newBook <-chan bool, requestStatus <-chan chan liveStatus) {
+	states := make(map[string]*tradeState)
+	// Periodic diagnostics, if enabled
+	analyticsTick, stopAnalytics := analyticsTicker()
+	defer stopAnalytics()
+	// Forced hedging of lasting net positions, if enabled
+	hedgeTick, stopHedge := hedgeTicker()
+	defer stopHedge()
+	// Priority from measured latency and fill rate, if enabled
+	priorityTick, stopPriority := priorityTicker()
+	defer stopPriority()
 
 	// Check for trade whenever new data is available
-	for _ = range newBook {
-		// Build local snapshot of latest data
-		markets = make(map[exchange.Interface]filteredBook)
-		for _, exg := range exchanges {
-			requestBook <- exg
-			// Don't use stale data
-			if fb := <-receiveBook; time.Since(fb.time) < time.Minute {
-				markets[exg] = fb
-				// Set MaxPos according to fiat funds and crypto available to short
-				exg.SetMaxPos(math.Min(exg.AvailFunds()/fb.ask.orderPrice, exg.AvailShort()))
-			}
-		}
-		// If net long from a previous missed leg, hit best bid
-		if netPosition >= cfg.Sec.MinNetPos {
-			bestBid := findBestBid(markets)
-			amount := math.Min(netPosition, bestBid.amount)
-			fillChan := make(chan float64)
-			log.Println("NET LONG POSITION EXIT")
-			go fillOrKill(bestBid.exg, "sell", amount, bestBid.orderPrice, fillChan)
-			updatePL(bestBid.adjPrice, <-fillChan, "sell")
-			calcNetPosition()
-			if cfg.Sec.PrintOn {
-				printResults()
+	for {
+		select {
+		case reply := <-requestStatus:
+			reply <- buildStatus(books)
+			continue
+		case <-analyticsTick:
+			logAnalytics(books)
+			continue
+		case <-priorityTick:
+			updatePriorities(exchanges)
+			continue
+		case <-hedgeTick:
+			// Runs without new books, which may have stopped
+			order, groups := groupBySymbol(exchanges)
+			for _, symbol := range order {
+				if states[symbol] == nil {
+					states[symbol] = &tradeState{}
+				}
+				markets := lastKnownMarkets(groups[symbol], books)
+				if markets == nil {
+					break
+				}
+				states[symbol].checkExposure(symbol, markets)
 			}
-			// Else if net short, lift best ask
-		} else if netPosition <= -cfg.Sec.MinNetPos {
-			bestAsk := findBestAsk(markets)
-			amount := math.Min(-netPosition, bestAsk.amount)
-			fillChan := make(chan float64)
-			log.Println("NET SHORT POSITION EXIT")
-			go fillOrKill(bestAsk.exg, "buy", amount, bestAsk.orderPrice, fillChan)
-			updatePL(bestAsk.adjPrice, <-fillChan, "buy")
-			calcNetPosition()
-			if cfg.Sec.PrintOn {
-				printResults()
+			continue
+		case _, ok := <-newBook:
+			if !ok {
+				return
 			}
-			// Else check for arb opportunities
-		} else {
-			// If an opportunity exists
-			if bestBid, bestAsk, exists := findBestArb(markets); exists {
-				arb := bestBid.adjPrice - bestAsk.adjPrice
-				amount := math.Min(bestBid.amount, bestAsk.amount)
-
-				// If it's not a false repeat, then trade
-				if math.Abs(arb-lastArb) > .000001 || math.Abs(amount-lastAmount) > .000001 || math.Abs(amount-cfg.Sec.MaxOrder) < .000001 {
-					log.Printf("***** Arb Opportunity: %.4f for %.4f on %s vs %s *****\n", arb, amount, bestAsk.exg, bestBid.exg)
-					sendPair(bestBid, bestAsk, amount)
-					calcNetPosition()
-					if cfg.Sec.PrintOn {
-						printResults()
+		}
+		// Build local snapshot of latest data for each symbol
+		order, groups := groupBySymbol(exchanges)
+		for _, symbol := range order {
+			exgs := groups[symbol]
+			// Nil once handleData has stopped
+			snapshot := func() map[exchange.Interface]filteredBook {
+				markets := make(map[exchange.Interface]filteredBook)
+				for _, exg := range exgs {
+					fb, ok := books.book(exg)
+					if !ok {
+						return nil
 					}
-					lastArb = arb
-					lastAmount = amount
+					addToSnapshot(markets, exg, fb)
 				}
+				return markets
+			}
+			if states[symbol] == nil {
+				states[symbol] = &tradeState{}
 			}
+			states[symbol].refresh = snapshot
+			markets := snapshot()
+			if markets == nil {
+				return
+			}
+			states[symbol].trade(symbol, markets)
 		}
 	}
 }
 
+// State carried between trading decisions
+type tradeState struct {
+	// For tracking last trade, to prevent false repeats on slow exchange updates
+	lastArb, lastAmount float64
+	// Times of the bid and ask books the last trade was made on
+	lastBooks [2]time.Time
+	// Last trade time of each buy and sell exchange pair, for PairCooldown
+	pairTimes map[[2]exchange.Interface]time.Time
+	// Set once the drawdown limit is hit, to warn only once
+	halted bool
+	// When the net position went beyond MinNetPos, zero if within it
+	exposedSince time.Time
+	// Fresh snapshot of the symbol's markets for slicing, nil to trade one slice per snapshot
+	// Returns nil once book handling has stopped, ending the slices
+	refresh func() map[exchange.Interface]filteredBook
+	// Last near miss logged, so an unchanged one isn't logged again
+	lastNearMiss string
+}
+
+// Add a filtered book to a snapshot of markets unless stale
+// Sets the exchange MaxPos from the book
+func addToSnapshot(markets map[exchange.Interface]filteredBook, exg exchange.Interface, fb filteredBook) {
+	// Don't use stale data
+	if clock.Now().Sub(fb.time) >= time.Minute {
+		return
+	}
+	markets[exg] = fb
+	recordMark(fb)
+	// Set MaxPos according to fiat funds and crypto available to short
+	// Funds are net of fills, so the position they bought is added back to measure from flat
+	maxPos := math.Min(exg.AvailFunds()/fb.ask.orderPrice+exg.Position(), exg.AvailShort())
+	// And to notional value if MaxNotional is set
+	if fb.maxPos > 0 {
+		maxPos = math.Min(maxPos, fb.maxPos)
+	}
+	exg.SetMaxPos(maxPos)
+}
+
+// Last USD mid price of each exchange, for unrealized P&L
+var markPrices = make(map[exchange.Interface]float64)
+
+// Record the USD mid price of a filtered book's exchange, if both sides have a price
+func recordMark(fb filteredBook) {
+	if fb.bid.topPrice <= 0 || fb.ask.topPrice <= 0 || fb.bid.fxPrice <= 0 {
+		return
+	}
+	markPrices[fb.bid.exg] = (fb.bid.topPrice + fb.ask.topPrice) / 2 / fb.bid.fxPrice
+}
+
+// Unrealized P&L of exchange positions marked to their last mid prices
+// Exchanges not yet marked are left out
+func unrealizedPL(exgs []exchange.Interface) float64 {
+	var total float64
+	for _, exg := range exgs {
+		total += exg.Position() * markPrices[exg]
+	}
+	return total
+}
+
+// Trade on net position exits and arb opportunities in a snapshot of one symbol's markets
+// The drawdown limit applies to P&L across all symbols
+func (state *tradeState) trade(symbol string, markets map[exchange.Interface]filteredBook) {
+	netPosition := netPositions[symbol]
+	// If net long from a previous missed leg, hit best bid
+	if netPosition >= cfg.Sec.MinNetPos {
+		bestBid := findBestBid(markets)
+		// No exchange may be able to sell, or all may be disabled
+		if bestBid.exg == nil {
+			return
+		}
+		bestBid = sweepExit(markets[bestBid.exg], bestBid, "sell", netPosition)
+		amount := math.Min(netPosition, bestBid.amount)
+		fillChan := make(chan float64)
+		logger.Infof("%sNET LONG POSITION EXIT\n", symbolPrefix(symbol))
+		go fillOrKill(bestBid.exg, "sell", amount, bestBid.orderPrice, fillChan)
+		filled := <-fillChan
+		updatePL(bestBid, "sell", amount, filled)
+		notifier.Notify(fmt.Sprintf("%sNet long position exit: sold %.4f of %.4f on %s at %.4f", symbolPrefix(symbol), filled, amount, bestBid.exg, bestBid.orderPrice))
+		calcNetPosition()
+		if cfg.Sec.PrintOn {
+			printResults()
+		}
+		// Else if net short, lift best ask
+	} else if netPosition <= -cfg.Sec.MinNetPos {
+		bestAsk := findBestAsk(markets)
+		if bestAsk.exg == nil {
+			return
+		}
+		bestAsk = sweepExit(markets[bestAsk.exg], bestAsk, "buy", -netPosition)
+		amount := math.Min(-netPosition, bestAsk.amount)
+		fillChan := make(chan float64)
+		logger.Infof("%sNET SHORT POSITION EXIT\n", symbolPrefix(symbol))
+		go fillOrKill(bestAsk.exg, "buy", amount, bestAsk.orderPrice, fillChan)
+		filled := <-fillChan
+		updatePL(bestAsk, "buy", amount, filled)
+		notifier.Notify(fmt.Sprintf("%sNet short position exit: bought %.4f of %.4f on %s at %.4f", symbolPrefix(symbol), filled, amount, bestAsk.exg, bestAsk.orderPrice))
+		calcNetPosition()
+		if cfg.Sec.PrintOn {
+			printResults()
+		}
+		// Else if past the drawdown limit, only allow net position exits
+	} else if cfg.Sec.MaxDrawdown > 0 && pl < -cfg.Sec.MaxDrawdown {
+		if !state.halted {
+			logger.Warnf("!!!!! P&L %.2f is past the drawdown limit of %.2f, no new arb positions !!!!!\n", pl, cfg.Sec.MaxDrawdown)
+			state.halted = true
+		}
+		// Else check for arb opportunities
+	} else {
+		amount, traded := state.tradeArb(symbol, markets, true)
+		// Slice a deep opportunity into further MaxOrder trades while it lasts
+		for slice := 1; traded && slice < cfg.Sec.MaxSlices && state.refresh != nil; slice++ {
+			// A slice below MaxOrder took what the books had
+			if amount < cfg.Sec.MaxOrder-.000001 {
+				break
+			}
+			<-clock.After(time.Duration(cfg.Sec.SliceDelay * float64(time.Second)))
+			// Missed legs and the drawdown limit are left to the next trade call
+			if math.Abs(netPositions[symbol]) >= cfg.Sec.MinNetPos || cfg.Sec.MaxDrawdown > 0 && pl < -cfg.Sec.MaxDrawdown {
+				break
+			}
+			lastBooks := state.lastBooks
+			if markets = state.refresh(); markets == nil {
+				break
+			}
+			bestBid, bestAsk, exists := findBestArb(markets)
+			// Stop when the spread closes or neither book has updated since the last slice
+			if !exists || markets[bestBid.exg].bookTime.Equal(lastBooks[0]) && markets[bestAsk.exg].bookTime.Equal(lastBooks[1]) {
+				break
+			}
+			logger.Infof("%sArb slice %d of up to %d\n", symbolPrefix(symbol), slice+1, cfg.Sec.MaxSlices)
+			amount, traded = state.tradeArb(symbol, markets, false)
+		}
+	}
+}
+
+// Widen a net position exit at the best bid or ask to sweep deeper levels of its exchange's book, up to amount
+// Levels within ExitSlippage of the top price are taken, with the order priced at the last one
+// Returns m unchanged if ExitSlippage is unset or the book offers no more than m within it
+func sweepExit(fb filteredBook, m market, action string, amount float64) market {
+	if cfg.Sec.ExitSlippage <= 0 || amount <= m.amount {
+		return m
+	}
+	var swept, price float64
+	if action == "sell" {
+		limit := m.topPrice * (1 - cfg.Sec.ExitSlippage)
+		for _, bid := range fb.book.Bids {
+			if bid.Price < limit || swept >= amount {
+				break
+			}
+			swept, price = math.Min(amount, swept+bid.Amount), bid.Price
+		}
+		swept = math.Min(swept, ableToSell(m.exg))
+	} else {
+		limit := m.topPrice * (1 + cfg.Sec.ExitSlippage)
+		for _, ask := range fb.book.Asks {
+			if ask.Price > limit || swept >= amount {
+				break
+			}
+			swept, price = math.Min(amount, swept+ask.Amount), ask.Price
+		}
+		swept = math.Min(swept, ableToBuy(m.exg, market{orderPrice: price}))
+	}
+	if swept <= m.amount {
+		return m
+	}
+
+	// Amount-weighted average of the levels taken, adjusted for fees and currency as in filterBook
+	fee := m.exg.TakerFee()
+	if action == "sell" {
+		vwap, _ := fb.book.BidVWAP(swept)
+		m.adjPrice = vwap * (1 - fee) / m.fxPrice
+	} else {
+		vwap, _ := fb.book.AskVWAP(swept)
+		m.adjPrice = vwap * (1 + fee) / m.fxPrice
+	}
+	m.orderPrice, m.amount = price, swept
+	return m
+}
+
+// Trade the best arb opportunity in a snapshot of one symbol's markets, if any
+// If cooldown is set, a pair traded within PairCooldown is skipped, as further slices of an opportunity are not
+// Returns the amount sent and whether a trade was made
+func (state *tradeState) tradeArb(symbol string, markets map[exchange.Interface]filteredBook, cooldown bool) (float64, bool) {
+	bestBid, bestAsk, exists := findBestArb(markets)
+	if !exists {
+		if cfg.Sec.LogNearMiss {
+			state.logNearMiss(symbol, markets)
+		}
+		return 0, false
+	}
+	pair := [2]exchange.Interface{bestAsk.exg, bestBid.exg}
+	if cooldown && clock.Now().Sub(state.pairTimes[pair]) < time.Duration(cfg.Sec.PairCooldown*float64(time.Second)) {
+		return 0, false
+	}
+	arb := bestBid.adjPrice - bestAsk.adjPrice
+	amount := math.Min(bestBid.amount, bestAsk.amount)
+	arbHistogram.Observe(arb)
+
+	books := [2]time.Time{markets[bestBid.exg].bookTime, markets[bestAsk.exg].bookTime}
+	// If it's a false repeat, don't trade
+	if state.repeats(arb, amount, books) {
+		return 0, false
+	}
+	logger.Infof("***** %sArb Opportunity: %.4f for %.4f on %s vs %s *****\n", symbolPrefix(symbol), arb, amount, bestAsk.exg, bestBid.exg)
+	if cfg.Sec.SampleDepth > cfg.Sec.MaxOrder {
+		logger.Debugf("%sLiquidity past the order: %.4f on %s, %.4f on %s\n", symbolPrefix(symbol), bestAsk.availBeyond, bestAsk.exg, bestBid.availBeyond, bestBid.exg)
+	}
+	slack := arb - calcNeededArb(bestAsk.exg, bestBid.exg)
+	if cfg.Sec.SlippageTicks > 0 {
+		bestBid, bestAsk = padPrices(bestBid, bestAsk, slack)
+	}
+	join := cfg.Sec.JoinMargin > 0 && slack >= cfg.Sec.JoinMargin
+	sendPair(bestBid, bestAsk, amount, join, cfg.Sec.PostOnlyMargin > 0 && slack >= cfg.Sec.PostOnlyMargin)
+	calcNetPosition()
+	notifier.Notify(fmt.Sprintf("%sArb traded: %.4f for %.4f, bought on %s and sold on %s, P&L %.2f", symbolPrefix(symbol), arb, amount, bestAsk.exg, bestBid.exg, pl))
+	if cfg.Sec.PrintOn {
+		printResults()
+	}
+	state.lastArb = arb
+	state.lastAmount = amount
+	state.lastBooks = books
+	if state.pairTimes == nil {
+		state.pairTimes = make(map[[2]exchange.Interface]time.Time)
+	}
+	state.pairTimes[pair] = clock.Now()
+	return amount, true
+}
+
+// Whether an opportunity repeats the last trade without either of its books having updated
+// Opportunities at MaxOrder are never repeats, since more size was available
+func (state *tradeState) repeats(arb, amount float64, books [2]time.Time) bool {
+	if math.Abs(amount-cfg.Sec.MaxOrder) < .000001 {
+		return false
+	}
+	return math.Abs(arb-state.lastArb) <= .000001 && math.Abs(amount-state.lastAmount) <= .000001 &&
+		books[0].Equal(state.lastBooks[0]) && books[1].Equal(state.lastBooks[1])
+}
+
+// Smallest order worth sending to an exchange
+func minOrder(exg exchange.Interface) float64 {
+	return math.Max(cfg.Sec.MinOrder, exg.MinOrderSize())
+}
+
 // Find best bid able to sell
-// Adjusts market amount according to exchange position
+// Adjusts market amount according to exchange position and crypto available to short
 func findBestBid(markets map[exchange.Interface]filteredBook) market {
 	var bestBid market
 
 	for exg, fb := range markets {
-		ableToSell := exg.Position() + exg.MaxPos()
-		// If not already max short
-		if ableToSell >= cfg.Sec.MinOrder {
+		if tripped(exg) {
+			continue
+		}
+		able := ableToSell(exg)
+		// If not already max short, and the book can supply the minimum order
+		if able >= minOrder(exg) && !fb.bid.short {
 			// If highest bid
 			if fb.bid.adjPrice > bestBid.adjPrice {
 				bestBid = fb.bid
-				bestBid.amount = math.Min(bestBid.amount, ableToSell)
+				bestBid.amount = math.Min(bestBid.amount, able)
 			}
 		}
 	}
@@ -380,20 +1167,23 @@ func findBestBid(markets map[exchange.Interface]filteredBook) market {
 }
 
 // Find best ask able to buy
-// Adjusts market amount according to exchange position
+// Adjusts market amount according to exchange position and fiat funds left
 func findBestAsk(markets map[exchange.Interface]filteredBook) market {
 	var bestAsk market
 	// Need to start with a high number
 	bestAsk.adjPrice = math.MaxFloat64
 
 	for exg, fb := range markets {
-		ableToBuy := exg.MaxPos() - exg.Position()
-		// If not already max long
-		if ableToBuy >= cfg.Sec.MinOrder {
+		if tripped(exg) {
+			continue
+		}
+		able := ableToBuy(exg, fb.ask)
+		// If not already max long, and the book can supply the minimum order
+		if able >= minOrder(exg) && !fb.ask.short {
 			// If lowest ask
 			if fb.ask.adjPrice < bestAsk.adjPrice {
 				bestAsk = fb.ask
-				bestAsk.amount = math.Min(bestAsk.amount, ableToBuy)
+				bestAsk.amount = math.Min(bestAsk.amount, able)
 			}
 		}
 	}
@@ -402,31 +1192,109 @@ func findBestAsk(markets map[exchange.Interface]filteredBook) market {
 
 }
 
+// Amount an exchange can sell, limited by its max position and crypto available to short
+func ableToSell(exg exchange.Interface) float64 {
+	return math.Min(exg.MaxPos(), exg.AvailShort()) + exg.Position()
+}
+
+// Amount an exchange can buy at an ask, limited by its max position and fiat funds left
+// Funds are in the exchange's currency, so they're divided by the order price rather than the USD adjusted price
+// An ask without a price is limited by position only
+func ableToBuy(exg exchange.Interface, ask market) float64 {
+	able := exg.MaxPos() - exg.Position()
+	if ask.orderPrice > 0 {
+		able = math.Min(able, exg.AvailFunds()/ask.orderPrice)
+	}
+	return able
+}
+
+// Candidate side of an arb opportunity
+// Score is the adjusted price plus the exchange's share of the needed arb
+type candidate struct {
+	m     market
+	score float64
+}
+
+// Best two bid and ask candidates for a currency, best first
+type currencyCandidates struct {
+	code         exchange.Currency
+	bids, asks   [2]candidate
+	nBids, nAsks int
+}
+
 // Find best arbitrage opportunity
-// Adjusts market amounts according to exchange positions
+// Adjusts market amounts according to exchange positions, funds, and short capacity
+//
+// The arb needed by calcNeededArb is not symmetric: the buy exchange's position
+// raises it and the sell exchange's position lowers it, and an FX premium applies
+// only to pairs in different currencies. So the best bid and best ask can't simply
+// be paired. Instead each bid and ask is scored once with its own position term,
+// leaving only the center and FX premium to depend on the pair. The two best
+// candidates per currency on each side are kept so an exchange is never paired
+// with itself, and the comparison is then over currencies rather than exchanges.
+//
+// With MaxNotional set, MaxPos falls as price rises, so an unchanged position is
+// a larger share of MaxPos. That raises the arb needed to add to the position and
+// lowers it for reducing, and a position left above MaxPos can only be reduced.
 func findBestArb(markets map[exchange.Interface]filteredBook) (market, market, bool) {
 	var (
 		bestBid, bestAsk market
 		bestOpp          float64
 		exists           bool
+		groups           [4]currencyCandidates
 	)
+	center, halfDist := arbBounds()
 
-	// Compare each bid to all other asks
-	for exg1, fb1 := range markets {
-		ableToSell := exg1.Position() + exg1.MaxPos()
-		// If exg1 is not already max short
-		if ableToSell >= cfg.Sec.MinOrder {
-			for exg2, fb2 := range markets {
-				ableToBuy := exg2.MaxPos() - exg2.Position()
-				// If exg2 is not already max long
-				if ableToBuy >= cfg.Sec.MinOrder {
-					opp := fb1.bid.adjPrice - fb2.ask.adjPrice - calcNeededArb(exg2, exg1)
+	// Score each exchange able to trade, keeping the best two per currency
+	byCurrency := groups[:0]
+	for exg, fb := range markets {
+		// Exchanges with a tripped breaker sit out their cooldown
+		if tripped(exg) {
+			continue
+		}
+		positionTerm := exg.Position() / exg.MaxPos() * halfDist
+		minAmount := minOrder(exg)
+		code := exg.CurrencyCode()
+		i := 0
+		for i < len(byCurrency) && byCurrency[i].code != code {
+			i++
+		}
+		if i == len(byCurrency) {
+			byCurrency = append(byCurrency, currencyCandidates{code: code})
+		}
+		group := &byCurrency[i]
+		// If exg is not already max short, and its book can supply the minimum order
+		if able := ableToSell(exg); able >= minAmount && !fb.bid.short {
+			bid := fb.bid
+			bid.amount = math.Min(bid.amount, able)
+			group.nBids = keepBest(&group.bids, group.nBids, candidate{bid, bid.adjPrice + positionTerm}, 1)
+		}
+		// If exg is not already max long, and its book can supply the minimum order
+		if able := ableToBuy(exg, fb.ask); able >= minAmount && !fb.ask.short {
+			ask := fb.ask
+			ask.amount = math.Min(ask.amount, able)
+			group.nAsks = keepBest(&group.asks, group.nAsks, candidate{ask, ask.adjPrice + positionTerm}, -1)
+		}
+	}
+
+	// Compare the best candidates for each pair of currencies
+	for _, bidGroup := range byCurrency {
+		for _, askGroup := range byCurrency {
+			neededCenter := center
+			// If taking currency risk, add required premium
+			if bidGroup.code != askGroup.code {
+				neededCenter += cfg.Sec.FXPremium
+			}
+			for _, bid := range bidGroup.bids[:bidGroup.nBids] {
+				for _, ask := range askGroup.asks[:askGroup.nAsks] {
+					if bid.m.exg == ask.m.exg {
+						continue
+					}
+					opp := bid.score - ask.score - neededCenter
 					// If best opportunity
 					if opp >= bestOpp {
-						bestBid = fb1.bid
-						bestBid.amount = math.Min(bestBid.amount, ableToSell)
-						bestAsk = fb2.ask
-						bestAsk.amount = math.Min(bestAsk.amount, ableToBuy)
+						bestBid = bid.m
+						bestAsk = ask.m
 						exists = true
 						bestOpp = opp
 					}
@@ -438,12 +1306,39 @@ func findBestArb(markets map[exchange.Interface]filteredBook) (market, market, b
 	return bestBid, bestAsk, exists
 }
 
-// Calculate arb needed for a trade based on existing positions
-func calcNeededArb(buyExg, sellExg exchange.Interface) float64 {
+// Insert a candidate into the best two, returning the new count
+// Higher scores are better for a sign of 1, lower for -1
+// Candidates with undefined scores are dropped
+func keepBest(best *[2]candidate, n int, c candidate, sign float64) int {
+	if math.IsNaN(c.score) {
+		return n
+	}
+	switch {
+	case n == 0:
+		best[0] = c
+		return 1
+	case sign*c.score > sign*best[0].score:
+		best[1] = best[0]
+		best[0] = c
+	case n == 1 || sign*c.score > sign*best[1].score:
+		best[1] = c
+	}
+	return 2
+}
+
+// Center of the arb range and half distance from center to min and max
+func arbBounds() (float64, float64) {
 	// Middle between min and max
 	center := (cfg.Sec.MaxArb + cfg.Sec.MinArb) / 2
 	// Half distance from center to min and max
 	halfDist := (cfg.Sec.MaxArb - center) / 2
+	return center, halfDist
+}
+
+// Calculate arb needed for a trade based on existing positions
+// Fees are already in the adjusted prices the arb is measured from, at the maker fee for resting legs
+func calcNeededArb(buyExg, sellExg exchange.Interface) float64 {
+	center, halfDist := arbBounds()
 	// If taking currency risk, add required premium
 	if buyExg.CurrencyCode() != sellExg.CurrencyCode() {
 		center += cfg.Sec.FXPremium
@@ -456,114 +1351,428 @@ func calcNeededArb(buyExg, sellExg exchange.Interface) float64 {
 	return center + buyExgPct*halfDist - sellExgPct*halfDist
 }
 
+// Pad arb order prices by up to SlippageTicks price steps, lower for the sell and higher for the buy
+// Padding is reduced until its cost fits in slack, the arb in excess of the needed arb
+// P&L is still recorded at the unpadded adjusted prices
+func padPrices(bestBid, bestAsk market, slack float64) (market, market) {
+	bidStep := bestBid.exg.PriceStep(bestBid.orderPrice)
+	askStep := bestAsk.exg.PriceStep(bestAsk.orderPrice)
+	for ticks := cfg.Sec.SlippageTicks; ticks > 0; ticks-- {
+		bidPad, askPad := float64(ticks)*bidStep, float64(ticks)*askStep
+		if bidPad <= 0 && askPad <= 0 || bidPad >= bestBid.orderPrice {
+			break
+		}
+		// Adjusted prices scale order prices for fees and currency
+		cost := bidPad*bestBid.adjPrice/bestBid.orderPrice + askPad*bestAsk.adjPrice/bestAsk.orderPrice
+		if cost <= slack {
+			logger.Infof("Slippage of %d ticks: sell on %s at %.4f vs %.4f, buy on %s at %.4f vs %.4f\n",
+				ticks, bestBid.exg, bestBid.orderPrice-bidPad, bestBid.orderPrice, bestAsk.exg, bestAsk.orderPrice+askPad, bestAsk.orderPrice)
+			bestBid.orderPrice -= bidPad
+			bestAsk.orderPrice += askPad
+			break
+		}
+	}
+	return bestBid, bestAsk
+}
+
 // Logic for sending a pair of orders
-func sendPair(bestBid, bestAsk market, amount float64) {
+// Exchanges are ordered by effective priority, which only changes between trading decisions
+// If join, the priority leg rests as a maker where the exchange allows it, instead of crossing the spread
+// If postOnly, the non-priority leg rests as a maker where the exchange allows it
+// Simultaneous legs always cross, since neither fill is confirmed before the other is sent
+func sendPair(bestBid, bestAsk market, amount float64, join, postOnly bool) {
 	fillChan1 := make(chan float64)
 	fillChan2 := make(chan float64)
 	// If exchanges have equal priority, send simultaneous orders
-	if bestBid.exg.Priority() == bestAsk.exg.Priority() {
+	if priorityOf(bestBid.exg) == priorityOf(bestAsk.exg) {
 		go fillOrKill(bestAsk.exg, "buy", amount, bestAsk.orderPrice, fillChan1)
 		go fillOrKill(bestBid.exg, "sell", amount, bestBid.orderPrice, fillChan2)
-		updatePL(bestAsk.adjPrice, <-fillChan1, "buy")
-		updatePL(bestBid.adjPrice, <-fillChan2, "sell")
+		bought, sold := <-fillChan1, <-fillChan2
+		updatePL(bestAsk, "buy", amount, bought)
+		updatePL(bestBid, "sell", amount, sold)
+		balanceLegs(bestBid, bestAsk, bought, sold)
 		// Else if bestBid exchange has priority, confirm fill before sending other side
-	} else if bestBid.exg.Priority() < bestAsk.exg.Priority() {
-		go fillOrKill(bestBid.exg, "sell", amount, bestBid.orderPrice, fillChan2)
-		amount = <-fillChan2
-		updatePL(bestBid.adjPrice, amount, "sell")
-		if amount >= cfg.Sec.MinNetPos {
-			go fillOrKill(bestAsk.exg, "buy", amount, bestAsk.orderPrice, fillChan1)
-			updatePL(bestAsk.adjPrice, <-fillChan1, "buy")
+	} else if priorityOf(bestBid.exg) < priorityOf(bestAsk.exg) {
+		bid, otype := legOrder(bestBid, "sell", join)
+		go placeOrder(bid.exg, "sell", otype, amount, bid.orderPrice, fillChan2)
+		filled := <-fillChan2
+		updatePL(bid, "sell", amount, filled)
+		if filled > 0 && filled >= minSecondLeg() {
+			sendSecondLeg(bestAsk, "buy", filled, postOnly)
 		}
 		// Else reverse priority
 	} else {
-		go fillOrKill(bestAsk.exg, "buy", amount, bestAsk.orderPrice, fillChan1)
-		amount = <-fillChan1
-		updatePL(bestAsk.adjPrice, amount, "buy")
-		if amount >= cfg.Sec.MinNetPos {
-			go fillOrKill(bestBid.exg, "sell", amount, bestBid.orderPrice, fillChan2)
-			updatePL(bestBid.adjPrice, <-fillChan2, "sell")
+		ask, otype := legOrder(bestAsk, "buy", join)
+		go placeOrder(ask.exg, "buy", otype, amount, ask.orderPrice, fillChan1)
+		filled := <-fillChan1
+		updatePL(ask, "buy", amount, filled)
+		if filled > 0 && filled >= minSecondLeg() {
+			sendSecondLeg(bestBid, "sell", filled, postOnly)
 		}
 	}
 }
 
-// Update P&L
-func updatePL(price, amount float64, action string) {
+// Complete the lagging leg of simultaneous orders that filled unequally
+// Differences below minSecondLeg, and any left by the corrective order, are left to the net position exit
+func balanceLegs(bestBid, bestAsk market, bought, sold float64) {
+	imbalance := bought - sold
+	if math.Abs(imbalance) <= .000001 {
+		return
+	}
+	logger.Warnf("Unequal fills: bought %.4f on %s, sold %.4f on %s\n", bought, bestAsk.exg, sold, bestBid.exg)
+	if imbalance >= minSecondLeg() {
+		sendSecondLeg(bestBid, "sell", imbalance, false)
+	} else if -imbalance >= minSecondLeg() {
+		sendSecondLeg(bestAsk, "buy", -imbalance, false)
+	}
+}
+
+// Smallest fill worth hedging with a second leg
+// MinNetPos is used unless MinSecondLeg is set, zero hedging any fill
+func minSecondLeg() float64 {
+	if cfg.Sec.MinSecondLeg >= 0 {
+		return cfg.Sec.MinSecondLeg
+	}
+	return cfg.Sec.MinNetPos
+}
+
+// Send the leg following a confirmed fill, post-only at a maker price if requested and possible
+// Any amount left unfilled after MakerWait is cancelled and exited by the net position logic
+func sendSecondLeg(m market, action string, amount float64, postOnly bool) {
+	m, otype := legOrder(m, action, postOnly)
+	fillChan := make(chan float64)
+	go placeOrder(m.exg, action, otype, amount, m.orderPrice, fillChan)
+	updatePL(m, action, amount, <-fillChan)
+}
+
+// Return the market and order type for a leg, joining the book post-only at a maker price if requested and possible
+// Otherwise the leg crosses the spread as a limit order at the market's order price
+func legOrder(m market, action string, join bool) (market, string) {
+	if !join {
+		return m, "limit"
+	}
+	maker, ok := makerPrice(m, action)
+	if !ok {
+		return m, "limit"
+	}
+	logger.Infof("%s post-only %s at %.4f vs %.4f\n", m.exg, action, maker.orderPrice, m.orderPrice)
+	return maker, "post_only"
+}
+
+// Return the market priced one step inside the top of the book, where a post-only order rests
+// The adjusted price is moved from the taker to the maker fee
+// Returns false if the exchange doesn't take post-only orders or has no known price step
+func makerPrice(m market, action string) (market, bool) {
+	if exg, ok := m.exg.(exchange.PostOnlyTrader); !ok || !exg.SupportsPostOnly() {
+		return m, false
+	}
+	step := m.exg.PriceStep(m.topPrice)
+	if step <= 0 || m.topPrice <= step || m.orderPrice <= 0 {
+		return m, false
+	}
+	price := m.topPrice - step
+	if action == "sell" {
+		price = m.topPrice + step
+	}
+	// Adjusted prices scale order prices for fees and currency
+	feeRatio := (1 + m.exg.MakerFee()) / (1 + m.exg.TakerFee())
+	if action == "sell" {
+		feeRatio = (1 - m.exg.MakerFee()) / (1 - m.exg.TakerFee())
+	}
+	m.adjPrice *= price / m.orderPrice * feeRatio
+	m.orderPrice = price
+	return m, true
+}
+
+// Update P&L and record the trade in the ledger
+func updatePL(m market, action string, requested, filled float64) {
+	amount := filled
 	if action == "buy" {
 		amount = -amount
 	}
-	pl += price * amount
+	pl += m.adjPrice * amount
+	symbolPL[symbolOf(m.exg)] += m.adjPrice * amount
+	venue := exchangePL[exchangeKey(m.exg)]
+	venue.USD += m.adjPrice * amount
+	venue.Native += m.adjPrice * m.fxPrice * amount
+	exchangePL[exchangeKey(m.exg)] = venue
+	plGauge.Set(pl)
+	if filled > 0 {
+		tradesCounter.Inc()
+		lastTrade = clock.Now()
+	}
+
+	if trades != nil {
+		err := trades.Append(Trade{
+			Time:       clock.Now(),
+			Exchange:   exchangeKey(m.exg),
+			Action:     action,
+			Requested:  requested,
+			Filled:     filled,
+			OrderPrice: m.orderPrice,
+			AdjPrice:   m.adjPrice,
+			PL:         pl,
+		})
+		isError(err)
+	}
 }
 
-// Handle communication for a FOK order
+// Handle communication for a FOK order, killed once it has rested for TimeInForce
 func fillOrKill(exg exchange.Interface, action string, amount, price float64, fillChan chan<- float64) {
+	placeOrder(exg, action, "limit", amount, price, fillChan)
+}
+
+// Send an order of otype, cancel any remainder, and send the filled amount on fillChan
+// In dry run mode the order is logged and treated as fully filled at the order price
+func placeOrder(exg exchange.Interface, action, otype string, amount, price float64, fillChan chan<- float64) {
 	var (
-		id    int64
-		err   error
-		order exchange.Order
+		last exchange.Order // Last successfully retrieved status
+		err  error
 	)
-
-	// Send order
-	id, err = exg.SendOrder(action, "limit", amount, price)
-	if isError(err) || id == 0 {
+	// Round to the exchange's lot and tick sizes, skipping orders rounded to nothing or otherwise invalid
+	// These are no-ops rather than errors, so they don't count toward breakers or notify
+	amount, price = exg.RoundOrder(amount, price)
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		logger.Warnf("%s %s order not sent: %s\n", exg, action, err)
 		fillChan <- 0
 		return
 	}
-
-	// Check status and cancel if necessary
-	for {
-		order, err = exg.GetOrderStatus(id)
-		isError(err)
-		if order.Status == "live" {
-			_, err = exg.CancelOrder(id)
-			isError(err)
-		} else if order.Status == "dead" {
-			break
+	borrower, borrows := exg.(exchange.Borrower)
+	if cfg.Sec.DryRun {
+		logger.Infof("DRY RUN %s order: %s %.4f at %.4f\n", exg, action, amount, price)
+		last = exchange.Order{FilledAmount: amount, Status: "dead"}
+	} else if borrows && action == "sell" && exchangeError(exg, borrowFor(borrower, amount)) {
+		notifier.Notify(fmt.Sprintf("%s sell of %.4f not sent: borrowing failed", exg, amount))
+		fillChan <- 0
+		return
+	} else if last, err = executeOrder(exg, action, otype, amount, price); exchangeError(exg, err) {
+		recordFillRate(exg, amount, last.FilledAmount)
+		notifier.Notify(fmt.Sprintf("%s %s order of %.4f at %.4f failed: %s", exg, action, amount, price, err))
+		// An order abandoned at the deadline keeps what it was last known to have filled
+		if last.FilledAmount <= 0 {
+			fillChan <- 0
+			return
 		}
-		// Continues while order status is empty
+	} else {
+		recordFillRate(exg, amount, last.FilledAmount)
 	}
 
-	filledAmount := order.FilledAmount
-
-	// Update position
+	// Update position, net of any fee taken in cryptocurrency
+	// Funds are spent or returned at the order price, short capacity moves with the position
+	filledAmount := exg.FeeAdjustedFill(action, last.FilledAmount)
+	value := last.FilledAmount * price
 	if action == "buy" {
-		if exg.HasCryptoFee() {
-			filledAmount = filledAmount * (1 - exg.Fee())
-		}
 		exg.SetPosition(exg.Position() + filledAmount)
+		exg.SetAvailFunds(exg.AvailFunds() - value)
 	} else {
 		exg.SetPosition(exg.Position() - filledAmount)
+		exg.SetAvailFunds(exg.AvailFunds() + value)
 	}
 	// Print to log
-	log.Printf("%s trade: %s %.4f at %.4f\n", exg, action, order.FilledAmount, price)
+	logger.Infof("%s trade: %s %.4f at %.4f\n", exg, action, last.FilledAmount, price)
 
 	fillChan <- filledAmount
+
+	// Loans covered by a buy are repaid once the fill is reported
+	if borrows && action == "buy" && !cfg.Sec.DryRun && filledAmount > 0 {
+		ctx, cancel := orderContext()
+		defer cancel()
+		exchangeError(exg, borrower.RepayExcess(ctx))
+	}
+}
+
+// Borrow what a sell of amount needs, within the order deadline
+func borrowFor(borrower exchange.Borrower, amount float64) error {
+	ctx, cancel := orderContext()
+	defer cancel()
+	return borrower.EnsureBorrowed(ctx, amount)
+}
+
+// Send an order and check status until dead, cancelling once if still live
+// Limit orders are left to rest for TimeInForce and post-only orders for MakerWait before the cancel
+// MaxStatusWait and OrderTimeout still apply, so they must exceed both
+// Gives up after MaxStatusWait, cancelling the order, and returns the last known status with an error
+func executeOrder(exg exchange.Interface, action, otype string, amount, price float64) (exchange.Order, error) {
+	// Deadline for the whole order so a stuck exchange can't block the arb loop
+	ctx, cancel := orderContext()
+	defer cancel()
+
+	// Send order, giving up if the retry budget is exhausted
+	id, err := sendOrder(ctx, exg, action, otype, amount, price)
+	if err != nil {
+		return exchange.Order{}, err
+	}
+	var rest time.Duration
+	switch otype {
+	case "limit":
+		rest = time.Duration(cfg.Sec.TimeInForce * float64(time.Second))
+	case "post_only":
+		rest = time.Duration(cfg.Sec.MakerWait * float64(time.Second))
+	}
+
+	statusCtx, statusCancel := statusContext(ctx)
+	defer statusCancel()
+	pollDelay := time.Duration(cfg.Sec.StatusPollDelay * float64(time.Second))
+	last, err := exchange.PollOrder(statusCtx, clock, exg, id, rest, pollDelay, func(err error) { exchangeError(exg, err) })
+	recordLatency(exg, "order status")
+	return last, err
+}
+
+// Send an order, retrying failures with increasing delay up to OrderRetries times
+// A rate limited retry waits at least as long as the exchange asked
+// Invalid orders, rejections, and insufficient funds aren't retried, as they would fail the same way
+// Nor are orders of unknown outcome, which may already have reached the exchange
+// Gives up early at the order deadline
+func sendOrder(ctx context.Context, exg exchange.Interface, action, otype string, amount, price float64) (int64, error) {
+	backoff := exchange.Backoff{Min: 250 * time.Millisecond, Max: 4 * time.Second}
+	for attempt := 0; ; attempt++ {
+		id, err := exg.SendOrder(ctx, action, otype, amount, price)
+		if latency, ok := recordLatency(exg, "SendOrder"); ok {
+			recordSendLatency(exg, latency)
+		}
+		if err == nil && id == 0 {
+			err = fmt.Errorf("%s SendOrder error: no order ID returned", exg)
+		}
+		if err == nil {
+			return id, nil
+		}
+		if errors.Is(err, exchange.ErrInvalidOrder) || errors.Is(err, exchange.ErrOrderRejected) || errors.Is(err, exchange.ErrInsufficientFunds) ||
+			errors.Is(err, exchange.ErrUnknownOutcome) {
+			return 0, err
+		}
+		if attempt >= cfg.Sec.OrderRetries {
+			return 0, fmt.Errorf("%w after %d attempts", err, attempt+1)
+		}
+		logger.Warnf("%s", err)
+		// Rate limited exchanges are given at least the wait they asked for
+		wait := backoff.Next()
+		if retry, limited := exchange.RetryAfter(err); limited && retry > wait {
+			wait = retry
+		}
+		select {
+		case <-clock.After(wait):
+		case <-ctx.Done():
+			return 0, fmt.Errorf("%s, abandoned at deadline", err)
+		}
+	}
+}
+
+// Return a context for checking order status, with a deadline if MaxStatusWait is set
+func statusContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cfg.Sec.MaxStatusWait > 0 {
+		return context.WithTimeout(ctx, time.Duration(cfg.Sec.MaxStatusWait*float64(time.Second)))
+	}
+	return context.WithCancel(ctx)
+}
+
+// Return a context for a single order, with a deadline if OrderTimeout is set
+func orderContext() (context.Context, context.CancelFunc) {
+	if cfg.Sec.OrderTimeout > 0 {
+		return context.WithTimeout(context.Background(), time.Duration(cfg.Sec.OrderTimeout*float64(time.Second)))
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Cancel any orders left live on the exchanges
+// Prevents orphaned orders filling after exit
+func cancelOpenOrders() {
+	for _, exg := range exchanges {
+		ctx, cancel := orderContext()
+		orders, err := exg.OpenOrders(ctx)
+		if !isError(err) {
+			for _, order := range orders {
+				if _, err := exg.CancelOrder(ctx, order.ID); !isError(err) {
+					logger.Warnf("%s order %d cancelled on shutdown: %s %.4f at %.4f, %.4f filled\n",
+						exg, order.ID, order.Side, order.Amount, order.Price, order.FilledAmount)
+				}
+			}
+		}
+		cancel()
+	}
 }
 
 // Print relevant data to terminal
+// As one JSON line if OutputFormat is json
 func printResults() {
+	if cfg.Sec.OutputFormat == "json" {
+		isError(writeResults(os.Stdout))
+		return
+	}
 	clearScreen()
 
 	fmt.Println("        Positions:")
 	fmt.Println("--------------------------")
 	for _, exg := range exchanges {
-		fmt.Printf("%-13s %10.2f\n", exg, exg.Position())
+		fmt.Printf("%-13s %10.2f\n", exchangeKey(exg), exg.Position())
 	}
 	fmt.Println("--------------------------")
-	fmt.Printf("\nRun P&L: $%.2f\n", pl)
+	fmt.Printf("\nRealized P&L: $%.2f, unrealized $%.2f\n", pl, unrealizedPL(exchanges))
+	if len(symbols) > 1 {
+		_, groups := groupBySymbol(exchanges)
+		for _, symbol := range symbols {
+			fmt.Printf("%s P&L: $%.2f, unrealized $%.2f\n", symbol, symbolPL[symbol], unrealizedPL(groups[symbol]))
+		}
+	}
+	fmt.Println("\n       P&L by exchange:       Unrealized")
+	fmt.Println("----------------------------------------------------")
+	for _, exg := range exchanges {
+		venue := exchangePL[exchangeKey(exg)]
+		fmt.Printf("%-13s $%10.2f %11.2f %s $%10.2f\n", exchangeKey(exg), venue.USD, venue.Native, strings.ToUpper(exg.Currency()), unrealizedPL([]exchange.Interface{exg}))
+	}
+	fmt.Println("----------------------------------------------------")
+}
+
+// Results printed as JSON, with exchanges keyed by name and prefixed with the symbol when more than one is traded
+type results struct {
+	Time         time.Time          `json:"time"`
+	Positions    map[string]float64 `json:"positions"`
+	NetPosition  float64            `json:"netPosition"`  // Sum across symbols, the net position for one symbol
+	NetPositions map[string]float64 `json:"netPositions"` // By symbol
+	PL           float64            `json:"pl"`
+	UnrealizedPL float64            `json:"unrealizedPL"`
+	SymbolPL     map[string]float64 `json:"symbolPL"`
+	ExchangePL   map[string]venuePL `json:"exchangePL"`
+}
+
+// Write the results as a single line of JSON
+func writeResults(w io.Writer) error {
+	r := results{
+		Time:         clock.Now(),
+		Positions:    make(map[string]float64),
+		NetPositions: make(map[string]float64),
+		PL:           pl,
+		UnrealizedPL: unrealizedPL(exchanges),
+		SymbolPL:     make(map[string]float64),
+		ExchangePL:   make(map[string]venuePL),
+	}
+	for _, exg := range exchanges {
+		r.Positions[exchangeKey(exg)] = exg.Position()
+		r.ExchangePL[exchangeKey(exg)] = exchangePL[exchangeKey(exg)]
+	}
+	for symbol, net := range netPositions {
+		r.NetPosition += net
+		r.NetPositions[symbol] = net
+	}
+	for symbol, symbolTotal := range symbolPL {
+		r.SymbolPL[symbol] = symbolTotal
+	}
+	return json.NewEncoder(w).Encode(r)
 }
 
 // Clear the terminal between prints
+// With PlainOutput set, or stdout not a terminal, prints are appended below a timestamp instead
 func clearScreen() {
-	c := exec.Command("clear")
-	c.Stdout = os.Stdout
-	c.Run()
+	if cfg.Sec.PlainOutput || !terminal.IsTerminal(os.Stdout) {
+		fmt.Printf("\n%s\n", clock.Now().Format("2006-01-02 15:04:05"))
+		return
+	}
+	terminal.Clear()
 }
 
 // Called on any error
 func isError(err error) bool {
 	if err != nil {
-		log.Println(err)
+		logger.Errorf("%s", err)
 		return true
 	}
 	return false
@@ -571,26 +1780,13 @@ func isError(err error) bool {
 
 // Save status to file
 func saveStatus() {
-	file, err := os.Create("status.csv")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer file.Close()
-	status := make([]string, len(exchanges)+1)
-	for i, exg := range exchanges {
-		status[i] = fmt.Sprintf("%f", exg.Position())
-	}
-	status[len(exchanges)] = fmt.Sprintf("%f", pl)
-	writer := csv.NewWriter(file)
-	err = writer.Write(status)
-	if err != nil {
+	if err := writeState("status.json", currentState()); err != nil {
 		log.Fatal(err)
 	}
-	writer.Flush()
 }
 
 // Close log file on exit
 func closeLogFile() {
-	log.Println("Ending run")
+	logger.Infof("Ending run")
 	logFile.Close()
 }
@@ -3,9 +3,20 @@ package main
 import (
 	"bitfx/bitfinex"
 	"bitfx/exchange"
+	"bitfx/exchangetest"
+	"bitfx/forex"
+	"bitfx/notify"
 	"bitfx/okcoin"
+	"bitfx/sim"
+	"context"
+	"errors"
+	"fmt"
 	"math"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -14,6 +25,57 @@ func init() {
 	cfg.Sec.FXPremium = .01
 	cfg.Sec.MinOrder = 25
 	cfg.Sec.MaxOrder = 50
+	cfg.Sec.MinSecondLeg = -1
+}
+
+// Returns an OKCoin client without credentials
+func testOKCoin(t *testing.T, currency string, priority int) *okcoin.Client {
+	t.Helper()
+	exg, err := okcoin.New("", "", "", currency, priority, 0.002, 500, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exg
+}
+
+// Returns a Bitfinex client without credentials
+func testBitfinex(t *testing.T) *bitfinex.Client {
+	t.Helper()
+	exg, err := bitfinex.New("", "", "", "usd", 2, 0.001, 500, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exg
+}
+
+// Constructor errors are returned instead of exiting
+func TestNewExchanges(t *testing.T) {
+	t.Setenv("KRAKEN_KEY", "key")
+	t.Setenv("KRAKEN_SECRET", "")
+	if _, err := newExchanges("btc"); err == nil || !strings.Contains(err.Error(), "Kraken(usd) New error") {
+		t.Fatalf("Expected Kraken credentials error, got %v", err)
+	}
+	// A bad ticker mapping fails before any client is created
+	defer func(tickers string) { cfg.Sec.TickersGDAX = tickers }(cfg.Sec.TickersGDAX)
+	cfg.Sec.TickersGDAX = "btc"
+	if _, err := newExchanges("btc"); err == nil || !strings.Contains(err.Error(), "Bad ticker mapping") {
+		t.Fatalf("Expected ticker mapping error, got %v", err)
+	}
+}
+
+func TestForeignCurrencies(t *testing.T) {
+	exgs := []exchange.Interface{
+		sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil),
+		sim.New("Test2", "btc", "eur", 1, 0, 10, 10000, nil),
+		sim.New("Test3", "btc", "cny", 1, 0, 10, 10000, nil),
+		sim.New("Test4", "btc", "eur", 1, 0, 10, 10000, nil),
+	}
+	if got := foreignCurrencies(exgs); strings.Join(got, ",") != "eur,cny" {
+		t.Fatalf("Expected eur and cny quotes, got %v", got)
+	}
+	if exgs[1].CurrencyCode() == exgs[0].CurrencyCode() || exgs[1].CurrencyCode() == exgs[2].CurrencyCode() {
+		t.Fatal("EUR should have its own currency code")
+	}
 }
 
 type neededArb struct {
@@ -35,9 +97,9 @@ func TestCalculateNeededArb(t *testing.T) {
 		{-200, 0, .002},
 		{-100, 100, .002},
 	}
-	buyExg := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	buyExg := testOKCoin(t, "usd", 1)
 	buyExg.SetMaxPos(500)
-	sellExg := bitfinex.New("", "", "", "usd", 2, 0.001, 500, 0)
+	sellExg := testBitfinex(t)
 	sellExg.SetMaxPos(500)
 
 	for _, neededArb := range neededArbTests {
@@ -63,9 +125,9 @@ func TestCalculateNeededArb(t *testing.T) {
 		{-200, 0, .012},
 		{-100, 100, .012},
 	}
-	buyExg = okcoin.New("", "", "", "cny", 1, 0.002, 500, 0)
+	buyExg = testOKCoin(t, "cny", 1)
 	buyExg.SetMaxPos(500)
-	sellExg = bitfinex.New("", "", "", "usd", 2, 0.001, 500, 0)
+	sellExg = testBitfinex(t)
 	sellExg.SetMaxPos(500)
 
 	for _, neededArb := range neededArbTests {
@@ -81,7 +143,7 @@ func TestCalculateNeededArb(t *testing.T) {
 
 func TestFilterBook(t *testing.T) {
 	testBook := exchange.Book{
-		Exg: okcoin.New("", "", "", "usd", 1, 0.002, 500, 0),
+		Exg: testOKCoin(t, "usd", 1),
 		Bids: exchange.BidItems{
 			0: {Price: 1.90, Amount: 10},
 			1: {Price: 1.80, Amount: 10},
@@ -140,7 +202,7 @@ func TestFilterBook(t *testing.T) {
 	}
 
 	testBook = exchange.Book{
-		Exg: okcoin.New("", "", "", "usd", 2, 0.002, 500, 0),
+		Exg: testOKCoin(t, "usd", 2),
 		Bids: exchange.BidItems{
 			0: {Price: 1.90, Amount: 30},
 			1: {Price: 1.80, Amount: 10},
@@ -199,13 +261,305 @@ func TestFilterBook(t *testing.T) {
 	}
 }
 
+func TestSampleDepth(t *testing.T) {
+	defer func(depth float64) { cfg.Sec.SampleDepth = depth }(cfg.Sec.SampleDepth)
+	testBook := exchange.Book{
+		Exg: testOKCoin(t, "usd", 1),
+		Bids: exchange.BidItems{
+			0: {Price: 1.90, Amount: 10},
+			1: {Price: 1.80, Amount: 10},
+			2: {Price: 1.70, Amount: 100},
+		},
+		Asks: exchange.AskItems{
+			0: {Price: 2.10, Amount: 10},
+			1: {Price: 2.20, Amount: 20},
+			2: {Price: 2.30, Amount: 10},
+		},
+	}
+	testBook.Exg.SetMaxPos(500)
+	cfg.Sec.SampleDepth = 0
+	market := filterBook(testBook, 1)
+	if market.bid.availBeyond != 0 || market.ask.availBeyond != 0 {
+		t.Errorf("Sampled liquidity with SampleDepth unset")
+	}
+	cfg.Sec.SampleDepth = 100
+	market = filterBook(testBook, 1)
+	// Bids sampled to 100 past an order of 50
+	if math.Abs(market.bid.availBeyond-50) > .000001 {
+		t.Errorf("Wrong bid liquidity past the order: %v", market.bid.availBeyond)
+	}
+	// Asks total 40 past an order of 30
+	if math.Abs(market.ask.availBeyond-10) > .000001 {
+		t.Errorf("Wrong ask liquidity past the order: %v", market.ask.availBeyond)
+	}
+	// Order amounts and prices are still capped by MaxOrder
+	if math.Abs(market.bid.amount-50) > .000001 || math.Abs(market.ask.amount-30) > .000001 {
+		t.Errorf("SampleDepth changed order amounts")
+	}
+}
+
+func TestBookDepth(t *testing.T) {
+	defer func(depth int) { cfg.Sec.BookDepth = depth }(cfg.Sec.BookDepth)
+	testBook := exchange.Book{
+		Exg: testOKCoin(t, "usd", 1),
+		Bids: exchange.BidItems{
+			0: {Price: 1.90, Amount: 10},
+			1: {Price: 1.80, Amount: 10},
+			2: {Price: 1.70, Amount: 100},
+		},
+		Asks: exchange.AskItems{
+			0: {Price: 2.10, Amount: 10},
+			1: {Price: 2.20, Amount: 20},
+			2: {Price: 2.30, Amount: 10},
+		},
+	}
+	testBook.Exg.SetMaxPos(500)
+	cfg.Sec.BookDepth = 0
+	fb := filterBook(testBook, 1)
+	if fb.bid.short || fb.ask.short {
+		t.Fatal("Full book should reach the minimum order")
+	}
+
+	// Two levels of bids total 20, short of the minimum order of 25
+	cfg.Sec.BookDepth = 2
+	fb = filterBook(testBook, 1)
+	if !fb.bid.short || fb.bid.amount != 0 {
+		t.Errorf("Bids should be short at depth 2: %+v", fb.bid)
+	}
+	if fb.ask.short || math.Abs(fb.ask.amount-30) > .000001 {
+		t.Errorf("Asks should reach 30 at depth 2: %+v", fb.ask)
+	}
+
+	// A short side is not traded, even against an ask it would arb
+	other := sim.New("Other", "btc", "usd", 1, 0, 500, 100000, nil)
+	other.SetMaxPos(500)
+	markets := map[exchange.Interface]filteredBook{
+		testBook.Exg: fb,
+		other:        {bid: market{exg: other, orderPrice: 1, adjPrice: 1, amount: 30}, ask: market{exg: other, orderPrice: 1.01, adjPrice: 1.01, amount: 30}},
+	}
+	if bid := findBestBid(markets); bid.exg != other {
+		t.Errorf("Short bids chosen as best bid")
+	}
+	if _, _, exists := findBestArb(markets); exists {
+		t.Errorf("Arb found against short bids")
+	}
+}
+
+func TestNegativeFee(t *testing.T) {
+	// Level books, each exchange bidding and offering 2
+	level := func(exg exchange.Interface) exchange.Book {
+		return exchange.Book{
+			Exg:  exg,
+			Bids: exchange.BidItems{{Price: 2, Amount: 100}},
+			Asks: exchange.AskItems{{Price: 2, Amount: 100}},
+		}
+	}
+	paying := sim.New("Paying", "btc", "usd", 1, .005, 100, 100000, nil)
+	rebate1 := sim.New("Rebate1", "btc", "usd", 1, -.005, 100, 100000, nil)
+	rebate2 := sim.New("Rebate2", "btc", "usd", 1, -.005, 100, 100000, nil)
+	for _, exg := range []*sim.Client{paying, rebate1, rebate2} {
+		exg.SetMaxPos(100)
+	}
+
+	// A rebate raises the adjusted bid and lowers the adjusted ask, where a fee does the opposite
+	fb := filterBook(level(rebate1), 1)
+	if math.Abs(fb.bid.adjPrice-2.01) > .000001 || math.Abs(fb.ask.adjPrice-1.99) > .000001 {
+		t.Errorf("Expected rebate adjusted bid 2.01 and ask 1.99, got %v and %v", fb.bid.adjPrice, fb.ask.adjPrice)
+	}
+	fb = filterBook(level(paying), 1)
+	if math.Abs(fb.bid.adjPrice-1.99) > .000001 || math.Abs(fb.ask.adjPrice-2.01) > .000001 {
+		t.Errorf("Expected fee adjusted bid 1.99 and ask 2.01, got %v and %v", fb.bid.adjPrice, fb.ask.adjPrice)
+	}
+
+	// Rebates on both legs make an arb of level prices, against the needed arb from positions alone
+	if calcNeededArb(rebate1, rebate2) != calcNeededArb(paying, paying) {
+		t.Errorf("Needed arb should not depend on fees")
+	}
+	markets := map[exchange.Interface]filteredBook{
+		rebate1: filterBook(level(rebate1), 1),
+		rebate2: filterBook(level(rebate2), 1),
+	}
+	bestBid, bestAsk, exists := findBestArb(markets)
+	if !exists || math.Abs(bestBid.adjPrice-bestAsk.adjPrice-.02) > .000001 {
+		t.Errorf("Expected arb of .02 from rebates, got %v", bestBid.adjPrice-bestAsk.adjPrice)
+	}
+	// A fee on one leg cancels a rebate on the other
+	markets = map[exchange.Interface]filteredBook{
+		paying:  filterBook(level(paying), 1),
+		rebate1: filterBook(level(rebate1), 1),
+	}
+	if _, _, exists := findBestArb(markets); exists {
+		t.Errorf("A fee and an equal rebate should leave no arb")
+	}
+}
+
+func TestFXFilterBook(t *testing.T) {
+	testBook := exchange.Book{
+		Exg:  sim.New("Test", "btc", "cny", 1, 0, 500, 0, nil),
+		Time: time.Now(),
+		Bids: exchange.BidItems{{Price: 6.2, Amount: 100}},
+		Asks: exchange.AskItems{{Price: 6.4, Amount: 100}},
+	}
+	testBook.Exg.SetMaxPos(500)
+
+	// A stale quote dates the filtered book
+	fb := fxFilterBook(testBook, forex.Quote{Price: 6.2, Symbol: "cny", Age: 2 * time.Minute})
+	if time.Since(fb.time) < 2*time.Minute {
+		t.Errorf("Filtered book should be as old as the FX quote")
+	}
+	if math.Abs(fb.bid.adjPrice-1) > .000001 {
+		t.Errorf("Wrong FX adjusted bid price")
+	}
+	fb = fxFilterBook(testBook, forex.Quote{Price: 6.2, Symbol: "cny"})
+	if !fb.time.Equal(testBook.Time) {
+		t.Errorf("Filtered book should keep the book time")
+	}
+
+	// Markets without a good quote are stale
+	fb = fxFilterBook(testBook, forex.Quote{Symbol: "cny", Error: errors.New("No quote")})
+	if time.Since(fb.time) < time.Minute || fb.bid.amount != 0 {
+		t.Errorf("Filtered book without FX should be unusable")
+	}
+}
+
+func TestSnapshotStaleness(t *testing.T) {
+	defer func(c exchange.Clock) { clock = c }(clock)
+	now := time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC)
+	manual := exchange.NewManualClock(now)
+	clock = manual
+	testBook := exchange.Book{
+		Exg:  sim.New("Test", "btc", "cny", 1, 0, 500, 10000, nil),
+		Time: now,
+		Bids: exchange.BidItems{{Price: 1550, Amount: 100}},
+		Asks: exchange.AskItems{{Price: 1560, Amount: 100}},
+	}
+	included := func(fb filteredBook) bool {
+		markets := make(map[exchange.Interface]filteredBook)
+		addToSnapshot(markets, testBook.Exg, fb)
+		_, ok := markets[testBook.Exg]
+		return ok
+	}
+
+	// A book is used until it is a minute old
+	fb := fxFilterBook(testBook, forex.Quote{Price: 6.2, Symbol: "cny"})
+	manual.Advance(59 * time.Second)
+	if !included(fb) {
+		t.Fatal("Book under a minute old should be in the snapshot")
+	}
+	manual.Advance(time.Second)
+	if included(fb) {
+		t.Fatal("Book a minute old should be left out of the snapshot")
+	}
+
+	// An FX quote older than the book ages it
+	manual.Set(now)
+	fb = fxFilterBook(testBook, forex.Quote{Price: 6.2, Symbol: "cny", Age: 30 * time.Second})
+	if !included(fb) {
+		t.Fatal("Book with a recent quote should be in the snapshot")
+	}
+	manual.Advance(30 * time.Second)
+	if included(fb) {
+		t.Fatal("Book with a quote a minute old should be left out of the snapshot")
+	}
+	manual.Set(now)
+	if included(fxFilterBook(testBook, forex.Quote{Price: 6.2, Symbol: "cny", Age: time.Minute})) {
+		t.Fatal("Book with a stale quote should be left out of the snapshot")
+	}
+	// Without a quote the book is never used
+	if included(fxFilterBook(testBook, forex.Quote{Symbol: "cny", Error: errors.New("No quote")})) {
+		t.Fatal("Book without a quote should be left out of the snapshot")
+	}
+}
+
+func TestFXRequestAfterStop(t *testing.T) {
+	defer func(c []string, timeout time.Duration) { currencies, fxRequestTimeout = c, timeout }(currencies, fxRequestTimeout)
+	currencies = nil
+	requestFX := make(chan string)
+	receiveFX := make(chan forex.Quote)
+	fxDoneChan := make(chan bool, 1)
+	fxStopped := make(chan bool)
+	go func() {
+		handleFX(requestFX, receiveFX, fxDoneChan)
+		close(fxStopped)
+	}()
+	fx := fxRequester{requestFX, receiveFX, fxStopped}
+	if quote := fx.quote("usd"); quote.Error != nil || quote.Price != 1 {
+		t.Fatalf("Expected USD quote of 1, got %v", quote)
+	}
+
+	// A book arriving after FX handling stops gets an unusable quote instead of blocking
+	fxDoneChan <- true
+	<-fxStopped
+	if quote := fx.quote("usd"); quote.Error == nil {
+		t.Fatal("Expected error after FX handling stopped")
+	}
+
+	// An unanswered request times out
+	fxRequestTimeout = 10 * time.Millisecond
+	fx = fxRequester{make(chan string), make(chan forex.Quote), make(chan bool)}
+	if quote := fx.quote("cny"); quote.Error == nil || quote.Symbol != "cny" {
+		t.Fatalf("Expected timed out cny quote, got %v", quote)
+	}
+}
+
+func TestBookRequestAfterStop(t *testing.T) {
+	exg := sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil)
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	stopped := make(chan bool)
+	go func() {
+		for range requestBook {
+			receiveBook <- filteredBook{bid: market{exg: exg, orderPrice: 250}}
+		}
+	}()
+	books := bookRequester{requestBook, receiveBook, stopped}
+	if fb, ok := books.book(exg); !ok || fb.bid.orderPrice != 250 {
+		t.Fatalf("Expected the book from handleData, got %v", fb)
+	}
+
+	// A request after handleData stops is refused instead of blocking
+	close(requestBook)
+	books.requestBook = make(chan exchange.Interface)
+	close(stopped)
+	if _, ok := books.book(exg); ok {
+		t.Fatal("Expected no book after handleData stopped")
+	}
+}
+
+func TestNotionalMaxPos(t *testing.T) {
+	defer func(notional float64) { cfg.Sec.MaxNotional = notional }(cfg.Sec.MaxNotional)
+	testBook := exchange.Book{
+		Exg:  sim.New("Test", "btc", "cny", 1, 0, 500, 0, nil),
+		Time: time.Now(),
+		Bids: exchange.BidItems{{Price: 6.2, Amount: 100}},
+		Asks: exchange.AskItems{{Price: 6.4, Amount: 100}},
+	}
+	quote := forex.Quote{Price: 6.3, Symbol: "cny"}
+
+	// Fixed coin limits when not set
+	cfg.Sec.MaxNotional = 0
+	if fb := fxFilterBook(testBook, quote); fb.maxPos != 0 {
+		t.Errorf("Should not set a notional limit")
+	}
+	// Mid of 1 USD
+	cfg.Sec.MaxNotional = 300
+	if fb := fxFilterBook(testBook, quote); math.Abs(fb.maxPos-300) > .000001 {
+		t.Errorf("Expected max position of 300, got %f", fb.maxPos)
+	}
+	// Limit shrinks as price rises
+	testBook.Bids[0].Price, testBook.Asks[0].Price = 12.5, 12.7
+	if fb := fxFilterBook(testBook, quote); math.Abs(fb.maxPos-150) > .000001 {
+		t.Errorf("Expected max position of 150, got %f", fb.maxPos)
+	}
+}
+
 func TestFindBestBid(t *testing.T) {
 	markets := make(map[exchange.Interface]filteredBook)
-	exg1 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg1 := testOKCoin(t, "usd", 1)
 	exg1.SetMaxPos(500)
-	exg2 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg2 := testOKCoin(t, "usd", 1)
 	exg2.SetMaxPos(500)
-	exg3 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg3 := testOKCoin(t, "usd", 1)
 	exg2.SetMaxPos(500)
 	markets[exg1] = filteredBook{bid: market{adjPrice: 2.00, amount: 500}}
 	markets[exg2] = filteredBook{bid: market{adjPrice: 1.99}}
@@ -225,11 +579,11 @@ func TestFindBestBid(t *testing.T) {
 
 func TestFindBestAsk(t *testing.T) {
 	markets := make(map[exchange.Interface]filteredBook)
-	exg1 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg1 := testOKCoin(t, "usd", 1)
 	exg1.SetMaxPos(500)
-	exg2 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg2 := testOKCoin(t, "usd", 1)
 	exg2.SetMaxPos(500)
-	exg3 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg3 := testOKCoin(t, "usd", 1)
 	exg2.SetMaxPos(500)
 	markets[exg1] = filteredBook{ask: market{adjPrice: 1.98, amount: 500}}
 	markets[exg2] = filteredBook{ask: market{adjPrice: 1.99}}
@@ -247,14 +601,48 @@ func TestFindBestAsk(t *testing.T) {
 	}
 }
 
+func TestFindBestCapital(t *testing.T) {
+	markets := make(map[exchange.Interface]filteredBook)
+	exg := sim.New("Test", "btc", "usd", 1, 0, 40, 7500, nil)
+	exg.SetMaxPos(500)
+	markets[exg] = filteredBook{
+		bid: market{exg: exg, orderPrice: 240, adjPrice: 240, amount: 50},
+		ask: market{exg: exg, orderPrice: 250, adjPrice: 250, amount: 50},
+	}
+
+	// Buys are capped by the funds left and sells by crypto available to short
+	if amount := findBestAsk(markets).amount; math.Abs(amount-30) > .000001 {
+		t.Errorf("Expected buy of 30 from funds, got %f", amount)
+	}
+	if amount := findBestBid(markets).amount; math.Abs(amount-40) > .000001 {
+		t.Errorf("Expected sell of 40 from short capacity, got %f", amount)
+	}
+
+	// A long position adds to what can be sold
+	exg.SetPosition(5)
+	if amount := findBestBid(markets).amount; math.Abs(amount-45) > .000001 {
+		t.Errorf("Expected sell of 45 with long position, got %f", amount)
+	}
+
+	// Updated funds are used, and below the minimum order there is no ask
+	exg.SetAvailFunds(10000)
+	if amount := findBestAsk(markets).amount; math.Abs(amount-40) > .000001 {
+		t.Errorf("Expected buy of 40 after funds update, got %f", amount)
+	}
+	exg.SetAvailFunds(6000)
+	if findBestAsk(markets).exg != nil {
+		t.Error("Should not buy with funds below the minimum order")
+	}
+}
+
 func TestFindBestArb(t *testing.T) {
 	// No opportunity
 	markets := make(map[exchange.Interface]filteredBook)
-	exg1 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg1 := testOKCoin(t, "usd", 1)
 	exg1.SetMaxPos(500)
-	exg2 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg2 := testOKCoin(t, "usd", 1)
 	exg2.SetMaxPos(500)
-	exg3 := okcoin.New("", "", "", "usd", 1, 0.002, 500, 0)
+	exg3 := testOKCoin(t, "usd", 1)
 	exg3.SetMaxPos(500)
 	markets[exg1] = filteredBook{
 		bid: market{adjPrice: 1.98, amount: 50, exg: exg1},
@@ -313,3 +701,1249 @@ func TestFindBestArb(t *testing.T) {
 		t.Errorf("Best bid exchange should have changed")
 	}
 }
+
+func TestFindBestArbCapital(t *testing.T) {
+	markets := make(map[exchange.Interface]filteredBook)
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 35, 100000, nil)
+	exg1.SetMaxPos(500)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 500, 48000, nil)
+	exg2.SetMaxPos(500)
+	markets[exg1] = filteredBook{
+		bid: market{exg: exg1, orderPrice: 260, adjPrice: 260, amount: 50},
+		ask: market{exg: exg1, orderPrice: 261, adjPrice: 261, amount: 50},
+	}
+	markets[exg2] = filteredBook{
+		bid: market{exg: exg2, orderPrice: 1590, adjPrice: 249, amount: 50},
+		ask: market{exg: exg2, orderPrice: 1600, adjPrice: 250, amount: 50},
+	}
+
+	// CNY funds are divided by the CNY order price, not the USD adjusted price
+	bestBid, bestAsk, exists := findBestArb(markets)
+	if !exists || bestBid.exg != exg1 || bestAsk.exg != exg2 {
+		t.Fatal("Should be an arb opportunity")
+	}
+	if math.Abs(bestAsk.amount-30) > .000001 {
+		t.Errorf("Expected buy of 30 from CNY funds, got %f", bestAsk.amount)
+	}
+	if math.Abs(bestBid.amount-35) > .000001 {
+		t.Errorf("Expected sell of 35 from short capacity, got %f", bestBid.amount)
+	}
+
+	// No opportunity once funds are below the minimum order
+	exg2.SetAvailFunds(32000)
+	if _, _, exists := findBestArb(markets); exists {
+		t.Error("Should be no arb opportunity without funds")
+	}
+}
+
+// Reference nested loop over every pair of distinct exchanges
+func bruteForceArb(markets map[exchange.Interface]filteredBook) (float64, bool) {
+	var bestOpp float64
+	var exists bool
+	for exg1, fb1 := range markets {
+		for exg2, fb2 := range markets {
+			if exg1 == exg2 || exg1.Position()+exg1.MaxPos() < cfg.Sec.MinOrder || exg2.MaxPos()-exg2.Position() < cfg.Sec.MinOrder {
+				continue
+			}
+			if opp := fb1.bid.adjPrice - fb2.ask.adjPrice - calcNeededArb(exg2, exg1); opp >= bestOpp {
+				bestOpp = opp
+				exists = true
+			}
+		}
+	}
+	return bestOpp, exists
+}
+
+// Returns markets for six exchanges in two currencies with random prices and positions
+func sixMarkets(r *rand.Rand) map[exchange.Interface]filteredBook {
+	markets := make(map[exchange.Interface]filteredBook)
+	for i, currency := range []string{"usd", "usd", "usd", "cny", "cny", "usd"} {
+		exg := sim.New(fmt.Sprintf("Test%d", i), "btc", currency, 1, 0, 500, 0, nil)
+		exg.SetMaxPos(500)
+		exg.SetPosition(float64(r.Intn(1001) - 500))
+		mid := 2 + r.Float64()*.04
+		markets[exg] = filteredBook{
+			bid: market{adjPrice: mid - .005, amount: 50, exg: exg},
+			ask: market{adjPrice: mid + .005, amount: 50, exg: exg},
+		}
+	}
+	return markets
+}
+
+func TestFindBestArbSixExchanges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	found := 0
+	for i := 0; i < 1000; i++ {
+		markets := sixMarkets(r)
+		wantOpp, wantExists := bruteForceArb(markets)
+		bestBid, bestAsk, exists := findBestArb(markets)
+		if exists != wantExists {
+			t.Fatalf("Case %d: exists %t, want %t", i, exists, wantExists)
+		}
+		if !exists {
+			continue
+		}
+		found++
+		if bestBid.exg == bestAsk.exg {
+			t.Fatalf("Case %d: paired an exchange with itself", i)
+		}
+		opp := bestBid.adjPrice - bestAsk.adjPrice - calcNeededArb(bestAsk.exg, bestBid.exg)
+		if math.Abs(opp-wantOpp) > .000001 {
+			t.Fatalf("Case %d: opportunity %.6f, want %.6f", i, opp, wantOpp)
+		}
+	}
+	if found == 0 {
+		t.Fatal("Test cases should include opportunities")
+	}
+}
+
+func BenchmarkFindBestArb(b *testing.B) {
+	markets := sixMarkets(rand.New(rand.NewSource(1)))
+	for i := 0; i < b.N; i++ {
+		findBestArb(markets)
+	}
+}
+
+func BenchmarkBruteForceArb(b *testing.B) {
+	markets := sixMarkets(rand.New(rand.NewSource(1)))
+	for i := 0; i < b.N; i++ {
+		bruteForceArb(markets)
+	}
+}
+
+// Exchange failing a set number of orders before accepting them
+type failingExchange struct {
+	*sim.Client
+	failures int
+}
+
+func (exg *failingExchange) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if exg.failures > 0 {
+		exg.failures--
+		return 0, errors.New("SendOrder error: unavailable")
+	}
+	return exg.Client.SendOrder(ctx, action, otype, amount, price)
+}
+
+func TestSendOrderRetries(t *testing.T) {
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 249, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 10}},
+	}}
+	defer func(retries int) { cfg.Sec.OrderRetries = retries }(cfg.Sec.OrderRetries)
+	cfg.Sec.OrderRetries = 1
+
+	// Succeeds within the budget
+	exg := &failingExchange{sim.New("Test", "btc", "usd", 1, 0, 10, 10000, books), 1}
+	if id, err := sendOrder(context.Background(), exg, "buy", "limit", 1, 251); err != nil || id == 0 {
+		t.Fatal("Order should succeed on retry")
+	}
+
+	// Fails after the budget and reports nothing filled
+	exg = &failingExchange{sim.New("Test", "btc", "usd", 1, 0, 10, 10000, books), 2}
+	if _, err := sendOrder(context.Background(), exg, "buy", "limit", 1, 251); err == nil {
+		t.Fatal("Order should fail after the retry budget")
+	}
+	exg.failures = 2
+	fillChan := make(chan float64)
+	go fillOrKill(exg, "buy", 1, 251, fillChan)
+	if filled := <-fillChan; filled != 0 {
+		t.Fatal("Failed order should report nothing filled")
+	}
+
+	// Gives up at the deadline
+	exg.failures = 2
+	cfg.Sec.OrderRetries = 10
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := sendOrder(ctx, exg, "buy", "limit", 1, 251); err == nil || time.Since(start) > time.Second {
+		t.Fatal("Order should be abandoned at the deadline")
+	}
+}
+
+func TestSendOrderRejected(t *testing.T) {
+	defer func(retries int) { cfg.Sec.OrderRetries = retries }(cfg.Sec.OrderRetries)
+	cfg.Sec.OrderRetries = 3
+
+	// Sent once, keeping the kind for callers
+	exg := exchangetest.New("Test", 1)
+	exg.SendOrderFunc = func(ctx context.Context, order exchangetest.SentOrder) (int64, error) {
+		return 0, fmt.Errorf("SendOrder error: %w", exchange.NewError(exchange.ErrOrderRejected, "Invalid order: minimum size is 0.01"))
+	}
+	if _, err := sendOrder(context.Background(), exg, "buy", "limit", 1, 251); !errors.Is(err, exchange.ErrOrderRejected) || len(exg.Orders()) != 1 {
+		t.Fatalf("Rejected order should not be retried, sent %d times with %v", len(exg.Orders()), err)
+	}
+}
+
+func TestSendOrderUnknownOutcome(t *testing.T) {
+	defer func(retries int) { cfg.Sec.OrderRetries = retries }(cfg.Sec.OrderRetries)
+	cfg.Sec.OrderRetries = 3
+
+	// An order that may have reached the exchange isn't sent again
+	for _, test := range []struct {
+		name string
+		err  error
+	}{
+		{"socket disconnect", exchange.NewError(exchange.ErrUnknownOutcome, "WebSocket disconnected after the order was sent")},
+		{"post-write timeout", exchange.NewError(exchange.ErrUnknownOutcome, "read timeout")},
+		{"REST transport error", exchange.SentError(exchange.ConnectionError(errors.New("read: connection reset by peer")))},
+	} {
+		exg := exchangetest.New("Test", 1)
+		exg.SendOrderFunc = func(ctx context.Context, order exchangetest.SentOrder) (int64, error) {
+			return 0, fmt.Errorf("SendOrder error: %w", test.err)
+		}
+		if _, err := sendOrder(context.Background(), exg, "buy", "limit", 1, 251); !errors.Is(err, exchange.ErrUnknownOutcome) || len(exg.Orders()) != 1 {
+			t.Errorf("%s: order of unknown outcome should not be retried, sent %d times with %v", test.name, len(exg.Orders()), err)
+		}
+	}
+}
+
+func TestPlaceOrderInvalid(t *testing.T) {
+	savedBreakers := breakers
+	defer func(n int) { cfg.Sec.BreakerErrors, breakers = n, savedBreakers }(cfg.Sec.BreakerErrors)
+	cfg.Sec.BreakerErrors = 1
+	breakers = make(map[exchange.Interface]*breaker)
+
+	// Not sent, reported unfilled, and not counted as an exchange error
+	exg := &countingExchange{Client: sim.New("Test", "btc", "usd", 1, 0, 100, 100000, nil)}
+	for _, order := range [][2]float64{{0, 251}, {-1, 251}, {1, 0}} {
+		fillChan := make(chan float64)
+		go fillOrKill(exg, "buy", order[0], order[1], fillChan)
+		if filled := <-fillChan; filled != 0 {
+			t.Fatalf("Invalid order %v should report nothing filled", order)
+		}
+	}
+	if exg.sends != 0 {
+		t.Fatalf("Invalid orders should not be sent, sent %d", exg.sends)
+	}
+	if _, counted := breakers[exg]; counted {
+		t.Fatal("Invalid orders should not count toward the breaker")
+	}
+}
+
+func TestSendOrderRateLimit(t *testing.T) {
+	defer func(retries int) { cfg.Sec.OrderRetries = retries }(cfg.Sec.OrderRetries)
+	cfg.Sec.OrderRetries = 1
+
+	// Waits out the Retry-After rather than the shorter backoff
+	exg := exchangetest.New("Test", 1)
+	exg.SendOrderFunc = func(ctx context.Context, order exchangetest.SentOrder) (int64, error) {
+		if order.ID == 1 {
+			return 0, fmt.Errorf("SendOrder error: %w", &exchange.RateLimitError{Status: "429 Too Many Requests", RetryAfter: 400 * time.Millisecond})
+		}
+		return order.ID, nil
+	}
+	start := time.Now()
+	if id, err := sendOrder(context.Background(), exg, "buy", "limit", 1, 251); err != nil || id == 0 {
+		t.Fatal("Order should succeed after the rate limit")
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("Retried after %v, before the Retry-After", elapsed)
+	}
+}
+
+// Exchange whose orders die a set number of polls after being cancelled
+type pollingExchange struct {
+	*sim.Client
+	polls, cancels, deadAfter int
+}
+
+func (exg *pollingExchange) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	return 1, nil
+}
+
+func (exg *pollingExchange) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	exg.cancels++
+	return true, nil
+}
+
+func (exg *pollingExchange) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	exg.polls++
+	if exg.deadAfter > 0 && exg.polls >= exg.deadAfter {
+		return exchange.Order{FilledAmount: .5, Status: "dead"}, nil
+	}
+	return exchange.Order{FilledAmount: .25, Status: "live"}, nil
+}
+
+func TestFillOrKillPolling(t *testing.T) {
+	defer func(delay, wait float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait = delay, wait
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait)
+	cfg.Sec.StatusPollDelay = .01
+	cfg.Sec.MaxStatusWait = 1
+
+	// Order dies after five polls with a single cancel
+	exg := &pollingExchange{Client: sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil), deadAfter: 5}
+	fillChan := make(chan float64)
+	start := time.Now()
+	go fillOrKill(exg, "buy", 1, 250, fillChan)
+	if filled := <-fillChan; math.Abs(filled-.5) > .000001 {
+		t.Fatal("Should report the final fill")
+	}
+	if exg.polls != 5 || exg.cancels != 1 {
+		t.Fatalf("Expected 5 polls and 1 cancel, got %d and %d", exg.polls, exg.cancels)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatal("Should wait between polls")
+	}
+
+	// Order never dies, last known fill is returned at the max wait
+	cfg.Sec.MaxStatusWait = .1
+	exg = &pollingExchange{Client: sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil)}
+	go fillOrKill(exg, "sell", 1, 250, fillChan)
+	if filled := <-fillChan; math.Abs(filled-.25) > .000001 {
+		t.Fatal("Should report the last known fill")
+	}
+	if exg.cancels != 1 || exg.polls > 20 {
+		t.Fatalf("Expected 1 cancel and limited polls, got %d and %d", exg.cancels, exg.polls)
+	}
+}
+
+func TestFillOrKillDryRun(t *testing.T) {
+	defer func(dryRun bool) { cfg.Sec.DryRun = dryRun }(cfg.Sec.DryRun)
+	cfg.Sec.DryRun = true
+
+	// Any attempt to send would fail
+	exg := &failingExchange{sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil), 100}
+	fillChan := make(chan float64)
+	go fillOrKill(exg, "buy", 2, 250, fillChan)
+	if filled := <-fillChan; math.Abs(filled-2) > .000001 {
+		t.Fatal("Dry run should simulate a full fill")
+	}
+	if exg.failures != 100 {
+		t.Fatal("Dry run should not send orders")
+	}
+	if math.Abs(exg.Position()-2) > .000001 {
+		t.Fatal("Dry run should update the position")
+	}
+}
+
+func TestFillUpdatesFunds(t *testing.T) {
+	defer func(dryRun bool) { cfg.Sec.DryRun = dryRun }(cfg.Sec.DryRun)
+	cfg.Sec.DryRun = true
+
+	exg := sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil)
+	fillChan := make(chan float64)
+	go fillOrKill(exg, "buy", 2, 250, fillChan)
+	<-fillChan
+	if math.Abs(exg.AvailFunds()-9500) > .000001 {
+		t.Fatalf("Buy should spend funds, got %f", exg.AvailFunds())
+	}
+	go fillOrKill(exg, "sell", 1, 300, fillChan)
+	<-fillChan
+	if math.Abs(exg.AvailFunds()-9800) > .000001 {
+		t.Fatalf("Sell should return funds, got %f", exg.AvailFunds())
+	}
+	// Short capacity is measured from flat
+	if math.Abs(exg.AvailShort()-10) > .000001 {
+		t.Fatal("Fills should not change short capacity")
+	}
+
+	// The position bought is added back for MaxPos
+	fb := filteredBook{ask: market{orderPrice: 245}, time: clock.Now()}
+	addToSnapshot(make(map[exchange.Interface]filteredBook), exg, fb)
+	if math.Abs(exg.MaxPos()-10) > .000001 {
+		t.Fatalf("Expected max position of 10, got %f", exg.MaxPos())
+	}
+	exg.SetAvailShort(50)
+	addToSnapshot(make(map[exchange.Interface]filteredBook), exg, fb)
+	if math.Abs(exg.MaxPos()-9800.0/245-1) > .000001 {
+		t.Fatalf("Expected max position of %f, got %f", 9800.0/245+1, exg.MaxPos())
+	}
+}
+
+// Exchange counting order attempts, which all fail
+type countingExchange struct {
+	*sim.Client
+	sends  int
+	amount float64 // Last amount sent
+}
+
+func (exg *countingExchange) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	exg.sends++
+	exg.amount = amount
+	return 0, errors.New("SendOrder error: unavailable")
+}
+
+// Run considerTrade over one update with an arb between two counting exchanges
+func runArbUpdate(exg1, exg2 *countingExchange) {
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	newBook := make(chan bool)
+	go func() {
+		newBook <- true
+		close(newBook)
+	}()
+	go func() {
+		for exg := range requestBook {
+			fb := filteredBook{
+				bid:  market{exg: exg, orderPrice: 250, adjPrice: 250, amount: 30},
+				ask:  market{exg: exg, orderPrice: 251, adjPrice: 251, amount: 30},
+				time: time.Now(),
+			}
+			if exg == exg2 {
+				fb.bid.orderPrice, fb.bid.adjPrice = 252, 252
+				fb.ask.orderPrice, fb.ask.adjPrice = 253, 253
+			}
+			receiveBook <- fb
+		}
+	}()
+	considerTrade(bookRequester{requestBook, receiveBook, nil}, newBook, nil)
+	close(requestBook)
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	defer func(e []exchange.Interface, p, d, m float64) {
+		exchanges, pl, netPositions, cfg.Sec.MaxDrawdown, cfg.Sec.MinNetPos = e, p, nil, d, m
+	}(exchanges, pl, cfg.Sec.MaxDrawdown, cfg.Sec.MinNetPos)
+	exg1 := &countingExchange{Client: sim.New("Test1", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exg2 := &countingExchange{Client: sim.New("Test2", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exchanges = []exchange.Interface{exg1, exg2}
+	netPositions = nil
+	cfg.Sec.MaxDrawdown = 50
+	cfg.Sec.MinNetPos = .1
+
+	// Within the limit the arb is traded
+	pl = -10
+	runArbUpdate(exg1, exg2)
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Arb should be attempted within the drawdown limit")
+	}
+
+	// Past the limit no new arb orders are sent
+	exg1.sends, exg2.sends = 0, 0
+	pl = -60
+	runArbUpdate(exg1, exg2)
+	if exg1.sends != 0 || exg2.sends != 0 {
+		t.Fatal("No arb should be attempted past the drawdown limit")
+	}
+}
+
+func TestSweepExit(t *testing.T) {
+	defer func(e []exchange.Interface, p, m, slippage float64) {
+		exchanges, pl, netPositions, cfg.Sec.MinNetPos, cfg.Sec.ExitSlippage = e, p, nil, m, slippage
+	}(exchanges, pl, cfg.Sec.MinNetPos, cfg.Sec.ExitSlippage)
+	exg := &countingExchange{Client: sim.New("Test", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exg.SetMaxPos(100)
+	exchanges = []exchange.Interface{exg}
+	cfg.Sec.MinNetPos = .1
+	fb := filterBook(exchange.Book{
+		Exg:  exg,
+		Time: time.Now(),
+		Bids: exchange.BidItems{{Price: 250, Amount: 30}, {Price: 249, Amount: 40}, {Price: 240, Amount: 100}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 30}, {Price: 252, Amount: 40}, {Price: 260, Amount: 100}},
+	}, 1)
+
+	// Without slippage only the filtered level is taken
+	cfg.Sec.ExitSlippage = 0
+	if m := sweepExit(fb, fb.bid, "sell", 60); m != fb.bid {
+		t.Fatalf("Expected the filtered bid, got %+v", m)
+	}
+
+	// Levels within the slippage are swept up to the net position, priced at the last
+	cfg.Sec.ExitSlippage = .01
+	if m := sweepExit(fb, fb.bid, "sell", 60); m.amount != 60 || m.orderPrice != 249 || math.Abs(m.adjPrice-249.5) > .000001 {
+		t.Fatalf("Expected 60 swept to 249 at 249.5, got %+v", m)
+	}
+	if m := sweepExit(fb, fb.ask, "buy", 50); m.amount != 50 || m.orderPrice != 252 || math.Abs(m.adjPrice-251.4) > .000001 {
+		t.Fatalf("Expected 50 swept to 252 at 251.4, got %+v", m)
+	}
+	// Nothing past the slippage
+	if m := sweepExit(fb, fb.bid, "sell", 200); m.amount != 70 || m.orderPrice != 249 {
+		t.Fatalf("Expected the sweep to stop at 249, got %+v", m)
+	}
+	cfg.Sec.ExitSlippage = .001
+	if m := sweepExit(fb, fb.bid, "sell", 60); m != fb.bid {
+		t.Fatalf("Expected the filtered bid within a tight slippage, got %+v", m)
+	}
+
+	// A net long exit sends the swept amount
+	cfg.Sec.ExitSlippage = .01
+	netPositions = map[string]float64{"": 60}
+	state := &tradeState{}
+	state.trade("", map[exchange.Interface]filteredBook{exg: fb})
+	if exg.sends == 0 || exg.amount != 60 {
+		t.Fatalf("Expected an exit of 60, sent %v", exg.amount)
+	}
+}
+
+func TestFalseRepeat(t *testing.T) {
+	defer func(e []exchange.Interface, p, m float64) {
+		exchanges, pl, netPositions, cfg.Sec.MinNetPos = e, p, nil, m
+	}(exchanges, pl, cfg.Sec.MinNetPos)
+	exg1 := &countingExchange{Client: sim.New("Test1", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exg2 := &countingExchange{Client: sim.New("Test2", "btc", "usd", 1, 0, 100, 100000, nil)}
+	for _, exg := range []*countingExchange{exg1, exg2} {
+		exg.SetMaxPos(100)
+	}
+	exchanges = []exchange.Interface{exg1, exg2}
+	netPositions = nil
+	cfg.Sec.MinNetPos = .1
+
+	// Markets with the same arb and amount on books of the given time
+	snapshot := func(bookTime time.Time) map[exchange.Interface]filteredBook {
+		return map[exchange.Interface]filteredBook{
+			exg1: {bid: market{exg: exg1, orderPrice: 250, adjPrice: 250, amount: 30}, ask: market{exg: exg1, orderPrice: 251, adjPrice: 251, amount: 30}, bookTime: bookTime},
+			exg2: {bid: market{exg: exg2, orderPrice: 252, adjPrice: 252, amount: 30}, ask: market{exg: exg2, orderPrice: 253, adjPrice: 253, amount: 30}, bookTime: bookTime},
+		}
+	}
+	state := &tradeState{}
+	bookTime := time.Now()
+	state.trade("", snapshot(bookTime))
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Arb should be traded")
+	}
+
+	// Unchanged books are a false repeat
+	exg1.sends, exg2.sends = 0, 0
+	state.trade("", snapshot(bookTime))
+	if exg1.sends != 0 || exg2.sends != 0 {
+		t.Fatal("Repeat on unchanged books should not be traded")
+	}
+
+	// New books with a coincidentally equal arb and amount are traded
+	state.trade("", snapshot(bookTime.Add(time.Second)))
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Equal arb on new books should be traded")
+	}
+}
+
+func TestArbSlices(t *testing.T) {
+	defer func(e []exchange.Interface, p, m, d float64, n int) {
+		exchanges, pl, netPositions, cfg.Sec.MinNetPos, cfg.Sec.SliceDelay, cfg.Sec.MaxSlices = e, p, nil, m, d, n
+	}(exchanges, pl, cfg.Sec.MinNetPos, cfg.Sec.SliceDelay, cfg.Sec.MaxSlices)
+	exg1 := &countingExchange{Client: sim.New("Test1", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exg2 := &countingExchange{Client: sim.New("Test2", "btc", "usd", 1, 0, 100, 100000, nil)}
+	for _, exg := range []*countingExchange{exg1, exg2} {
+		exg.SetMaxPos(100)
+	}
+	exchanges = []exchange.Interface{exg1, exg2}
+	netPositions = nil
+	cfg.Sec.MinNetPos = .1
+	cfg.Sec.SliceDelay = 0
+
+	// Markets with an arb of the given amount on books of the given time
+	snapshot := func(amount float64, bookTime time.Time) map[exchange.Interface]filteredBook {
+		return map[exchange.Interface]filteredBook{
+			exg1: {bid: market{exg: exg1, orderPrice: 250, adjPrice: 250, amount: amount}, ask: market{exg: exg1, orderPrice: 251, adjPrice: 251, amount: amount}, bookTime: bookTime},
+			exg2: {bid: market{exg: exg2, orderPrice: 252, adjPrice: 252, amount: amount}, ask: market{exg: exg2, orderPrice: 253, adjPrice: 253, amount: amount}, bookTime: bookTime},
+		}
+	}
+	tests := []struct {
+		name      string
+		maxSlices int
+		amount    float64 // Amount on the books
+		updates   bool    // Whether books update between slices
+		sends     int     // Orders expected on each exchange
+	}{
+		{"disabled", 0, 50, true, 1},
+		{"single slice", 1, 50, true, 1},
+		{"all slices", 3, 50, true, 3},
+		{"below MaxOrder", 3, 30, true, 1},
+		{"books not updated", 3, 50, false, 1},
+	}
+	for _, test := range tests {
+		cfg.Sec.MaxSlices = test.maxSlices
+		exg1.sends, exg2.sends = 0, 0
+		bookTime := time.Now()
+		state := &tradeState{refresh: func() map[exchange.Interface]filteredBook {
+			if test.updates {
+				bookTime = bookTime.Add(time.Second)
+			}
+			return snapshot(test.amount, bookTime)
+		}}
+		state.trade("", snapshot(test.amount, bookTime))
+		if exg1.sends != test.sends || exg2.sends != test.sends {
+			t.Errorf("%s: sent %d and %d orders, expected %d each", test.name, exg1.sends, exg2.sends, test.sends)
+		}
+	}
+
+	// Slicing stops once the spread closes
+	cfg.Sec.MaxSlices = 3
+	exg1.sends, exg2.sends = 0, 0
+	state := &tradeState{refresh: func() map[exchange.Interface]filteredBook {
+		markets := snapshot(50, time.Now().Add(time.Second))
+		fb := markets[exg2]
+		fb.bid.adjPrice = 250
+		markets[exg2] = fb
+		return markets
+	}}
+	state.trade("", snapshot(50, time.Now()))
+	if exg1.sends != 1 || exg2.sends != 1 {
+		t.Errorf("Slices sent after the spread closed: %d and %d orders", exg1.sends, exg2.sends)
+	}
+
+	// Slicing stops once book handling stops
+	exg1.sends, exg2.sends = 0, 0
+	state = &tradeState{refresh: func() map[exchange.Interface]filteredBook { return nil }}
+	state.trade("", snapshot(50, time.Now()))
+	if exg1.sends != 1 || exg2.sends != 1 {
+		t.Errorf("Slices sent after book handling stopped: %d and %d orders", exg1.sends, exg2.sends)
+	}
+}
+
+func TestPairCooldown(t *testing.T) {
+	defer func(e []exchange.Interface, p, m, c float64, savedClock exchange.Clock) {
+		exchanges, pl, netPositions, cfg.Sec.MinNetPos, cfg.Sec.PairCooldown, clock = e, p, nil, m, c, savedClock
+	}(exchanges, pl, cfg.Sec.MinNetPos, cfg.Sec.PairCooldown, clock)
+	exg1 := &countingExchange{Client: sim.New("Test1", "btc", "usd", 1, 0, 100, 100000, nil)}
+	exg2 := &countingExchange{Client: sim.New("Test2", "btc", "usd", 1, 0, 100, 100000, nil)}
+	for _, exg := range []*countingExchange{exg1, exg2} {
+		exg.SetMaxPos(100)
+	}
+	exchanges = []exchange.Interface{exg1, exg2}
+	netPositions = nil
+	cfg.Sec.MinNetPos = .1
+	cfg.Sec.PairCooldown = 10
+	now := time.Now()
+	manual := exchange.NewManualClock(now)
+	clock = manual
+
+	// Markets buying on exg1 and selling on exg2, or the reverse, on books of the given time
+	snapshot := func(reverse bool, bookTime time.Time) map[exchange.Interface]filteredBook {
+		low, high := exg1, exg2
+		if reverse {
+			low, high = exg2, exg1
+		}
+		return map[exchange.Interface]filteredBook{
+			low:  {bid: market{exg: low, orderPrice: 250, adjPrice: 250, amount: 30}, ask: market{exg: low, orderPrice: 251, adjPrice: 251, amount: 30}, bookTime: bookTime},
+			high: {bid: market{exg: high, orderPrice: 252, adjPrice: 252, amount: 30}, ask: market{exg: high, orderPrice: 253, adjPrice: 253, amount: 30}, bookTime: bookTime},
+		}
+	}
+	state := &tradeState{}
+	state.trade("", snapshot(false, now))
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Arb should be traded")
+	}
+
+	// The same pair on new books is skipped within the cooldown
+	exg1.sends, exg2.sends = 0, 0
+	now = manual.Advance(5 * time.Second)
+	state.trade("", snapshot(false, now))
+	if exg1.sends != 0 || exg2.sends != 0 {
+		t.Fatal("Same pair within the cooldown should not be traded")
+	}
+
+	// The reverse pair is traded
+	state.trade("", snapshot(true, now))
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Reverse pair should be traded")
+	}
+
+	// The same pair is traded again after the cooldown
+	exg1.sends, exg2.sends = 0, 0
+	now = manual.Advance(10 * time.Second)
+	state.trade("", snapshot(false, now))
+	if exg1.sends == 0 || exg2.sends == 0 {
+		t.Fatal("Same pair after the cooldown should be traded")
+	}
+}
+
+func TestCancelOpenOrders(t *testing.T) {
+	defer func(e []exchange.Interface) { exchanges = e }(exchanges)
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 249, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 10}},
+	}}
+	exg := sim.New("Test", "btc", "usd", 1, 0, 10, 10000, books)
+	exchanges = []exchange.Interface{exg}
+
+	// Resting orders are cancelled
+	exg.SendOrder(context.Background(), "buy", "limit", 1, 240)
+	exg.SendOrder(context.Background(), "sell", "limit", 1, 260)
+	cancelOpenOrders()
+	if orders, _ := exg.OpenOrders(context.Background()); len(orders) != 0 {
+		t.Fatalf("Expected no open orders, got %v", orders)
+	}
+}
+
+// Exchange accepting whole amounts only
+type lotExchange struct {
+	*countingExchange
+}
+
+func (exg *lotExchange) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, 1), price
+}
+
+func TestFillOrKillRounding(t *testing.T) {
+	exg := &lotExchange{&countingExchange{Client: sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil)}}
+	fillChan := make(chan float64)
+	go fillOrKill(exg, "buy", .5, 250, fillChan)
+	if filled := <-fillChan; filled != 0 || exg.sends != 0 {
+		t.Fatal("Order rounded to nothing should not be sent")
+	}
+	go fillOrKill(exg, "buy", 1.5, 250, fillChan)
+	<-fillChan
+	if exg.sends != 1 || exg.amount != 1 {
+		t.Fatal("Rounded order should be sent")
+	}
+}
+
+// Exchange with its own minimum order size
+type minExchange struct {
+	*sim.Client
+	minOrder float64
+}
+
+func (exg *minExchange) MinOrderSize() float64 {
+	return exg.minOrder
+}
+
+func TestMinOrderSize(t *testing.T) {
+	exg1 := &minExchange{sim.New("Test1", "btc", "usd", 1, 0, 500, 0, nil), 0}
+	exg1.SetMaxPos(500)
+	exg2 := &minExchange{sim.New("Test2", "btc", "usd", 1, 0, 500, 0, nil), 0}
+	exg2.SetMaxPos(500)
+	markets := map[exchange.Interface]filteredBook{
+		exg1: {
+			bid: market{exg: exg1, adjPrice: 2.04, amount: 50},
+			ask: market{exg: exg1, adjPrice: 2.05, amount: 50},
+		},
+		exg2: {
+			bid: market{exg: exg2, adjPrice: 1.99, amount: 50},
+			ask: market{exg: exg2, adjPrice: 2.00, amount: 50},
+		},
+	}
+	// Able to sell 30 and buy 970 on exg1, above the global minimum of 25
+	exg1.SetPosition(-470)
+	if bestBid := findBestBid(markets); bestBid.exg != exg1 {
+		t.Fatal("Best bid should be on exg1")
+	}
+	if _, _, exists := findBestArb(markets); !exists {
+		t.Fatal("Should be an arb opportunity")
+	}
+
+	// Exchange minimum above the amount able to sell
+	exg1.minOrder = 40
+	if bestBid := findBestBid(markets); bestBid.exg != exg2 {
+		t.Fatal("Best bid should skip exg1 below its minimum")
+	}
+	if bestAsk := findBestAsk(markets); bestAsk.exg != exg2 {
+		t.Fatal("Best ask should still be on exg2")
+	}
+	if _, _, exists := findBestArb(markets); exists {
+		t.Fatal("Arb should skip exg1 below its minimum")
+	}
+	exg1.minOrder = 1000
+	if bestAsk := findBestAsk(markets); bestAsk.exg != exg2 {
+		t.Fatal("Best ask should skip exg1 below its minimum")
+	}
+}
+
+// Exchange with a fixed price step
+type tickExchange struct {
+	*sim.Client
+	step float64
+}
+
+func (exg *tickExchange) PriceStep(price float64) float64 {
+	return exg.step
+}
+
+func TestPadPrices(t *testing.T) {
+	defer func(ticks int) { cfg.Sec.SlippageTicks = ticks }(cfg.Sec.SlippageTicks)
+	cfg.Sec.SlippageTicks = 3
+	exg1 := &tickExchange{sim.New("Test1", "btc", "usd", 1, 0, 100, 100000, nil), .01}
+	exg2 := &tickExchange{sim.New("Test2", "btc", "usd", 1, 0, 100, 100000, nil), .1}
+	bid := market{exg: exg1, orderPrice: 252, adjPrice: 252, amount: 30}
+	ask := market{exg: exg2, orderPrice: 251, adjPrice: 251, amount: 30}
+
+	padded := []struct {
+		slack, bidPrice, askPrice float64
+	}{
+		{1, 251.97, 251.3},   // All ticks fit
+		{.23, 251.98, 251.2}, // Two ticks cost .22
+		{.05, 252, 251},      // No tick fits
+	}
+	for _, p := range padded {
+		b, a := padPrices(bid, ask, p.slack)
+		if math.Abs(b.orderPrice-p.bidPrice) > .000001 || math.Abs(a.orderPrice-p.askPrice) > .000001 {
+			t.Errorf("Slack %v: expected %v and %v, got %v and %v", p.slack, p.bidPrice, p.askPrice, b.orderPrice, a.orderPrice)
+		}
+		if b.adjPrice != 252 || a.adjPrice != 251 {
+			t.Error("Adjusted prices should be unchanged")
+		}
+	}
+}
+
+func TestPostOnlyLeg(t *testing.T) {
+	defer func(delay, wait, makerWait, minNetPos float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait, cfg.Sec.MakerWait, cfg.Sec.MinNetPos = delay, wait, makerWait, minNetPos
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait, cfg.Sec.MakerWait, cfg.Sec.MinNetPos)
+	cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait, cfg.Sec.MakerWait, cfg.Sec.MinNetPos = .01, 1, .5, .1
+
+	sellBooks := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 253, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 254, Amount: 10}},
+	}}
+	buyBooks := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 250, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 10}},
+	}, {
+		Bids: exchange.BidItems{{Price: 249, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 250.5, Amount: 10}},
+	}}
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, sellBooks)
+	exg2 := &tickExchange{sim.New("Test2", "btc", "usd", 2, 0, 10, 10000, buyBooks), .01}
+	bid := market{exg: exg1, orderPrice: 253, adjPrice: 253, amount: 1, topPrice: 253}
+	ask := market{exg: exg2, orderPrice: 251, adjPrice: 251, amount: 1, topPrice: 251}
+
+	// Without a price step there is no maker price
+	if _, ok := makerPrice(market{exg: exg1, orderPrice: 253, topPrice: 253}, "sell"); ok {
+		t.Fatal("Exchange without a price step should not rest post-only")
+	}
+	if maker, ok := makerPrice(ask, "buy"); !ok || math.Abs(maker.orderPrice-250.99) > .000001 {
+		t.Fatal("Post-only buy should rest one step below the top ask")
+	}
+	// Resting legs are adjusted at the maker fee
+	exg2.SetFees(.001, .002)
+	if maker, _ := makerPrice(ask, "buy"); math.Abs(maker.adjPrice-250.99*1.001/1.002) > .000001 {
+		t.Fatalf("Expected adjusted price at the maker fee, got %v", maker.adjPrice)
+	}
+	exg2.SetFees(0, 0)
+
+	// The resting buy fills when a later book crosses it within MakerWait
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		exg2.Advance()
+	}()
+	sendPair(bid, ask, 1, false, true)
+	if math.Abs(exg1.Position()+1) > .000001 || math.Abs(exg2.Position()-1) > .000001 {
+		t.Fatalf("Expected both legs filled, got %v and %v", exg1.Position(), exg2.Position())
+	}
+	orders, _ := exg2.OpenOrders(context.Background())
+	if len(orders) != 0 {
+		t.Fatal("Post-only order should not be left open")
+	}
+}
+
+func TestJoinLeg(t *testing.T) {
+	defer func(delay float64) { cfg.Sec.StatusPollDelay = delay }(cfg.Sec.StatusPollDelay)
+	cfg.Sec.StatusPollDelay = .01
+
+	type leg struct {
+		otype string
+		price float64
+	}
+	tests := []struct {
+		name                     string
+		bidPriority, askPriority int
+		join, postOnly           bool
+		sell, buy                leg
+	}{
+		{"both cross", 1, 2, false, false, leg{"limit", 253}, leg{"limit", 251}},
+		{"priority sell joins", 1, 2, true, false, leg{"post_only", 253.01}, leg{"limit", 251}},
+		{"priority buy joins", 2, 1, true, false, leg{"limit", 253}, leg{"post_only", 250.99}},
+		{"both join", 1, 2, true, true, leg{"post_only", 253.01}, leg{"post_only", 250.99}},
+		{"simultaneous legs cross", 1, 1, true, true, leg{"limit", 253}, leg{"limit", 251}},
+	}
+	for _, test := range tests {
+		// A price step lets legs join the book
+		bidExg, askExg := exchangetest.New("Bid", test.bidPriority), exchangetest.New("Ask", test.askPriority)
+		bidExg.PriceStepFunc = func(price float64) float64 { return .01 }
+		askExg.PriceStepFunc = bidExg.PriceStepFunc
+		bid := market{exg: bidExg, orderPrice: 253, adjPrice: 253, amount: 1, topPrice: 253}
+		ask := market{exg: askExg, orderPrice: 251, adjPrice: 251, amount: 1, topPrice: 251}
+		sendPair(bid, ask, 1, test.join, test.postOnly)
+
+		sells, buys := bidExg.Orders(), askExg.Orders()
+		if len(sells) != 1 || sells[0].Otype != test.sell.otype || math.Abs(sells[0].Price-test.sell.price) > .000001 {
+			t.Errorf("%s: expected a %s sell at %v, got %v", test.name, test.sell.otype, test.sell.price, sells)
+		}
+		if len(buys) != 1 || buys[0].Otype != test.buy.otype || math.Abs(buys[0].Price-test.buy.price) > .000001 {
+			t.Errorf("%s: expected a %s buy at %v, got %v", test.name, test.buy.otype, test.buy.price, buys)
+		}
+	}
+
+	// Without a price step the leg crosses
+	if m, otype := legOrder(market{exg: exchangetest.New("Bid", 1), orderPrice: 253, topPrice: 253}, "sell", true); otype != "limit" || m.orderPrice != 253 {
+		t.Fatalf("Expected a limit sell at 253, got %s at %v", otype, m.orderPrice)
+	}
+}
+
+func TestTimeInForce(t *testing.T) {
+	defer func(delay, wait, timeInForce float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait, cfg.Sec.TimeInForce = delay, wait, timeInForce
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait, cfg.Sec.TimeInForce)
+	cfg.Sec.StatusPollDelay, cfg.Sec.MaxStatusWait = .01, 1
+
+	// The buy at 250.5 rests until the second book's ask crosses it
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 250, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 10}},
+	}, {
+		Bids: exchange.BidItems{{Price: 249, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 250.5, Amount: 10}},
+	}}
+	delayedFill := func(timeInForce float64) (*sim.Client, float64) {
+		cfg.Sec.TimeInForce = timeInForce
+		exg := sim.New("Test", "btc", "usd", 1, 0, 10, 10000, books)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			exg.Advance()
+		}()
+		fillChan := make(chan float64)
+		go fillOrKill(exg, "buy", 1, 250.5, fillChan)
+		return exg, <-fillChan
+	}
+
+	// Killed at the first live status by default
+	exg, filled := delayedFill(0)
+	if filled != 0 {
+		t.Fatalf("Expected no fill without a time in force, got %v", filled)
+	}
+	// Fills while resting within the time in force
+	exg, filled = delayedFill(.5)
+	if math.Abs(filled-1) > .000001 || math.Abs(exg.Position()-1) > .000001 {
+		t.Fatalf("Expected the delayed fill, got %v", filled)
+	}
+	// Cancelled once the time in force passes without a fill
+	start := time.Now()
+	books = books[:1]
+	exg, filled = delayedFill(.1)
+	if filled != 0 || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("Expected a cancel after the time in force, got %v after %v", filled, time.Since(start))
+	}
+	if orders, _ := exg.OpenOrders(context.Background()); len(orders) != 0 {
+		t.Fatal("Order should not be left open")
+	}
+
+	// Still resting at MaxStatusWait, the order is cancelled rather than left live
+	cfg.Sec.MaxStatusWait = .1
+	exg, filled = delayedFill(1)
+	if filled != 0 {
+		t.Fatalf("Expected no fill, got %v", filled)
+	}
+	if orders, _ := exg.OpenOrders(context.Background()); len(orders) != 0 {
+		t.Fatal("Order abandoned at the deadline should be cancelled")
+	}
+}
+
+func TestCheckOrderWaits(t *testing.T) {
+	defer func(wait, timeout, timeInForce, makerWait, postOnly float64) {
+		cfg.Sec.MaxStatusWait, cfg.Sec.OrderTimeout, cfg.Sec.TimeInForce, cfg.Sec.MakerWait, cfg.Sec.PostOnlyMargin = wait, timeout, timeInForce, makerWait, postOnly
+	}(cfg.Sec.MaxStatusWait, cfg.Sec.OrderTimeout, cfg.Sec.TimeInForce, cfg.Sec.MakerWait, cfg.Sec.PostOnlyMargin)
+	cfg.Sec.MaxStatusWait, cfg.Sec.OrderTimeout, cfg.Sec.TimeInForce, cfg.Sec.MakerWait, cfg.Sec.PostOnlyMargin = 10, 30, 0, 15, 0
+
+	// MakerWait only counts when orders can be sent post-only
+	if err := checkOrderWaits(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Sec.PostOnlyMargin = .5
+	if err := checkOrderWaits(); err == nil {
+		t.Fatal("Expected error for maxStatusWait within makerWait")
+	}
+	cfg.Sec.MaxStatusWait = 0
+	if err := checkOrderWaits(); err != nil {
+		t.Fatal("No status wait limit should pass")
+	}
+	cfg.Sec.TimeInForce = 30
+	if err := checkOrderWaits(); err == nil {
+		t.Fatal("Expected error for orderTimeout within timeInForce")
+	}
+}
+
+// Notifier recording messages
+type recordingNotifier struct {
+	messages []string
+}
+
+func (n *recordingNotifier) Notify(message string) {
+	n.messages = append(n.messages, message)
+}
+
+func TestNotifyOrderFailure(t *testing.T) {
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 249, Amount: 10}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 10}},
+	}}
+	defer func(retries int) { cfg.Sec.OrderRetries = retries }(cfg.Sec.OrderRetries)
+	cfg.Sec.OrderRetries = 1
+	defer func(n notify.Notifier) { notifier = n }(notifier)
+	recorder := &recordingNotifier{}
+	notifier = recorder
+
+	exg := &failingExchange{sim.New("Test", "btc", "usd", 1, 0, 10, 10000, books), 2}
+	fillChan := make(chan float64)
+	go fillOrKill(exg, "buy", 1, 251, fillChan)
+	<-fillChan
+	if len(recorder.messages) != 1 || !strings.Contains(recorder.messages[0], "after 2 attempts") {
+		t.Fatalf("Expected one failure notification, got %v", recorder.messages)
+	}
+
+	// Successful orders aren't notified
+	go fillOrKill(exg, "buy", 1, 251, fillChan)
+	<-fillChan
+	if len(recorder.messages) != 1 {
+		t.Fatalf("Expected no new notification, got %v", recorder.messages)
+	}
+}
+
+func TestSendPairPriority(t *testing.T) {
+	defer func(delay, minNetPos float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos = delay, minNetPos
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos)
+	cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos = .01, .1
+
+	tests := []struct {
+		name                     string
+		bidPriority, askPriority int
+		firstFill                float64   // Amount the higher priority leg fills
+		actions                  []string  // Actions in the sequence sent
+		amounts                  []float64 // Amounts in the sequence sent
+	}{
+		{"bid first, full fill", 1, 2, 1, []string{"sell", "buy"}, []float64{1, 1}},
+		{"ask first, full fill", 2, 1, 1, []string{"buy", "sell"}, []float64{1, 1}},
+		{"ask first, partial fill", 2, 1, .5, []string{"buy", "sell"}, []float64{1, .5}},
+		{"bid first, fill at MinNetPos", 1, 2, .1, []string{"sell", "buy"}, []float64{1, .1}},
+		{"bid first, fill below MinNetPos", 1, 2, .05, []string{"sell"}, []float64{1}},
+		{"ask first, zero fill", 2, 1, 0, []string{"buy"}, []float64{1}},
+	}
+	for _, test := range tests {
+		var journal exchangetest.Journal
+		bidExg, askExg := exchangetest.New("Bid", test.bidPriority), exchangetest.New("Ask", test.askPriority)
+		bidExg.Journal, askExg.Journal = &journal, &journal
+		fill := func(order exchangetest.SentOrder) float64 {
+			if len(journal.Orders()) == 1 {
+				return test.firstFill
+			}
+			return order.Amount
+		}
+		bidExg.Fill, askExg.Fill = fill, fill
+		bid := market{exg: bidExg, orderPrice: 253, adjPrice: 253, amount: 1}
+		ask := market{exg: askExg, orderPrice: 251, adjPrice: 251, amount: 1}
+		sendPair(bid, ask, 1, false, false)
+
+		orders := journal.Orders()
+		if len(orders) != len(test.actions) {
+			t.Errorf("%s: expected %d orders, got %v", test.name, len(test.actions), orders)
+			continue
+		}
+		for i, order := range orders {
+			exg := exchange.Interface(bidExg)
+			if order.Action == "buy" {
+				exg = askExg
+			}
+			if order.Exchange != exg || order.Action != test.actions[i] || math.Abs(order.Amount-test.amounts[i]) > .000001 {
+				t.Errorf("%s: expected order %d to %s %.2f, got %v", test.name, i, test.actions[i], test.amounts[i], order)
+			}
+		}
+	}
+}
+
+func TestSendPairConcurrent(t *testing.T) {
+	defer func(delay float64) { cfg.Sec.StatusPollDelay = delay }(cfg.Sec.StatusPollDelay)
+	cfg.Sec.StatusPollDelay = .01
+
+	// Each leg is only accepted once the other has been sent
+	var sent sync.WaitGroup
+	sent.Add(2)
+	together := func(ctx context.Context, order exchangetest.SentOrder) (int64, error) {
+		sent.Done()
+		both := make(chan bool)
+		go func() {
+			sent.Wait()
+			close(both)
+		}()
+		select {
+		case <-both:
+			return order.ID, nil
+		case <-time.After(time.Second):
+			return 0, errors.New("SendOrder error: other leg not sent")
+		}
+	}
+	bidExg, askExg := exchangetest.New("Bid", 1), exchangetest.New("Ask", 1)
+	bidExg.SendOrderFunc, askExg.SendOrderFunc = together, together
+	bid := market{exg: bidExg, orderPrice: 253, adjPrice: 253, amount: 1}
+	ask := market{exg: askExg, orderPrice: 251, adjPrice: 251, amount: 1}
+	sendPair(bid, ask, 1, false, false)
+
+	if math.Abs(bidExg.Position()+1) > .000001 || math.Abs(askExg.Position()-1) > .000001 {
+		t.Fatalf("Expected both legs sent together and filled, got positions %v and %v", bidExg.Position(), askExg.Position())
+	}
+	if orders := bidExg.Orders(); len(orders) != 1 || orders[0].Action != "sell" || orders[0].Amount != 1 {
+		t.Fatalf("Expected a sell of 1, got %v", orders)
+	}
+	if orders := askExg.Orders(); len(orders) != 1 || orders[0].Action != "buy" || orders[0].Amount != 1 {
+		t.Fatalf("Expected a buy of 1, got %v", orders)
+	}
+}
+
+func TestSendPairUnequalFills(t *testing.T) {
+	defer func(delay, minNetPos float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos = delay, minNetPos
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos)
+	cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos = .01, .1
+
+	tests := []struct {
+		name              string
+		buyFill, sellFill float64   // Amounts the simultaneous legs fill
+		buyAmounts        []float64 // Amounts of each buy sent
+		sellAmounts       []float64 // Amounts of each sell sent
+		buyPos, sellPos   float64   // Resulting positions
+	}{
+		{"sell lags", 1, .6, []float64{1}, []float64{1, .4}, 1, -1},
+		{"buy lags", .3, .8, []float64{1, .5}, []float64{1}, .8, -.8},
+		{"below MinNetPos", 1, .95, []float64{1}, []float64{1}, 1, -.95},
+		{"equal", .5, .5, []float64{1}, []float64{1}, .5, -.5},
+	}
+	for _, test := range tests {
+		bidExg, askExg := exchangetest.New("Bid", 1), exchangetest.New("Ask", 1)
+		// First orders fill as scripted, corrective orders in full
+		askExg.Fill = func(order exchangetest.SentOrder) float64 {
+			if order.ID == 1 {
+				return test.buyFill
+			}
+			return order.Amount
+		}
+		bidExg.Fill = func(order exchangetest.SentOrder) float64 {
+			if order.ID == 1 {
+				return test.sellFill
+			}
+			return order.Amount
+		}
+		bid := market{exg: bidExg, orderPrice: 253, adjPrice: 253, amount: 1}
+		ask := market{exg: askExg, orderPrice: 251, adjPrice: 251, amount: 1}
+		sendPair(bid, ask, 1, false, false)
+
+		for _, leg := range []struct {
+			exg     *exchangetest.Exchange
+			amounts []float64
+			pos     float64
+		}{{askExg, test.buyAmounts, test.buyPos}, {bidExg, test.sellAmounts, test.sellPos}} {
+			orders := leg.exg.Orders()
+			if len(orders) != len(leg.amounts) {
+				t.Errorf("%s: expected %d orders on %s, got %v", test.name, len(leg.amounts), leg.exg, orders)
+				continue
+			}
+			for i, order := range orders {
+				if math.Abs(order.Amount-leg.amounts[i]) > .000001 {
+					t.Errorf("%s: expected order %d on %s for %.2f, got %.2f", test.name, i, leg.exg, leg.amounts[i], order.Amount)
+				}
+			}
+			if math.Abs(leg.exg.Position()-leg.pos) > .000001 {
+				t.Errorf("%s: expected position %.2f on %s, got %.2f", test.name, leg.pos, leg.exg, leg.exg.Position())
+			}
+		}
+	}
+}
+
+func TestMinSecondLeg(t *testing.T) {
+	defer func(delay, minNetPos, minSecondLeg float64) {
+		cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos, cfg.Sec.MinSecondLeg = delay, minNetPos, minSecondLeg
+	}(cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos, cfg.Sec.MinSecondLeg)
+	cfg.Sec.StatusPollDelay, cfg.Sec.MinNetPos = .01, .5
+
+	// Defaults to MinNetPos
+	if minSecondLeg() != .5 {
+		t.Fatalf("Expected MinNetPos, got %v", minSecondLeg())
+	}
+
+	// A fill below MinNetPos is hedged once MinSecondLeg allows it
+	cfg.Sec.MinSecondLeg = .1
+	bidExg, askExg := exchangetest.New("Bid", 1), exchangetest.New("Ask", 2)
+	bidExg.Fill = func(order exchangetest.SentOrder) float64 { return .2 }
+	bid := market{exg: bidExg, orderPrice: 253, adjPrice: 253, amount: 1}
+	ask := market{exg: askExg, orderPrice: 251, adjPrice: 251, amount: 1}
+	sendPair(bid, ask, 1, false, false)
+	if orders := askExg.Orders(); len(orders) != 1 || math.Abs(orders[0].Amount-.2) > .000001 {
+		t.Fatalf("Expected a second leg of .2, got %v", orders)
+	}
+
+	// And not hedged once MinSecondLeg is above it
+	cfg.Sec.MinSecondLeg = .3
+	bidExg, askExg = exchangetest.New("Bid", 1), exchangetest.New("Ask", 2)
+	bidExg.Fill = func(order exchangetest.SentOrder) float64 { return .2 }
+	bid.exg, ask.exg = bidExg, askExg
+	sendPair(bid, ask, 1, false, false)
+	if orders := askExg.Orders(); len(orders) != 0 {
+		t.Fatalf("Expected no second leg, got %v", orders)
+	}
+
+	// Zero hedges any fill, but not an empty one
+	cfg.Sec.MinSecondLeg = 0
+	for _, test := range []struct {
+		fill float64
+		legs int
+	}{{.000001, 1}, {0, 0}} {
+		bidExg, askExg = exchangetest.New("Bid", 1), exchangetest.New("Ask", 2)
+		bidExg.Fill = func(order exchangetest.SentOrder) float64 { return test.fill }
+		bid.exg, ask.exg = bidExg, askExg
+		sendPair(bid, ask, 1, false, false)
+		if orders := askExg.Orders(); len(orders) != test.legs {
+			t.Fatalf("Expected %d second legs for a fill of %v, got %v", test.legs, test.fill, orders)
+		}
+	}
+}
+
+// Exchange recording borrows and repayments
+type borrowingExchange struct {
+	*exchangetest.Exchange
+	borrowErr error
+	borrowed  []float64
+	repaid    chan bool
+}
+
+func (exg *borrowingExchange) EnsureBorrowed(ctx context.Context, amount float64) error {
+	exg.borrowed = append(exg.borrowed, amount)
+	return exg.borrowErr
+}
+
+func (exg *borrowingExchange) RepayExcess(ctx context.Context) error {
+	exg.repaid <- true
+	return nil
+}
+
+func TestBorrowForSells(t *testing.T) {
+	defer func(delay float64) { cfg.Sec.StatusPollDelay = delay }(cfg.Sec.StatusPollDelay)
+	cfg.Sec.StatusPollDelay = .01
+	exg := &borrowingExchange{Exchange: exchangetest.New("Test", 1), repaid: make(chan bool, 1)}
+	fillChan := make(chan float64)
+
+	// Sells borrow first
+	go fillOrKill(exg, "sell", 2, 249, fillChan)
+	if filled := <-fillChan; math.Abs(filled-2) > .000001 || len(exg.borrowed) != 1 || exg.borrowed[0] != 2 {
+		t.Fatalf("Expected a borrow of 2 before the sell, got %v and %v", exg.borrowed, filled)
+	}
+
+	// Buys repay spare loans after the fill
+	go fillOrKill(exg, "buy", 1, 251, fillChan)
+	<-fillChan
+	select {
+	case <-exg.repaid:
+	case <-time.After(time.Second):
+		t.Fatal("Expected loans repaid after a buy")
+	}
+
+	// Sells aren't sent if borrowing fails
+	exg.borrowErr = errors.New("Borrow error: limit reached")
+	go fillOrKill(exg, "sell", 2, 249, fillChan)
+	if filled := <-fillChan; filled != 0 || len(exg.Orders()) != 2 {
+		t.Fatal("Sell should not be sent without its loan")
+	}
+}
+
+// Exchange reporting a fixed order round trip
+type latencyExchange struct {
+	*exchangetest.Exchange
+}
+
+func (exg *latencyExchange) LastLatency() time.Duration {
+	return 250 * time.Millisecond
+}
+
+func TestRecordLatency(t *testing.T) {
+	exg := &latencyExchange{exchangetest.New("Latency", 1)}
+	recordLatency(exg, "SendOrder")
+	if latency := latencyGauge.Value(exchangeKey(exg)); math.Abs(latency-.25) > .000001 {
+		t.Errorf("Wrong latency exported: %v", latency)
+	}
+	// Exchanges without timing are skipped
+	untimed := exchangetest.New("Untimed", 1)
+	recordLatency(untimed, "SendOrder")
+	if latencyGauge.Value(exchangeKey(untimed)) != 0 {
+		t.Error("Latency exported for an exchange without timing")
+	}
+}
@@ -0,0 +1,115 @@
+// Circuit breaker for exchanges with repeated errors
+// A tripped exchange is left out of trading decisions for a cooldown, keeping the rest trading
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Error state of one exchange
+type breaker struct {
+	errors     []time.Time // Times of errors within BreakerWindow, oldest first
+	openUntil  time.Time   // End of the cooldown, zero if not tripped
+	probeUntil time.Time   // End of the probe after a cooldown, when one error trips again
+}
+
+// Breakers by exchange, recorded from order goroutines and handleData and read by the trading goroutine
+var (
+	breakers     = make(map[exchange.Interface]*breaker)
+	breakerMutex sync.Mutex
+)
+
+// Called on any error from an exchange
+// Logs like isError, and counts the error toward tripping the exchange's breaker
+// Insufficient funds trips the breaker at once, even with BreakerErrors unset, as later orders would fail the same way
+func exchangeError(exg exchange.Interface, err error) bool {
+	if !isError(err) {
+		return false
+	}
+	if exg != nil && errors.Is(err, exchange.ErrInsufficientFunds) {
+		tripBreaker(exg, "insufficient funds")
+	} else if exg != nil && cfg.Sec.BreakerErrors > 0 {
+		recordBreakerError(exg)
+	}
+	return true
+}
+
+// Count an error, tripping the breaker at BreakerErrors within BreakerWindow or at any error while probing
+func recordBreakerError(exg exchange.Interface) {
+	breakerMutex.Lock()
+	defer breakerMutex.Unlock()
+	b, ok := breakers[exg]
+	if !ok {
+		b = &breaker{}
+		breakers[exg] = b
+	}
+	now := clock.Now()
+	if now.Before(b.openUntil) {
+		return
+	}
+	window := time.Duration(cfg.Sec.BreakerWindow * float64(time.Second))
+	for len(b.errors) > 0 && now.Sub(b.errors[0]) >= window {
+		b.errors = b.errors[1:]
+	}
+	b.errors = append(b.errors, now)
+	probing := now.Before(b.probeUntil)
+	if len(b.errors) < cfg.Sec.BreakerErrors && !probing {
+		return
+	}
+	reason := fmt.Sprintf("%d errors in %.0fs", cfg.Sec.BreakerErrors, window.Seconds())
+	if probing {
+		reason = "an error on probing"
+	}
+	openBreaker(exg, b, now, reason)
+}
+
+// Trip an exchange's breaker at once, unless its cooldown is already running
+func tripBreaker(exg exchange.Interface, reason string) {
+	breakerMutex.Lock()
+	defer breakerMutex.Unlock()
+	b, ok := breakers[exg]
+	if !ok {
+		b = &breaker{}
+		breakers[exg] = b
+	}
+	now := clock.Now()
+	if now.Before(b.openUntil) {
+		return
+	}
+	openBreaker(exg, b, now, reason)
+}
+
+// Start an exchange's cooldown, logging and notifying why, must hold breakerMutex
+func openBreaker(exg exchange.Interface, b *breaker, now time.Time, reason string) {
+	window := time.Duration(cfg.Sec.BreakerWindow * float64(time.Second))
+	cooldown := time.Duration(cfg.Sec.BreakerCooldown * float64(time.Second))
+	b.openUntil = now.Add(cooldown)
+	b.probeUntil = b.openUntil.Add(window)
+	b.errors = nil
+	message := fmt.Sprintf("%s disabled for %.0fs after %s", exchangeKey(exg), cooldown.Seconds(), reason)
+	logger.Warnf("!!!!! %s !!!!!\n", message)
+	notifier.Notify(message)
+}
+
+// Whether an exchange's breaker has tripped and its cooldown is running
+// Logs the end of a cooldown once, when the exchange is probed again
+func tripped(exg exchange.Interface) bool {
+	breakerMutex.Lock()
+	defer breakerMutex.Unlock()
+	b, ok := breakers[exg]
+	if !ok || b.openUntil.IsZero() {
+		return false
+	}
+	if clock.Now().Before(b.openUntil) {
+		return true
+	}
+	logger.Infof("%s cooldown over, probing with trades again\n", exchangeKey(exg))
+	b.openUntil = time.Time{}
+	return false
+}
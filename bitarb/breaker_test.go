@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	savedClock, savedBreakers := clock, breakers
+	defer func(n int, window, cooldown float64) {
+		cfg.Sec.BreakerErrors, cfg.Sec.BreakerWindow, cfg.Sec.BreakerCooldown = n, window, cooldown
+		clock, breakers = savedClock, savedBreakers
+	}(cfg.Sec.BreakerErrors, cfg.Sec.BreakerWindow, cfg.Sec.BreakerCooldown)
+	cfg.Sec.BreakerErrors, cfg.Sec.BreakerWindow, cfg.Sec.BreakerCooldown = 3, 60, 300
+	breakers = make(map[exchange.Interface]*breaker)
+	now := time.Now()
+	manual := exchange.NewManualClock(now)
+	clock = manual
+
+	flaky := sim.New("Flaky", "btc", "usd", 1, 0, 100, 100000, nil)
+	healthy := sim.New("Healthy", "btc", "usd", 1, 0, 100, 100000, nil)
+	for _, exg := range []*sim.Client{flaky, healthy} {
+		exg.SetMaxPos(100)
+	}
+	markets := map[exchange.Interface]filteredBook{
+		flaky:   {bid: market{exg: flaky, orderPrice: 253, adjPrice: 253, amount: 30}, ask: market{exg: flaky, orderPrice: 254, adjPrice: 254, amount: 30}},
+		healthy: {bid: market{exg: healthy, orderPrice: 250, adjPrice: 250, amount: 30}, ask: market{exg: healthy, orderPrice: 251, adjPrice: 251, amount: 30}},
+	}
+	err := errors.New("Flaky SendOrder error: unavailable")
+
+	// Errors spread beyond the window don't trip
+	exchangeError(flaky, err)
+	now = manual.Advance(61 * time.Second)
+	exchangeError(flaky, err)
+	exchangeError(flaky, err)
+	if tripped(flaky) {
+		t.Fatal("Breaker should count errors within the window only")
+	}
+
+	// A third error within the window trips, leaving the flaky exchange out
+	exchangeError(flaky, err)
+	if !tripped(flaky) || tripped(healthy) {
+		t.Fatal("Only the flaky exchange should be tripped")
+	}
+	if findBestBid(markets).exg != healthy {
+		t.Error("Tripped exchange should be left out of the best bid")
+	}
+	if _, _, exists := findBestArb(markets); exists {
+		t.Error("No arb should be found with the flaky exchange tripped")
+	}
+
+	// After the cooldown the exchange is probed, and one error trips it again
+	now = manual.Advance(301 * time.Second)
+	if tripped(flaky) || findBestBid(markets).exg != flaky {
+		t.Fatal("Exchange should be probed after the cooldown")
+	}
+	exchangeError(flaky, err)
+	if !tripped(flaky) {
+		t.Fatal("An error while probing should trip again")
+	}
+
+	// Once the probe passes, errors are counted afresh
+	now = manual.Advance(301*time.Second + 61*time.Second)
+	if tripped(flaky) {
+		t.Fatal("Cooldown should be over")
+	}
+	exchangeError(flaky, err)
+	if tripped(flaky) {
+		t.Fatal("A single error after the probe should not trip")
+	}
+
+	// Disabled breakers never trip
+	cfg.Sec.BreakerErrors = 0
+	for i := 0; i < 5; i++ {
+		exchangeError(healthy, err)
+	}
+	if tripped(healthy) {
+		t.Fatal("Breaker should be disabled")
+	}
+
+	// Insufficient funds trips at once, even with the breaker disabled
+	exchangeError(healthy, fmt.Errorf("Healthy SendOrder error: %w", exchange.NewError(exchange.ErrInsufficientFunds, "not enough balance")))
+	if !tripped(healthy) {
+		t.Fatal("Insufficient funds should trip the breaker")
+	}
+}
@@ -0,0 +1,56 @@
+// Exchange clock offsets for signed requests
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"context"
+	"time"
+)
+
+// Measure each exchange clock able to sync, logging offsets that change
+// Exchanges apply the offset to their own nonces, so a failed sync keeps the last one
+func syncClocks(exgs []exchange.Interface) {
+	for _, exg := range exgs {
+		syncer, ok := exg.(exchange.ClockSyncer)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		offset, err := syncer.SyncClock(ctx)
+		cancel()
+		if exchangeError(exg, err) {
+			continue
+		}
+		if last, ok := clockOffsets[exg]; !ok && offset != 0 || ok && offset != last {
+			logger.Infof("%s clock offset from local time is %s\n", exchangeKey(exg), offset)
+		}
+		clockOffsets[exg] = offset
+	}
+}
+
+// Last offset measured for each exchange, used only by syncClocks
+var clockOffsets = make(map[exchange.Interface]time.Duration)
+
+// Sync clocks every ClockSyncInterval after the startup sync in setExchanges
+// Returns a function stopping the updates
+func startClockSync() func() {
+	if cfg.Sec.ClockSyncInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan bool)
+	go func() {
+		ticker := clock.NewTicker(time.Duration(cfg.Sec.ClockSyncInterval * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.Chan():
+				syncClocks(exchanges)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
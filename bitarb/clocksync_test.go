@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/exchangetest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Exchange with a clock offset to sync
+type clockExchange struct {
+	*exchangetest.Exchange
+	offset time.Duration
+	err    error
+	syncs  int
+}
+
+func (exg *clockExchange) SyncClock(ctx context.Context) (time.Duration, error) {
+	exg.syncs++
+	return exg.offset, exg.err
+}
+
+func TestSyncClocks(t *testing.T) {
+	defer func(offsets map[exchange.Interface]time.Duration) { clockOffsets = offsets }(clockOffsets)
+	clockOffsets = make(map[exchange.Interface]time.Duration)
+	exg := &clockExchange{Exchange: exchangetest.New("Clock", 1), offset: 3 * time.Second}
+	other := exchangetest.New("Other", 1)
+
+	syncClocks([]exchange.Interface{exg, other})
+	if exg.syncs != 1 || clockOffsets[exg] != 3*time.Second {
+		t.Fatal("Offset should be measured")
+	}
+	if _, ok := clockOffsets[other]; ok {
+		t.Fatal("Exchanges without a clock to sync should be skipped")
+	}
+
+	// A failed sync keeps the last offset
+	exg.offset, exg.err = 0, errors.New("Clock SyncClock error: unavailable")
+	syncClocks([]exchange.Interface{exg})
+	if exg.syncs != 2 || clockOffsets[exg] != 3*time.Second {
+		t.Fatal("Failed sync should keep the last offset")
+	}
+}
@@ -0,0 +1,51 @@
+// Fee tier updates from exchange account info
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"math"
+	"time"
+)
+
+// Set fees from the current tier of each exchange able to report it
+// Exchanges guard their fee, so books filtered meanwhile see either the old or the new fee
+func refreshFees(exgs []exchange.Interface) {
+	for _, exg := range exgs {
+		reporter, ok := exg.(exchange.FeeReporter)
+		if !ok {
+			continue
+		}
+		maker, taker, err := reporter.AccountFees()
+		if exchangeError(exg, err) {
+			continue
+		}
+		if oldMaker, oldTaker := exg.MakerFee(), exg.TakerFee(); math.Abs(maker-oldMaker) > .0000001 || math.Abs(taker-oldTaker) > .0000001 {
+			logger.Infof("%s fee tier changed from %.4f maker, %.4f taker to %.4f maker, %.4f taker\n", exchangeKey(exg), oldMaker, oldTaker, maker, taker)
+			exg.SetFees(maker, taker)
+		}
+	}
+}
+
+// Refresh fees at startup and then every FeeInterval
+// Returns a function stopping the updates
+func startFeeUpdates() func() {
+	if cfg.Sec.FeeInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan bool)
+	go func() {
+		ticker := clock.NewTicker(time.Duration(cfg.Sec.FeeInterval * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			refreshFees(exchanges)
+			select {
+			case <-ticker.Chan():
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
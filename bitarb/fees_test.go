@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"errors"
+	"math"
+	"testing"
+)
+
+// Exchange reporting a fee tier
+type feeExchange struct {
+	*sim.Client
+	maker, taker float64
+	err          error
+}
+
+func (exg *feeExchange) AccountFees() (float64, float64, error) {
+	return exg.maker, exg.taker, exg.err
+}
+
+func TestRefreshFees(t *testing.T) {
+	book := exchange.Book{
+		Bids: exchange.BidItems{{Price: 250, Amount: 50}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 50}},
+	}
+	exg := &feeExchange{Client: sim.New("Test", "btc", "usd", 1, .002, 10, 10000, nil), maker: .0005, taker: .001}
+	book.Exg = exg
+	before := filterBook(book, 1)
+
+	// A lower fee tier raises the adjusted bid and lowers the adjusted ask
+	refreshFees([]exchange.Interface{exg})
+	if math.Abs(exg.TakerFee()-.001) > .000001 || math.Abs(exg.MakerFee()-.0005) > .000001 {
+		t.Fatal("Fees should be set from the tier")
+	}
+	after := filterBook(book, 1)
+	if math.Abs(after.bid.adjPrice-250*.999) > .000001 || math.Abs(after.ask.adjPrice-251*1.001) > .000001 {
+		t.Fatalf("Expected adjusted prices at the new fee, got %v and %v", after.bid.adjPrice, after.ask.adjPrice)
+	}
+	if after.bid.adjPrice <= before.bid.adjPrice || after.ask.adjPrice >= before.ask.adjPrice {
+		t.Fatal("Fee change should alter the filtered book")
+	}
+
+	// Errors leave the fee unchanged
+	exg.taker, exg.err = .003, errors.New("AccountFees error: unavailable")
+	refreshFees([]exchange.Interface{exg})
+	if math.Abs(exg.TakerFee()-.001) > .000001 {
+		t.Fatal("Fee should be unchanged after an error")
+	}
+}
@@ -0,0 +1,85 @@
+// Monitoring of exchange book feeds
+// An exchange without fresh books drops out of the arb, so a quiet feed is logged
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"fmt"
+	"time"
+)
+
+// Feed state of the exchanges checked
+type feedMonitor struct {
+	started time.Time                   // Feeds are measured from here until their first book
+	down    map[exchange.Interface]bool // Exchanges last found down, to log changes only
+}
+
+// Return a monitor measuring feeds from now
+func newFeedMonitor() *feedMonitor {
+	return &feedMonitor{started: clock.Now(), down: make(map[exchange.Interface]bool)}
+}
+
+// Check the feed of each exchange able to report it, logging those going down or recovering
+// A feed is down if it has sent no book within FeedTimeout or its last book had an error
+// Returns the exchanges newly down
+func (monitor *feedMonitor) check(exgs []exchange.Interface) []exchange.Interface {
+	var newlyDown []exchange.Interface
+	timeout := time.Duration(cfg.Sec.FeedTimeout * float64(time.Second))
+	for _, exg := range exgs {
+		reporter, ok := exg.(exchange.HealthReporter)
+		if !ok {
+			continue
+		}
+		last := reporter.LastUpdate()
+		silent := clock.Now().Sub(last)
+		if last.Before(monitor.started) {
+			silent = clock.Now().Sub(monitor.started)
+		}
+		failed := !last.IsZero() && !reporter.Connected()
+		down := silent >= timeout || failed
+		if down {
+			connectedGauge.Set(exchangeKey(exg), 0)
+		} else {
+			connectedGauge.Set(exchangeKey(exg), 1)
+		}
+		switch {
+		case down && !monitor.down[exg] && failed:
+			logger.Warnf("!!!!! %s book feed down: last book had an error, trading on fewer exchanges !!!!!\n", exchangeKey(exg))
+			newlyDown = append(newlyDown, exg)
+		case down && !monitor.down[exg]:
+			logger.Warnf("!!!!! %s book feed down: no book for %.0fs, trading on fewer exchanges !!!!!\n", exchangeKey(exg), silent.Seconds())
+			newlyDown = append(newlyDown, exg)
+		case !down && monitor.down[exg]:
+			logger.Infof("%s book feed recovered\n", exchangeKey(exg))
+		}
+		monitor.down[exg] = down
+	}
+	return newlyDown
+}
+
+// Check exchange feeds every quarter of FeedTimeout
+// Returns a function stopping the checks
+func startFeedMonitor() func() {
+	if cfg.Sec.FeedTimeout <= 0 {
+		return func() {}
+	}
+	monitor := newFeedMonitor()
+	done := make(chan bool)
+	go func() {
+		ticker := clock.NewTicker(time.Duration(cfg.Sec.FeedTimeout / 4 * float64(time.Second)))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.Chan():
+				for _, exg := range monitor.check(exchanges) {
+					notifier.Notify(fmt.Sprintf("%s book feed down, trading on fewer exchanges", exchangeKey(exg)))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/exchangetest"
+	"testing"
+	"time"
+)
+
+// Exchange reporting a set feed state
+type feedExchange struct {
+	*exchangetest.Exchange
+	last      time.Time
+	connected bool
+}
+
+func (exg *feedExchange) LastUpdate() time.Time {
+	return exg.last
+}
+
+func (exg *feedExchange) Connected() bool {
+	return exg.connected
+}
+
+func TestFeedMonitor(t *testing.T) {
+	defer func(c exchange.Clock, timeout float64) { clock, cfg.Sec.FeedTimeout = c, timeout }(clock, cfg.Sec.FeedTimeout)
+	now := time.Now()
+	manual := exchange.NewManualClock(now)
+	clock = manual
+	cfg.Sec.FeedTimeout = 30
+
+	exg := &feedExchange{Exchange: exchangetest.New("Test", 1)}
+	exgs := []exchange.Interface{exg}
+	monitor := newFeedMonitor()
+
+	// Feeds get FeedTimeout from the start to send a first book
+	now = manual.Advance(20 * time.Second)
+	if down := monitor.check(exgs); len(down) != 0 {
+		t.Fatal("Feed should not be down before the timeout")
+	}
+	now = manual.Advance(20 * time.Second)
+	if down := monitor.check(exgs); len(down) != 1 || down[0] != exg {
+		t.Fatal("Feed without books should be down")
+	}
+	// Reported once
+	if down := monitor.check(exgs); len(down) != 0 {
+		t.Fatal("Feed already down should not be reported again")
+	}
+
+	// A good book recovers the feed
+	exg.last, exg.connected = now, true
+	monitor.check(exgs)
+	if monitor.down[exg] || connectedGauge.Value(exchangeKey(exg)) != 1 {
+		t.Fatal("Feed should recover after a good book")
+	}
+
+	// A book with an error is down before the timeout
+	exg.connected = false
+	if down := monitor.check(exgs); len(down) != 1 {
+		t.Fatal("Feed whose last book had an error should be down")
+	}
+}
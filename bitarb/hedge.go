@@ -0,0 +1,104 @@
+// Forced hedging of one-legged exposure
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"math"
+	"time"
+)
+
+// Return a channel ticking often enough to enforce HedgeTimeout and a function stopping it
+// The channel is nil if HedgeTimeout is not set
+func hedgeTicker() (<-chan time.Time, func()) {
+	if cfg.Sec.HedgeTimeout <= 0 {
+		return nil, func() {}
+	}
+	interval := time.Duration(math.Min(cfg.Sec.HedgeTimeout/4, 1) * float64(time.Second))
+	ticker := clock.NewTicker(interval)
+	return ticker.Chan(), ticker.Stop
+}
+
+// Build a snapshot of every exchange for the symbol that has ever had a usable book, stale or not
+// Must be called from the trading goroutine, returns nil once handleData has stopped
+func lastKnownMarkets(exgs []exchange.Interface, books bookRequester) map[exchange.Interface]filteredBook {
+	markets := make(map[exchange.Interface]filteredBook)
+	for _, exg := range exgs {
+		fb, ok := books.book(exg)
+		if !ok {
+			return nil
+		}
+		if !fb.time.IsZero() {
+			markets[exg] = fb
+		}
+	}
+	return markets
+}
+
+// Track how long the symbol's net position has been beyond MinNetPos
+// Past HedgeTimeout the position is flattened by forceHedge, and the timer restarts
+func (state *tradeState) checkExposure(symbol string, markets map[exchange.Interface]filteredBook) {
+	netPosition := netPositions[symbol]
+	if math.Abs(netPosition) < cfg.Sec.MinNetPos {
+		state.exposedSince = time.Time{}
+		return
+	}
+	if state.exposedSince.IsZero() {
+		state.exposedSince = clock.Now()
+		return
+	}
+	if clock.Now().Sub(state.exposedSince) < time.Duration(cfg.Sec.HedgeTimeout*float64(time.Second)) {
+		return
+	}
+	forceHedge(symbol, netPosition, markets)
+	calcNetPosition()
+	state.exposedSince = time.Time{}
+	if cfg.Sec.PrintOn {
+		printResults()
+	}
+}
+
+// Flatten a net position with a market order on the exchange with the best last known price, of those taking market orders
+// Stale books are used since fresh data may be what's missing
+// The amount is limited by the exchange's position capacity but not by book depth or MinOrder
+func forceHedge(symbol string, netPosition float64, markets map[exchange.Interface]filteredBook) {
+	action := "sell"
+	if netPosition < 0 {
+		action = "buy"
+	}
+	var (
+		m        market
+		capacity float64
+	)
+	for exg, fb := range markets {
+		side, able := fb.bid, ableToSell(exg)
+		if action == "buy" {
+			side, able = fb.ask, ableToBuy(exg, fb.ask)
+		}
+		// Skip sides without a price and exchanges unable to trade, or to take market orders
+		if side.orderPrice <= 0 || able <= 0 || able < exg.MinOrderSize() || !supportsMarket(exg) {
+			continue
+		}
+		if m.exg == nil || action == "sell" && side.adjPrice > m.adjPrice || action == "buy" && side.adjPrice < m.adjPrice {
+			m, capacity = side, able
+		}
+	}
+	if m.exg == nil {
+		logger.Errorf("!!!!! %sFORCED HEDGE of %.4f failed: no exchange able to %s !!!!!\n", symbolPrefix(symbol), netPosition, action)
+		return
+	}
+	amount := math.Min(math.Abs(netPosition), capacity)
+	logger.Warnf("!!!!! %sFORCED HEDGE: net position %.4f held past %.0fs, market %s %.4f on %s !!!!!\n",
+		symbolPrefix(symbol), netPosition, cfg.Sec.HedgeTimeout, action, amount, m.exg)
+	fillChan := make(chan float64)
+	// The price is a reference for exchanges sizing market buys in fiat
+	go placeOrder(m.exg, action, "market", amount, m.orderPrice, fillChan)
+	updatePL(m, action, amount, <-fillChan)
+}
+
+// Whether exg accepts the market orders sent by forceHedge
+func supportsMarket(exg exchange.Interface) bool {
+	trader, ok := exg.(exchange.MarketTrader)
+	return ok && trader.SupportsMarket()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestForcedHedge(t *testing.T) {
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 250, Amount: 50}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 50}},
+	}}
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, books)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 10, 10000, books)
+	testSymbols(t, map[string][]exchange.Interface{"btc": {exg1, exg2}}, "btc")
+	savedClock, savedPL := clock, pl
+	defer func(m, h float64) {
+		cfg.Sec.MinNetPos, cfg.Sec.HedgeTimeout = m, h
+		clock, pl = savedClock, savedPL
+	}(cfg.Sec.MinNetPos, cfg.Sec.HedgeTimeout)
+	cfg.Sec.MinNetPos, cfg.Sec.HedgeTimeout = .1, 30
+	now := time.Now()
+	manual := exchange.NewManualClock(now)
+	clock = manual
+
+	// One leg filled and the books went stale
+	exg1.SetPosition(1.5)
+	calcNetPosition()
+	markets := make(map[exchange.Interface]filteredBook)
+	for _, exg := range []exchange.Interface{exg1, exg2} {
+		book := books[0]
+		book.Exg, book.Time = exg, now.Add(-5*time.Minute)
+		markets[exg] = filterBook(book, 1)
+		exg.SetMaxPos(10)
+	}
+
+	var state tradeState
+	state.checkExposure("btc", markets)
+	now = manual.Advance(20 * time.Second)
+	state.checkExposure("btc", markets)
+	if math.Abs(netPositions["btc"]-1.5) > .000001 {
+		t.Fatal("Should not hedge before HedgeTimeout")
+	}
+
+	// Flattened with a market order past the timeout
+	now = manual.Advance(20 * time.Second)
+	state.checkExposure("btc", markets)
+	if math.Abs(netPositions["btc"]) > .000001 {
+		t.Fatalf("Expected flat net position, got %v", netPositions["btc"])
+	}
+	if !state.exposedSince.IsZero() {
+		t.Fatal("Exposure timer should restart after the hedge")
+	}
+}
+
+func TestLastKnownMarkets(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 10, 10000, nil)
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	go func() {
+		for exg := range requestBook {
+			fb := filteredBook{bid: market{exg: exg, orderPrice: 250}}
+			// Test2 has never had a usable book
+			if exg == exg1 {
+				fb.time = time.Now().Add(-5 * time.Minute)
+			}
+			receiveBook <- fb
+		}
+	}()
+	defer close(requestBook)
+	stopped := make(chan bool)
+
+	// Stale books are kept
+	markets := lastKnownMarkets([]exchange.Interface{exg1, exg2}, bookRequester{requestBook, receiveBook, stopped})
+	if _, ok := markets[exg1]; !ok || len(markets) != 1 {
+		t.Fatalf("Expected only Test1's stale book, got %v", markets)
+	}
+
+	// Nothing once handleData has stopped
+	close(stopped)
+	if markets := lastKnownMarkets([]exchange.Interface{exg1}, bookRequester{make(chan exchange.Interface), receiveBook, stopped}); markets != nil {
+		t.Fatalf("Expected no markets after handleData stopped, got %v", markets)
+	}
+}
+
+func TestForcedHedgeAvailShort(t *testing.T) {
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 250, Amount: 50}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 50}},
+	}}
+	better := books[0]
+	better.Bids = exchange.BidItems{{Price: 255, Amount: 50}}
+	noShort := sim.New("Test1", "btc", "usd", 1, 0, 0, 10000, []exchange.Book{better})
+	exg := sim.New("Test2", "btc", "usd", 1, 0, 10, 10000, books)
+	testSymbols(t, map[string][]exchange.Interface{"btc": {noShort, exg}}, "btc")
+	defer func(p float64) { pl = p }(pl)
+
+	// The better bid is on an exchange with nothing to short
+	markets := make(map[exchange.Interface]filteredBook)
+	for _, book := range []exchange.Book{{Exg: noShort, Bids: better.Bids, Asks: better.Asks}, {Exg: exg, Bids: books[0].Bids, Asks: books[0].Asks}} {
+		markets[book.Exg] = filterBook(book, 1)
+		book.Exg.SetMaxPos(10)
+	}
+	exg.SetPosition(2)
+	forceHedge("btc", 1, markets)
+	if math.Abs(exg.Position()-1) > .000001 || noShort.Position() != 0 {
+		t.Fatalf("Expected the hedge on the exchange able to short, got positions %v and %v", exg.Position(), noShort.Position())
+	}
+}
+
+// Exchange hiding the optional interfaces of the one it wraps, so it takes no market orders
+type limitOnlyExchange struct {
+	exchange.Interface
+}
+
+func TestForcedHedgeLimitOnly(t *testing.T) {
+	books := []exchange.Book{{
+		Bids: exchange.BidItems{{Price: 250, Amount: 50}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 50}},
+	}}
+	better := books[0]
+	better.Bids = exchange.BidItems{{Price: 255, Amount: 50}}
+	limitOnly := &limitOnlyExchange{sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, []exchange.Book{better})}
+	exg := sim.New("Test2", "btc", "usd", 1, 0, 10, 10000, books)
+	testSymbols(t, map[string][]exchange.Interface{"btc": {limitOnly, exg}}, "btc")
+	defer func(p float64) { pl = p }(pl)
+
+	// The better bid is on an exchange that would reject the market order
+	markets := make(map[exchange.Interface]filteredBook)
+	for _, book := range []exchange.Book{{Exg: limitOnly, Bids: better.Bids, Asks: better.Asks}, {Exg: exg, Bids: books[0].Bids, Asks: books[0].Asks}} {
+		markets[book.Exg] = filterBook(book, 1)
+		book.Exg.SetMaxPos(10)
+	}
+	exg.SetPosition(1)
+	forceHedge("btc", 1, markets)
+	if math.Abs(exg.Position()) > .000001 || limitOnly.Position() != 0 {
+		t.Fatalf("Expected the hedge on the exchange taking market orders, got positions %v and %v", exg.Position(), limitOnly.Position())
+	}
+
+	// With no exchange taking market orders nothing is sent
+	delete(markets, exg)
+	forceHedge("btc", 1, markets)
+	if limitOnly.Position() != 0 {
+		t.Fatal("Limit-only exchange should not be sent a market order")
+	}
+}
@@ -0,0 +1,87 @@
+// Append-only CSV record of trades
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Ledger column names
+var ledgerHeader = []string{"timestamp", "exchange", "action", "requested", "filled", "order_price", "adj_price", "pl"}
+
+// Trade defines a ledger record
+type Trade struct {
+	Time       time.Time
+	Exchange   string
+	Action     string  // "buy" or "sell"
+	Requested  float64 // Amount sent to exchange
+	Filled     float64 // Amount filled
+	OrderPrice float64 // Price sent to exchange
+	AdjPrice   float64 // Price adjusted for fees and currency
+	PL         float64 // Running P&L after the trade
+}
+
+// Receives every trade
+type tradeRecorder interface {
+	Append(t Trade) error
+	Close() error
+}
+
+// Appends trades to a CSV file
+type ledger struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// Open a ledger file for appending, writing a header if the file is new
+func openLedger(filename string) (*ledger, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	l := &ledger{file: file, writer: csv.NewWriter(file)}
+	if info.Size() == 0 {
+		if err := l.write(ledgerHeader); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Append writes a trade and flushes it to the file
+func (l *ledger) Append(t Trade) error {
+	return l.write([]string{
+		t.Time.UTC().Format(time.RFC3339Nano),
+		t.Exchange,
+		t.Action,
+		fmt.Sprintf("%f", t.Requested),
+		fmt.Sprintf("%f", t.Filled),
+		fmt.Sprintf("%f", t.OrderPrice),
+		fmt.Sprintf("%f", t.AdjPrice),
+		fmt.Sprintf("%f", t.PL),
+	})
+}
+
+// Write and flush a record
+func (l *ledger) write(record []string) error {
+	if err := l.writer.Write(record); err != nil {
+		return err
+	}
+	l.writer.Flush()
+	return l.writer.Error()
+}
+
+// Close the ledger file
+func (l *ledger) Close() error {
+	return l.file.Close()
+}
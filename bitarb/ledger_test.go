@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLedger(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "trades.csv")
+	trade := Trade{
+		Time:       time.Unix(1427811013, 0),
+		Exchange:   "OKCoin(usd)",
+		Action:     "buy",
+		Requested:  1,
+		Filled:     0.5,
+		OrderPrice: 1.65,
+		AdjPrice:   1.653,
+		PL:         -0.8265,
+	}
+
+	// Append across two opens, as across restarts
+	for i := 0; i < 2; i++ {
+		l, err := openLedger(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Append(trade); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected header and 2 trades, got %d records", len(records))
+	}
+	if records[0][0] != "timestamp" || records[1][1] != "OKCoin(usd)" || records[2][4] != "0.500000" {
+		t.Fatalf("Unexpected ledger contents: %v", records)
+	}
+}
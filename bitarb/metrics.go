@@ -0,0 +1,47 @@
+// Prometheus metrics for the running system
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"bitfx/metrics"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	positionGauge    = metrics.NewGaugeVec("bitarb_position", "Position on each exchange", "exchange")
+	netPositionGauge = metrics.NewGaugeVec("bitarb_net_position", "Net position across exchanges", "symbol")
+	plGauge          = metrics.NewGauge("bitarb_pl", "Net P&L for current run")
+	tradesCounter    = metrics.NewCounter("bitarb_trades_total", "Orders filled at least in part")
+	connectedGauge   = metrics.NewGaugeVec("bitarb_exchange_connected", "1 if the exchange book feed is up, 0 if down", "exchange")
+	arbHistogram     = metrics.NewHistogram("bitarb_arb_spread", "Adjusted spread of arb opportunities found", []float64{-1, -.5, 0, .5, 1, 2, 5, 10})
+	latencyGauge     = metrics.NewGaugeVec("bitarb_order_latency_seconds", "Round trip of the last order request to each exchange", "exchange")
+)
+
+// Serve metrics over HTTP if a port is configured
+func setMetrics() {
+	if cfg.Sec.MetricsPort <= 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		logger.Errorf("%s", http.ListenAndServe(fmt.Sprintf(":%d", cfg.Sec.MetricsPort), mux))
+	}()
+}
+
+// Log and export the round trip of an exchange's last order request, if it times them
+// Returns the round trip and whether the exchange reported one
+func recordLatency(exg exchange.Interface, call string) (time.Duration, bool) {
+	reporter, ok := exg.(exchange.LatencyReporter)
+	if !ok {
+		return 0, false
+	}
+	latency := reporter.LastLatency()
+	logger.Debugf("%s %s round trip: %s\n", exg, call, latency)
+	latencyGauge.Set(exchangeKey(exg), latency.Seconds())
+	return latency, true
+}
@@ -0,0 +1,151 @@
+// Execution priority from measured latency and fill reliability
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Orders kept per exchange for the rolling latency and fill rate
+const statsWindow = 20
+
+// Recent order results on one exchange, oldest first
+type orderStats struct {
+	latencies []float64 // SendOrder round trips in seconds
+	fillRates []float64 // Filled share of each order sent
+}
+
+// Rolling order results and the priorities ranked from them
+// Results are recorded from order goroutines, ranks are read and set by the trading goroutine
+var (
+	stats      = make(map[exchange.Interface]*orderStats)
+	ranks      map[exchange.Interface]int // Effective priorities, nil for static priority
+	statsMutex sync.Mutex
+)
+
+// Return a channel ticking every PriorityInterval and a function stopping it
+// The channel is nil if PriorityInterval is not set
+func priorityTicker() (<-chan time.Time, func()) {
+	if cfg.Sec.PriorityInterval <= 0 {
+		return nil, func() {}
+	}
+	ticker := clock.NewTicker(time.Duration(cfg.Sec.PriorityInterval * float64(time.Second)))
+	return ticker.Chan(), ticker.Stop
+}
+
+// Effective priority of an exchange, lower values sent first
+// Static priority until ranks are set by updatePriorities
+func priorityOf(exg exchange.Interface) int {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	if rank, ok := ranks[exg]; ok {
+		return rank
+	}
+	return exg.Priority()
+}
+
+// Add a value to a window, dropping the oldest past statsWindow
+func appendWindow(window []float64, value float64) []float64 {
+	window = append(window, value)
+	if len(window) > statsWindow {
+		window = window[1:]
+	}
+	return window
+}
+
+// Record the SendOrder round trip of an exchange timing its requests
+func recordSendLatency(exg exchange.Interface, latency time.Duration) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	s := statsFor(exg)
+	s.latencies = appendWindow(s.latencies, latency.Seconds())
+}
+
+// Record the share of an order filled, orders that failed outright counting as unfilled
+func recordFillRate(exg exchange.Interface, requested, filled float64) {
+	if requested <= 0 {
+		return
+	}
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	s := statsFor(exg)
+	s.fillRates = appendWindow(s.fillRates, math.Min(filled/requested, 1))
+}
+
+// Stats of an exchange, created if missing
+// Must be called with statsMutex held
+func statsFor(exg exchange.Interface) *orderStats {
+	s, ok := stats[exg]
+	if !ok {
+		s = &orderStats{}
+		stats[exg] = s
+	}
+	return s
+}
+
+// Expected time to a confirmed fill, the mean round trip over the fill rate
+// Returns false without both latencies and fills recorded
+func (s *orderStats) score() (float64, bool) {
+	if s == nil || len(s.latencies) == 0 || len(s.fillRates) == 0 {
+		return 0, false
+	}
+	var latency, fillRate float64
+	for _, l := range s.latencies {
+		latency += l
+	}
+	for _, f := range s.fillRates {
+		fillRate += f
+	}
+	latency /= float64(len(s.latencies))
+	fillRate /= float64(len(s.fillRates))
+	// Exchanges that never fill rank last among those measured
+	return latency / math.Max(fillRate, .01), true
+}
+
+// Rank exchanges by score, fastest and most reliable first, setting their effective priorities
+// Unmeasured exchanges follow in static priority order, and equal scores share a rank for simultaneous orders
+// Must be called from the trading goroutine, so ranks don't change within a trading decision
+func updatePriorities(exgs []exchange.Interface) {
+	type ranked struct {
+		exg      exchange.Interface
+		score    float64
+		measured bool
+	}
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	list := make([]ranked, len(exgs))
+	for i, exg := range exgs {
+		score, measured := stats[exg].score()
+		list[i] = ranked{exg, score, measured}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].measured != list[j].measured {
+			return list[i].measured
+		}
+		if list[i].measured && list[i].score != list[j].score {
+			return list[i].score < list[j].score
+		}
+		return list[i].exg.Priority() < list[j].exg.Priority()
+	})
+
+	newRanks := make(map[exchange.Interface]int)
+	for i, r := range list {
+		rank := i + 1
+		if i > 0 {
+			prev := list[i-1]
+			if prev.measured == r.measured && (r.measured && prev.score == r.score || !r.measured && prev.exg.Priority() == r.exg.Priority()) {
+				rank = newRanks[prev.exg]
+			}
+		}
+		newRanks[r.exg] = rank
+		if old, ok := ranks[r.exg]; !ok || old != rank {
+			logger.Infof("%s effective priority set to %d, score %.4f\n", exchangeKey(r.exg), rank, r.score)
+		}
+	}
+	ranks = newRanks
+}
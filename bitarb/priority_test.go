@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/exchangetest"
+	"testing"
+	"time"
+)
+
+func TestUpdatePriorities(t *testing.T) {
+	defer func(s map[exchange.Interface]*orderStats, r map[exchange.Interface]int, delay float64) {
+		stats, ranks, cfg.Sec.StatusPollDelay = s, r, delay
+	}(stats, ranks, cfg.Sec.StatusPollDelay)
+	stats, ranks = make(map[exchange.Interface]*orderStats), nil
+	cfg.Sec.StatusPollDelay = .01
+
+	slow, fast, unmeasured := exchangetest.New("Slow", 1), exchangetest.New("Fast", 2), exchangetest.New("Unmeasured", 3)
+	exgs := []exchange.Interface{slow, fast, unmeasured}
+	for _, exg := range exgs {
+		if priorityOf(exg) != exg.Priority() {
+			t.Fatalf("%s should have static priority before ranking", exg)
+		}
+	}
+
+	// Slow fills everything in .5 seconds, fast fills half in .1 seconds
+	recordSendLatency(slow, 500*time.Millisecond)
+	recordFillRate(slow, 1, 1)
+	recordSendLatency(fast, 100*time.Millisecond)
+	recordFillRate(fast, 1, 1)
+	recordFillRate(fast, 1, 0)
+	// Latency alone isn't enough to be measured
+	recordSendLatency(unmeasured, time.Millisecond)
+	updatePriorities(exgs)
+	for exg, rank := range map[exchange.Interface]int{fast: 1, slow: 2, unmeasured: 3} {
+		if priorityOf(exg) != rank {
+			t.Errorf("%s ranked %d, expected %d", exg, priorityOf(exg), rank)
+		}
+	}
+
+	// The fast exchange now confirms first despite its static priority
+	var journal exchangetest.Journal
+	slow.Journal, fast.Journal = &journal, &journal
+	bid := market{exg: slow, orderPrice: 253, adjPrice: 253, amount: 1}
+	ask := market{exg: fast, orderPrice: 251, adjPrice: 251, amount: 1}
+	sendPair(bid, ask, 1, false, false)
+	if orders := journal.Orders(); len(orders) != 2 || orders[0].Exchange != fast {
+		t.Errorf("Fast exchange should be sent first, got %v", orders)
+	}
+
+	// Old results roll out of the window
+	for i := 0; i < statsWindow; i++ {
+		recordFillRate(fast, 1, 1)
+	}
+	if len(stats[fast].fillRates) != statsWindow {
+		t.Errorf("Window holds %d fills, expected %d", len(stats[fast].fillRates), statsWindow)
+	}
+}
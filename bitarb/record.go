@@ -0,0 +1,144 @@
+// Recording of received book and FX data for backtesting
+// Records are newline-delimited JSON, one file per UTC day
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/forex"
+	"bitfx/logger"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Directory to record in, recording is off if empty
+var recordDir = flag.String("record", "", "Directory to record received books and FX quotes in for backtesting")
+
+// Data recorder, nil if not recording
+var rec *recorder
+
+// Recorded book or FX quote
+type record struct {
+	Type     string       `json:"type"` // "book" or "fx"
+	Received time.Time    `json:"received"`
+	Exchange string       `json:"exchange,omitempty"`
+	Bids     [][2]float64 `json:"bids,omitempty"`   // Price and amount
+	Asks     [][2]float64 `json:"asks,omitempty"`   // Price and amount
+	Symbol   string       `json:"symbol,omitempty"` // Trading symbol for books, currency for FX quotes
+	Price    float64      `json:"price,omitempty"`
+	Age      float64      `json:"age,omitempty"` // Seconds since the quote was fetched
+}
+
+// Writes records in the background so data handling never waits on disk
+type recorder struct {
+	dir     string
+	records chan record
+	stop    chan bool
+	done    chan bool
+	file    *os.File
+	day     string // UTC date of the open file
+}
+
+// Start recording to files in dir
+func newRecorder(dir string) *recorder {
+	r := &recorder{
+		dir:     dir,
+		records: make(chan record, 1000),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+	go r.run()
+	return r
+}
+
+// Set recorder if a directory is configured
+func setRecorder() {
+	if *recordDir != "" {
+		rec = newRecorder(*recordDir)
+		logger.Infof("Recording books and FX quotes to %s\n", *recordDir)
+	}
+}
+
+// Book records a book, safe to call on a nil recorder
+// Books with errors carry no data and are skipped
+func (r *recorder) Book(book exchange.Book) {
+	if r == nil || book.Error != nil || book.Exg == nil {
+		return
+	}
+	rc := record{Type: "book", Received: clock.Now(), Exchange: book.Exg.String(), Symbol: symbolOf(book.Exg)}
+	for _, bid := range book.Bids {
+		rc.Bids = append(rc.Bids, [2]float64{bid.Price, bid.Amount})
+	}
+	for _, ask := range book.Asks {
+		rc.Asks = append(rc.Asks, [2]float64{ask.Price, ask.Amount})
+	}
+	r.send(rc)
+}
+
+// Quote records an FX quote, safe to call on a nil recorder
+// Quotes with errors are skipped
+func (r *recorder) Quote(quote forex.Quote) {
+	if r == nil || quote.Error != nil {
+		return
+	}
+	r.send(record{Type: "fx", Received: clock.Now(), Symbol: quote.Symbol, Price: quote.Price, Age: quote.Age.Seconds()})
+}
+
+// Queue a record, dropping it if the writer is behind or stopped
+func (r *recorder) send(rc record) {
+	select {
+	case <-r.stop:
+	case r.records <- rc:
+	default:
+		logger.Warnf("Recorder busy, record dropped")
+	}
+}
+
+// Write queued records until stopped, then write what remains
+func (r *recorder) run() {
+	for {
+		select {
+		case rc := <-r.records:
+			isError(r.write(rc))
+		case <-r.stop:
+			for len(r.records) > 0 {
+				isError(r.write(<-r.records))
+			}
+			if r.file != nil {
+				isError(r.file.Close())
+			}
+			r.done <- true
+			return
+		}
+	}
+}
+
+// Write a record, moving to a new file on a new UTC day
+func (r *recorder) write(rc record) error {
+	if day := rc.Received.UTC().Format("2006-01-02"); day != r.day || r.file == nil {
+		if r.file != nil {
+			if err := r.file.Close(); err != nil {
+				return err
+			}
+			r.file = nil
+		}
+		file, err := os.OpenFile(filepath.Join(r.dir, "record-"+day+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		r.file, r.day = file, day
+	}
+	return json.NewEncoder(r.file).Encode(rc)
+}
+
+// Close stops recording after writing queued records, safe to call on a nil recorder
+func (r *recorder) Close() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
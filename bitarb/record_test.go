@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/forex"
+	"bitfx/sim"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fake exchange with the name of a live one
+type namedExchange struct {
+	*sim.Client
+	name string
+}
+
+func (exg *namedExchange) String() string {
+	return exg.name
+}
+
+func TestRecordReplay(t *testing.T) {
+	savedClock := clock
+	defer func() { clock = savedClock }()
+	now := time.Date(2015, 4, 1, 23, 59, 59, 0, time.UTC)
+	manual := exchange.NewManualClock(now)
+	clock = manual
+
+	dir := t.TempDir()
+	r := newRecorder(dir)
+	exg := &namedExchange{sim.New("", "btc", "usd", 1, 0, 0, 0, nil), "A(usd)"}
+	other := &namedExchange{sim.New("", "btc", "usd", 1, 0, 0, 0, nil), "Other(usd)"}
+	book := exchange.Book{
+		Exg:  exg,
+		Bids: exchange.BidItems{{Price: 249, Amount: 2}, {Price: 248, Amount: 1}},
+		Asks: exchange.AskItems{{Price: 251, Amount: 3}},
+	}
+	r.Book(book)
+	r.Quote(forex.Quote{Symbol: "cny", Price: 6.2, Age: 10 * time.Second})
+	r.Book(exchange.Book{Exg: exg, Error: errors.New("skipped")})
+	r.Quote(forex.Quote{Symbol: "cny", Error: errors.New("skipped")})
+	// Next day goes to a new file
+	now = manual.Advance(2 * time.Second)
+	book.Exg = other
+	r.Book(book)
+	r.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "record-*.jsonl"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 daily files, got %v", files)
+	}
+
+	books, quotes, err := readBacktestData(dir, "", []simVenue{{name: "A", currency: "usd"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || len(books[0]) != 1 {
+		t.Fatal("Expected one book for the venue")
+	}
+	replayed := books[0][0]
+	if !replayed.Time.Equal(now.Add(-2*time.Second)) || len(replayed.Bids) != 2 || replayed.Bids[1].Price != 248 || replayed.Asks[0].Amount != 3 {
+		t.Fatalf("Wrong replayed book: %v", replayed)
+	}
+	if len(quotes) != 1 || quotes[0].quote.Price != 6.2 || !quotes[0].time.Equal(now.Add(-12*time.Second)) {
+		t.Fatalf("Wrong replayed quotes: %v", quotes)
+	}
+}
+
+// Recording is off with a nil recorder
+func TestNilRecorder(t *testing.T) {
+	var r *recorder
+	r.Book(exchange.Book{})
+	r.Quote(forex.Quote{})
+	r.Close()
+}
@@ -0,0 +1,177 @@
+// Positions and P&L saved between runs
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Saved state, with positions keyed by exchange name
+// Names are prefixed with the symbol when more than one symbol is traded
+type savedState struct {
+	Positions  map[string]float64 `json:"positions"`
+	PL         float64            `json:"pl"`
+	SymbolPL   map[string]float64 `json:"symbolPL,omitempty"`
+	ExchangePL map[string]venuePL `json:"exchangePL,omitempty"` // Keyed as positions
+}
+
+// Return the state of the exchanges in use
+func currentState() savedState {
+	state := savedState{Positions: make(map[string]float64), PL: pl, ExchangePL: make(map[string]venuePL)}
+	for _, exg := range exchanges {
+		state.Positions[exchangeKey(exg)] = exg.Position()
+		state.ExchangePL[exchangeKey(exg)] = exchangePL[exchangeKey(exg)]
+	}
+	if len(symbols) > 1 {
+		state.SymbolPL = make(map[string]float64)
+		for _, symbol := range symbols {
+			state.SymbolPL[symbol] = symbolPL[symbol]
+		}
+	}
+	return state
+}
+
+// Set positions and P&L from a saved state
+// Exchanges missing from the state are left flat, and start without P&L from older states
+// Positions saved by a single symbol run are loaded for the first symbol
+func (state savedState) apply() {
+	names := make(map[string]bool)
+	for _, exg := range exchanges {
+		key := exchangeKey(exg)
+		position, ok := state.Positions[key]
+		if !ok && len(symbols) > 1 && symbolOf(exg) == symbols[0] {
+			key = exg.String()
+			position, ok = state.Positions[key]
+		}
+		names[key] = true
+		if !ok {
+			logger.Infof("No saved position for %s\n", exchangeKey(exg))
+			continue
+		}
+		exg.SetPosition(position)
+		logger.Infof("Loaded %s position %f\n", exchangeKey(exg), position)
+	}
+	for name := range state.Positions {
+		if !names[name] {
+			logger.Warnf("Saved position for %s ignored, exchange not in use\n", name)
+		}
+	}
+	pl = state.PL
+	symbolPL = make(map[string]float64)
+	for symbol, symbolTotal := range state.SymbolPL {
+		symbolPL[symbol] = symbolTotal
+	}
+	if len(state.SymbolPL) == 0 && len(symbols) > 0 {
+		symbolPL[symbols[0]] = pl
+	}
+	exchangePL = make(map[string]venuePL)
+	for key, venue := range state.ExchangePL {
+		exchangePL[key] = venue
+	}
+	logger.Infof("Loaded P&L %f\n", pl)
+}
+
+// Read a state file
+func readState(filename string) (savedState, error) {
+	var state savedState
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%s: %s", filename, err)
+	}
+	return state, nil
+}
+
+// Read a legacy CSV state file
+// Columns are positions in the order of exchanges, then P&L
+func readLegacyState(filename string) (savedState, error) {
+	state := savedState{Positions: make(map[string]float64)}
+	file, err := os.Open(filename)
+	if err != nil {
+		return state, err
+	}
+	defer file.Close()
+	record, err := csv.NewReader(file).Read()
+	if err != nil {
+		return state, fmt.Errorf("%s: %s", filename, err)
+	}
+	if len(record) != len(exchanges)+1 {
+		return state, fmt.Errorf("%s: %d columns for %d exchanges", filename, len(record), len(exchanges))
+	}
+	for i, exg := range exchanges {
+		position, err := strconv.ParseFloat(record[i], 64)
+		if err != nil {
+			return state, fmt.Errorf("%s: %s", filename, err)
+		}
+		state.Positions[exchangeKey(exg)] = position
+	}
+	state.PL, err = strconv.ParseFloat(record[len(exchanges)], 64)
+	if err != nil {
+		return state, fmt.Errorf("%s: %s", filename, err)
+	}
+	return state, nil
+}
+
+// Write a state file
+// Written to a temporary file first so a crash can't leave it truncated
+func writeState(filename string, state savedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Check loaded positions against the exchanges, warning or halting on a mismatch
+func checkPositions() {
+	if cfg.Sec.PositionTolerance <= 0 {
+		return
+	}
+	if err := reconcilePositions(cfg.Sec.PositionTolerance); err != nil {
+		if cfg.Sec.HaltOnMismatch {
+			log.Fatal(err)
+		}
+		logger.Warnf("!!!!! WARNING: %s !!!!!\n", err)
+		fmt.Printf("WARNING: %s\n", err)
+	}
+}
+
+// Compare loaded positions with actual positions on exchanges able to report them
+// Returns an error naming every exchange differing by more than tolerance
+func reconcilePositions(tolerance float64) error {
+	var mismatches []string
+	for _, exg := range exchanges {
+		reporter, ok := exg.(exchange.PositionReporter)
+		if !ok {
+			continue
+		}
+		actual, err := reporter.ActualPosition()
+		if isError(err) {
+			mismatches = append(mismatches, fmt.Sprintf("%s actual position unknown", exchangeKey(exg)))
+			continue
+		}
+		if math.Abs(actual-exg.Position()) > tolerance {
+			mismatches = append(mismatches, fmt.Sprintf("%s loaded %.4f, actual %.4f", exchangeKey(exg), exg.Position(), actual))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("position mismatch: %s", strings.Join(mismatches, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Set the exchanges in use for a test, restoring them and P&L after
+func testExchanges(t *testing.T, exgs ...exchange.Interface) {
+	saved, savedPL, savedExchangePL := exchanges, pl, exchangePL
+	t.Cleanup(func() { exchanges, pl, exchangePL = saved, savedPL, savedExchangePL })
+	exchangePL = make(map[string]venuePL)
+	exchanges = exgs
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 10, 10000, nil)
+	testExchanges(t, exg1, exg2)
+	exg1.SetPosition(1.5)
+	exg2.SetPosition(-2.25)
+	pl = 12.5
+
+	filename := filepath.Join(t.TempDir(), "status.json")
+	if err := writeState(filename, currentState()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reordered exchanges still get their own positions
+	exg1.SetPosition(0)
+	exg2.SetPosition(0)
+	pl = 0
+	exchanges = []exchange.Interface{exg2, exg1}
+	state, err := readState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.apply()
+	if math.Abs(exg1.Position()-1.5) > .000001 || math.Abs(exg2.Position()+2.25) > .000001 {
+		t.Fatal("Positions should be loaded by exchange name")
+	}
+	if math.Abs(pl-12.5) > .000001 {
+		t.Fatal("P&L should be loaded")
+	}
+
+	if _, err := readState(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Fatal("Missing file should be reported as not existing")
+	}
+}
+
+func TestExchangePL(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 10, 10000, nil)
+	testExchanges(t, exg1, exg2)
+	defer func() { symbolPL = make(map[string]float64) }()
+	pl = 0
+
+	// Bought on the CNY exchange at 6 per USD and sold on the USD exchange
+	updatePL(market{exg: exg2, orderPrice: 1500, adjPrice: 250, fxPrice: 6}, "buy", 1, 1)
+	updatePL(market{exg: exg1, orderPrice: 252, adjPrice: 252, fxPrice: 1}, "sell", 1, 1)
+	venue1, venue2 := exchangePL["SimTest1(usd)"], exchangePL["SimTest2(cny)"]
+	if math.Abs(venue1.USD-252) > .000001 || math.Abs(venue1.Native-252) > .000001 {
+		t.Fatalf("Wrong USD exchange P&L %+v", venue1)
+	}
+	if math.Abs(venue2.USD+250) > .000001 || math.Abs(venue2.Native+1500) > .000001 {
+		t.Fatalf("Wrong CNY exchange P&L %+v", venue2)
+	}
+	if math.Abs(venue1.USD+venue2.USD-pl) > .000001 {
+		t.Fatal("Exchange P&L should sum to run P&L")
+	}
+
+	// Saved with positions
+	filename := filepath.Join(t.TempDir(), "status.json")
+	if err := writeState(filename, currentState()); err != nil {
+		t.Fatal(err)
+	}
+	exchangePL = make(map[string]venuePL)
+	state, err := readState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.apply()
+	if exchangePL["SimTest2(cny)"] != venue2 || exchangePL["SimTest1(usd)"] != venue1 {
+		t.Fatalf("Exchange P&L should be loaded, got %v", exchangePL)
+	}
+}
+
+func TestUnrealizedPL(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 10, 10000, nil)
+	exg3 := sim.New("Test3", "btc", "usd", 1, 0, 10, 10000, nil)
+	defer func(marks map[exchange.Interface]float64) { markPrices = marks }(markPrices)
+	markPrices = make(map[exchange.Interface]float64)
+	exg1.SetPosition(1.5)
+	exg2.SetPosition(-1)
+	exg3.SetPosition(2)
+	book := func(exg exchange.Interface, bid, ask float64) exchange.Book {
+		return exchange.Book{
+			Exg:  exg,
+			Time: time.Now(),
+			Bids: exchange.BidItems{{Price: bid, Amount: 100}},
+			Asks: exchange.AskItems{{Price: ask, Amount: 100}},
+		}
+	}
+
+	// Marked to the USD mid, with the CNY book at 2 per USD
+	markets := make(map[exchange.Interface]filteredBook)
+	addToSnapshot(markets, exg1, filterBook(book(exg1, 249, 251), 1))
+	addToSnapshot(markets, exg2, filterBook(book(exg2, 504, 506), 2))
+	// A book without asks leaves the exchange unmarked
+	noAsks := book(exg3, 249, 251)
+	noAsks.Asks = nil
+	addToSnapshot(markets, exg3, filterBook(noAsks, 1))
+	if unrealized := unrealizedPL([]exchange.Interface{exg1, exg2, exg3}); math.Abs(unrealized-(1.5*250-252.5)) > .000001 {
+		t.Fatalf("Expected positions marked to mid, got %v", unrealized)
+	}
+
+	// Stale books don't move the mark
+	stale := book(exg1, 299, 301)
+	stale.Time = time.Now().Add(-2 * time.Minute)
+	addToSnapshot(markets, exg1, filterBook(stale, 1))
+	if unrealized := unrealizedPL([]exchange.Interface{exg1}); math.Abs(unrealized-1.5*250) > .000001 {
+		t.Fatalf("Expected the last fresh mark, got %v", unrealized)
+	}
+}
+
+func TestLegacyState(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 10, 10000, nil)
+	testExchanges(t, exg1, exg2)
+
+	filename := filepath.Join(t.TempDir(), "status.csv")
+	if err := ioutil.WriteFile(filename, []byte("1.500000,-2.250000,12.500000\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	state, err := readLegacyState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(state.Positions["SimTest1(usd)"]-1.5) > .000001 || math.Abs(state.Positions["SimTest2(cny)"]+2.25) > .000001 {
+		t.Fatalf("Wrong legacy positions %v", state.Positions)
+	}
+	if math.Abs(state.PL-12.5) > .000001 {
+		t.Fatal("Wrong legacy P&L")
+	}
+
+	// Column count must match the exchanges in use
+	exchanges = exchanges[:1]
+	if _, err := readLegacyState(filename); err == nil {
+		t.Fatal("Expected error for mismatched columns")
+	}
+}
+
+func TestReconcilePositions(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "cny", 1, 0, 10, 10000, nil)
+	testExchanges(t, exg1, exg2)
+
+	// Balances of AvailShort are flat
+	if err := reconcilePositions(.01); err != nil {
+		t.Fatal(err)
+	}
+	// Thinks it's long but is actually flat
+	exg2.SetPosition(1)
+	if err := reconcilePositions(.01); err == nil {
+		t.Fatal("Expected mismatch error")
+	}
+	if err := reconcilePositions(2); err != nil {
+		t.Fatal("Difference within tolerance should pass")
+	}
+}
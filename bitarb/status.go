@@ -0,0 +1,100 @@
+// HTTP status server reporting live state
+
+package main
+
+import (
+	"bitfx/logger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Live state reported by the status server
+type liveStatus struct {
+	Exchanges    []exchangeStatus   `json:"exchanges"`
+	NetPosition  float64            `json:"netPosition"`  // Sum across symbols, the net position for one symbol
+	NetPositions map[string]float64 `json:"netPositions"` // By symbol
+	PL           float64            `json:"pl"`
+	SymbolPL     map[string]float64 `json:"symbolPL"`
+	ExchangePL   map[string]venuePL `json:"exchangePL"` // By exchange name, prefixed with the symbol when more than one is traded
+	LastTrade    time.Time          `json:"lastTrade"`
+}
+
+// Exchange state reported by the status server
+type exchangeStatus struct {
+	Name     string       `json:"name"`
+	Symbol   string       `json:"symbol,omitempty"`
+	Position float64      `json:"position"`
+	BookTime time.Time    `json:"bookTime"`
+	Bid      marketStatus `json:"bid"`
+	Ask      marketStatus `json:"ask"`
+}
+
+// Filtered market data reported by the status server
+type marketStatus struct {
+	OrderPrice float64 `json:"orderPrice"`
+	AdjPrice   float64 `json:"adjPrice"`
+	Amount     float64 `json:"amount"`
+}
+
+// Build the live status, must be called from the trading goroutine
+// Book data is requested from handleData, and left empty once it has stopped
+func buildStatus(books bookRequester) liveStatus {
+	status := liveStatus{
+		NetPositions: make(map[string]float64),
+		PL:           pl,
+		SymbolPL:     make(map[string]float64),
+		ExchangePL:   make(map[string]venuePL),
+		LastTrade:    lastTrade,
+	}
+	for symbol, net := range netPositions {
+		status.NetPosition += net
+		status.NetPositions[symbol] = net
+	}
+	for symbol, symbolTotal := range symbolPL {
+		status.SymbolPL[symbol] = symbolTotal
+	}
+	for _, exg := range exchanges {
+		status.ExchangePL[exchangeKey(exg)] = exchangePL[exchangeKey(exg)]
+		fb, _ := books.book(exg)
+		status.Exchanges = append(status.Exchanges, exchangeStatus{
+			Name:     exg.String(),
+			Symbol:   symbolOf(exg),
+			Position: exg.Position(),
+			BookTime: fb.time,
+			Bid:      marketStatus{fb.bid.orderPrice, fb.bid.adjPrice, fb.bid.amount},
+			Ask:      marketStatus{fb.ask.orderPrice, fb.ask.adjPrice, fb.ask.amount},
+		})
+	}
+	return status
+}
+
+// Returns a handler requesting the live status through requestStatus
+func statusHandler(requestStatus chan<- chan liveStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := make(chan liveStatus, 1)
+		select {
+		case requestStatus <- reply:
+		case <-time.After(5 * time.Second):
+			http.Error(w, "Status unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(<-reply); err != nil {
+			logger.Errorf("%s", err)
+		}
+	}
+}
+
+// Serve /status over HTTP if a port is configured
+func setStatusServer(requestStatus chan<- chan liveStatus) {
+	if cfg.Sec.StatusPort <= 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/status", statusHandler(requestStatus))
+	go func() {
+		logger.Errorf("%s", http.ListenAndServe(fmt.Sprintf(":%d", cfg.Sec.StatusPort), mux))
+	}()
+}
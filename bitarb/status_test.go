@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+	exg := sim.New("Test", "btc", "usd", 1, 0, 10, 1000, nil)
+	exg.SetPosition(2)
+	exchanges = []exchange.Interface{exg}
+	netPositions, pl = map[string]float64{"": 2}, 15
+	defer func() {
+		exchanges = nil
+		netPositions, pl = nil, 0
+	}()
+
+	// Answer book requests as handleData would
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	go func() {
+		for exg := range requestBook {
+			receiveBook <- filteredBook{
+				bid: market{exg: exg, orderPrice: 249, adjPrice: 248.5, amount: 1},
+				ask: market{exg: exg, orderPrice: 251, adjPrice: 251.5, amount: 3},
+			}
+		}
+	}()
+	defer close(requestBook)
+
+	// Answer status requests as considerTrade would
+	requestStatus := make(chan chan liveStatus)
+	go func() {
+		reply := <-requestStatus
+		reply <- buildStatus(bookRequester{requestBook, receiveBook, nil})
+	}()
+
+	w := httptest.NewRecorder()
+	statusHandler(requestStatus)(w, httptest.NewRequest("GET", "/status", nil))
+	var status liveStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.NetPosition != 2 || status.PL != 15 || len(status.Exchanges) != 1 {
+		t.Fatal("Wrong status totals")
+	}
+	exgStatus := status.Exchanges[0]
+	if exgStatus.Name != exg.String() || exgStatus.Position != 2 {
+		t.Fatal("Wrong exchange status")
+	}
+	if exgStatus.Bid.OrderPrice != 249 || exgStatus.Ask.Amount != 3 {
+		t.Fatal("Wrong market status")
+	}
+
+	// Once handleData has stopped, the status is built without books
+	stopped := make(chan bool)
+	close(stopped)
+	status = buildStatus(bookRequester{make(chan exchange.Interface), receiveBook, stopped})
+	if len(status.Exchanges) != 1 || status.Exchanges[0].Position != 2 || status.Exchanges[0].Bid.OrderPrice != 0 {
+		t.Fatalf("Expected positions without books, got %+v", status.Exchanges)
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 10, 1000, nil)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 10, 1000, nil)
+	testExchanges(t, exg1, exg2)
+	defer func(marks map[exchange.Interface]float64) { markPrices = marks }(markPrices)
+	markPrices = map[exchange.Interface]float64{exg1: 250, exg2: 252}
+	exg1.SetPosition(2)
+	exg2.SetPosition(-1.5)
+	netPositions, pl = map[string]float64{"": .5}, 15
+	defer func() { netPositions = nil }()
+	exchangePL["SimTest1(usd)"] = venuePL{USD: -500, Native: -500}
+
+	// One line of JSON per update
+	var buf bytes.Buffer
+	if err := writeResults(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(buf.String(), "\n") != 1 || !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("Expected a single line, got %q", buf.String())
+	}
+	var r results
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Positions["SimTest1(usd)"] != 2 || r.Positions["SimTest2(usd)"] != -1.5 || r.NetPosition != .5 {
+		t.Fatalf("Wrong positions %+v", r)
+	}
+	if r.PL != 15 || r.UnrealizedPL != 2*250-1.5*252 || r.ExchangePL["SimTest1(usd)"].USD != -500 {
+		t.Fatalf("Wrong P&L %+v", r)
+	}
+}
@@ -0,0 +1,89 @@
+// Trading several symbols in one process
+// Exchanges are grouped by symbol, with net position and P&L kept for each
+
+package main
+
+import (
+	"bitfx/exchange"
+	"fmt"
+	"strings"
+)
+
+// Per symbol state
+var (
+	symbols        []string                              // Symbols traded, in config order
+	exchangeSymbol = make(map[exchange.Interface]string) // Symbol traded by each exchange
+	netPositions   map[string]float64                    // Net position across exchanges by symbol
+	symbolPL       = make(map[string]float64)            // P&L by symbol, summing to pl
+)
+
+// Parse comma separated symbols from config
+func parseSymbols(value string) []string {
+	var parsed []string
+	for _, symbol := range strings.Split(value, ",") {
+		if symbol = strings.ToLower(strings.TrimSpace(symbol)); symbol != "" {
+			parsed = append(parsed, symbol)
+		}
+	}
+	return parsed
+}
+
+// Ticker an exchange uses for symbol, from comma separated symbol:ticker pairs
+// Symbols not listed are used as is
+func venueTicker(pairs, symbol string) (string, error) {
+	for _, pair := range strings.Split(pairs, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return "", fmt.Errorf("Bad ticker mapping %q, expected symbol:ticker", pair)
+		}
+		if strings.ToLower(strings.TrimSpace(parts[0])) == symbol {
+			return strings.TrimSpace(parts[1]), nil
+		}
+	}
+	return symbol, nil
+}
+
+// Add an exchange trading symbol to those in use
+func addExchange(symbol string, exg exchange.Interface) {
+	exchanges = append(exchanges, exg)
+	exchangeSymbol[exg] = symbol
+}
+
+// Symbol traded by an exchange, empty if it wasn't added with one
+func symbolOf(exg exchange.Interface) string {
+	return exchangeSymbol[exg]
+}
+
+// Group exchanges by symbol, with symbols in order of first appearance
+func groupBySymbol(exgs []exchange.Interface) ([]string, map[string][]exchange.Interface) {
+	var order []string
+	groups := make(map[string][]exchange.Interface)
+	for _, exg := range exgs {
+		symbol := symbolOf(exg)
+		if _, ok := groups[symbol]; !ok {
+			order = append(order, symbol)
+		}
+		groups[symbol] = append(groups[symbol], exg)
+	}
+	return order, groups
+}
+
+// Name of an exchange, prefixed with its symbol when more than one symbol is traded
+// Exchange names alone repeat across symbols
+func exchangeKey(exg exchange.Interface) string {
+	if len(symbols) > 1 {
+		return symbolOf(exg) + " " + exg.String()
+	}
+	return exg.String()
+}
+
+// Prefix for log lines about a symbol, empty for exchanges added without one
+func symbolPrefix(symbol string) string {
+	if symbol == "" {
+		return ""
+	}
+	return symbol + " "
+}
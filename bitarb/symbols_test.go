@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"testing"
+	"time"
+)
+
+func TestParseSymbols(t *testing.T) {
+	if parsed := parseSymbols("btc"); len(parsed) != 1 || parsed[0] != "btc" {
+		t.Fatal("Expected a single symbol")
+	}
+	if parsed := parseSymbols(" LTC, btc,"); len(parsed) != 2 || parsed[0] != "ltc" || parsed[1] != "btc" {
+		t.Fatalf("Expected ltc and btc, got %v", parsed)
+	}
+}
+
+func TestVenueTicker(t *testing.T) {
+	tests := []struct {
+		pairs, symbol, ticker string
+	}{
+		{"", "btc", "btc"},
+		{"dash:dsh", "btc", "btc"},
+		{"dash:dsh", "dash", "dsh"},
+		{" DASH : dsh, ltc:LTC ,", "ltc", "LTC"},
+	}
+	for _, test := range tests {
+		if ticker, err := venueTicker(test.pairs, test.symbol); err != nil || ticker != test.ticker {
+			t.Errorf("Ticker for %s in %q: expected %s, got %s, %v", test.symbol, test.pairs, test.ticker, ticker, err)
+		}
+	}
+	for _, pairs := range []string{"dash", "dash:", ":dsh", "dash:dsh:x"} {
+		if _, err := venueTicker(pairs, "dash"); err == nil {
+			t.Errorf("Expected an error for %q", pairs)
+		}
+	}
+}
+
+// Add exchanges with symbols for a test
+func testSymbols(t *testing.T, bySymbol map[string][]exchange.Interface, order ...string) {
+	savedExchanges, savedSymbols := exchanges, symbols
+	t.Cleanup(func() {
+		for _, exg := range exchanges {
+			delete(exchangeSymbol, exg)
+		}
+		exchanges, symbols, netPositions = savedExchanges, savedSymbols, nil
+	})
+	exchanges, symbols = nil, order
+	for _, symbol := range order {
+		for _, exg := range bySymbol[symbol] {
+			addExchange(symbol, exg)
+		}
+	}
+}
+
+func TestConsiderTradeBySymbol(t *testing.T) {
+	btc1 := &countingExchange{Client: sim.New("BTC1", "btc", "usd", 1, 0, 100, 100000, nil)}
+	btc2 := &countingExchange{Client: sim.New("BTC2", "btc", "usd", 1, 0, 100, 100000, nil)}
+	ltc1 := &countingExchange{Client: sim.New("LTC1", "ltc", "usd", 1, 0, 100, 100000, nil)}
+	ltc2 := &countingExchange{Client: sim.New("LTC2", "ltc", "usd", 1, 0, 100, 100000, nil)}
+	testSymbols(t, map[string][]exchange.Interface{
+		"btc": {btc1, btc2},
+		"ltc": {ltc1, ltc2},
+	}, "btc", "ltc")
+	defer func(m float64) { cfg.Sec.MinNetPos = m }(cfg.Sec.MinNetPos)
+	cfg.Sec.MinNetPos = .1
+	if exchangeKey(ltc1) != "ltc SimLTC1(usd)" {
+		t.Fatalf("Expected symbol in key, got %s", exchangeKey(ltc1))
+	}
+
+	// BTC has no arb, LTC1 bids over LTC2's ask, and BTC asks are lowest
+	prices := map[exchange.Interface][2]float64{
+		btc1: {250, 251}, btc2: {250, 251},
+		ltc1: {260, 261}, ltc2: {254, 255},
+	}
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	newBook := make(chan bool)
+	go func() {
+		newBook <- true
+		close(newBook)
+	}()
+	go func() {
+		for exg := range requestBook {
+			p := prices[exg]
+			receiveBook <- filteredBook{
+				bid:  market{exg: exg, orderPrice: p[0], adjPrice: p[0], amount: 30},
+				ask:  market{exg: exg, orderPrice: p[1], adjPrice: p[1], amount: 30},
+				time: time.Now(),
+			}
+		}
+	}()
+	considerTrade(bookRequester{requestBook, receiveBook, nil}, newBook, nil)
+	close(requestBook)
+
+	// Symbols are never paired with each other
+	if btc1.sends != 0 || btc2.sends != 0 {
+		t.Fatal("BTC exchanges should not trade against LTC")
+	}
+	if ltc1.sends == 0 || ltc2.sends == 0 {
+		t.Fatal("LTC arb should be attempted")
+	}
+}
+
+func TestStateBySymbol(t *testing.T) {
+	btc := sim.New("Test", "btc", "usd", 1, 0, 10, 10000, nil)
+	ltc := sim.New("Test", "ltc", "usd", 1, 0, 10, 10000, nil)
+	testSymbols(t, map[string][]exchange.Interface{"btc": {btc}, "ltc": {ltc}}, "btc", "ltc")
+	defer func(p float64) { pl, symbolPL = p, make(map[string]float64) }(pl)
+
+	// A single symbol state loads into the first symbol
+	savedState{Positions: map[string]float64{"SimTest(usd)": 1.5}, PL: 10}.apply()
+	if btc.Position() != 1.5 || ltc.Position() != 0 || symbolPL["btc"] != 10 {
+		t.Fatal("Single symbol state should load into the first symbol")
+	}
+
+	// Names repeat across symbols, so keys are prefixed
+	ltc.SetPosition(-2)
+	symbolPL["ltc"] = 3
+	state := currentState()
+	if state.Positions["btc SimTest(usd)"] != 1.5 || state.Positions["ltc SimTest(usd)"] != -2 || state.SymbolPL["ltc"] != 3 {
+		t.Fatalf("Wrong saved state: %v", state)
+	}
+	btc.SetPosition(0)
+	ltc.SetPosition(0)
+	state.apply()
+	if btc.Position() != 1.5 || ltc.Position() != -2 || symbolPL["ltc"] != 3 {
+		t.Fatal("Saved state should load by symbol")
+	}
+}
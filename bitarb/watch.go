@@ -0,0 +1,130 @@
+// Consolidated live book display across exchanges, without trading
+
+package main
+
+import (
+	"bitfx/exchange"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var watchMode = flag.Bool("watch", false, "Display the top of each exchange's book and the best arb instead of trading")
+
+// Terminal control for redrawing in place, so frames don't flicker as with clearScreen
+const (
+	cursorHome   = "\033[H"
+	clearLine    = "\033[K"
+	clearToEnd   = "\033[J"
+	highlightOn  = "\033[1m"
+	highlightOff = "\033[0m"
+)
+
+// Show live books until user input, leaving positions and orders untouched
+func runWatch() {
+	setExchanges()
+	// Loaded positions set the arb needed, as when trading
+	setStatus()
+
+	doneChan := make(chan bool, 1)
+	go checkStdin(doneChan)
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	newBook := make(chan bool)
+	booksStopped := make(chan bool)
+	go func() {
+		handleData(requestBook, receiveBook, newBook, doneChan)
+		close(booksStopped)
+	}()
+	books := bookRequester{requestBook, receiveBook, booksStopped}
+
+	// Redraw at least every second so book ages keep counting while feeds are quiet
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	fmt.Print("\033[2J")
+	for {
+		select {
+		case <-ticker.Chan():
+		case _, ok := <-newBook:
+			if !ok {
+				for _, exg := range exchanges {
+					exg.Done()
+				}
+				return
+			}
+		}
+		drawFrame(os.Stdout, watchLines(books))
+	}
+}
+
+// Write lines over the previous frame in one write, clearing what's left of it
+func drawFrame(w io.Writer, lines []string) {
+	var buf bytes.Buffer
+	buf.WriteString(cursorHome)
+	for _, line := range lines {
+		buf.WriteString(line + clearLine + "\n")
+	}
+	buf.WriteString(clearToEnd)
+	w.Write(buf.Bytes())
+}
+
+// Lines of the display, with each exchange's filtered top of book in USD and the best arb for each symbol
+// The best bid and ask are highlighted and marked with *, must be called from the watching goroutine
+func watchLines(books bookRequester) []string {
+	rule := strings.Repeat("-", 70)
+	lines := []string{"Press enter to quit", ""}
+	order, groups := groupBySymbol(exchanges)
+	for _, symbol := range order {
+		markets := make(map[exchange.Interface]filteredBook)
+		ages := make(map[exchange.Interface]time.Duration)
+		for _, exg := range groups[symbol] {
+			// Books are empty once handleData has stopped, and the frame shows them stale
+			fb, _ := books.book(exg)
+			ages[exg] = clock.Now().Sub(fb.time)
+			addToSnapshot(markets, exg, fb)
+		}
+		bestBid, bestAsk := findBestBid(markets), findBestAsk(markets)
+
+		lines = append(lines, fmt.Sprintf("%s (USD at max order, after fees)", symbol), rule)
+		lines = append(lines, fmt.Sprintf("%-20s%12s%10s%12s%10s%6s", "Exchange", "Bid", "Size", "Ask", "Size", "Age"), rule)
+		for _, exg := range groups[symbol] {
+			fb, ok := markets[exg]
+			if !ok {
+				lines = append(lines, fmt.Sprintf("%-20s%44s%5.0fs", exchangeKey(exg), "stale", ages[exg].Seconds()))
+				continue
+			}
+			bid := watchPrice(fb.bid, fb.bid.exg == bestBid.exg && bestBid.exg != nil)
+			ask := watchPrice(fb.ask, fb.ask.exg == bestAsk.exg && bestAsk.exg != nil)
+			lines = append(lines, fmt.Sprintf("%-20s%s%10.2f%s%10.2f%5.1fs", exchangeKey(exg), bid, fb.bid.amount, ask, fb.ask.amount, ages[exg].Seconds()))
+		}
+		lines = append(lines, rule)
+
+		// The best tradable arb, or else the best spread below the needed arb, as in analytics
+		if arbBid, arbAsk, exists := findBestArb(markets); exists {
+			lines = append(lines, fmt.Sprintf("%sArb: %.4f, needed %.4f, buy %s sell %s%s",
+				highlightOn, arbBid.adjPrice-arbAsk.adjPrice, calcNeededArb(arbAsk.exg, arbBid.exg), exchangeKey(arbAsk.exg), exchangeKey(arbBid.exg), highlightOff))
+		} else if bestBid.exg != nil && bestAsk.exg != nil && bestBid.exg != bestAsk.exg {
+			lines = append(lines, fmt.Sprintf("Spread: %.4f, needed %.4f, buy %s sell %s",
+				bestBid.adjPrice-bestAsk.adjPrice, calcNeededArb(bestAsk.exg, bestBid.exg), exchangeKey(bestAsk.exg), exchangeKey(bestBid.exg)))
+		} else {
+			lines = append(lines, "No spread available")
+		}
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+// Price column of the display, blank without liquidity for MaxOrder
+func watchPrice(m market, best bool) string {
+	if m.amount <= 0 {
+		return fmt.Sprintf("%12s", "-")
+	}
+	if best {
+		return fmt.Sprintf("%s%11.4f*%s", highlightOn, m.adjPrice, highlightOff)
+	}
+	return fmt.Sprintf("%11.4f ", m.adjPrice)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchLines(t *testing.T) {
+	exg1 := sim.New("Test1", "btc", "usd", 1, 0, 100, 10000, nil)
+	exg2 := sim.New("Test2", "btc", "usd", 1, 0, 100, 10000, nil)
+	exg3 := sim.New("Test3", "btc", "usd", 1, 0, 100, 10000, nil)
+	testExchanges(t, exg1, exg2, exg3)
+
+	// Answer book requests as handleData would, with Test1 bidding bid over Test2's ask of 2 and Test3 stale
+	var bid float64
+	requestBook := make(chan exchange.Interface)
+	receiveBook := make(chan filteredBook)
+	go func() {
+		for exg := range requestBook {
+			fb := filteredBook{
+				bid:  market{exg: exg, orderPrice: 1.9, adjPrice: 1.9, amount: 50},
+				ask:  market{exg: exg, orderPrice: 2.1, adjPrice: 2.1, amount: 50},
+				time: time.Now(),
+			}
+			switch exg {
+			case exg1:
+				fb.bid = market{exg: exg, orderPrice: bid, adjPrice: bid, amount: 50}
+			case exg2:
+				fb.ask = market{exg: exg, orderPrice: 2, adjPrice: 2, amount: 50}
+			default:
+				fb.time = time.Now().Add(-2 * time.Minute)
+			}
+			receiveBook <- fb
+		}
+	}()
+	defer close(requestBook)
+
+	bid = 2.05
+	display := strings.Join(watchLines(bookRequester{requestBook, receiveBook, nil}), "\n")
+	if !strings.Contains(display, "2.0500*") || !strings.Contains(display, "2.0000*") {
+		t.Errorf("Expected best bid and ask marked:\n%s", display)
+	}
+	if strings.Count(display, "*") != 2 {
+		t.Errorf("Expected only the best bid and ask marked:\n%s", display)
+	}
+	if !strings.Contains(display, "Arb: 0.0500, needed 0.0050, buy SimTest2(usd) sell SimTest1(usd)") {
+		t.Errorf("Expected best arb:\n%s", display)
+	}
+	if !strings.Contains(display, "stale") {
+		t.Errorf("Expected stale exchange shown:\n%s", display)
+	}
+
+	// Spreads below the needed arb are still shown
+	bid = 2.001
+	display = strings.Join(watchLines(bookRequester{requestBook, receiveBook, nil}), "\n")
+	if !strings.Contains(display, "Spread: 0.0010, needed 0.0050") {
+		t.Errorf("Expected best spread:\n%s", display)
+	}
+}
+
+func TestDrawFrame(t *testing.T) {
+	var buf bytes.Buffer
+	drawFrame(&buf, []string{"one", "two"})
+	if expected := cursorHome + "one" + clearLine + "\ntwo" + clearLine + "\n" + clearToEnd; buf.String() != expected {
+		t.Errorf("Frame %q, expected %q", buf.String(), expected)
+	}
+}
@@ -4,50 +4,129 @@ package bitfinex
 
 import (
 	"bitfx/exchange"
+	"bitfx/logger"
+	"context"
 	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Client contains all exchange information
 type Client struct {
-	key, secret, symbol, currency, name, baseURL  string
-	priority                                      int
-	position, fee, maxPos, availShort, availFunds float64
-	currencyCode                                  byte
-	done                                          chan bool
+	key, secret, symbol, currency, name, baseURL                 string
+	websocketURL                                                 string
+	priority, depth                                              int
+	position, makerFee, takerFee, maxPos, availShort, availFunds float64
+	currencyCode                                                 exchange.Currency
+	clock                                                        exchange.Clock // Paces reconnection attempts and rate limited polling
+	httpClient                                                   *http.Client
+	httpOnce                                                     sync.Once    // Creates httpClient on first use if not set
+	orders                                                       *orderSocket // Nil without credentials
+	logger                                                       *logger.Logger
+	feeMutex                                                     sync.Mutex
+	orderLimit                                                   exchange.RateLimiter // Limits orders and cancels per second
+	health                                                       exchange.Health      // Books sent
+	latency                                                      exchange.Latency     // Round trip of the last order request
+	serverClock                                                  exchange.ServerClock // Exchange clock, for nonces
+	lastNonce                                                    int64                // Last REST nonce, read and written atomically
+	margin                                                       bool                 // Trade from the margin wallet rather than the exchange wallet
+	exchangeOrders                                               bool                 // Send exchange wallet order types when not on margin
+	done                                                         chan bool
+}
+
+// Option changes a Client setting before New validates it and connects
+type Option func(*Client)
+
+// WithURLs replaces the REST base URL and the WebSocket URL, for mock servers or proxies
+// Plain http and ws URLs are allowed for local testing
+func WithURLs(baseURL, websocketURL string) Option {
+	return func(client *Client) {
+		client.baseURL = baseURL
+		client.websocketURL = websocketURL
+	}
+}
+
+// WithClock replaces the clock reconnection attempts and rate limited polling are paced by, the system clock by default
+func WithClock(clock exchange.Clock) Option {
+	return func(client *Client) {
+		client.clock = clock
+	}
 }
 
 // New returns a pointer to a Client instance
-func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64) *Client {
-	return &Client{
+// Orders use an authenticated WebSocket when credentials are supplied, falling back to REST
+func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64, options ...Option) (*Client, error) {
+	// Pairs and balances are named in lower case
+	currency = strings.ToLower(currency)
+	client := &Client{
 		key:          key,
 		secret:       secret,
 		symbol:       symbol,
 		currency:     currency,
 		priority:     priority,
-		fee:          fee,
+		depth:        20,
+		makerFee:     fee,
+		takerFee:     fee,
+		logger:       logger.Default(),
 		availShort:   availShort,
 		availFunds:   availFunds,
-		currencyCode: 0,
 		name:         fmt.Sprintf("Bitfinex(%s)", currency),
 		baseURL:      "https://api.bitfinex.com",
+		websocketURL: "wss://api.bitfinex.com/ws/2",
+		clock:        exchange.SystemClock{},
 		done:         make(chan bool, 1),
 	}
+	for _, option := range options {
+		option(client)
+	}
+	if err := client.validate(); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", client, err)
+	}
+	// Supported currencies all have codes
+	client.currencyCode, _ = exchange.ParseCurrency(currency)
+
+	// Run order WebSocket connection
+	if key != "" {
+		client.orders = newOrderSocket(client)
+		go client.orders.maintain()
+	}
+
+	return client, nil
+}
+
+// Check constructor arguments
+func (client *Client) validate() error {
+	if err := exchange.CheckCurrency(client.currency, "usd", "eur", "gbp", "jpy"); err != nil {
+		return err
+	}
+	if err := exchange.CheckKeys(client.key, client.secret); err != nil {
+		return err
+	}
+	if err := exchange.CheckURL(client.baseURL, "https", "http"); err != nil {
+		return err
+	}
+	return exchange.CheckURL(client.websocketURL, "wss", "ws")
 }
 
 // Done closes all connections
 func (client *Client) Done() {
 	client.done <- true
+	if client.orders != nil {
+		client.orders.close()
+	}
 }
 
 // String implements the Stringer interface
@@ -60,9 +139,76 @@ func (client *Client) Priority() int {
 	return client.priority
 }
 
-// Fee returns the exchange order fee
-func (client *Client) Fee() float64 {
-	return client.fee
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, safe to call while books are filtered
+func (client *Client) SetFees(maker, taker float64) {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetDepth sets the number of book levels requested from the exchange
+func (client *Client) SetDepth(depth int) {
+	client.depth = depth
+}
+
+// SetHTTPTimeout sets the time limit for HTTP requests
+func (client *Client) SetHTTPTimeout(timeout time.Duration) {
+	client.getHTTPClient().Timeout = timeout
+}
+
+// Default time limit for HTTP requests
+const httpTimeout = 10 * time.Second
+
+// Return the client for HTTP requests, created with httpTimeout on first use if not set
+func (client *Client) getHTTPClient() *http.Client {
+	client.httpOnce.Do(func() {
+		if client.httpClient == nil {
+			client.httpClient = &http.Client{Timeout: httpTimeout}
+		}
+	})
+	return client.httpClient
+}
+
+// SetMargin sets whether orders trade on margin
+// Margin orders use the trading wallet and can go net short, exchange orders only sell what the exchange wallet holds
+func (client *Client) SetMargin(margin bool) {
+	client.margin = margin
+}
+
+// SetExchangeOrders sets whether orders not on margin use the exchange wallet order types
+// Otherwise orders are sent with the unprefixed margin types, while balances are read from the exchange wallet
+func (client *Client) SetExchangeOrders(exchangeOrders bool) {
+	client.exchangeOrders = exchangeOrders
+}
+
+// Return the Bitfinex order type for otype, prefixed by "exchange" if set to send exchange orders
+func (client *Client) orderType(otype string) string {
+	if client.margin || !client.exchangeOrders {
+		return otype
+	}
+	return "exchange " + otype
+}
+
+// Return the wallet orders trade from
+func (client *Client) wallet() string {
+	if client.margin {
+		return "trading"
+	}
+	return "exchange"
 }
 
 // SetPosition sets the exchange position
@@ -81,7 +227,7 @@ func (client *Client) Currency() string {
 }
 
 // CurrencyCode returns the exchange currency code
-func (client *Client) CurrencyCode() byte {
+func (client *Client) CurrencyCode() exchange.Currency {
 	return client.currencyCode
 }
 
@@ -105,52 +251,129 @@ func (client *Client) AvailShort() float64 {
 	return client.availShort
 }
 
-// HasCrytpoFee returns true if fee is taken in cryptocurrency on buys
-func (client *Client) HasCryptoFee() bool {
-	return false
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// LastLatency returns the round trip of the last order request, zero if none has been sent
+func (client *Client) LastLatency() time.Duration {
+	return client.latency.Last()
+}
+
+// SyncClock measures and applies the exchange clock's offset from the local clock, returning it
+func (client *Client) SyncClock(ctx context.Context) (time.Duration, error) {
+	offset, err := client.serverClock.Sync(ctx, client.getHTTPClient(), client.baseURL+"/v1/symbols")
+	if err != nil {
+		return 0, fmt.Errorf("%s SyncClock error: %s", client, err)
+	}
+	return offset, nil
+}
+
+// Nonce for a signed request, from the exchange clock and always increasing
+// Requests sent concurrently, as for the legs of a pair, never share a nonce even within a clock tick
+// A clock set back by SyncClock continues from the last nonce
+func (client *Client) nonce() string {
+	for {
+		last := atomic.LoadInt64(&client.lastNonce)
+		next := client.serverClock.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&client.lastNonce, last, next) {
+			return strconv.FormatInt(next, 10)
+		}
+	}
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity available for short selling
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill, fees are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	return filled
 }
 
 // CommunicateBook sends the latest available book data on the supplied channel
-func (client *Client) CommunicateBook(bookChan chan<- exchange.Book) exchange.Book {
+// Streams from the WebSocket book channel, polling REST if it can't connect
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	ws, local, err := client.openBook()
+	if err == nil {
+		// Initial book to return
+		book := client.convertToBook(*local)
+
+		// Run read loop in new goroutine
+		go client.runBookLoop(ws, local, book, bookChan, doneChan)
+
+		return book
+	}
+	client.logger.Warnf("%s WebSocket error: %s, using REST polling", client, err)
+
 	// Initial book to return
 	book, _ := client.getBook()
 
 	// Run read loop in new goroutine
-	go client.runLoop(bookChan)
+	go client.runLoop(bookChan, doneChan)
 
 	return book
 }
 
 // HTTP read loop
-func (client *Client) runLoop(bookChan chan<- exchange.Book) {
+func (client *Client) runLoop(bookChan chan<- exchange.Book, doneChan <-chan bool) {
 	// Used to compare timestamps
-	oldTimestamps := make([]float64, 40)
+	var oldTimestamps []float64
 
 	for {
 		select {
+		case <-doneChan:
+			return
 		case <-client.done:
 			return
 		default:
 			book, newTimestamps := client.getBook()
 			// Send out only if changed
 			if bookChanged(oldTimestamps, newTimestamps) {
-				bookChan <- book
+				select {
+				case bookChan <- book:
+					client.health.Update(book)
+				case <-doneChan:
+					return
+				}
 			}
 			oldTimestamps = newTimestamps
+			// Wait as long as asked after a rate limit response
+			if wait, limited := exchange.RetryAfter(book.Error); limited {
+				select {
+				case <-client.clock.After(wait):
+				case <-doneChan:
+					return
+				case <-client.done:
+					return
+				}
+			}
 		}
 	}
 }
 
 // Get book data with an HTTP request
 func (client *Client) getBook() (exchange.Book, []float64) {
-	// Used to compare timestamps
-	timestamps := make([]float64, 40)
-
 	// Send GET request
-	url := fmt.Sprintf("%s/v1/book/%s%s?limit_bids=%d&limit_asks=%d", client.baseURL, client.symbol, client.currency, 20, 20)
-	data, err := client.get(url)
+	url := fmt.Sprintf("%s/v1/book/%s%s?limit_bids=%d&limit_asks=%d", client.baseURL, client.symbol, client.currency, client.depth, client.depth)
+	data, err := client.get(context.Background(), url)
 	if err != nil {
-		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, timestamps
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %w", client, err)}, nil
 	}
 
 	// Unmarshal
@@ -167,19 +390,32 @@ func (client *Client) getBook() (exchange.Book, []float64) {
 		} `json:"asks"`
 	}
 	if err := json.Unmarshal(data, &response); err != nil {
-		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, timestamps
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, nil
+	}
+
+	// Depth is bounded by what the exchange returned
+	numBids, numAsks := client.depth, client.depth
+	if len(response.Bids) < numBids {
+		numBids = len(response.Bids)
+	}
+	if len(response.Asks) < numAsks {
+		numAsks = len(response.Asks)
 	}
 
 	// Translate into an exchange.Book
-	bids := make(exchange.BidItems, 20)
-	asks := make(exchange.AskItems, 20)
-	for i := 0; i < 20; i++ {
+	// Timestamps are bids followed by asks, used to compare books
+	timestamps := make([]float64, numBids+numAsks)
+	bids := make(exchange.BidItems, numBids)
+	asks := make(exchange.AskItems, numAsks)
+	for i := 0; i < numBids; i++ {
 		bids[i].Price = response.Bids[i].Price
 		bids[i].Amount = response.Bids[i].Amount
+		timestamps[i] = response.Bids[i].Timestamp
+	}
+	for i := 0; i < numAsks; i++ {
 		asks[i].Price = response.Asks[i].Price
 		asks[i].Amount = response.Asks[i].Amount
-		timestamps[i] = response.Bids[i].Timestamp
-		timestamps[i+20] = response.Asks[i].Timestamp
+		timestamps[i+numBids] = response.Asks[i].Timestamp
 	}
 	sort.Sort(bids)
 	sort.Sort(asks)
@@ -196,7 +432,10 @@ func (client *Client) getBook() (exchange.Book, []float64) {
 
 // Returns true if the book has changed
 func bookChanged(timestamps1, timestamps2 []float64) bool {
-	for i := 0; i < 40; i++ {
+	if len(timestamps1) != len(timestamps2) {
+		return true
+	}
+	for i := range timestamps1 {
 		if math.Abs(timestamps1[i]-timestamps2[i]) > .5 {
 			return true
 		}
@@ -204,8 +443,100 @@ func bookChanged(timestamps1, timestamps2 []float64) bool {
 	return false
 }
 
+// Ticker returns the latest ticker data
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	// Send GET request
+	url := fmt.Sprintf("%s/v1/pubticker/%s%s", client.baseURL, client.symbol, client.currency)
+	data, err := client.get(context.Background(), url)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+
+	// Unmarshal
+	var response struct {
+		Bid       float64 `json:"bid,string"`
+		Ask       float64 `json:"ask,string"`
+		Last      float64 `json:"last_price,string"`
+		Volume    float64 `json:"volume,string"`
+		Timestamp float64 `json:"timestamp,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+
+	// Timestamp is in seconds with a fractional part
+	sec, frac := math.Modf(response.Timestamp)
+
+	return exchange.Ticker{
+		Last:   response.Last,
+		Bid:    response.Bid,
+		Ask:    response.Ask,
+		Volume: response.Volume,
+		Time:   time.Unix(int64(sec), int64(frac*1e9)),
+	}, nil
+}
+
+// MinOrderSize returns the smallest order amount the exchange accepts
+func (client *Client) MinOrderSize() float64 {
+	if client.symbol == "btc" {
+		return .01
+	}
+	return .1
+}
+
+// RoundOrder rounds an order to what the exchange accepts
+// Prices have 5 significant figures and amounts 8 decimals
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, 1e-8), exchange.RoundSignificant(price, 5)
+}
+
+// PriceStep returns the tick size at a price, the last of 5 significant figures
+func (client *Client) PriceStep(price float64) float64 {
+	return exchange.SignificantStep(price, 5)
+}
+
+// SupportsMarket returns true
+func (client *Client) SupportsMarket() bool {
+	return true
+}
+
+// SupportsPostOnly returns true, post-only orders are limit orders with the post-only flag
+func (client *Client) SupportsPostOnly() bool {
+	return true
+}
+
+// SetRateLimit limits orders and cancels sent per second, zero for no limit
+// Requests over the limit wait for their turn or their context
+func (client *Client) SetRateLimit(perSecond float64) {
+	client.orderLimit.SetRate(perSecond)
+}
+
 // SendOrder sends an order to the exchange
-func (client *Client) SendOrder(action, otype string, amount, price float64) (int64, error) {
+// Uses the order WebSocket if connected
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: rate limited, %s", client, err)
+	}
+	// Post-only orders that would take liquidity are cancelled by the exchange
+	postOnly := otype == "post_only"
+	if postOnly {
+		otype = "limit"
+	}
+	otype = client.orderType(otype)
+	defer client.latency.Observe(time.Now())
+	if client.orders != nil {
+		id, err := client.orders.sendOrder(ctx, action, otype, amount, price, postOnly)
+		if err != errSocketDown {
+			if err != nil {
+				return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+			}
+			return id, nil
+		}
+	}
+
 	// Create request struct
 	request := struct {
 		URL      string  `json:"request"`
@@ -216,21 +547,23 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 		Exchange string  `json:"exchange"`
 		Side     string  `json:"side"`
 		Type     string  `json:"type"`
+		PostOnly bool    `json:"is_postonly,omitempty"`
 	}{
 		"/v1/order/new",
-		strconv.FormatInt(time.Now().UnixNano(), 10),
+		client.nonce(),
 		client.symbol + client.currency,
 		amount,
 		price,
 		"bitfinex",
 		action,
 		otype,
+		postOnly,
 	}
 
 	// Send POST request
-	data, err := client.post(client.baseURL+request.URL, request)
+	data, err := client.post(ctx, client.baseURL+request.URL, request)
 	if err != nil {
-		return 0, fmt.Errorf("%s SendOrder error: %s", client, err.Error())
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.SentError(err))
 	}
 
 	// Unmarshal response
@@ -243,14 +576,29 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 		return 0, fmt.Errorf("%s SendOrder error: %s", client, err.Error())
 	}
 	if response.Message != "" {
-		return 0, fmt.Errorf("%s SendOrder error: %s", client, response.Message)
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, messageError(response.Message))
 	}
 
 	return response.ID, nil
 }
 
 // CancelOrder cancels an order on the exchange
-func (client *Client) CancelOrder(id int64) (bool, error) {
+// Uses the order WebSocket if connected
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: rate limited, %s", client, err)
+	}
+	defer client.latency.Observe(time.Now())
+	if client.orders != nil {
+		err := client.orders.cancelOrder(ctx, id)
+		if err != errSocketDown {
+			if err != nil {
+				return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
+			}
+			return true, nil
+		}
+	}
+
 	// Create request struct
 	request := struct {
 		URL     string `json:"request"`
@@ -258,14 +606,14 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 		OrderID int64  `json:"order_id"`
 	}{
 		"/v1/order/cancel",
-		strconv.FormatInt(time.Now().UnixNano(), 10),
+		client.nonce(),
 		id,
 	}
 
 	// Send POST request
-	data, err := client.post(client.baseURL+request.URL, request)
+	data, err := client.post(ctx, client.baseURL+request.URL, request)
 	if err != nil {
-		return false, fmt.Errorf("%s CancelOrder error: %s", client, err.Error())
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
 	}
 
 	// Unmarshal response
@@ -277,14 +625,21 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 		return false, fmt.Errorf("%s CancelOrder error: %s", client, err.Error())
 	}
 	if response.Message != "" {
-		return false, fmt.Errorf("%s CancelOrder error: %s", client, response.Message)
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, messageError(response.Message))
 	}
 
 	return true, nil
 }
 
 // GetOrderStatus gets the status of an order on the exchange
-func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
+// Uses order WebSocket updates if connected and the order is known
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	if client.orders != nil {
+		if order, ok := client.orders.orderStatus(id); ok {
+			return order, nil
+		}
+	}
+
 	// Create request struct
 	request := struct {
 		URL     string `json:"request"`
@@ -292,7 +647,7 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 		OrderID int64  `json:"order_id"`
 	}{
 		"/v1/order/status",
-		strconv.FormatInt(time.Now().UnixNano(), 10),
+		client.nonce(),
 		id,
 	}
 
@@ -300,9 +655,10 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	var order exchange.Order
 
 	// Send POST request
-	data, err := client.post(client.baseURL+request.URL, request)
+	defer client.latency.Observe(time.Now())
+	data, err := client.post(ctx, client.baseURL+request.URL, request)
 	if err != nil {
-		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
+		return order, fmt.Errorf("%s GetOrderStatus error: %w", client, err)
 	}
 
 	// Unmarshal response
@@ -316,7 +672,7 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
 	}
 	if response.Message != "" {
-		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, response.Message)
+		return order, fmt.Errorf("%s GetOrderStatus error: %w", client, messageError(response.Message))
 	}
 
 	if response.IsLive {
@@ -328,8 +684,209 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	return order, nil
 }
 
+// OpenOrders returns all live orders for the symbol and currency in use
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	// Create request struct
+	request := struct {
+		URL   string `json:"request"`
+		Nonce string `json:"nonce"`
+	}{
+		"/v1/orders",
+		client.nonce(),
+	}
+
+	// Send POST request
+	data, err := client.post(ctx, client.baseURL+request.URL, request)
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response []struct {
+		ID             int64   `json:"id"`
+		Symbol         string  `json:"symbol"`
+		Side           string  `json:"side"`
+		Price          float64 `json:"price,string"`
+		OriginalAmount float64 `json:"original_amount,string"`
+		ExecutedAmount float64 `json:"executed_amount,string"`
+		IsLive         bool    `json:"is_live"`
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, err.Error())
+	}
+
+	var orders []exchange.Order
+	for _, order := range response {
+		if order.IsLive && order.Symbol == client.symbol+client.currency {
+			orders = append(orders, exchange.Order{
+				ID:           order.ID,
+				Side:         order.Side,
+				Price:        order.Price,
+				Amount:       math.Abs(order.OriginalAmount),
+				FilledAmount: math.Abs(order.ExecutedAmount),
+				Status:       "live",
+			})
+		}
+	}
+	return orders, nil
+}
+
+// Balances returns the available fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	// Create request struct
+	request := struct {
+		URL   string `json:"request"`
+		Nonce string `json:"nonce"`
+	}{
+		"/v1/balances",
+		client.nonce(),
+	}
+
+	// Send POST request
+	data, err := client.post(context.Background(), client.baseURL+request.URL, request)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response []struct {
+		Type      string  `json:"type"`
+		Currency  string  `json:"currency"`
+		Available float64 `json:"available,string"`
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err.Error())
+	}
+
+	// Only balances in the wallet orders trade from are available
+	var fiat, crypto float64
+	for _, balance := range response {
+		if balance.Type != client.wallet() {
+			continue
+		}
+		if balance.Currency == client.currency {
+			fiat = balance.Available
+		} else if balance.Currency == client.symbol {
+			crypto = balance.Available
+		}
+	}
+
+	return fiat, crypto, nil
+}
+
+// AccountFees returns the maker and taker fees for the symbol at the account's 30-day volume tier
+func (client *Client) AccountFees() (float64, float64, error) {
+	// Create request struct
+	request := struct {
+		URL   string `json:"request"`
+		Nonce string `json:"nonce"`
+	}{
+		"/v1/account_infos",
+		client.nonce(),
+	}
+
+	// Send POST request
+	data, err := client.post(context.Background(), client.baseURL+request.URL, request)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+
+	// Unmarshal response, fees are in percent
+	type fees struct {
+		Pairs     string  `json:"pairs"`
+		MakerFees float64 `json:"maker_fees,string"`
+		TakerFees float64 `json:"taker_fees,string"`
+	}
+	var response []struct {
+		fees
+		Fees []fees `json:"fees"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+	if len(response) == 0 {
+		return 0, 0, fmt.Errorf("%s AccountFees error: no account info returned", client)
+	}
+
+	// Use the symbol's fees where listed
+	account := response[0].fees
+	for _, pair := range response[0].Fees {
+		if strings.EqualFold(pair.Pairs, client.symbol) {
+			account = pair
+		}
+	}
+
+	return account.MakerFees / 100, account.TakerFees / 100, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort is a flat position
+// On margin the wallet doesn't change with fills, so the open margin position is returned
+func (client *Client) ActualPosition() (float64, error) {
+	if client.margin {
+		return client.marginPosition()
+	}
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort, nil
+}
+
+// Return the net amount of open margin positions in the symbol, negative if short
+func (client *Client) marginPosition() (float64, error) {
+	// Create request struct
+	request := struct {
+		URL   string `json:"request"`
+		Nonce string `json:"nonce"`
+	}{
+		"/v1/positions",
+		client.nonce(),
+	}
+
+	// Send POST request
+	data, err := client.post(context.Background(), client.baseURL+request.URL, request)
+	if err != nil {
+		return 0, fmt.Errorf("%s ActualPosition error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response []struct {
+		Symbol string  `json:"symbol"`
+		Status string  `json:"status"`
+		Amount float64 `json:"amount,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, fmt.Errorf("%s ActualPosition error: %s", client, err.Error())
+	}
+
+	var position float64
+	for _, p := range response {
+		if p.Symbol == client.symbol+client.currency && p.Status == "ACTIVE" {
+			position += p.Amount
+		}
+	}
+	return position, nil
+}
+
+// Error for a Bitfinex error message, of a known kind where the message has one
+// Order rejections start with "Invalid order", as in "Invalid order: not enough exchange balance for 1.0 BTCUSD at 250.0"
+func messageError(message string) error {
+	switch {
+	case strings.Contains(message, "not enough") && strings.Contains(message, "balance"):
+		return exchange.NewError(exchange.ErrInsufficientFunds, message)
+	case strings.HasPrefix(message, "Invalid order"):
+		return exchange.NewError(exchange.ErrOrderRejected, message)
+	case strings.Contains(message, "ERR_RATE_LIMIT") || strings.Contains(message, "Ratelimit"):
+		return exchange.NewError(exchange.ErrRateLimited, message)
+	}
+	return errors.New(message)
+}
+
 // Authenticated POST
-func (client *Client) post(url string, payload interface{}) ([]byte, error) {
+func (client *Client) post(ctx context.Context, url string, payload interface{}) ([]byte, error) {
 	// Payload = parameters-dictionary -> JSON encode -> base64
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
@@ -342,9 +899,9 @@ func (client *Client) post(url string, payload interface{}) ([]byte, error) {
 	h.Write([]byte(payloadBase64))
 	signature := hex.EncodeToString(h.Sum(nil))
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, exchange.ConnectionError(exchange.Redact(err, client.key, client.secret, signature))
 	}
 
 	// HTTP headers:
@@ -356,29 +913,32 @@ func (client *Client) post(url string, payload interface{}) ([]byte, error) {
 	req.Header.Add("X-BFX-SIGNATURE", signature)
 
 	// Send POST
-	httpClient := http.Client{}
-	resp, err := httpClient.Do(req)
+	resp, err := client.getHTTPClient().Do(req)
 	if err != nil {
-		return []byte{}, err
-	}
-	if resp.StatusCode != 200 {
-		return []byte{}, fmt.Errorf(resp.Status)
+		return []byte{}, exchange.ConnectionError(exchange.Redact(err, client.key, client.secret, signature))
 	}
 	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
+		return []byte{}, err
+	}
 
 	return ioutil.ReadAll(resp.Body)
 }
 
 // Unauthenticated GET
-func (client *Client) get(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+func (client *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return []byte{}, err
 	}
-	if resp.StatusCode != 200 {
-		return []byte{}, fmt.Errorf(resp.Status)
+	resp, err := client.getHTTPClient().Do(req)
+	if err != nil {
+		return []byte{}, exchange.ConnectionError(err)
 	}
 	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
+		return []byte{}, err
+	}
 
 	return ioutil.ReadAll(resp.Body)
 }
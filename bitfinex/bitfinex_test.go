@@ -2,19 +2,66 @@ package bitfinex
 
 import (
 	"bitfx/exchange"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
 var (
 	book   exchange.Book
-	client = New(os.Getenv("BITFINEX_KEY"), os.Getenv("BITFINEX_SECRET"), "ltc", "usd", 2, 0.001, 2, .1)
+	client = mustNew(New(os.Getenv("BITFINEX_KEY"), os.Getenv("BITFINEX_SECRET"), "ltc", "usd", 2, 0.001, 2, .1))
 )
 
+// Returns the client, panicking on constructor errors
+func mustNew(client *Client, err error) *Client {
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Test constructor argument errors
+func TestNew(t *testing.T) {
+	if _, err := New("", "", "ltc", "cny", 1, 0, 0, 0); err == nil || err.Error() != "Bitfinex(cny) New error: currency must be USD or EUR or GBP or JPY" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+	eur, err := New("", "", "ltc", "EUR", 1, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eur.Currency() != "eur" || eur.CurrencyCode() != 2 || eur.String() != "Bitfinex(eur)" {
+		t.Fatalf("Wrong EUR client %s with code %d", eur, eur.CurrencyCode())
+	}
+	eur.Done()
+	if _, err := New("key", "", "ltc", "usd", 1, 0, 0, 0); err == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+	if _, err := New("", "", "ltc", "usd", 1, 0, 0, 0, WithURLs("https://api.example.com", "https://ws.example.com")); err == nil {
+		t.Fatal("Expected error for WebSocket URL with an HTTP scheme")
+	}
+	proxied, err := New("", "", "ltc", "usd", 1, 0, 0, 0, WithURLs("http://127.0.0.1:8080", "ws://127.0.0.1:8080/ws/2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxied.baseURL != "http://127.0.0.1:8080" || proxied.websocketURL != "ws://127.0.0.1:8080/ws/2" {
+		t.Fatalf("URLs not overridden: %s and %s", proxied.baseURL, proxied.websocketURL)
+	}
+	proxied.Done()
+}
+
 // Returns a mock HTTP server
 func testServer(code int, body string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +82,7 @@ func notEqual(f1, f2 float64) bool {
 func TestGetBook(t *testing.T) {
 	body := `{"bids":[{"price":"1.6391","amount":"53.08276864","timestamp":"1427811013.0"},{"price":"1.639","amount":"13.62","timestamp":"1427810280.0"},{"price":"1.638","amount":"14.26","timestamp":"1427810251.0"},{"price":"1.637","amount":"8.44","timestamp":"1427810231.0"},{"price":"1.636","amount":"21.43","timestamp":"1427810216.0"},{"price":"1.634","amount":"9.96","timestamp":"1427810238.0"},{"price":"1.631","amount":"11.7","timestamp":"1427809353.0"},{"price":"1.63","amount":"0.1","timestamp":"1427788892.0"},{"price":"1.629","amount":"6.98","timestamp":"1427809000.0"},{"price":"1.628","amount":"11.7","timestamp":"1427809359.0"},{"price":"1.627","amount":"25.91512719","timestamp":"1427808956.0"},{"price":"1.6269","amount":"13.54211743","timestamp":"1427811077.0"},{"price":"1.626","amount":"6.98","timestamp":"1427808940.0"},{"price":"1.625","amount":"11.7","timestamp":"1427809365.0"},{"price":"1.6233","amount":"0.1","timestamp":"1427680917.0"},{"price":"1.622","amount":"15.68","timestamp":"1427808196.0"},{"price":"1.6201","amount":"174.0","timestamp":"1427810992.0"},{"price":"1.62","amount":"119.94830228","timestamp":"1427810640.0"},{"price":"1.6159","amount":"200.0","timestamp":"1427811056.0"},{"price":"1.6157","amount":"2151.8","timestamp":"1427811049.0"}],"asks":[{"price":"1.649","amount":"8.225777","timestamp":"1427811011.0"},{"price":"1.65","amount":"118.35905692","timestamp":"1427807969.0"},{"price":"1.651","amount":"56.3099955","timestamp":"1427810969.0"},{"price":"1.652","amount":"21.79","timestamp":"1427810806.0"},{"price":"1.653","amount":"21.29","timestamp":"1427810776.0"},{"price":"1.654","amount":"21.1","timestamp":"1427811017.0"},{"price":"1.655","amount":"21.69","timestamp":"1427810883.0"},{"price":"1.656","amount":"19.45","timestamp":"1427810790.0"},{"price":"1.657","amount":"27.1030322","timestamp":"1427803455.0"},{"price":"1.658","amount":"21.69","timestamp":"1427810824.0"},{"price":"1.659","amount":"26.8","timestamp":"1427810129.0"},{"price":"1.66","amount":"27.20087772","timestamp":"1427800329.0"},{"price":"1.661","amount":"21.69","timestamp":"1427810843.0"},{"price":"1.662","amount":"44.3","timestamp":"1427811018.0"},{"price":"1.6792","amount":"3.0","timestamp":"1427808043.0"},{"price":"1.68","amount":"119.94830228","timestamp":"1427810640.0"},{"price":"1.681","amount":"7.1386","timestamp":"1427784448.0"},{"price":"1.684","amount":"10.0","timestamp":"1427771020.0"},{"price":"1.6868","amount":"100.0","timestamp":"1427787418.0"},{"price":"1.6935","amount":"200.0","timestamp":"1427811056.0"}]}`
 	server := testServer(200, body)
-	client := Client{baseURL: server.URL}
+	client := Client{baseURL: server.URL, depth: 20}
 	book, timeStamps := client.getBook()
 	if len(timeStamps) != 40 || len(book.Bids) != 20 || len(book.Asks) != 20 {
 		t.Fatal("Should have returned 20 items")
@@ -48,6 +95,357 @@ func TestGetBook(t *testing.T) {
 	}
 }
 
+// Test retrieving a book thinner than the requested depth
+func TestGetBookShort(t *testing.T) {
+	body := `{"bids":[{"price":"1.6391","amount":"53.08276864","timestamp":"1427811013.0"},{"price":"1.639","amount":"13.62","timestamp":"1427810280.0"}],"asks":[{"price":"1.649","amount":"8.225777","timestamp":"1427811011.0"}]}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := Client{baseURL: server.URL, depth: 20}
+	book, timeStamps := client.getBook()
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(timeStamps) != 3 || len(book.Bids) != 2 || len(book.Asks) != 1 {
+		t.Fatal("Should have returned only the available items")
+	}
+	if !bookChanged(timeStamps, timeStamps[:2]) {
+		t.Fatal("Books of different depth should be changed")
+	}
+}
+
+// Test retrieving ticker data with mock server
+func TestTicker(t *testing.T) {
+	body := `{"mid":"1.644","bid":"1.6391","ask":"1.649","last_price":"1.645","low":"1.62","high":"1.71","volume":"52114.9","timestamp":"1427811013.5"}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := Client{baseURL: server.URL}
+	ticker, err := client.Ticker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(ticker.Bid, 1.6391) || notEqual(ticker.Ask, 1.649) || notEqual(ticker.Last, 1.645) {
+		t.Fatal("Wrong ticker prices")
+	}
+	if notEqual(ticker.Volume, 52114.9) {
+		t.Fatal("Wrong ticker volume")
+	}
+	if ticker.Time.Unix() != 1427811013 {
+		t.Fatal("Wrong ticker time")
+	}
+}
+
+// Test retrieving balances with mock server
+func TestBalances(t *testing.T) {
+	body := `[{"type":"deposit","currency":"usd","amount":"50.0","available":"50.0"},{"type":"exchange","currency":"usd","amount":"120.5","available":"100.5"},{"type":"exchange","currency":"ltc","amount":"3.0","available":"2.5"},{"type":"exchange","currency":"btc","amount":"1.0","available":"1.0"}]`
+	server := testServer(200, body)
+	defer server.Close()
+	client := Client{baseURL: server.URL, symbol: "ltc", currency: "usd"}
+	fiat, crypto, err := client.Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(fiat, 100.5) {
+		t.Fatal("Fiat balance should be 100.5")
+	}
+	if notEqual(crypto, 2.5) {
+		t.Fatal("Crypto balance should be 2.5")
+	}
+}
+
+// Test nonces following a skewed exchange clock with mock server
+func TestServerClockNonce(t *testing.T) {
+	var nonces []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Exchange clock an hour ahead of the local clock
+		w.Header().Set("Date", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		if payload := r.Header.Get("X-BFX-PAYLOAD"); payload != "" {
+			data, _ := base64.StdEncoding.DecodeString(payload)
+			var request struct {
+				Nonce int64 `json:"nonce,string"`
+			}
+			if err := json.Unmarshal(data, &request); err != nil {
+				t.Error(err)
+			}
+			nonces = append(nonces, request.Nonce)
+		}
+		fmt.Fprintln(w, `[]`)
+	}))
+	defer server.Close()
+	client := Client{name: "Bitfinex(usd)", baseURL: server.URL, symbol: "ltc", currency: "usd"}
+
+	offset, err := client.SyncClock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 59*time.Minute || offset > 61*time.Minute {
+		t.Fatalf("Expected an offset near an hour, got %s", offset)
+	}
+	if _, _, err := client.Balances(); err != nil {
+		t.Fatal(err)
+	}
+	if ahead := time.Duration(nonces[0] - time.Now().UnixNano()); ahead < 59*time.Minute {
+		t.Fatalf("Nonce should follow the exchange clock, got %s ahead", ahead)
+	}
+
+	// Nonces keep increasing when the clock is set back
+	client.serverClock.SetOffset(0)
+	if _, _, err := client.Balances(); err != nil {
+		t.Fatal(err)
+	}
+	if nonces[1] <= nonces[0] {
+		t.Fatal("Nonce should increase after the clock is set back")
+	}
+}
+
+// Test nonces of concurrent requests with mock server
+func TestConcurrentNonces(t *testing.T) {
+	var (
+		seen  = make(map[int64]bool)
+		mutex sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := base64.StdEncoding.DecodeString(r.Header.Get("X-BFX-PAYLOAD"))
+		var request struct {
+			Nonce int64 `json:"nonce,string"`
+		}
+		if err := json.Unmarshal(data, &request); err != nil {
+			t.Error(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if seen[request.Nonce] {
+			t.Errorf("Nonce %d sent twice", request.Nonce)
+		}
+		seen[request.Nonce] = true
+		fmt.Fprintln(w, `{}`)
+	}))
+	defer server.Close()
+	client := Client{name: "Bitfinex(usd)", baseURL: server.URL}
+
+	// Each sender's nonces increase, and no two senders share one
+	const senders, sends = 20, 10
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for j := 0; j < sends; j++ {
+				request := struct {
+					URL   string `json:"request"`
+					Nonce string `json:"nonce"`
+				}{"/v1/balances", client.nonce()}
+				nonce, _ := strconv.ParseInt(request.Nonce, 10, 64)
+				if nonce <= last {
+					t.Errorf("Nonce %d not above the previous %d", nonce, last)
+				}
+				last = nonce
+				if _, err := client.post(context.Background(), server.URL+request.URL, request); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != senders*sends {
+		t.Fatalf("Expected %d unique nonces, got %d", senders*sends, len(seen))
+	}
+}
+
+// Test retrieving the fee tier with mock server
+func TestAccountFees(t *testing.T) {
+	body := `[{"maker_fees":"0.1","taker_fees":"0.2","fees":[{"pairs":"BTC","maker_fees":"0.1","taker_fees":"0.2"},{"pairs":"LTC","maker_fees":"0.08","taker_fees":"0.18"}]}]`
+	server := testServer(200, body)
+	defer server.Close()
+	client := Client{baseURL: server.URL, symbol: "ltc", currency: "usd"}
+	maker, taker, err := client.AccountFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(maker, .0008) || notEqual(taker, .0018) {
+		t.Fatalf("Expected the LTC fees of .0008 and .0018, got %v and %v", maker, taker)
+	}
+	client.SetFees(maker, taker)
+	if notEqual(client.MakerFee(), .0008) || notEqual(client.TakerFee(), .0018) {
+		t.Fatal("Fees should be updated")
+	}
+}
+
+// Test retrieving open orders with mock server
+func TestOpenOrders(t *testing.T) {
+	body := `[{"id":448411365,"symbol":"ltcusd","side":"sell","price":"1.75","original_amount":"-2.0","executed_amount":"-0.5","is_live":true},{"id":448411366,"symbol":"btcusd","side":"buy","price":"250.0","original_amount":"1.0","executed_amount":"0.0","is_live":true},{"id":448411367,"symbol":"ltcusd","side":"buy","price":"1.5","original_amount":"1.0","executed_amount":"0.0","is_live":false}]`
+	server := testServer(200, body)
+	defer server.Close()
+	client := Client{baseURL: server.URL, symbol: "ltc", currency: "usd"}
+	orders, err := client.OpenOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != 448411365 {
+		t.Fatalf("Expected only order 448411365, got %v", orders)
+	}
+	if orders[0].Side != "sell" || notEqual(orders[0].Price, 1.75) || notEqual(orders[0].Amount, 2) || notEqual(orders[0].FilledAmount, .5) {
+		t.Fatal("Wrong open order details")
+	}
+}
+
+// Test order types and balances for exchange and margin trading with mock server
+func TestMargin(t *testing.T) {
+	var orderType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := base64.StdEncoding.DecodeString(r.Header.Get("X-BFX-PAYLOAD"))
+		var request struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(payload, &request)
+		orderType = request.Type
+		fmt.Fprintln(w, `{"order_id":1}`)
+	}))
+	defer server.Close()
+	client := Client{baseURL: server.URL, symbol: "ltc", currency: "usd"}
+
+	// Margin order types by default, exchange orders once set unless on margin
+	for _, test := range []struct {
+		margin, exchangeOrders bool
+		otype, sent            string
+	}{
+		{false, false, "limit", "limit"},
+		{false, false, "post_only", "limit"},
+		{false, true, "limit", "exchange limit"},
+		{false, true, "post_only", "exchange limit"},
+		{false, true, "market", "exchange market"},
+		{true, false, "limit", "limit"},
+		{true, true, "post_only", "limit"},
+		{true, true, "market", "market"},
+	} {
+		client.SetMargin(test.margin)
+		client.SetExchangeOrders(test.exchangeOrders)
+		if _, err := client.SendOrder(context.Background(), "sell", test.otype, 1, 1.7); err != nil {
+			t.Fatal(err)
+		}
+		if orderType != test.sent {
+			t.Errorf("Expected %q sent for %s with margin %v and exchange orders %v, got %q", test.sent, test.otype, test.margin, test.exchangeOrders, orderType)
+		}
+	}
+
+	// Margin balances come from the trading wallet
+	balances := testServer(200, `[{"type":"exchange","currency":"usd","available":"100.5"},{"type":"trading","currency":"usd","available":"80"},{"type":"trading","currency":"ltc","available":"1.5"}]`)
+	defer balances.Close()
+	client.baseURL = balances.URL
+	if fiat, crypto, err := client.Balances(); err != nil || notEqual(fiat, 80) || notEqual(crypto, 1.5) {
+		t.Fatalf("Expected trading wallet balances of 80 and 1.5, got %v and %v, %v", fiat, crypto, err)
+	}
+
+	// The margin position is the open position in the symbol
+	positions := testServer(200, `[{"id":1,"symbol":"ltcusd","status":"ACTIVE","amount":"-2.5"},{"id":2,"symbol":"btcusd","status":"ACTIVE","amount":"1.0"}]`)
+	defer positions.Close()
+	client.baseURL = positions.URL
+	if position, err := client.ActualPosition(); err != nil || notEqual(position, -2.5) {
+		t.Fatalf("Expected a margin position of -2.5, got %v, %v", position, err)
+	}
+}
+
+// Test that an order request is abandoned at the context deadline
+func TestSendOrderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprintln(w, `{"order_id":1}`)
+	}))
+	defer server.Close()
+	client := Client{baseURL: server.URL}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.SendOrder(ctx, "buy", "limit", 1, 1); err == nil {
+		t.Fatal("Expected error after deadline")
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Fatal("Should return at the deadline")
+	}
+}
+
+// Test that a slow response errors at the HTTP timeout
+func TestHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprintln(w, `{}`)
+	}))
+	defer server.Close()
+	client := Client{baseURL: server.URL}
+	client.SetHTTPTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.Ticker(); err == nil {
+		t.Fatal("Expected error after timeout")
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Fatal("Should return at the timeout")
+	}
+}
+
+// Test that Bitfinex error messages map to their kinds
+func TestMessageError(t *testing.T) {
+	messages := []struct {
+		message string
+		kind    error
+	}{
+		{"Invalid order: not enough exchange balance for 1.0 BTCUSD at 250.0", exchange.ErrInsufficientFunds},
+		{"Invalid order: not enough tradable balance for 1.0 BTCUSD at 250.0", exchange.ErrInsufficientFunds},
+		{"Invalid order: minimum size for BTC/USD is 0.01", exchange.ErrOrderRejected},
+		{"ERR_RATE_LIMIT", exchange.ErrRateLimited},
+		{"No such order found.", nil},
+	}
+	kinds := []error{exchange.ErrInsufficientFunds, exchange.ErrOrderRejected, exchange.ErrRateLimited, exchange.ErrConnection}
+	for _, test := range messages {
+		err := messageError(test.message)
+		if err.Error() != test.message {
+			t.Errorf("%s: wrong message %q", test.message, err)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.kind) {
+				t.Errorf("%s: expected kind %v, got %v", test.message, test.kind, err)
+			}
+		}
+	}
+
+	// Kinds are kept through REST order errors
+	server := testServer(200, `{"message":"Invalid order: minimum size for BTC/USD is 0.01"}`)
+	defer server.Close()
+	client := Client{name: "Bitfinex(usd)", baseURL: server.URL}
+	if _, err := client.SendOrder(context.Background(), "buy", "limit", .001, 250); !errors.Is(err, exchange.ErrOrderRejected) {
+		t.Fatalf("Expected a rejected order, got %v", err)
+	}
+}
+
+// Test that invalid orders are rejected without a request
+func TestSendOrderInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Invalid order sent to %s", r.URL.Path)
+	}))
+	defer server.Close()
+	client := Client{name: "Bitfinex(usd)", baseURL: server.URL}
+	for _, order := range [][2]float64{{0, 250}, {-1, 250}, {1, 0}} {
+		if _, err := client.SendOrder(context.Background(), "buy", "limit", order[0], order[1]); !errors.Is(err, exchange.ErrInvalidOrder) {
+			t.Errorf("Expected invalid order for %v, got %v", order, err)
+		}
+	}
+}
+
+func TestRoundOrder(t *testing.T) {
+	amount, price := client.RoundOrder(1.234567891, 1.639149)
+	if amount != 1.23456789 || price != 1.6391 {
+		t.Fatalf("Expected 1.23456789 at 1.6391, got %v at %v", amount, price)
+	}
+	if _, price := client.RoundOrder(1, 37512.6); price != 37513 {
+		t.Fatalf("Expected price of 37513, got %v", price)
+	}
+	if step := client.PriceStep(1.6391); math.Abs(step-.0001) > 1e-12 {
+		t.Fatalf("Expected step of .0001, got %v", step)
+	}
+	if step := client.PriceStep(37512.6); step != 1 {
+		t.Fatalf("Expected step of 1, got %v", step)
+	}
+}
+
 func TestPriority(t *testing.T) {
 	if client.Priority() != 2 {
 		t.Fatal("Priority should be 2")
@@ -55,8 +453,9 @@ func TestPriority(t *testing.T) {
 }
 
 func TestFee(t *testing.T) {
-	if notEqual(client.Fee(), 0.001) {
-		t.Fatal("Fee should be 0.001")
+	// Both fees default to the fee supplied to New
+	if notEqual(client.TakerFee(), 0.001) || notEqual(client.MakerFee(), 0.001) {
+		t.Fatal("Maker and taker fees should be 0.001")
 	}
 }
 
@@ -104,9 +503,9 @@ func TestAvailShort(t *testing.T) {
 	}
 }
 
-func TestHasCryptoFee(t *testing.T) {
-	if client.HasCryptoFee() {
-		t.Fatal("Should not have cryptocurrency fee")
+func TestFeeAdjustedFill(t *testing.T) {
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2) || notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Fees in fiat should leave fills unchanged")
 	}
 }
 
@@ -115,7 +514,8 @@ func TestHasCryptoFee(t *testing.T) {
 
 func TestCommunicateBook(t *testing.T) {
 	bookChan := make(chan exchange.Book)
-	if book = client.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book = client.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		t.Fatal(book.Error)
 	}
 
@@ -140,14 +540,14 @@ func TestNewOrder(t *testing.T) {
 	price := book.Asks[0].Price + 0.10
 
 	// Test submitting a new order
-	id, err := client.SendOrder(action, otype, amount, price)
+	id, err := client.SendOrder(context.Background(), action, otype, amount, price)
 	if err != nil || id == 0 {
 		t.Fatal(err)
 	}
 	t.Logf("Placed a new sell order of 0.1 ltcusd @ %v limit with ID: %d", price, id)
 
 	// Check status
-	order, err := client.GetOrderStatus(id)
+	order, err := client.GetOrderStatus(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -161,7 +561,7 @@ func TestNewOrder(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test cancelling the order
-	success, err := client.CancelOrder(id)
+	success, err := client.CancelOrder(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -174,7 +574,7 @@ func TestNewOrder(t *testing.T) {
 	tryAgain := true
 	for tryAgain {
 		t.Logf("checking status...")
-		order, err = client.GetOrderStatus(id)
+		order, err = client.GetOrderStatus(context.Background(), id)
 		tryAgain = order.Status == ""
 	}
 	if err != nil {
@@ -190,7 +590,7 @@ func TestNewOrder(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test bad order
-	id, err = client.SendOrder("kill", otype, amount, price)
+	id, err = client.SendOrder(context.Background(), "kill", otype, amount, price)
 	if id != 0 {
 		t.Fatal("Expected id = 0")
 	}
@@ -0,0 +1,297 @@
+// Bitfinex WebSocket book channel
+// https://docs.bitfinex.com/reference/ws-public-books
+
+package bitfinex
+
+import (
+	"bitfx/exchange"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Local book maintained from the book channel
+type level struct {
+	price, amount float64
+}
+type localBook struct {
+	bids []level // Sorted by price high to low
+	asks []level // Sorted by price low to high
+}
+
+// Book channel event format
+type bookEvent struct {
+	Event string `json:"event"`
+	Msg   string `json:"msg"`
+	Code  int    `json:"code"`
+}
+
+// Connect to the WebSocket and subscribe to the book channel
+func (client *Client) connectBook() (*websocket.Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(client.websocketURL, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The 25 level book covers the default depth, 100 levels is the other option
+	length := "25"
+	if client.depth > 25 {
+		length = "100"
+	}
+	subMsg := struct {
+		Event     string `json:"event"`
+		Channel   string `json:"channel"`
+		Symbol    string `json:"symbol"`
+		Precision string `json:"prec"`
+		Length    string `json:"len"`
+	}{"subscribe", "book", "t" + strings.ToUpper(client.symbol+client.currency), "P0", length}
+	if err := ws.SetWriteDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := ws.WriteJSON(subMsg); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := ws.SetWriteDeadline(time.Time{}); err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// Connect and read until the book snapshot arrives
+func (client *Client) openBook() (*websocket.Conn, *localBook, error) {
+	ws, err := client.connectBook()
+	if err != nil {
+		return nil, nil, err
+	}
+	local := &localBook{}
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			ws.Close()
+			return nil, nil, err
+		}
+		if changed, err := local.apply(data); err != nil {
+			ws.Close()
+			return nil, nil, err
+		} else if changed {
+			return ws, local, nil
+		}
+	}
+}
+
+// Websocket read loop
+// Falls back to REST polling if the connection drops and can't be restored
+func (client *Client) runBookLoop(ws *websocket.Conn, local *localBook, lastBook exchange.Book, bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	// Read from websocket
+	dataChan := make(chan []byte)
+	errChan := make(chan error, 1)
+	readLoop := func(ws *websocket.Conn) {
+		for {
+			// Heartbeats arrive every 15 seconds
+			ws.SetReadDeadline(time.Now().Add(30 * time.Second))
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			dataChan <- data
+		}
+	}
+	go readLoop(ws)
+
+	for {
+		select {
+		case <-doneChan:
+			// End if notified
+			ws.Close()
+			return
+		case <-client.done:
+			// End if notified
+			ws.Close()
+			return
+		case err := <-errChan:
+			// Reconnect, a new snapshot replaces the local book
+			client.logger.Warnf("%s WebSocket error: %s", client, err)
+			ws.Close()
+			var newLocal *localBook
+			ws, newLocal, err = client.openBook()
+			if err != nil {
+				client.logger.Warnf("%s WebSocket error: %s, falling back to REST polling", client, err)
+				go client.runLoop(bookChan, doneChan)
+				return
+			}
+			*local = *newLocal
+			go readLoop(ws)
+		case data := <-dataChan:
+			changed, err := local.apply(data)
+			if err != nil {
+				// Closing makes the read loop reconnect
+				client.logger.Errorf("%s WebSocket error: %s", client, err)
+				ws.Close()
+				continue
+			}
+			if !changed {
+				continue
+			}
+			// Send out only if the levels in use changed
+			book := client.convertToBook(*local)
+			if !levelsChanged(lastBook, book) {
+				continue
+			}
+			lastBook = book
+			select {
+			case bookChan <- book:
+				client.health.Update(book)
+			case <-doneChan:
+				ws.Close()
+				return
+			}
+		}
+	}
+}
+
+// Apply a book channel message to the local book
+// Returns true if the book changed
+func (local *localBook) apply(data []byte) (bool, error) {
+	// Events are objects, data are arrays of channel ID and payload
+	if len(data) > 0 && data[0] == '{' {
+		var event bookEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false, err
+		}
+		if event.Event == "error" {
+			return false, fmt.Errorf("code %d: %s", event.Code, event.Msg)
+		}
+		return false, nil
+	}
+	var msg []json.RawMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return false, err
+	}
+	if len(msg) < 2 {
+		return false, errors.New("bad book message")
+	}
+	payload := msg[1]
+
+	// Heartbeats are the string "hb"
+	if len(payload) > 0 && payload[0] == '"' {
+		return false, nil
+	}
+
+	// A snapshot is an array of price, count, amount entries
+	var entries [][3]float64
+	if err := json.Unmarshal(payload, &entries); err == nil {
+		local.bids = local.bids[:0]
+		local.asks = local.asks[:0]
+		for _, entry := range entries {
+			if entry[2] > 0 {
+				local.bids = append(local.bids, level{entry[0], entry[2]})
+			} else {
+				local.asks = append(local.asks, level{entry[0], -entry[2]})
+			}
+		}
+		sort.Slice(local.bids, func(i, j int) bool { return local.bids[i].price > local.bids[j].price })
+		sort.Slice(local.asks, func(i, j int) bool { return local.asks[i].price < local.asks[j].price })
+		return true, nil
+	}
+
+	// An update is a single entry, a count of zero removes the level
+	var entry [3]float64
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return false, err
+	}
+	price, count, amount := entry[0], entry[1], entry[2]
+	if count == 0 {
+		amount = 0
+	}
+	if entry[2] > 0 {
+		local.bids = update(local.bids, price, amount, func(p float64) bool { return p <= price })
+	} else {
+		local.asks = update(local.asks, price, -amount, func(p float64) bool { return p >= price })
+	}
+	return true, nil
+}
+
+// Set the amount at a price level, removing the level if amount is zero
+// atOrBeyond reports whether a price sorts at or after the updated price
+func update(levels []level, price, amount float64, atOrBeyond func(float64) bool) []level {
+	i := sort.Search(len(levels), func(i int) bool { return atOrBeyond(levels[i].price) })
+	found := i < len(levels) && levels[i].price == price
+	switch {
+	case found && amount == 0:
+		return append(levels[:i], levels[i+1:]...)
+	case found:
+		levels[i].amount = amount
+	case amount != 0:
+		levels = append(levels, level{})
+		copy(levels[i+1:], levels[i:])
+		levels[i] = level{price, amount}
+	}
+	return levels
+}
+
+// Convert the local book to an exchange.Book
+func (client *Client) convertToBook(local localBook) exchange.Book {
+	if len(local.bids) == 0 || len(local.asks) == 0 {
+		return exchange.Book{Error: fmt.Errorf("%s ConvertToBook error: empty book side", client)}
+	}
+
+	// Depth is bounded by the local book
+	numBids, numAsks := client.depth, client.depth
+	if len(local.bids) < numBids {
+		numBids = len(local.bids)
+	}
+	if len(local.asks) < numAsks {
+		numAsks = len(local.asks)
+	}
+
+	// Local book is already sorted
+	bids := make(exchange.BidItems, numBids)
+	for i := range bids {
+		bids[i].Price = local.bids[i].price
+		bids[i].Amount = local.bids[i].amount
+	}
+	asks := make(exchange.AskItems, numAsks)
+	for i := range asks {
+		asks[i].Price = local.asks[i].price
+		asks[i].Amount = local.asks[i].amount
+	}
+
+	return exchange.Book{
+		Exg:   client,
+		Time:  time.Now(),
+		Bids:  bids,
+		Asks:  asks,
+		Error: nil,
+	}
+}
+
+// Returns true if any level within depth differs between books
+// The WebSocket counterpart to bookChanged, changes beyond depth are ignored
+func levelsChanged(book1, book2 exchange.Book) bool {
+	if len(book1.Bids) != len(book2.Bids) || len(book1.Asks) != len(book2.Asks) {
+		return true
+	}
+	for i := range book1.Bids {
+		if book1.Bids[i] != book2.Bids[i] {
+			return true
+		}
+	}
+	for i := range book1.Asks {
+		if book1.Asks[i] != book2.Asks[i] {
+			return true
+		}
+	}
+	return false
+}
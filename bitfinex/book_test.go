@@ -0,0 +1,76 @@
+package bitfinex
+
+import "testing"
+
+// Test maintaining the local book from book channel messages
+func TestApplyBook(t *testing.T) {
+	var local localBook
+	if changed, err := local.apply([]byte(`{"event":"subscribed","channel":"book","chanId":17,"symbol":"tLTCUSD","prec":"P0","len":"25"}`)); err != nil || changed {
+		t.Fatal("Subscription should not change the book")
+	}
+	snapshot := `[17,[[1.639,2,13.62],[1.6391,1,53.08],[1.649,1,-8.225],[1.651,3,-56.309],[1.65,1,-118.359]]]`
+	if changed, err := local.apply([]byte(snapshot)); err != nil || !changed {
+		t.Fatal("Snapshot should change the book")
+	}
+	if len(local.bids) != 2 || notEqual(local.bids[0].price, 1.6391) || notEqual(local.bids[1].amount, 13.62) {
+		t.Fatal("Bids not sorted properly")
+	}
+	if len(local.asks) != 3 || notEqual(local.asks[0].price, 1.649) || notEqual(local.asks[2].price, 1.651) || notEqual(local.asks[0].amount, 8.225) {
+		t.Fatal("Asks not sorted properly")
+	}
+
+	// Insert, modify, and remove levels
+	updates := []string{`[17,[1.6395,1,4]]`, `[17,[1.639,0,1]]`, `[17,[1.65,2,-100]]`, `[17,[1.651,0,-1]]`}
+	for _, update := range updates {
+		if changed, err := local.apply([]byte(update)); err != nil || !changed {
+			t.Fatal("Update should change the book")
+		}
+	}
+	bids := []float64{1.6395, 1.6391}
+	if len(local.bids) != len(bids) {
+		t.Fatal("Wrong number of bids")
+	}
+	for i := range bids {
+		if notEqual(local.bids[i].price, bids[i]) {
+			t.Fatal("Bids not updated properly")
+		}
+	}
+	if len(local.asks) != 2 || notEqual(local.asks[1].price, 1.65) || notEqual(local.asks[1].amount, 100) {
+		t.Fatal("Asks not updated properly")
+	}
+
+	// Heartbeats don't change the book
+	if changed, err := local.apply([]byte(`[17,"hb"]`)); err != nil || changed {
+		t.Fatal("Heartbeat should not change the book")
+	}
+	if _, err := local.apply([]byte(`{"event":"error","msg":"symbol: invalid","code":10300}`)); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+// Test that only changes within depth are sent
+func TestLevelsChanged(t *testing.T) {
+	client := mustNew(New("", "", "ltc", "usd", 1, 0, 0, 0))
+	client.SetDepth(1)
+	local := localBook{
+		bids: []level{{1.6391, 53.08}, {1.639, 13.62}},
+		asks: []level{{1.649, 8.225}},
+	}
+	book := client.convertToBook(local)
+	if len(book.Bids) != 1 {
+		t.Fatal("Depth should be bounded")
+	}
+
+	// Below depth
+	local.bids[1].amount = 1
+	if levelsChanged(book, client.convertToBook(local)) {
+		t.Fatal("Changes beyond depth should be ignored")
+	}
+	local.bids[0].amount = 1
+	if !levelsChanged(book, client.convertToBook(local)) {
+		t.Fatal("Changes within depth should be detected")
+	}
+	if book := client.convertToBook(localBook{bids: local.bids}); book.Error == nil {
+		t.Fatal("Expected error for empty side")
+	}
+}
@@ -0,0 +1,461 @@
+// Bitfinex authenticated WebSocket for orders
+// https://docs.bitfinex.com/docs/ws-auth
+
+package bitfinex
+
+import (
+	"bitfx/exchange"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Returned when the socket is down before a request is written
+var errSocketDown = errors.New("order WebSocket not connected")
+
+// Order flag for post-only orders
+const postOnlyFlag = 4096
+
+// Authenticated order WebSocket with order state kept from its updates
+type orderSocket struct {
+	client    *Client
+	conn      *websocket.Conn               // Nil while disconnected
+	orders    map[int64]exchange.Order      // Latest state by order ID
+	sends     map[int64]chan<- socketResult // Pending new orders by client order ID
+	cancels   map[int64]chan<- socketResult // Pending cancels by order ID
+	unknown   map[int64]int64               // Order IDs of sends cut off by a disconnect, by client order ID, zero until found
+	snapshot  chan bool                     // Closed when the open orders snapshot of the current connection arrives
+	synced    bool                          // Whether snapshot is closed
+	lastCID   int64
+	closed    bool
+	mutex     sync.Mutex
+	writeLock sync.Mutex
+}
+
+// Result of an order request
+type socketResult struct {
+	id  int64
+	err error
+}
+
+// Order fields used from Bitfinex order arrays
+type socketOrder struct {
+	id, cid            int64
+	amount, amountOrig float64
+	status             string
+}
+
+// Returns a new orderSocket, call maintain to connect
+func newOrderSocket(client *Client) *orderSocket {
+	return &orderSocket{
+		client:   client,
+		orders:   make(map[int64]exchange.Order),
+		sends:    make(map[int64]chan<- socketResult),
+		cancels:  make(map[int64]chan<- socketResult),
+		unknown:  make(map[int64]int64),
+		snapshot: make(chan bool),
+	}
+}
+
+// Keep the socket connected until closed
+func (ows *orderSocket) maintain() {
+	backoff := exchange.Backoff{Min: time.Second, Max: time.Minute}
+	for {
+		conn, err := ows.connect()
+		if err != nil {
+			ows.client.logger.Warnf("%s WebSocket error: %s", ows.client, err)
+			<-ows.client.clock.After(backoff.Next())
+			if ows.isClosed() {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+
+		ows.mutex.Lock()
+		if ows.closed {
+			ows.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		ows.conn = conn
+		ows.mutex.Unlock()
+
+		err = ows.read(conn)
+		ows.disconnect(err)
+		conn.Close()
+		if ows.isClosed() {
+			return
+		}
+		ows.client.logger.Warnf("%s WebSocket error: %s", ows.client, err)
+	}
+}
+
+// Close the socket for good
+func (ows *orderSocket) close() {
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+	ows.closed = true
+	if ows.conn != nil {
+		ows.conn.Close()
+	}
+}
+
+// Returns true if the socket has been closed for good
+func (ows *orderSocket) isClosed() bool {
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+	return ows.closed
+}
+
+// Dial and authenticate
+func (ows *orderSocket) connect() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(ows.client.websocketURL, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Payload = "AUTH" + nonce, signature = HMAC-SHA384(payload, api-secret) as hexadecimal
+	nonce := strconv.FormatInt(ows.client.serverClock.Now().UnixNano()/1000, 10)
+	payload := "AUTH" + nonce
+	h := hmac.New(sha512.New384, []byte(ows.client.secret))
+	h.Write([]byte(payload))
+	authMsg := map[string]string{
+		"event":       "auth",
+		"apiKey":      ows.client.key,
+		"authSig":     hex.EncodeToString(h.Sum(nil)),
+		"authPayload": payload,
+		"authNonce":   nonce,
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.WriteJSON(authMsg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Wait for the auth response, skipping the info message
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		var event struct {
+			Event  string `json:"event"`
+			Status string `json:"status"`
+			Msg    string `json:"msg"`
+		}
+		if json.Unmarshal(data, &event) != nil || event.Event != "auth" {
+			continue
+		}
+		if event.Status != "OK" {
+			conn.Close()
+			return nil, fmt.Errorf("auth failed: %s", event.Msg)
+		}
+		return conn, nil
+	}
+}
+
+// Read messages until an error
+func (ows *orderSocket) read(conn *websocket.Conn) error {
+	for {
+		// Heartbeats arrive every 15 seconds
+		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		ows.handle(data)
+	}
+}
+
+// Mark the socket down, failing pending requests
+// Pending orders may have reached the exchange, so they fail with an unknown outcome to be reconciled
+func (ows *orderSocket) disconnect(err error) {
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+	ows.conn = nil
+	// Orders closed while disconnected would be stale
+	ows.orders = make(map[int64]exchange.Order)
+	if ows.synced {
+		ows.snapshot = make(chan bool)
+		ows.synced = false
+	}
+	for cid, reply := range ows.sends {
+		ows.unknown[cid] = 0
+		reply <- socketResult{err: &exchange.Error{Kind: exchange.ErrUnknownOutcome, Err: fmt.Errorf("WebSocket disconnected after the order was sent: %v", err)}}
+		delete(ows.sends, cid)
+	}
+	for id, reply := range ows.cancels {
+		reply <- socketResult{err: exchange.ConnectionError(fmt.Errorf("WebSocket disconnected: %v", err))}
+		delete(ows.cancels, id)
+	}
+}
+
+// Handle a message on the account channel
+func (ows *orderSocket) handle(data []byte) {
+	// Account channel messages are [0, type, data]
+	var msg []json.RawMessage
+	if json.Unmarshal(data, &msg) != nil || len(msg) < 3 {
+		return
+	}
+	var msgType string
+	if json.Unmarshal(msg[1], &msgType) != nil {
+		return
+	}
+
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+
+	switch msgType {
+	case "os":
+		// Snapshot of open orders
+		var orders []json.RawMessage
+		if json.Unmarshal(msg[2], &orders) != nil {
+			return
+		}
+		for _, raw := range orders {
+			if order, err := parseSocketOrder(raw); err == nil {
+				ows.update(order, false)
+			}
+		}
+		if !ows.synced {
+			close(ows.snapshot)
+			ows.synced = true
+		}
+	case "on", "ou", "oc":
+		order, err := parseSocketOrder(msg[2])
+		if err != nil {
+			return
+		}
+		ows.update(order, msgType == "oc")
+		if reply, ok := ows.sends[order.cid]; ok && msgType != "oc" {
+			reply <- socketResult{id: order.id}
+			delete(ows.sends, order.cid)
+		}
+		if reply, ok := ows.cancels[order.id]; ok && msgType == "oc" {
+			reply <- socketResult{id: order.id}
+			delete(ows.cancels, order.id)
+		}
+	case "n":
+		ows.notify(msg[2])
+	}
+}
+
+// Handle a notification, failing requests that the exchange rejected
+// Notifications are [MTS, TYPE, MESSAGE_ID, null, ORDER, CODE, STATUS, TEXT], must hold mutex
+func (ows *orderSocket) notify(data json.RawMessage) {
+	var n []json.RawMessage
+	if json.Unmarshal(data, &n) != nil || len(n) < 8 {
+		return
+	}
+	var nType, status, text string
+	json.Unmarshal(n[1], &nType)
+	json.Unmarshal(n[6], &status)
+	json.Unmarshal(n[7], &text)
+	if status != "ERROR" && status != "FAILURE" {
+		return
+	}
+	order, err := parseSocketOrder(n[4])
+	if err != nil {
+		return
+	}
+	switch nType {
+	case "on-req":
+		if reply, ok := ows.sends[order.cid]; ok {
+			reply <- socketResult{err: messageError(text)}
+			delete(ows.sends, order.cid)
+		}
+	case "oc-req":
+		if reply, ok := ows.cancels[order.id]; ok {
+			reply <- socketResult{err: messageError(text)}
+			delete(ows.cancels, order.id)
+		}
+	}
+}
+
+// Store the latest state of an order, must hold mutex
+// Sends of unknown outcome are matched by client order ID
+func (ows *orderSocket) update(order socketOrder, closed bool) {
+	if _, ok := ows.unknown[order.cid]; ok && order.cid != 0 {
+		ows.unknown[order.cid] = order.id
+	}
+	status := "dead"
+	if !closed && (strings.HasPrefix(order.status, "ACTIVE") || strings.HasPrefix(order.status, "PARTIALLY FILLED")) {
+		status = "live"
+	}
+	ows.orders[order.id] = exchange.Order{
+		FilledAmount: math.Abs(order.amountOrig - order.amount),
+		Status:       status,
+	}
+}
+
+// Parse the fields used from an order array
+// [ID, GID, CID, SYMBOL, MTS_CREATE, MTS_UPDATE, AMOUNT, AMOUNT_ORIG, TYPE, TYPE_PREV, _, _, FLAGS, STATUS, ...]
+func parseSocketOrder(data json.RawMessage) (socketOrder, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return socketOrder{}, err
+	}
+	if len(fields) < 14 {
+		return socketOrder{}, errors.New("short order array")
+	}
+	var order socketOrder
+	// CID is null on some notifications
+	json.Unmarshal(fields[2], &order.cid)
+	json.Unmarshal(fields[6], &order.amount)
+	json.Unmarshal(fields[7], &order.amountOrig)
+	json.Unmarshal(fields[13], &order.status)
+	if err := json.Unmarshal(fields[0], &order.id); err != nil {
+		return socketOrder{}, err
+	}
+	return order, nil
+}
+
+// Write a request if connected, registering reply under key in pending
+func (ows *orderSocket) write(request interface{}, pending map[int64]chan<- socketResult, key int64, reply chan<- socketResult) error {
+	ows.mutex.Lock()
+	conn := ows.conn
+	if conn == nil {
+		ows.mutex.Unlock()
+		return errSocketDown
+	}
+	pending[key] = reply
+	ows.mutex.Unlock()
+
+	ows.writeLock.Lock()
+	defer ows.writeLock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+	return conn.WriteJSON(request)
+}
+
+// Wait for a reply or the context, removing the pending request on return
+func (ows *orderSocket) wait(ctx context.Context, pending map[int64]chan<- socketResult, key int64, reply <-chan socketResult) (int64, error) {
+	select {
+	case result := <-reply:
+		return result.id, result.err
+	case <-ctx.Done():
+		ows.mutex.Lock()
+		delete(pending, key)
+		ows.mutex.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// Send a new order, returns errSocketDown if nothing was written
+func (ows *orderSocket) sendOrder(ctx context.Context, action, otype string, amount, price float64, postOnly bool) (int64, error) {
+	// Sells are negative amounts
+	if action == "sell" {
+		amount = -amount
+	}
+
+	// Client order IDs must be unique for the day
+	ows.mutex.Lock()
+	cid := time.Now().UnixNano() / int64(time.Millisecond)
+	if cid <= ows.lastCID {
+		cid = ows.lastCID + 1
+	}
+	ows.lastCID = cid
+	ows.mutex.Unlock()
+
+	order := map[string]interface{}{
+		"cid":    cid,
+		"type":   strings.ToUpper(otype),
+		"symbol": "t" + strings.ToUpper(ows.client.symbol+ows.client.currency),
+		"amount": strconv.FormatFloat(amount, 'f', -1, 64),
+		"price":  strconv.FormatFloat(price, 'f', -1, 64),
+	}
+	if postOnly {
+		order["flags"] = postOnlyFlag
+	}
+	request := []interface{}{0, "on", nil, order}
+	reply := make(chan socketResult, 1)
+	if err := ows.write(request, ows.sends, cid, reply); err != nil {
+		if err == errSocketDown {
+			return 0, err
+		}
+		// The order may have been sent, so don't fall back
+		ows.mutex.Lock()
+		delete(ows.sends, cid)
+		ows.mutex.Unlock()
+		return 0, &exchange.Error{Kind: exchange.ErrUnknownOutcome, Err: err}
+	}
+	id, err := ows.wait(ctx, ows.sends, cid, reply)
+	if errors.Is(err, exchange.ErrUnknownOutcome) {
+		return ows.reconcile(ctx, cid, err)
+	}
+	if err != nil && err == ctx.Err() {
+		return 0, &exchange.Error{Kind: exchange.ErrUnknownOutcome, Err: fmt.Errorf("no reply to the order: %w", err)}
+	}
+	return id, err
+}
+
+// Find an order cut off by a disconnect in the open orders snapshot sent after reconnecting
+// Returns the order ID if it's resting on the exchange, otherwise err, as it may have filled or never arrived
+func (ows *orderSocket) reconcile(ctx context.Context, cid int64, err error) (int64, error) {
+	ows.mutex.Lock()
+	snapshot := ows.snapshot
+	ows.mutex.Unlock()
+	select {
+	case <-snapshot:
+	case <-ctx.Done():
+	}
+
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+	id := ows.unknown[cid]
+	delete(ows.unknown, cid)
+	if id == 0 {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Cancel an order, returns errSocketDown if nothing was written
+func (ows *orderSocket) cancelOrder(ctx context.Context, id int64) error {
+	request := []interface{}{0, "oc", nil, map[string]int64{"id": id}}
+	reply := make(chan socketResult, 1)
+	if err := ows.write(request, ows.cancels, id, reply); err != nil {
+		ows.mutex.Lock()
+		delete(ows.cancels, id)
+		ows.mutex.Unlock()
+		return err
+	}
+	_, err := ows.wait(ctx, ows.cancels, id, reply)
+	return err
+}
+
+// Returns the latest state of an order if connected and known
+// Dead orders are forgotten once reported, as they won't change
+func (ows *orderSocket) orderStatus(id int64) (exchange.Order, bool) {
+	ows.mutex.Lock()
+	defer ows.mutex.Unlock()
+	if ows.conn == nil {
+		return exchange.Order{}, false
+	}
+	order, ok := ows.orders[id]
+	if order.Status == "dead" {
+		delete(ows.orders, id)
+	}
+	return order, ok
+}
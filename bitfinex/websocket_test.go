@@ -0,0 +1,131 @@
+package bitfinex
+
+import (
+	"bitfx/exchange"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Test order state and replies from account channel messages
+func TestOrderSocketHandle(t *testing.T) {
+	ows := newOrderSocket(&Client{name: "Bitfinex(usd)"})
+	sendReply := make(chan socketResult, 1)
+	ows.sends[1001] = sendReply
+
+	// New order acknowledged
+	ows.handle([]byte(`[0,"on",[55,0,1001,"tBTCUSD",1,1,0.4,1,"LIMIT",null,null,null,0,"ACTIVE",null,null,250,0]]`))
+	if result := <-sendReply; result.err != nil || result.id != 55 {
+		t.Fatal("Send should be acknowledged with the order ID")
+	}
+	if order := ows.orders[55]; order.Status != "live" || notEqual(order.FilledAmount, .6) {
+		t.Fatal("Order should be live with 0.6 filled")
+	}
+
+	// Sells have negative amounts
+	ows.handle([]byte(`[0,"ou",[56,0,1002,"tBTCUSD",1,1,-0.5,-2,"LIMIT",null,null,null,0,"PARTIALLY FILLED @ 250(-1.5)",null,null,250,250]]`))
+	if order := ows.orders[56]; order.Status != "live" || notEqual(order.FilledAmount, 1.5) {
+		t.Fatal("Sell should be live with 1.5 filled")
+	}
+
+	// Cancel confirmed
+	cancelReply := make(chan socketResult, 1)
+	ows.cancels[55] = cancelReply
+	ows.handle([]byte(`[0,"oc",[55,0,1001,"tBTCUSD",1,1,0.4,1,"LIMIT",null,null,null,0,"CANCELED",null,null,250,0]]`))
+	if result := <-cancelReply; result.err != nil {
+		t.Fatal("Cancel should be confirmed")
+	}
+	if order := ows.orders[55]; order.Status != "dead" || notEqual(order.FilledAmount, .6) {
+		t.Fatal("Cancelled order should be dead with 0.6 filled")
+	}
+
+	// Rejected order
+	ows.sends[1003] = sendReply
+	ows.handle([]byte(`[0,"n",[1,"on-req",null,null,[null,null,1003,"tBTCUSD",null,null,1,1,"LIMIT",null,null,null,null,null,null,null,250,null],null,"ERROR","Invalid order: not enough balance"]]`))
+	if result := <-sendReply; !errors.Is(result.err, exchange.ErrInsufficientFunds) {
+		t.Fatalf("Rejected order should return an insufficient funds error, got %v", result.err)
+	}
+	ows.handle([]byte(`[0,"hb"]`))
+	ows.handle([]byte(`{"event":"info","version":2}`))
+
+	// Pending orders may have been sent, so fail with an unknown outcome on disconnect
+	ows.sends[1004] = sendReply
+	ows.disconnect(nil)
+	if result := <-sendReply; !errors.Is(result.err, exchange.ErrUnknownOutcome) || errors.Is(result.err, exchange.ErrConnection) {
+		t.Fatalf("Pending order should fail with an unknown outcome on disconnect, got %v", result.err)
+	}
+	if len(ows.orders) != 0 {
+		t.Fatal("Order state should be cleared on disconnect")
+	}
+}
+
+// Test that dead orders are forgotten once their status is reported
+func TestOrderSocketStatus(t *testing.T) {
+	ows := newOrderSocket(&Client{name: "Bitfinex(usd)"})
+	ows.conn = &websocket.Conn{}
+	ows.handle([]byte(`[0,"on",[55,0,1001,"tBTCUSD",1,1,0.4,1,"LIMIT",null,null,null,0,"ACTIVE",null,null,250,0]]`))
+	if order, ok := ows.orderStatus(55); !ok || order.Status != "live" {
+		t.Fatal("Live order should be reported")
+	}
+	if _, ok := ows.orders[55]; !ok {
+		t.Fatal("Live order should be kept after reporting")
+	}
+	ows.handle([]byte(`[0,"oc",[55,0,1001,"tBTCUSD",1,1,0,1,"LIMIT",null,null,null,0,"EXECUTED @ 250(1.0)",null,null,250,250]]`))
+	if order, ok := ows.orderStatus(55); !ok || order.Status != "dead" || notEqual(order.FilledAmount, 1) {
+		t.Fatal("Filled order should be reported dead with 1 filled")
+	}
+	if len(ows.orders) != 0 {
+		t.Fatal("Dead order should be forgotten after reporting")
+	}
+}
+
+// Test finding orders cut off by a disconnect in the snapshot sent on reconnecting
+func TestOrderSocketReconcile(t *testing.T) {
+	ows := newOrderSocket(&Client{name: "Bitfinex(usd)"})
+	// The snapshot of the first connection doesn't count
+	ows.handle([]byte(`[0,"os",[]]`))
+	for _, cid := range []int64{1001, 1002} {
+		ows.sends[cid] = make(chan socketResult, 1)
+	}
+	ows.disconnect(nil)
+	unknown := exchange.NewError(exchange.ErrUnknownOutcome, "WebSocket disconnected")
+
+	// Only 1001 reached the exchange and is still resting
+	go ows.handle([]byte(`[0,"os",[[60,0,1001,"tBTCUSD",1,1,1,1,"LIMIT",null,null,null,0,"ACTIVE",null,null,250,0]]]`))
+	if id, err := ows.reconcile(context.Background(), 1001, unknown); err != nil || id != 60 {
+		t.Fatalf("Expected order 60 found by client order ID, got %d and %v", id, err)
+	}
+	if id, err := ows.reconcile(context.Background(), 1002, unknown); err != unknown || id != 0 {
+		t.Fatalf("Expected the unknown outcome kept for an order not in the snapshot, got %d and %v", id, err)
+	}
+	if len(ows.unknown) != 0 {
+		t.Fatal("Reconciled orders should be forgotten")
+	}
+
+	// Gives up at the deadline without a snapshot
+	ows.sends[1003] = make(chan socketResult, 1)
+	ows.disconnect(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ows.reconcile(ctx, 1003, unknown); err != unknown {
+		t.Fatalf("Expected the unknown outcome at the deadline, got %v", err)
+	}
+}
+
+// Test falling back to REST while the socket is down
+func TestOrderSocketFallback(t *testing.T) {
+	server := testServer(200, `{"order_id":77}`)
+	defer server.Close()
+	client := Client{baseURL: server.URL}
+	client.orders = newOrderSocket(&client)
+	id, err := client.SendOrder(context.Background(), "buy", "limit", 1, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 77 {
+		t.Fatal("Order should be sent over REST")
+	}
+}
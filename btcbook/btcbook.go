@@ -6,14 +6,14 @@ import (
 	"bitfx/btcchina"
 	"bitfx/exchange"
 	"bitfx/forex"
+	"bitfx/terminal"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 )
 
 var (
-	btc = btcchina.New("", "", "btc", "cny", 0, 0, 0, 0)
+	btc *btcchina.Client
 	cny float64
 )
 
@@ -25,6 +25,9 @@ func main() {
 	}
 	log.SetOutput(logFile)
 	log.Println("Starting new run")
+	if btc, err = btcchina.New("", "", "btc", "cny", 0, 0, 0, 0); err != nil {
+		log.Fatal(err)
+	}
 	fxChan := make(chan forex.Quote)
 	fxDoneChan := make(chan bool, 1)
 	quote := forex.CommunicateFX("cny", fxChan, fxDoneChan)
@@ -34,7 +37,8 @@ func main() {
 	cny = quote.Price
 
 	bookChan := make(chan exchange.Book)
-	if book := btc.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book := btc.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		log.Fatal(book.Error)
 	}
 	inputChan := make(chan rune)
@@ -46,6 +50,7 @@ Loop:
 		case book := <-bookChan:
 			printBook(book)
 		case <-inputChan:
+			doneChan <- true
 			btc.Done()
 			fxDoneChan <- true
 			break Loop
@@ -63,7 +68,7 @@ func checkStdin(inputChan chan<- rune) {
 
 // Print book data from each exchange
 func printBook(book exchange.Book) {
-	clearScreen()
+	terminal.Clear()
 	if book.Error != nil {
 		log.Println(book.Error)
 	} else {
@@ -80,10 +85,3 @@ func printBook(book exchange.Book) {
 		fmt.Println("----------------------------")
 	}
 }
-
-// Clear the terminal between prints
-func clearScreen() {
-	c := exec.Command("clear")
-	c.Stdout = os.Stdout
-	c.Run()
-}
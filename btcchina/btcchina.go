@@ -4,17 +4,20 @@ package btcchina
 
 import (
 	"bitfx/exchange"
+	"bitfx/logger"
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,13 +25,36 @@ import (
 
 // Client contains all exchange information
 type Client struct {
-	key, secret, symbol, currency, websocketURL, restURL, name, market string
-	priority                                                           int
-	position, fee, maxPos, availShort, availFunds                      float64
-	currencyCode                                                       byte
-	done                                                               chan bool
+	key, secret, symbol, currency, websocketURL, restURL, dataURL, name, market string
+	priority, depth                                                             int
+	position, makerFee, takerFee, maxPos, availShort, availFunds                float64
+	currencyCode                                                                exchange.Currency
+	maxBackoff                                                                  time.Duration
+	clock                                                                       exchange.Clock // Paces reconnection attempts
+	httpClient                                                                  *http.Client
+	httpOnce                                                                    sync.Once // Creates httpClient on first use if not set
+	logger                                                                      *logger.Logger
+	feeMutex                                                                    sync.Mutex
+	orderLimit                                                                  exchange.RateLimiter // Limits orders and cancels per second
+	health                                                                      exchange.Health      // Books sent
+	latency                                                                     exchange.Latency     // Round trip of the last order request
+	serverClock                                                                 exchange.ServerClock // Exchange clock, for tonces
+	subscribeTimeout                                                            time.Duration        // Time after connecting for the first grouporder frame
+	connect                                                                     func() (socketConn, time.Duration, error)
+	done                                                                        chan bool
 }
 
+// Socket.IO connection, a *websocket.Conn outside of tests
+type socketConn interface {
+	ReadMessage() (int, []byte, error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// Consecutive connections without grouporder data before the feed is reported down
+const maxSubscribeFailures = 3
+
 // Exchange request format
 type request struct {
 	Method string        `json:"method"`
@@ -36,24 +62,78 @@ type request struct {
 	ID     int           `json:"id"`
 }
 
+// Option changes a Client setting before New validates it
+type Option func(*Client)
+
+// WithURLs replaces the Socket.IO, trade API, and market data URLs, for mock servers or proxies
+// The Socket.IO URL is for the polling handshake, and the WebSocket scheme is derived from it
+// Plain http URLs are allowed for local testing
+func WithURLs(websocketURL, restURL, dataURL string) Option {
+	return func(client *Client) {
+		client.websocketURL = websocketURL
+		client.restURL = restURL
+		client.dataURL = dataURL
+	}
+}
+
+// WithClock replaces the clock reconnection attempts are paced by, the system clock by default
+func WithClock(clock exchange.Clock) Option {
+	return func(client *Client) {
+		client.clock = clock
+	}
+}
+
 // New returns a pointer to a Client instance
-func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64) *Client {
-	return &Client{
+func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64, options ...Option) (*Client, error) {
+	client := &Client{
 		key:          key,
 		secret:       secret,
 		symbol:       symbol,
 		currency:     currency,
-		websocketURL: "websocket.btcchina.com/socket.io",
-		restURL:      "api.btcchina.com/api_trade_v1.php",
+		websocketURL: "https://websocket.btcchina.com/socket.io",
+		restURL:      "https://api.btcchina.com/api_trade_v1.php",
+		dataURL:      "https://data.btcchina.com/data",
 		priority:     priority,
-		fee:          fee,
+		depth:        5,
+		makerFee:     fee,
+		takerFee:     fee,
+		logger:       logger.Default(),
 		availShort:   availShort,
 		availFunds:   availFunds,
-		currencyCode: 1,
+		currencyCode: exchange.CNY,
 		name:         fmt.Sprintf("BTCChina(%s)", currency),
 		market:       strings.ToUpper(symbol + currency),
+		maxBackoff:   60 * time.Second,
+		clock:        exchange.SystemClock{},
 		done:         make(chan bool, 1),
 	}
+	client.subscribeTimeout = 10 * time.Second
+	client.connect = func() (socketConn, time.Duration, error) {
+		return client.connectSocketIO()
+	}
+	for _, option := range options {
+		option(client)
+	}
+	if err := client.validate(); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", client, err)
+	}
+	return client, nil
+}
+
+// Check constructor arguments
+func (client *Client) validate() error {
+	if err := exchange.CheckCurrency(client.currency, "cny"); err != nil {
+		return err
+	}
+	if err := exchange.CheckKeys(client.key, client.secret); err != nil {
+		return err
+	}
+	for _, u := range []string{client.websocketURL, client.restURL, client.dataURL} {
+		if err := exchange.CheckURL(u, "https", "http"); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Done closes all connections
@@ -71,9 +151,54 @@ func (client *Client) Priority() int {
 	return client.priority
 }
 
-// Fee returns the exchange order fee
-func (client *Client) Fee() float64 {
-	return client.fee
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, safe to call while books are filtered
+func (client *Client) SetFees(maker, taker float64) {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetDepth sets the maximum number of book levels used from the exchange
+// Only a depth of 5 is available from the exchange
+func (client *Client) SetDepth(depth int) {
+	client.depth = depth
+}
+
+// SetMaxBackoff sets the maximum delay between reconnection attempts
+func (client *Client) SetMaxBackoff(maxBackoff time.Duration) {
+	client.maxBackoff = maxBackoff
+}
+
+// SetHTTPTimeout sets the time limit for HTTP requests
+func (client *Client) SetHTTPTimeout(timeout time.Duration) {
+	client.getHTTPClient().Timeout = timeout
+}
+
+// Default time limit for HTTP requests
+const httpTimeout = 10 * time.Second
+
+// Return the client for HTTP requests, created with httpTimeout on first use if not set
+func (client *Client) getHTTPClient() *http.Client {
+	client.httpOnce.Do(func() {
+		if client.httpClient == nil {
+			client.httpClient = &http.Client{Timeout: httpTimeout}
+		}
+	})
+	return client.httpClient
 }
 
 // SetPosition sets the exchange position
@@ -92,7 +217,7 @@ func (client *Client) Currency() string {
 }
 
 // CurrencyCode returns the exchange currency code
-func (client *Client) CurrencyCode() byte {
+func (client *Client) CurrencyCode() exchange.Currency {
 	return client.currencyCode
 }
 
@@ -116,28 +241,69 @@ func (client *Client) AvailShort() float64 {
 	return client.availShort
 }
 
-// HasCrytpoFee returns true if fee is taken in cryptocurrency on buys
-func (client *Client) HasCryptoFee() bool {
-	return false
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// LastLatency returns the round trip of the last order request, zero if none has been sent
+func (client *Client) LastLatency() time.Duration {
+	return client.latency.Last()
+}
+
+// SyncClock measures and applies the exchange clock's offset from the local clock, returning it
+func (client *Client) SyncClock(ctx context.Context) (time.Duration, error) {
+	url := fmt.Sprintf("%s/ticker?market=%s%s", client.dataURL, client.symbol, client.currency)
+	offset, err := client.serverClock.Sync(ctx, client.getHTTPClient(), url)
+	if err != nil {
+		return 0, fmt.Errorf("%s SyncClock error: %s", client, err)
+	}
+	return offset, nil
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity available for short selling
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill, fees are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	return filled
 }
 
 // CommunicateBook sends the latest available book data on the supplied channel
-func (client *Client) CommunicateBook(bookChan chan<- exchange.Book) exchange.Book {
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
 	// Connect to Socket.IO
-	ws, pingInterval, err := client.connectSocketIO()
+	ws, pingInterval, err := client.connect()
 	if err != nil {
 		return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: %s", client, err)}
 	}
 
-	// Get an initial book to return
+	// Get an initial book to return, which must come from the subscription
+	ws.SetReadDeadline(time.Now().Add(client.subscribeTimeout))
 	_, data, err := ws.ReadMessage()
 	if err != nil {
+		ws.Close()
 		return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: %s", client, err)}
 	}
+	if !isGroupOrder(data) {
+		ws.Close()
+		return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: expected grouporder data, got %.50q", client, data)}
+	}
 	book := client.convertToBook(data)
 
 	// Run a read loop in new goroutine
-	go client.runLoop(ws, pingInterval, bookChan)
+	go client.runLoop(ws, pingInterval, bookChan, doneChan)
 
 	return book
 }
@@ -145,8 +311,8 @@ func (client *Client) CommunicateBook(bookChan chan<- exchange.Book) exchange.Bo
 // Connect to Socket.IO
 func (client *Client) connectSocketIO() (*websocket.Conn, time.Duration, error) {
 	// Socket.IO handshake
-	getURL := fmt.Sprintf("https://%s/?transport=polling", client.websocketURL)
-	resp, err := http.Get(getURL)
+	getURL := fmt.Sprintf("%s/?transport=polling", client.websocketURL)
+	resp, err := client.getHTTPClient().Get(getURL)
 	if err != nil {
 		return nil, time.Duration(0), err
 	}
@@ -171,7 +337,8 @@ func (client *Client) connectSocketIO() (*websocket.Conn, time.Duration, error)
 		}
 		return nil, time.Duration(0), fmt.Errorf("WebSocket upgrade not available")
 	}
-	wsURL := fmt.Sprintf("wss://%s/?transport=websocket&sid=%s", client.websocketURL, session.Sid)
+	// wss for https and ws for http
+	wsURL := fmt.Sprintf("ws%s/?transport=websocket&sid=%s", strings.TrimPrefix(client.websocketURL, "http"), session.Sid)
 	ws, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{})
 	if err != nil {
 		return nil, time.Duration(0), err
@@ -199,10 +366,17 @@ func (client *Client) connectSocketIO() (*websocket.Conn, time.Duration, error)
 	return ws, time.Duration(session.PingInterval) * time.Millisecond, nil
 }
 
-// Websocket read loop
-func (client *Client) runLoop(ws *websocket.Conn, pingInterval time.Duration, bookChan chan<- exchange.Book) {
-	// Syncronize access to *websocket.Conn
-	receiveWS := make(chan *websocket.Conn)
+// Whether a Socket.IO message is a grouporder event
+func isGroupOrder(data []byte) bool {
+	return strings.HasPrefix(string(data), `42["grouporder"`)
+}
+
+// Websocket read loop, ws having already sent grouporder data
+// Reconnects if a new connection sends no grouporder data within subscribeTimeout,
+// sending a book with an error once maxSubscribeFailures connections in a row have failed
+func (client *Client) runLoop(ws socketConn, pingInterval time.Duration, bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	// Syncronize access to the connection
+	receiveWS := make(chan socketConn)
 	reconnectWS := make(chan bool)
 	closeWS := make(chan bool)
 	go func() {
@@ -214,14 +388,14 @@ func (client *Client) runLoop(ws *websocket.Conn, pingInterval time.Duration, bo
 			// Request to reconnect websocket
 			case <-reconnectWS:
 				ws.Close()
-				var err error
-				ws, _, err = client.connectSocketIO()
-				// Keep trying on error
-				for err != nil {
-					log.Printf("%s WebSocket error: %s", client, err)
-					time.Sleep(1 * time.Second)
-					ws, _, err = client.connectSocketIO()
-				}
+				// Keep trying on error with increasing delay
+				backoff := exchange.Backoff{Min: time.Second, Max: client.maxBackoff, Sleep: exchange.SleepOn(client.clock)}
+				backoff.Retry(func() (err error) {
+					ws, _, err = client.connect()
+					return err
+				}, func(err error) {
+					client.logger.Warnf("%s WebSocket error: %s", client, err)
+				})
 			// Request to close websocket
 			case <-closeWS:
 				ws.Close()
@@ -232,18 +406,40 @@ func (client *Client) runLoop(ws *websocket.Conn, pingInterval time.Duration, bo
 
 	// Read from websocket
 	dataChan := make(chan []byte)
+	errChan := make(chan error)
 	go func() {
+		// Connection being read, whether it has sent grouporder data, and when it was first read
+		current, subscribed, connected := <-receiveWS, true, time.Now()
+		failures := 0
 		for {
-			(<-receiveWS).SetReadDeadline(time.Now().Add(pingInterval + time.Second))
-			_, data, err := (<-receiveWS).ReadMessage()
+			conn := <-receiveWS
+			if conn != current {
+				current, subscribed, connected = conn, false, time.Now()
+			}
+			conn.SetReadDeadline(time.Now().Add(pingInterval + time.Second))
+			_, data, err := conn.ReadMessage()
 			if err != nil {
-				// Reconnect on error
-				log.Printf("%s WebSocket error: %s", client, err)
-				reconnectWS <- true
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
 			} else if string(data) != "3" {
+				if isGroupOrder(data) && !subscribed {
+					subscribed, failures = true, 0
+				}
 				// If not a pong, send for processing
 				dataChan <- data
 			}
+			if !subscribed && (err != nil || time.Since(connected) >= client.subscribeTimeout) {
+				// Count connections ending without grouporder data, reporting persistent failures
+				failures++
+				err = fmt.Errorf("%s book error: no grouporder data from %d connections in a row", client, failures)
+				client.logger.Warnf("%s", err)
+				if failures >= maxSubscribeFailures {
+					errChan <- err
+				}
+			} else if err == nil {
+				continue
+			}
+			// Reconnect on error
+			reconnectWS <- true
 		}
 	}()
 
@@ -253,6 +449,11 @@ func (client *Client) runLoop(ws *websocket.Conn, pingInterval time.Duration, bo
 
 	for {
 		select {
+		case <-doneChan:
+			// End if notified
+			ticker.Stop()
+			closeWS <- true
+			return
 		case <-client.done:
 			// End if notified
 			ticker.Stop()
@@ -262,12 +463,31 @@ func (client *Client) runLoop(ws *websocket.Conn, pingInterval time.Duration, bo
 			// Send Socket.IO ping
 			if err := (<-receiveWS).WriteMessage(1, ping); err != nil {
 				// Reconnect on error
-				log.Printf("%s WebSocket error: %s", client, err)
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
 				reconnectWS <- true
 			}
+		case err := <-errChan:
+			// Send out subscription failures so the feed is dropped
+			book := exchange.Book{Exg: client, Time: time.Now(), Error: err}
+			select {
+			case bookChan <- book:
+				client.health.Update(book)
+			case <-doneChan:
+				ticker.Stop()
+				closeWS <- true
+				return
+			}
 		case data := <-dataChan:
 			// Process data and send out to user
-			bookChan <- client.convertToBook(data)
+			book := client.convertToBook(data)
+			select {
+			case bookChan <- book:
+				client.health.Update(book)
+			case <-doneChan:
+				ticker.Stop()
+				closeWS <- true
+				return
+			}
 		}
 	}
 }
@@ -294,13 +514,23 @@ func (client *Client) convertToBook(data []byte) exchange.Book {
 		return exchange.Book{Error: fmt.Errorf("%s book error: %s", client, err)}
 	}
 
+	// Depth is bounded by what the exchange returned
+	numBids, numAsks := client.depth, client.depth
+	if len(response.GroupOrder.Bid) < numBids {
+		numBids = len(response.GroupOrder.Bid)
+	}
+	if len(response.GroupOrder.Ask) < numAsks {
+		numAsks = len(response.GroupOrder.Ask)
+	}
+
 	// Translate into exchange.Book structure
-	bids := make(exchange.BidItems, 5)
-	asks := make(exchange.AskItems, 5)
-	// Only depth of 5 is available
-	for i := 0; i < 5; i++ {
+	bids := make(exchange.BidItems, numBids)
+	asks := make(exchange.AskItems, numAsks)
+	for i := 0; i < numBids; i++ {
 		bids[i].Price = response.GroupOrder.Bid[i].Price
 		bids[i].Amount = response.GroupOrder.Bid[i].TotalAmount
+	}
+	for i := 0; i < numAsks; i++ {
 		asks[i].Price = response.GroupOrder.Ask[i].Price
 		asks[i].Amount = response.GroupOrder.Ask[i].TotalAmount
 	}
@@ -317,8 +547,68 @@ func (client *Client) convertToBook(data []byte) exchange.Book {
 	}
 }
 
+// Ticker returns the latest ticker data
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	// Send GET request
+	url := fmt.Sprintf("%s/ticker?market=%s%s", client.dataURL, client.symbol, client.currency)
+	data, err := client.get(context.Background(), url)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+
+	// Unmarshal
+	var response struct {
+		Ticker struct {
+			Buy  float64 `json:"buy,string"`
+			Sell float64 `json:"sell,string"`
+			Last float64 `json:"last,string"`
+			Vol  float64 `json:"vol,string"`
+			Date int64   `json:"date"`
+		} `json:"ticker"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+
+	return exchange.Ticker{
+		Last:   response.Ticker.Last,
+		Bid:    response.Ticker.Buy,
+		Ask:    response.Ticker.Sell,
+		Volume: response.Ticker.Vol,
+		Time:   time.Unix(response.Ticker.Date, 0),
+	}, nil
+}
+
+// MinOrderSize returns the smallest order amount the exchange accepts
+func (client *Client) MinOrderSize() float64 {
+	return .001
+}
+
+// RoundOrder rounds an order to what the exchange accepts
+// Prices have 2 decimals and amounts 4 decimals
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, .0001), exchange.RoundNearest(price, .01)
+}
+
+// PriceStep returns the tick size of .01
+func (client *Client) PriceStep(price float64) float64 {
+	return .01
+}
+
+// SetRateLimit limits orders and cancels sent per second, zero for no limit
+// Requests over the limit wait for their turn or their context
+func (client *Client) SetRateLimit(perSecond float64) {
+	client.orderLimit.SetRate(perSecond)
+}
+
 // SendOrder sends an order to the exchange
-func (client *Client) SendOrder(action, otype string, amount, price float64) (int64, error) {
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: rate limited, %s", client, err)
+	}
 	// Set method
 	var method string
 	if action == "buy" {
@@ -330,6 +620,9 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 	}
 
 	// Check order type
+	if otype == "post_only" {
+		return 0, fmt.Errorf("%s SendOrder error: post-only orders not supported", client)
+	}
 	if otype != "limit" {
 		return 0, fmt.Errorf("%s SendOrder error: only limit orders supported", client)
 	}
@@ -341,10 +634,11 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 	paramString := strings.Join([]string{strPrice, strAmount, client.market}, ",")
 
 	// Send POST
+	defer client.latency.Observe(time.Now())
 	req := request{method, params, 1}
-	data, err := client.post(method, paramString, req)
+	data, err := client.post(ctx, method, paramString, req)
 	if err != nil {
-		return 0, fmt.Errorf("%s SendOrder error: %s", client, err)
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.SentError(err))
 	}
 
 	// Unmarshal
@@ -359,24 +653,28 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 		return 0, fmt.Errorf("%s SendOrder error: %s", client, err)
 	}
 	if response.Error.Message != "" {
-		return 0, fmt.Errorf("%s SendOrder error code %d: %s", client, response.Error.Code, response.Error.Message)
+		return 0, fmt.Errorf("%s SendOrder error code %d: %w", client, response.Error.Code, codeError(response.Error.Code, response.Error.Message))
 	}
 
 	return response.Result, nil
 }
 
 // CancelOrder cancels an order on the exchange
-func (client *Client) CancelOrder(id int64) (bool, error) {
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: rate limited, %s", client, err)
+	}
 	// Set params
 	method := "cancelOrder"
 	params := []interface{}{id, client.market}
 	paramString := strconv.FormatInt(id, 10) + "," + client.market
 
 	// Send POST
+	defer client.latency.Observe(time.Now())
 	req := request{method, params, 1}
-	data, err := client.post(method, paramString, req)
+	data, err := client.post(ctx, method, paramString, req)
 	if err != nil {
-		return false, fmt.Errorf("%s CancelOrder error: %s", client, err)
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
 	}
 
 	// Unmarshal
@@ -391,24 +689,25 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 		return false, fmt.Errorf("%s CancelOrder error: %s", client, err)
 	}
 	if response.Error.Message != "" {
-		return false, fmt.Errorf("%s CancelOrder error code %d: %s", client, response.Error.Code, response.Error.Message)
+		return false, fmt.Errorf("%s CancelOrder error code %d: %w", client, response.Error.Code, codeError(response.Error.Code, response.Error.Message))
 	}
 
 	return response.Result, nil
 }
 
 // GetOrderStatus gets the status of an order on the exchange
-func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
 	// Set params
 	method := "getOrder"
 	params := []interface{}{id, client.market}
 	paramString := strconv.FormatInt(id, 10) + "," + client.market
 
 	// Send POST
+	defer client.latency.Observe(time.Now())
 	req := request{method, params, 1}
-	data, err := client.post(method, paramString, req)
+	data, err := client.post(ctx, method, paramString, req)
 	if err != nil {
-		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: %s", client, err)
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: %w", client, err)
 	}
 
 	// Unmarshal
@@ -426,10 +725,10 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 		}
 	}
 	if err := json.Unmarshal(data, &response); err != nil {
-		return exchange.Order{}, fmt.Errorf("%s CancelOrder error: %s", client, err)
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: %s", client, err)
 	}
 	if response.Error.Message != "" {
-		return exchange.Order{}, fmt.Errorf("%s CancelOrder error code %d: %s", client, response.Error.Code, response.Error.Message)
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error code %d: %w", client, response.Error.Code, codeError(response.Error.Code, response.Error.Message))
 	}
 
 	// Status from exchange can be "pending", "open", "cancelled", or "closed"
@@ -446,15 +745,143 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	return exchange.Order{FilledAmount: filled, Status: status}, nil
 }
 
+// OpenOrders returns all live orders for the symbol and currency in use
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	// Set params, booleans are signed as 1 or empty
+	method := "getOrders"
+	params := []interface{}{true, client.market}
+	paramString := "1," + client.market
+
+	// Send POST
+	req := request{method, params, 1}
+	data, err := client.post(ctx, method, paramString, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %w", client, err)
+	}
+
+	// Unmarshal
+	var response struct {
+		Result struct {
+			Order []struct {
+				ID         int64
+				Type       string
+				Status     string
+				Price      float64 `json:"price,string"`
+				Amount     float64 `json:"amount,string"`
+				OrigAmount float64 `json:"amount_original,string"`
+			}
+		}
+		Error struct {
+			Code    int
+			Message string
+		}
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, err)
+	}
+	if response.Error.Message != "" {
+		return nil, fmt.Errorf("%s OpenOrders error code %d: %w", client, response.Error.Code, codeError(response.Error.Code, response.Error.Message))
+	}
+
+	// Type from exchange is "bid" or "ask"
+	var orders []exchange.Order
+	for _, order := range response.Result.Order {
+		if order.Status == "open" {
+			side := "buy"
+			if order.Type == "ask" {
+				side = "sell"
+			}
+			orders = append(orders, exchange.Order{
+				ID:           order.ID,
+				Side:         side,
+				Price:        order.Price,
+				Amount:       order.OrigAmount,
+				FilledAmount: order.OrigAmount - order.Amount,
+				Status:       "live",
+			})
+		}
+	}
+
+	return orders, nil
+}
+
+// Balances returns the available fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	// Set params
+	method := "getAccountInfo"
+	params := []interface{}{}
+	paramString := ""
+
+	// Send POST
+	req := request{method, params, 1}
+	data, err := client.post(context.Background(), method, paramString, req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err)
+	}
+
+	// Unmarshal
+	var response struct {
+		Result struct {
+			Balance map[string]struct {
+				Amount float64 `json:"amount,string"`
+			}
+		}
+		Error struct {
+			Code    int
+			Message string
+		}
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err)
+	}
+	if response.Error.Message != "" {
+		return 0, 0, fmt.Errorf("%s Balances error code %d: %w", client, response.Error.Code, codeError(response.Error.Code, response.Error.Message))
+	}
+
+	fiat := response.Result.Balance[client.currency].Amount
+	crypto := response.Result.Balance[client.symbol].Amount
+
+	return fiat, crypto, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort is a flat position
+func (client *Client) ActualPosition() (float64, error) {
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort, nil
+}
+
+// Tonce for a signed request, microseconds on the exchange clock
+func (client *Client) tonce() string {
+	return strconv.FormatInt(client.serverClock.Now().UnixNano()/1000, 10)
+}
+
+// Error for a BTCChina JSON-RPC error, of a known kind where the code has one
+func codeError(code int, message string) error {
+	switch code {
+	case -32003, -32004:
+		// Insufficient CNY balance, insufficient BTC balance
+		return exchange.NewError(exchange.ErrInsufficientFunds, message)
+	case -32008, -32017, -32018, -32019, -32087, -32088, -32089:
+		// Invalid amount, type, price, parameter, market order amount, price precision, amount precision
+		return exchange.NewError(exchange.ErrOrderRejected, message)
+	}
+	return errors.New(message)
+}
+
 // Authenticated POST
-func (client *Client) post(method, params string, payload interface{}) ([]byte, error) {
+func (client *Client) post(ctx context.Context, method, params string, payload interface{}) ([]byte, error) {
 	// Create signature to be signed
-	tonce := strconv.FormatInt(time.Now().UnixNano()/1000, 10)
+	tonce := client.tonce()
 	signature := fmt.Sprintf("tonce=%s&accesskey=%s&requestmethod=post&id=1&method=%s&params=%s",
 		tonce, client.key, method, params)
 	// Perform HMAC on signature using client.secret
 	h := hmac.New(sha1.New, []byte(client.secret))
 	h.Write([]byte(signature))
+	hash := fmt.Sprintf("%x", h.Sum(nil))
 
 	// Marshal payload
 	body, err := json.Marshal(payload)
@@ -462,25 +889,43 @@ func (client *Client) post(method, params string, payload interface{}) ([]byte,
 		return []byte{}, err
 	}
 	// Create http request using specified url
-	url := fmt.Sprintf("https://%s:%x@%s", client.key, h.Sum(nil), client.restURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", client.restURL, bytes.NewBuffer(body))
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, exchange.Redact(err, client.key, client.secret, hash)
 	}
 
+	// Key and hash as basic auth in a header rather than the URL, where they would show in request errors
+	req.SetBasicAuth(client.key, hash)
 	// Add tonce header
 	req.Header.Add("Json-Rpc-Tonce", tonce)
 
 	// Send POST
-	httpClient := http.Client{}
-	resp, err := httpClient.Do(req)
+	resp, err := client.getHTTPClient().Do(req)
 	if err != nil {
+		return []byte{}, exchange.ConnectionError(exchange.Redact(err, client.key, client.secret, hash))
+	}
+	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
 		return []byte{}, err
 	}
-	if resp.StatusCode != 200 {
-		return []byte{}, fmt.Errorf(resp.Status)
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Unauthenticated GET
+func (client *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	resp, err := client.getHTTPClient().Do(req)
+	if err != nil {
+		return []byte{}, exchange.ConnectionError(err)
 	}
 	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
+		return []byte{}, err
+	}
 
 	return ioutil.ReadAll(resp.Body)
 }
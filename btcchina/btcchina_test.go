@@ -2,16 +2,72 @@ package btcchina
 
 import (
 	"bitfx/exchange"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
 var (
 	book   exchange.Book
-	client = New(os.Getenv("BTC_KEY"), os.Getenv("BTC_SECRET"), "btc", "cny", 1, 0.002, 2, .1)
+	client = mustNew(New(os.Getenv("BTC_KEY"), os.Getenv("BTC_SECRET"), "btc", "cny", 1, 0.002, 2, .1))
 )
 
+// Returns the client, panicking on constructor errors
+func mustNew(client *Client, err error) *Client {
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Test constructor argument errors
+func TestNew(t *testing.T) {
+	if _, err := New("", "", "btc", "usd", 1, 0, 0, 0); err == nil || err.Error() != "BTCChina(usd) New error: currency must be CNY" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+	if _, err := New("", "secret", "btc", "cny", 1, 0, 0, 0); err == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+	if _, err := New("", "", "btc", "cny", 1, 0, 0, 0, WithURLs("https://ws.example.com", "api.example.com", "https://data.example.com")); err == nil {
+		t.Fatal("Expected error for URL without a scheme")
+	}
+}
+
+// Test that REST requests go to overridden URLs
+func TestWithURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/ticker" || r.URL.Query().Get("market") != "btccny" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"ticker":{"buy":"1630.10","sell":"1630.50","last":"1630.20","vol":"12.5","date":1400000000}}`))
+	}))
+	defer server.Close()
+	client := mustNew(New("", "", "btc", "cny", 1, 0, 0, 0, WithURLs(server.URL+"/socket.io", server.URL+"/api", server.URL+"/data")))
+	ticker, err := client.Ticker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(ticker.Bid, 1630.1) || notEqual(ticker.Ask, 1630.5) || notEqual(ticker.Last, 1630.2) {
+		t.Fatalf("Wrong ticker: %+v", ticker)
+	}
+}
+
 // Used for float equality
 func notEqual(f1, f2 float64) bool {
 	if math.Abs(f1-f2) > 0.000001 {
@@ -20,6 +76,13 @@ func notEqual(f1, f2 float64) bool {
 	return false
 }
 
+func TestRoundOrder(t *testing.T) {
+	amount, price := client.RoundOrder(0.123456, 1650.126)
+	if amount != 0.1234 || price != 1650.13 {
+		t.Fatalf("Expected 0.1234 at 1650.13, got %v at %v", amount, price)
+	}
+}
+
 func TestPriority(t *testing.T) {
 	if client.Priority() != 1 {
 		t.Fatal("Priority should be 1")
@@ -27,8 +90,9 @@ func TestPriority(t *testing.T) {
 }
 
 func TestFee(t *testing.T) {
-	if notEqual(client.Fee(), 0.002) {
-		t.Fatal("Fee should be 0.002")
+	// Both fees default to the fee supplied to New
+	if notEqual(client.TakerFee(), 0.002) || notEqual(client.MakerFee(), 0.002) {
+		t.Fatal("Maker and taker fees should be 0.002")
 	}
 }
 
@@ -76,9 +140,281 @@ func TestAvailShort(t *testing.T) {
 	}
 }
 
-func TestHasCryptoFee(t *testing.T) {
-	if client.HasCryptoFee() {
-		t.Fatal("Should not have cryptocurrency fee")
+func TestFeeAdjustedFill(t *testing.T) {
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2) || notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Fees in fiat should leave fills unchanged")
+	}
+}
+
+func TestTonce(t *testing.T) {
+	client := &Client{}
+	client.serverClock.SetOffset(-time.Hour)
+	tonce, err := strconv.ParseInt(client.tonce(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Microseconds on an exchange clock an hour behind
+	if behind := time.Since(time.Unix(0, tonce*1000)); behind < 59*time.Minute || behind > 61*time.Minute {
+		t.Fatalf("Tonce should follow the exchange clock, got %s behind", behind)
+	}
+}
+
+func TestPostCredentials(t *testing.T) {
+	var user, pass string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		w.Write([]byte(`{"result":true}`))
+	}))
+	client := &Client{key: "testkey", secret: "testsecret", restURL: server.URL, httpClient: server.Client()}
+
+	// Key and hash are sent as basic auth
+	if _, err := client.post(context.Background(), "getAccountInfo", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if user != "testkey" || len(pass) != 40 {
+		t.Fatalf("Expected key and SHA1 hash as basic auth, got %q and %q", user, pass)
+	}
+
+	// A forced request error doesn't show the credentials
+	server.Close()
+	_, err := client.post(context.Background(), "getAccountInfo", "", nil)
+	if err == nil {
+		t.Fatal("Expected error from closed server")
+	}
+	if strings.Contains(err.Error(), "testkey") || strings.Contains(err.Error(), "testsecret") || strings.Contains(err.Error(), pass) {
+		t.Fatalf("Credentials in error: %s", err)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte(`{"result":true}`))
+	}))
+	defer server.Close()
+	client := Client{restURL: server.URL}
+	client.SetHTTPTimeout(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := client.post(context.Background(), "getAccountInfo", "", nil); err == nil {
+		t.Fatal("Expected error after timeout")
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Fatal("Should return at the timeout")
+	}
+}
+
+func TestConvertToBookShort(t *testing.T) {
+	data := []byte(`42["grouporder",{"grouporder":{"bid":[{"price":1630.5,"totalamount":1.2},{"price":1630.1,"totalamount":0.5}],"ask":[{"price":1631,"totalamount":2}]}}]`)
+	book := client.convertToBook(data)
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(book.Bids) != 2 || len(book.Asks) != 1 {
+		t.Fatal("Should have returned only the available items")
+	}
+}
+
+// Connection replaying scripted messages, then answering pings with pongs only
+type stubConn struct {
+	messages [][]byte
+	pongs    chan bool
+}
+
+func newStubConn(messages ...string) *stubConn {
+	conn := &stubConn{pongs: make(chan bool, 10)}
+	for _, message := range messages {
+		var data []byte // Empty messages drop the connection
+		if message != "" {
+			data = []byte(message)
+		}
+		conn.messages = append(conn.messages, data)
+	}
+	return conn
+}
+
+func (conn *stubConn) ReadMessage() (int, []byte, error) {
+	if len(conn.messages) > 0 {
+		data := conn.messages[0]
+		conn.messages = conn.messages[1:]
+		if data == nil {
+			return 0, nil, errors.New("connection dropped")
+		}
+		return 1, data, nil
+	}
+	select {
+	case <-conn.pongs:
+		return 1, []byte("3"), nil
+	case <-time.After(time.Second):
+		return 0, nil, errors.New("read timeout")
+	}
+}
+
+func (conn *stubConn) WriteMessage(messageType int, data []byte) error {
+	if string(data) == "2" {
+		conn.pongs <- true
+	}
+	return nil
+}
+
+func (conn *stubConn) SetReadDeadline(t time.Time) error { return nil }
+func (conn *stubConn) Close() error                      { return nil }
+
+func TestSubscriptionFailure(t *testing.T) {
+	data := `42["grouporder",{"grouporder":{"bid":[{"price":1630.5,"totalamount":1.2}],"ask":[{"price":1631,"totalamount":2}]}}]`
+	client := mustNew(New("", "", "btc", "cny", 1, 0, 0, 0))
+	client.subscribeTimeout = 30 * time.Millisecond
+	var connects int32
+	client.connect = func() (socketConn, time.Duration, error) {
+		// The first connection drops after two books, later ones only pong
+		if atomic.AddInt32(&connects, 1) == 1 {
+			return newStubConn(data, data, ""), 10 * time.Millisecond, nil
+		}
+		return newStubConn(), 10 * time.Millisecond, nil
+	}
+
+	bookChan := make(chan exchange.Book)
+	doneChan := make(chan bool, 1)
+	defer func() { doneChan <- true }()
+	if book := client.CommunicateBook(bookChan, doneChan); book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if book := <-bookChan; book.Error != nil {
+		t.Fatal(book.Error)
+	}
+
+	// Reconnections without data are reported once they persist
+	select {
+	case book := <-bookChan:
+		if book.Error == nil || !strings.Contains(book.Error.Error(), "no grouporder data from 3 connections") {
+			t.Fatalf("Expected a subscription error, got %v", book)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a subscription error")
+	}
+	if client.Connected() {
+		t.Fatal("Should not be connected after subscription failures")
+	}
+	if n := atomic.LoadInt32(&connects); n < 4 {
+		t.Fatalf("Expected 3 reconnections, got %d", n-1)
+	}
+}
+
+// Returns a mock trade API answering with reply, and a client sending to it
+// Each request's signature is checked against the method and params expected
+func orderServer(t *testing.T, reply, method, params string) (*httptest.Server, *Client) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != method {
+			t.Errorf("Expected %s request, got %+v, %v", method, req, err)
+		}
+		// Signed string as documented, HMAC-SHA1 with the secret in hex
+		tonce := r.Header.Get("Json-Rpc-Tonce")
+		signed := fmt.Sprintf("tonce=%s&accesskey=testkey&requestmethod=post&id=1&method=%s&params=%s", tonce, method, params)
+		h := hmac.New(sha1.New, []byte("testsecret"))
+		h.Write([]byte(signed))
+		if user, pass, ok := r.BasicAuth(); !ok || user != "testkey" || pass != hex.EncodeToString(h.Sum(nil)) {
+			t.Errorf("Wrong signature for %q", signed)
+		}
+		w.Write([]byte(reply))
+	}))
+	client := mustNew(New("testkey", "testsecret", "btc", "cny", 1, 0, 0, 0, WithURLs(server.URL, server.URL, server.URL)))
+	return server, client
+}
+
+// Test order responses with a mock trade API
+func TestSendOrderMock(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		id          int64
+		err         string
+		kind        error
+	}{
+		{"placed", `{"result":12345,"id":"1"}`, 12345, "", nil},
+		{"error", `{"error":{"code":-32003,"message":"Insufficient CNY balance","id":"1"}}`, 0, "BTCChina(cny) SendOrder error code -32003: Insufficient CNY balance", exchange.ErrInsufficientFunds},
+		{"rejected", `{"error":{"code":-32018,"message":"Invalid price","id":"1"}}`, 0, "BTCChina(cny) SendOrder error code -32018: Invalid price", exchange.ErrOrderRejected},
+		{"unknown", `{"error":{"code":-32000,"message":"Internal error","id":"1"}}`, 0, "BTCChina(cny) SendOrder error code -32000: Internal error", nil},
+	}
+	for _, test := range tests {
+		server, client := orderServer(t, test.reply, "buyOrder2", "1630.10,0.5000,BTCCNY")
+		id, err := client.SendOrder(context.Background(), "buy", "limit", .5, 1630.1)
+		server.Close()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+		if test.kind != nil && !errors.Is(err, test.kind) || test.kind == nil && err != nil && (errors.Is(err, exchange.ErrInsufficientFunds) || errors.Is(err, exchange.ErrOrderRejected)) {
+			t.Errorf("%s: expected kind %v, got %v", test.name, test.kind, err)
+		}
+		if id != test.id {
+			t.Errorf("%s: expected ID %d, got %d", test.name, test.id, id)
+		}
+	}
+}
+
+// Test that invalid orders are rejected without a request
+func TestSendOrderInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Invalid order sent")
+	}))
+	defer server.Close()
+	client := mustNew(New("testkey", "testsecret", "btc", "cny", 1, 0, 0, 0, WithURLs(server.URL, server.URL, server.URL)))
+	for _, order := range [][2]float64{{0, 1630.1}, {-.5, 1630.1}, {.5, 0}} {
+		if _, err := client.SendOrder(context.Background(), "buy", "limit", order[0], order[1]); !errors.Is(err, exchange.ErrInvalidOrder) {
+			t.Errorf("Expected invalid order for %v, got %v", order, err)
+		}
+	}
+}
+
+func TestCancelOrderMock(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		success     bool
+		err         string
+	}{
+		{"cancelled", `{"result":true,"id":"1"}`, true, ""},
+		{"not cancelled", `{"result":false,"id":"1"}`, false, ""},
+		{"error", `{"error":{"code":-32025,"message":"Order already cancelled","id":"1"}}`, false, "BTCChina(cny) CancelOrder error code -32025: Order already cancelled"},
+	}
+	for _, test := range tests {
+		server, client := orderServer(t, test.reply, "cancelOrder", "12345,BTCCNY")
+		success, err := client.CancelOrder(context.Background(), 12345)
+		server.Close()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+		if success != test.success {
+			t.Errorf("%s: expected success %t, got %t", test.name, test.success, success)
+		}
+	}
+}
+
+func TestGetOrderStatusMock(t *testing.T) {
+	tests := []struct {
+		status   string // Exchange status
+		expected string
+	}{
+		{"pending", ""},
+		{"open", "live"},
+		{"cancelled", "dead"},
+		{"closed", "dead"},
+	}
+	for _, test := range tests {
+		reply := fmt.Sprintf(`{"result":{"order":{"id":12345,"type":"bid","price":"1630.10","currency":"CNY","amount":"0.2000","amount_original":"0.5000","date":1400000000,"status":"%s"}},"id":"1"}`, test.status)
+		server, client := orderServer(t, reply, "getOrder", "12345,BTCCNY")
+		order, err := client.GetOrderStatus(context.Background(), 12345)
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Filled is the original amount less the amount left
+		if order.Status != test.expected || notEqual(order.FilledAmount, .3) {
+			t.Errorf("Status %s: expected %q with .3 filled, got %q with %v filled", test.status, test.expected, order.Status, order.FilledAmount)
+		}
+	}
+
+	server, client := orderServer(t, `{"error":{"code":-32026,"message":"Order not found","id":"1"}}`, "getOrder", "12345,BTCCNY")
+	defer server.Close()
+	if _, err := client.GetOrderStatus(context.Background(), 12345); err == nil || err.Error() != "BTCChina(cny) GetOrderStatus error code -32026: Order not found" {
+		t.Errorf("Expected error object, got %v", err)
 	}
 }
 
@@ -87,7 +423,8 @@ func TestHasCryptoFee(t *testing.T) {
 
 func TestCommunicateBook(t *testing.T) {
 	bookChan := make(chan exchange.Book)
-	if book = client.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book = client.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		t.Fatal(book.Error)
 	}
 
@@ -112,7 +449,7 @@ func TestNewOrder(t *testing.T) {
 	price := book.Bids[0].Price - 10
 
 	// Test submitting a new order
-	id, err := client.SendOrder(action, otype, amount, price)
+	id, err := client.SendOrder(context.Background(), action, otype, amount, price)
 	if err != nil || id == 0 {
 		t.Fatal(err)
 	}
@@ -123,7 +460,7 @@ func TestNewOrder(t *testing.T) {
 	tryAgain := true
 	for tryAgain {
 		t.Logf("checking status...")
-		order, err = client.GetOrderStatus(id)
+		order, err = client.GetOrderStatus(context.Background(), id)
 		tryAgain = order.Status == ""
 	}
 	if err != nil {
@@ -139,7 +476,7 @@ func TestNewOrder(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test cancelling the order
-	success, err := client.CancelOrder(id)
+	success, err := client.CancelOrder(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,7 +489,7 @@ func TestNewOrder(t *testing.T) {
 	tryAgain = true
 	for tryAgain {
 		t.Logf("checking status...")
-		order, err = client.GetOrderStatus(id)
+		order, err = client.GetOrderStatus(context.Background(), id)
 		tryAgain = order.Status == ""
 	}
 	if err != nil {
@@ -165,7 +502,7 @@ func TestNewOrder(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test bad order
-	id, err = client.SendOrder("buy", otype, 0, price)
+	id, err = client.SendOrder(context.Background(), "buy", otype, 0, price)
 	if id != 0 {
 		t.Fatal("Expected id = 0")
 	}
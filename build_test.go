@@ -0,0 +1,67 @@
+// Build test compiling all library packages together under the bitfx import path
+
+package bitfx_test
+
+import (
+	"bitfx/bitfinex"
+	"bitfx/btcchina"
+	"bitfx/exchange"
+	"bitfx/forex"
+	"bitfx/gdax"
+	"bitfx/kraken"
+	"bitfx/okcoin"
+	"testing"
+)
+
+// Every exchange client must satisfy exchange.Interface
+var (
+	_ exchange.Interface = (*bitfinex.Client)(nil)
+	_ exchange.Interface = (*btcchina.Client)(nil)
+	_ exchange.Interface = (*gdax.Client)(nil)
+	_ exchange.Interface = (*kraken.Client)(nil)
+	_ exchange.Interface = (*okcoin.Client)(nil)
+)
+
+// Every live exchange client can limit its order rate
+var (
+	_ exchange.OrderRateLimiter = (*bitfinex.Client)(nil)
+	_ exchange.OrderRateLimiter = (*btcchina.Client)(nil)
+	_ exchange.OrderRateLimiter = (*gdax.Client)(nil)
+	_ exchange.OrderRateLimiter = (*kraken.Client)(nil)
+	_ exchange.OrderRateLimiter = (*okcoin.Client)(nil)
+)
+
+// Every live exchange client reports its book feed
+var (
+	_ exchange.HealthReporter = (*bitfinex.Client)(nil)
+	_ exchange.HealthReporter = (*btcchina.Client)(nil)
+	_ exchange.HealthReporter = (*gdax.Client)(nil)
+	_ exchange.HealthReporter = (*kraken.Client)(nil)
+	_ exchange.HealthReporter = (*okcoin.Client)(nil)
+)
+
+// Every live exchange client times its order requests
+var (
+	_ exchange.LatencyReporter = (*bitfinex.Client)(nil)
+	_ exchange.LatencyReporter = (*btcchina.Client)(nil)
+	_ exchange.LatencyReporter = (*gdax.Client)(nil)
+	_ exchange.LatencyReporter = (*kraken.Client)(nil)
+	_ exchange.LatencyReporter = (*okcoin.Client)(nil)
+)
+
+// Exchanges signing with timestamps follow the exchange clock
+var (
+	_ exchange.ClockSyncer = (*bitfinex.Client)(nil)
+	_ exchange.ClockSyncer = (*btcchina.Client)(nil)
+)
+
+// OKCoin can borrow for short sales
+var _ exchange.Borrower = (*okcoin.Client)(nil)
+
+func TestBuild(t *testing.T) {
+	// Reference forex so a broken import path fails here too
+	var quote forex.Quote
+	if quote.Error != nil {
+		t.Fatal("Zero value quote should have no error")
+	}
+}
@@ -0,0 +1,51 @@
+// Reconnection backoff shared by exchange clients
+
+package exchange
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes capped exponential delays with jitter between retries
+type Backoff struct {
+	Min, Max time.Duration       // Delay bounds, Max of zero means no cap
+	Sleep    func(time.Duration) // Waits between tries, time.Sleep if nil
+	attempt  uint                // Consecutive failures since last success
+}
+
+// Next returns the delay before the next try and increments the attempt count
+// Delay doubles on each attempt and is randomized to between half and all of that value
+func (b *Backoff) Next() time.Duration {
+	delay := b.Min << b.attempt
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	} else if delay <= math.MaxInt64/2 {
+		// Doubling stops short of overflow, which caps the delay when Max is zero
+		b.attempt++
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Reset returns to the minimum delay, called after a success
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Retry calls connect until it succeeds, waiting Next() between failures
+// onError is called with each failure
+func (b *Backoff) Retry(connect func() error, onError func(error)) {
+	sleep := b.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	for err := connect(); err != nil; err = connect() {
+		onError(err)
+		sleep(b.Next())
+	}
+	b.Reset()
+}
@@ -0,0 +1,63 @@
+package exchange
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffRetry(t *testing.T) {
+	var delays []time.Duration
+	b := Backoff{
+		Min:   time.Second,
+		Max:   8 * time.Second,
+		Sleep: func(d time.Duration) { delays = append(delays, d) },
+	}
+
+	// Fail 6 times then succeed
+	tries, failures := 0, 0
+	b.Retry(func() error {
+		tries++
+		if tries <= 6 {
+			return errors.New("dial error")
+		}
+		return nil
+	}, func(error) { failures++ })
+
+	if tries != 7 || failures != 6 || len(delays) != 6 {
+		t.Fatalf("Expected 7 tries and 6 delays, got %d tries and %d delays", tries, len(delays))
+	}
+	// Each delay is within [base/2, base] for base = 1s, 2s, 4s, 8s, 8s, 8s
+	bases := []time.Duration{1, 2, 4, 8, 8, 8}
+	for i, delay := range delays {
+		base := bases[i] * time.Second
+		if delay < base/2 || delay > base {
+			t.Errorf("Delay %d of %s outside of [%s, %s]", i, delay, base/2, base)
+		}
+	}
+
+	// Success resets the delay
+	if b.attempt != 0 {
+		t.Fatal("Attempts should reset after success")
+	}
+	if delay := b.Next(); delay > time.Second {
+		t.Fatal("Delay should reset after success")
+	}
+}
+
+func TestBackoffNoCap(t *testing.T) {
+	// Without a cap the delay keeps growing, and never wraps to zero or negative
+	b := Backoff{Min: time.Second}
+	last := time.Duration(0)
+	for i := 0; i < 100; i++ {
+		delay := b.Next()
+		if delay <= 0 || delay < last/2 {
+			t.Fatalf("Delay %d of %s after %s", i, delay, last)
+		}
+		last = delay
+	}
+	if last < time.Duration(math.MaxInt64/4) {
+		t.Fatalf("Delay should have grown without a cap, got %s", last)
+	}
+}
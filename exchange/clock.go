@@ -0,0 +1,231 @@
+// Clocks shared by exchange clients and the trading system
+// The server clock offset, and a clock that can be replaced by a manual one in tests and backtests
+
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerClock tracks the offset of an exchange's clock from the local clock
+// Used for nonces and timestamps checked against the exchange's time
+// The zero value has no offset, and is safe to use concurrently
+type ServerClock struct {
+	offset time.Duration // Exchange time less local time
+	mutex  sync.Mutex
+}
+
+// Now returns the current time on the exchange's clock
+func (clock *ServerClock) Now() time.Time {
+	return time.Now().Add(clock.Offset())
+}
+
+// Offset returns the exchange time less local time
+func (clock *ServerClock) Offset() time.Duration {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.offset
+}
+
+// SetOffset sets the exchange time less local time
+func (clock *ServerClock) SetOffset(offset time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.offset = offset
+}
+
+// Sync measures the offset from the Date header of a GET to url, returning it
+// The header has one second resolution, so offsets within a second are taken as none
+func (clock *ServerClock) Sync(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := time.Now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("bad Date header: %s", err)
+	}
+
+	// The server's time is somewhere in the second after the header, and the request halfway through the round trip
+	offset := date.Add(500 * time.Millisecond).Sub(sent.Add(received.Sub(sent) / 2))
+	if offset > -time.Second && offset < time.Second {
+		offset = 0
+	}
+	clock.SetOffset(offset)
+	return offset, nil
+}
+
+// Clock tells the time and makes tickers and timers, so time-dependent loops can be run on a ManualClock
+type Clock interface {
+	Now() time.Time
+	NewTicker(period time.Duration) ClockTicker
+	After(d time.Duration) <-chan time.Time
+}
+
+// ClockTicker sends the time on Chan every period until stopped
+// Ticks are dropped while the last is unread, as with time.Ticker
+type ClockTicker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// SleepOn returns a function pausing on clock, for Backoff.Sleep
+func SleepOn(clock Clock) func(time.Duration) {
+	return func(d time.Duration) {
+		<-clock.After(d)
+	}
+}
+
+// SystemClock is the local clock
+type SystemClock struct{}
+
+// Now returns the local time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTicker returns a time.Ticker
+func (SystemClock) NewTicker(period time.Duration) ClockTicker {
+	return systemTicker{time.NewTicker(period)}
+}
+
+// After returns time.After(d)
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Ticker from the local clock
+type systemTicker struct {
+	*time.Ticker
+}
+
+// Chan returns the channel ticks are sent on
+func (ticker systemTicker) Chan() <-chan time.Time {
+	return ticker.C
+}
+
+// ManualClock is a Clock whose time only moves when it is set
+// Used for tests, and for simulated time in backtests. Safe to use concurrently
+type ManualClock struct {
+	now     time.Time
+	tickers []*manualTicker
+	timers  []manualTimer // Pending After channels
+	mutex   sync.Mutex
+}
+
+// Channel from ManualClock.After and the time it's due
+type manualTimer struct {
+	c   chan time.Time
+	due time.Time
+}
+
+// NewManualClock returns a ManualClock set to now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the time the clock is set to
+func (clock *ManualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// NewTicker returns a ticker firing as the clock is moved past each period from now
+func (clock *ManualClock) NewTicker(period time.Duration) ClockTicker {
+	if period <= 0 {
+		panic("non-positive interval for ManualClock.NewTicker")
+	}
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	ticker := &manualTicker{c: make(chan time.Time, 1), period: period, next: clock.now.Add(period)}
+	clock.tickers = append(clock.tickers, ticker)
+	return ticker
+}
+
+// After returns a channel sent the time once the clock is moved d past now, at once if d isn't positive
+func (clock *ManualClock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- clock.now
+		return c
+	}
+	clock.timers = append(clock.timers, manualTimer{c: c, due: clock.now.Add(d)})
+	return c
+}
+
+// Set moves the clock to now, firing tickers and timers that are due
+// Returns now for chaining in tests
+func (clock *ManualClock) Set(now time.Time) time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = now
+	for _, ticker := range clock.tickers {
+		ticker.fire(now)
+	}
+	pending := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if now.Before(timer.due) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- now
+		}
+	}
+	clock.timers = pending
+	return now
+}
+
+// Advance moves the clock on by d, firing tickers that are due, and returns the new time
+func (clock *ManualClock) Advance(d time.Duration) time.Time {
+	return clock.Set(clock.Now().Add(d))
+}
+
+// Ticker from a ManualClock
+type manualTicker struct {
+	c       chan time.Time
+	period  time.Duration
+	next    time.Time // Time of the next tick
+	stopped bool
+	mutex   sync.Mutex
+}
+
+// Chan returns the channel ticks are sent on
+func (ticker *manualTicker) Chan() <-chan time.Time {
+	return ticker.c
+}
+
+// Stop turns off the ticker
+func (ticker *manualTicker) Stop() {
+	ticker.mutex.Lock()
+	defer ticker.mutex.Unlock()
+	ticker.stopped = true
+}
+
+// Send one tick if the next is due at now, skipping any others passed
+func (ticker *manualTicker) fire(now time.Time) {
+	ticker.mutex.Lock()
+	defer ticker.mutex.Unlock()
+	if ticker.stopped || now.Before(ticker.next) {
+		return
+	}
+	select {
+	case ticker.c <- now:
+	default:
+	}
+	for !now.Before(ticker.next) {
+		ticker.next = ticker.next.Add(ticker.period)
+	}
+}
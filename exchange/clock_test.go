@@ -0,0 +1,133 @@
+package exchange
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerClock(t *testing.T) {
+	var clock ServerClock
+	if clock.Offset() != 0 || time.Since(clock.Now()) > time.Second {
+		t.Fatal("Zero value should have no offset")
+	}
+
+	// Server a minute ahead
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	offset, err := clock.Sync(context.Background(), server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 59*time.Second || offset > 61*time.Second || clock.Offset() != offset {
+		t.Fatalf("Expected an offset near a minute, got %s", offset)
+	}
+	if ahead := clock.Now().Sub(time.Now()); ahead < 59*time.Second {
+		t.Fatalf("Server time should be a minute ahead, got %s", ahead)
+	}
+
+	// Offsets within the header's resolution are none
+	clock.SetOffset(time.Hour)
+	inSync := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}))
+	defer inSync.Close()
+	if offset, err := clock.Sync(context.Background(), inSync.Client(), inSync.URL); err != nil || offset != 0 || clock.Offset() != 0 {
+		t.Fatalf("Expected no offset, got %s, %v", offset, err)
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	ticker := clock.NewTicker(10 * time.Second)
+	ticked := func() bool {
+		select {
+		case <-ticker.Chan():
+			return true
+		default:
+			return false
+		}
+	}
+
+	if clock.Advance(9*time.Second) != start.Add(9*time.Second) || !clock.Now().Equal(start.Add(9*time.Second)) {
+		t.Fatal("Clock should move by the duration advanced")
+	}
+	if ticked() {
+		t.Fatal("Ticker should not fire before its period")
+	}
+	clock.Advance(time.Second)
+	if !ticked() || ticked() {
+		t.Fatal("Ticker should fire once at its period")
+	}
+
+	// Periods passed at once give one tick, and the ticker keeps its schedule
+	clock.Advance(35 * time.Second)
+	if !ticked() || ticked() {
+		t.Fatal("Ticker should fire once for several periods")
+	}
+	clock.Advance(4 * time.Second)
+	if ticked() {
+		t.Fatal("Ticker should not fire before its next period")
+	}
+	clock.Advance(time.Second)
+	if !ticked() {
+		t.Fatal("Ticker should fire on its schedule")
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	if ticked() {
+		t.Fatal("Stopped ticker should not fire")
+	}
+	var _ Clock = SystemClock{}
+}
+
+func TestManualClockAfter(t *testing.T) {
+	start := time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	fired := func(c <-chan time.Time) bool {
+		select {
+		case <-c:
+			return true
+		default:
+			return false
+		}
+	}
+
+	if !fired(clock.After(0)) {
+		t.Fatal("Timer without a delay should fire at once")
+	}
+	timer := clock.After(10 * time.Second)
+	clock.Advance(9 * time.Second)
+	if fired(timer) {
+		t.Fatal("Timer should not fire before it's due")
+	}
+	clock.Advance(5 * time.Second)
+	if !fired(timer) {
+		t.Fatal("Timer should fire once the clock passes its due time")
+	}
+	clock.Advance(time.Minute)
+	if fired(timer) || len(clock.timers) != 0 {
+		t.Fatal("Timer should fire only once")
+	}
+
+	// SleepOn returns when the clock is moved past the delay
+	slept := make(chan bool)
+	go func() {
+		SleepOn(clock)(time.Second)
+		close(slept)
+	}()
+	for {
+		select {
+		case <-slept:
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Second)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Order book merged across exchanges
+
+package exchange
+
+import "sort"
+
+// ConsolidatedLevel is one exchange's book level in a ConsolidatedBook
+type ConsolidatedLevel struct {
+	Exg      Interface
+	Price    float64 // USD price, the exchange price over its FX price
+	ExgPrice float64 // Price in the exchange's currency, for orders
+	Amount   float64
+}
+
+// ConsolidatedBook merges the books of several exchanges into one ladder per side, priced in USD
+// Each exchange's levels are replaced by its next Add. The zero value is empty and ready to use,
+// but is not safe for concurrent use
+type ConsolidatedBook struct {
+	bids []ConsolidatedLevel // Sorted by USD price high to low
+	asks []ConsolidatedLevel // Sorted by USD price low to high
+}
+
+// Add replaces the levels of book.Exg with those of book, converted to USD at fxPrice units per USD
+// A book with an error or a non-positive fxPrice only removes the exchange's levels, as its prices can't be compared
+// Equal prices keep the order they were added in
+func (cb *ConsolidatedBook) Add(book Book, fxPrice float64) {
+	cb.Remove(book.Exg)
+	if book.Error != nil || fxPrice <= 0 {
+		return
+	}
+	for _, bid := range book.Bids {
+		cb.bids = append(cb.bids, ConsolidatedLevel{Exg: book.Exg, Price: bid.Price / fxPrice, ExgPrice: bid.Price, Amount: bid.Amount})
+	}
+	for _, ask := range book.Asks {
+		cb.asks = append(cb.asks, ConsolidatedLevel{Exg: book.Exg, Price: ask.Price / fxPrice, ExgPrice: ask.Price, Amount: ask.Amount})
+	}
+	sort.SliceStable(cb.bids, func(i, j int) bool { return cb.bids[i].Price > cb.bids[j].Price })
+	sort.SliceStable(cb.asks, func(i, j int) bool { return cb.asks[i].Price < cb.asks[j].Price })
+}
+
+// Remove drops the levels of exg
+func (cb *ConsolidatedBook) Remove(exg Interface) {
+	cb.bids = removeLevels(cb.bids, exg)
+	cb.asks = removeLevels(cb.asks, exg)
+}
+
+// Levels not from exg, in order
+func removeLevels(levels []ConsolidatedLevel, exg Interface) []ConsolidatedLevel {
+	kept := levels[:0]
+	for _, level := range levels {
+		if level.Exg != exg {
+			kept = append(kept, level)
+		}
+	}
+	return kept
+}
+
+// Bids returns a copy of the bids across exchanges, best first
+func (cb *ConsolidatedBook) Bids() []ConsolidatedLevel {
+	return append([]ConsolidatedLevel(nil), cb.bids...)
+}
+
+// Asks returns a copy of the asks across exchanges, best first
+func (cb *ConsolidatedBook) Asks() []ConsolidatedLevel {
+	return append([]ConsolidatedLevel(nil), cb.asks...)
+}
@@ -0,0 +1,78 @@
+package exchange
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestConsolidatedBook(t *testing.T) {
+	usd, cny := &scriptedExchange{}, &scriptedExchange{}
+	// The test book in USD, and the Bitfinex sample top of book in CNY at 2 per USD, which converts exactly
+	var cb ConsolidatedBook
+	cb.Add(testBook(), 1)
+	cb.Add(Book{
+		Exg:  cny,
+		Bids: BidItems{{Price: 1.6391 * 2, Amount: 53.08276864}, {Price: 1.639 * 2, Amount: 13.62}},
+		Asks: AskItems{{Price: 1.649 * 2, Amount: 8.225777}, {Price: 1.65 * 2, Amount: 118.35905692}},
+	}, 2)
+	// testBook has no exchange set
+	cb.Remove(nil)
+	book := testBook()
+	book.Exg = usd
+	cb.Add(book, 1)
+
+	bids, asks := cb.Bids(), cb.Asks()
+	if len(bids) != 5 || len(asks) != 5 {
+		t.Fatalf("Expected 5 levels a side, got %d bids and %d asks", len(bids), len(asks))
+	}
+	// Equal prices are in the order added
+	expectedBids := []struct {
+		exg    Interface
+		price  float64
+		amount float64
+	}{{cny, 1.6391, 53.08276864}, {usd, 1.6391, 10}, {cny, 1.639, 13.62}, {usd, 1.639, 5}, {usd, 1.638, 1}}
+	for i, expected := range expectedBids {
+		if bids[i].Exg != expected.exg || math.Abs(bids[i].Price-expected.price) > .000001 || bids[i].Amount != expected.amount {
+			t.Errorf("Bid %d: expected %v at %v, got %+v", i, expected.amount, expected.price, bids[i])
+		}
+	}
+	for i := 1; i < len(asks); i++ {
+		if asks[i].Price < asks[i-1].Price {
+			t.Fatalf("Asks not sorted: %+v", asks)
+		}
+	}
+	if asks[0].Exg != usd && asks[0].Exg != cny || math.Abs(asks[0].Price-1.649) > .000001 {
+		t.Errorf("Wrong best ask %+v", asks[0])
+	}
+	// Exchange prices are kept for orders
+	if math.Abs(bids[0].ExgPrice-1.6391*2) > .000001 {
+		t.Errorf("Wrong exchange price %v", bids[0].ExgPrice)
+	}
+
+	// A new book replaces the exchange's levels
+	cb.Add(Book{Exg: usd, Bids: BidItems{{Price: 1.7, Amount: 1}}}, 1)
+	if bids := cb.Bids(); len(bids) != 3 || bids[0].Exg != usd || bids[0].Price != 1.7 {
+		t.Errorf("Expected replaced bids, got %+v", bids)
+	}
+	if asks := cb.Asks(); len(asks) != 2 || asks[0].Exg != cny {
+		t.Errorf("Expected only CNY asks, got %+v", asks)
+	}
+
+	// A book with an error or no FX price removes the exchange
+	cb.Add(Book{Exg: cny, Error: errors.New("disconnected")}, 2)
+	if len(cb.Bids()) != 1 || len(cb.Asks()) != 0 {
+		t.Errorf("Expected CNY levels removed, got %+v and %+v", cb.Bids(), cb.Asks())
+	}
+	cb.Add(book, 0)
+	if len(cb.Bids()) != 0 {
+		t.Errorf("Expected USD levels removed, got %+v", cb.Bids())
+	}
+
+	// Accessors return copies
+	cb.Add(book, 1)
+	cb.Bids()[0].Amount = 100
+	if cb.Bids()[0].Amount != 10 {
+		t.Error("Bids should be a copy")
+	}
+}
@@ -0,0 +1,54 @@
+// Fiat currencies shared by exchange clients
+
+package exchange
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Currency identifies a fiat currency
+// Values are stable as currencies are added, so they can be persisted
+type Currency byte
+
+// Supported fiat currencies
+const (
+	USD Currency = 0
+	CNY Currency = 1
+	EUR Currency = 2
+	GBP Currency = 3
+	JPY Currency = 4
+)
+
+// Registry of lower case ISO symbols for each currency
+var currencySymbols = map[Currency]string{
+	USD: "usd",
+	CNY: "cny",
+	EUR: "eur",
+	GBP: "gbp",
+	JPY: "jpy",
+}
+
+// ParseCurrency returns the currency for an ISO symbol, ignoring case
+func ParseCurrency(symbol string) (Currency, error) {
+	symbol = strings.ToLower(symbol)
+	for currency, s := range currencySymbols {
+		if s == symbol {
+			return currency, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown currency %s", strings.ToUpper(symbol))
+}
+
+// Symbol returns the lower case ISO symbol, as used for FX quotes
+func (currency Currency) Symbol() string {
+	if s, ok := currencySymbols[currency]; ok {
+		return s
+	}
+	return fmt.Sprintf("currency(%d)", byte(currency))
+}
+
+// String implements the Stringer interface with the upper case ISO symbol
+func (currency Currency) String() string {
+	return strings.ToUpper(currency.Symbol())
+}
@@ -0,0 +1,24 @@
+package exchange
+
+import "testing"
+
+func TestParseCurrency(t *testing.T) {
+	for symbol, want := range map[string]Currency{"usd": USD, "CNY": CNY, "eur": EUR} {
+		if currency, err := ParseCurrency(symbol); err != nil || currency != want {
+			t.Errorf("Expected %s for %s, got %s, %v", want, symbol, currency, err)
+		}
+	}
+	if _, err := ParseCurrency("xyz"); err == nil {
+		t.Fatal("Expected error for unknown currency")
+	}
+}
+
+func TestCurrencyValues(t *testing.T) {
+	// Codes are persisted and must not change
+	if USD != 0 || CNY != 1 || EUR != 2 || GBP != 3 || JPY != 4 {
+		t.Fatal("Currency codes changed")
+	}
+	if JPY.Symbol() != "jpy" || GBP.String() != "GBP" {
+		t.Fatalf("Wrong symbols %s and %s", JPY.Symbol(), GBP)
+	}
+}
@@ -0,0 +1,68 @@
+// Kinds of exchange failure shared by exchange clients
+// Clients wrap the API errors they recognize so callers can react by kind with errors.Is
+
+package exchange
+
+import (
+	"errors"
+	"net"
+)
+
+// Kinds of exchange failure
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrOrderRejected     = errors.New("order rejected")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrInvalidOrder      = errors.New("invalid order") // Caught by the client before sending
+	ErrRateLimited       = errors.New("rate limited")
+	ErrConnection        = errors.New("connection error")
+	ErrUnknownOutcome    = errors.New("order outcome unknown") // Sent, but may not have reached the exchange
+)
+
+// Error is an exchange failure of a known kind, keeping the error as reported
+type Error struct {
+	Kind error // One of the Err kinds
+	Err  error // Error as reported by the exchange or HTTP client
+}
+
+// NewError returns an error of kind with the exchange's message
+func NewError(kind error, message string) error {
+	return &Error{Kind: kind, Err: errors.New(message)}
+}
+
+// ConnectionError marks an error sending a request or reading its response as a connection failure
+// Returns nil for a nil error
+func ConnectionError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: ErrConnection, Err: err}
+}
+
+// SentError marks a connection failure of an order request as of unknown outcome, since the exchange may have placed the order
+// Failures to connect, before anything was sent, and errors of other kinds are returned as is
+func SentError(err error) error {
+	if !errors.Is(err, ErrConnection) {
+		return err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return err
+	}
+	return &Error{Kind: ErrUnknownOutcome, Err: err}
+}
+
+// Error implements the error interface with the reported message
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the reported error
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Is reports whether target is the error's kind
+func (err *Error) Is(target error) bool {
+	return target == err.Kind
+}
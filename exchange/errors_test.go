@@ -0,0 +1,43 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := fmt.Errorf("Test SendOrder error: %w", NewError(ErrInsufficientFunds, "not enough balance"))
+	if !errors.Is(err, ErrInsufficientFunds) || errors.Is(err, ErrOrderRejected) {
+		t.Fatal("Wrapped error should match only its kind")
+	}
+	if err.Error() != "Test SendOrder error: not enough balance" {
+		t.Fatalf("Wrong message %q", err)
+	}
+
+	// Connection errors keep the reported error
+	err = ConnectionError(context.DeadlineExceeded)
+	if !errors.Is(err, ErrConnection) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Connection error should match its kind and the reported error")
+	}
+	if ConnectionError(nil) != nil {
+		t.Fatal("No error should stay nil")
+	}
+
+	// Order requests that may have been sent have an unknown outcome, unless they never connected
+	if err := SentError(ConnectionError(context.DeadlineExceeded)); !errors.Is(err, ErrUnknownOutcome) {
+		t.Fatalf("Expected an unknown outcome, got %v", err)
+	}
+	dial := ConnectionError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	if err := SentError(dial); errors.Is(err, ErrUnknownOutcome) || !errors.Is(err, ErrConnection) {
+		t.Fatalf("Failed dial should stay a connection error, got %v", err)
+	}
+	if err := SentError(NewError(ErrOrderRejected, "rejected")); errors.Is(err, ErrUnknownOutcome) {
+		t.Fatal("Rejection should keep its kind")
+	}
+	if SentError(nil) != nil {
+		t.Fatal("No error should stay nil")
+	}
+}
@@ -3,6 +3,9 @@
 package exchange
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"time"
 )
 
@@ -15,7 +18,11 @@ type Interface interface {
 	// Equal priority results in concurrent execution
 	Priority() int
 	// Return percent fee charged for taking a market
-	Fee() float64
+	TakerFee() float64
+	// Return percent fee charged when a resting order is filled
+	MakerFee() float64
+	// Maker and taker fee setter method, safe to call concurrently with the getters
+	SetFees(maker, taker float64)
 	// Position setter method
 	SetPosition(float64)
 	// Return position set above
@@ -28,34 +35,123 @@ type Interface interface {
 	AvailFunds() float64
 	// Return amount of cryptocurrency available for short selling
 	AvailShort() float64
+	// Available funds setter method, updated as fills spend or return fiat
+	SetAvailFunds(float64)
+	// Available short setter method
+	SetAvailShort(float64)
+	// Return live fiat and cryptocurrency balances available for trading
+	Balances() (fiat float64, crypto float64, err error)
 	// Return the fiat currency in use
 	Currency() string
-	// Return the fiat currency code
-	// USD = 0
-	// CNY = 1
-	CurrencyCode() byte
+	// Return the fiat currency code, matching Currency()
+	CurrencyCode() Currency
 	// Send the latest available exchange.Book on the supplied channel
-	CommunicateBook(bookChan chan<- Book) Book
+	// Sending stops when doneChan receives a value or is closed
+	CommunicateBook(bookChan chan<- Book, doneChan <-chan bool) Book
+	// Return the latest top-of-book ticker without streaming book depth
+	Ticker() (Ticker, error)
+	// Return the smallest order amount the exchange accepts
+	MinOrderSize() float64
+	// Round an order amount down to the lot size and price to the tick size
+	RoundOrder(amount, price float64) (float64, float64)
+	// Return the tick size at a price
+	PriceStep(price float64) float64
 	// Send an order to the exchange
+	// Order methods return an error if ctx is done before completion
 	// action = "buy" or "sell"
-	// otype = "limit" or "market"
-	SendOrder(action, otype string, amount, price float64) (int64, error)
+	// otype = "limit", "market" where MarketTrader is implemented, or "post_only" where PostOnlyTrader is implemented
+	SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error)
 	// Cancel an existing order on the exchange
-	CancelOrder(id int64) (bool, error)
+	CancelOrder(ctx context.Context, id int64) (bool, error)
 	// Return status of an existing order on the exchange
-	GetOrderStatus(id int64) (Order, error)
-	// Return true if fees are charged in cryptocurrency on purchases
-	HasCryptoFee() bool
+	GetOrderStatus(ctx context.Context, id int64) (Order, error)
+	// Return all live orders for the symbol and currency in use
+	OpenOrders(ctx context.Context) ([]Order, error)
+	// Return the position change magnitude for a filled amount, net of fees charged in cryptocurrency
+	// action = "buy" or "sell"
+	FeeAdjustedFill(action string, filled float64) float64
 	// Close all connections
 	Done()
 }
 
+// PositionReporter is implemented by exchanges able to report their actual position
+// Used to check positions loaded at startup
+type PositionReporter interface {
+	ActualPosition() (float64, error)
+}
+
+// PostOnlyTrader is implemented by exchanges accepting "post_only" orders
+// A post-only order is a limit order that rests as a maker and never takes liquidity
+type PostOnlyTrader interface {
+	SupportsPostOnly() bool
+}
+
+// MarketTrader is implemented by exchanges accepting "market" orders
+// A market order takes the book at whatever price fills it
+type MarketTrader interface {
+	SupportsMarket() bool
+}
+
+// FeeReporter is implemented by exchanges able to report the account's current fee tier
+// Used to keep fees current as trading volume changes the tier
+type FeeReporter interface {
+	AccountFees() (maker, taker float64, err error)
+}
+
+// OrderRateLimiter is implemented by exchanges able to limit orders and cancels per second
+// Used to guard against runaway order submission
+type OrderRateLimiter interface {
+	SetRateLimit(perSecond float64)
+}
+
+// HealthReporter is implemented by exchanges tracking the books they send
+// Used to warn when an exchange stops sending books
+type HealthReporter interface {
+	Connected() bool
+	LastUpdate() time.Time
+}
+
+// LatencyReporter is implemented by exchanges timing their order requests
+// Covers SendOrder, GetOrderStatus, and CancelOrder, to compare exchanges when setting priority
+type LatencyReporter interface {
+	LastLatency() time.Duration
+}
+
+// ClockSyncer is implemented by exchanges signing requests with nonces or timestamps checked against their clock
+// Used to correct for local clock skew
+type ClockSyncer interface {
+	// Measure and apply the exchange clock's offset from the local clock, returning it
+	SyncClock(ctx context.Context) (time.Duration, error)
+}
+
+// Borrower is implemented by exchanges able to borrow cryptocurrency for short sales
+// AvailShort includes what can be borrowed
+type Borrower interface {
+	// Borrow what a sell of amount needs beyond the coins held, returning an error past the borrow limit
+	EnsureBorrowed(ctx context.Context, amount float64) error
+	// Repay loans no longer needed at the current position
+	RepayExcess(ctx context.Context) error
+}
+
 // Order defines the order status format
 type Order struct {
+	ID           int64   // Set by OpenOrders
+	Side         string  // "buy" or "sell", set by OpenOrders
+	Price        float64 // Limit price, set by OpenOrders
+	Amount       float64 // Original amount, set by OpenOrders
 	FilledAmount float64 // Positive number for buys and sells
 	Status       string  // "live" or "dead"
 }
 
+// Ticker defines the ticker data format
+type Ticker struct {
+	Last   float64   // Last traded price
+	Bid    float64   // Best bid price
+	Ask    float64   // Best ask price
+	Volume float64   // Trailing 24 hour volume
+	Time   time.Time // Time of the ticker data
+}
+
 // Book defines the book data format
 type Book struct {
 	Exg   Interface
@@ -65,6 +161,103 @@ type Book struct {
 	Error error
 }
 
+// Validate returns an error if the book is crossed, out of order, or has non-positive items
+// Glitchy feeds produce such books, which would show phantom arbs
+func (book Book) Validate() error {
+	for i, item := range book.Bids {
+		if item.Price <= 0 || item.Amount <= 0 {
+			return fmt.Errorf("%v book error: non-positive bid %d", book.Exg, i)
+		}
+		if i > 0 && item.Price > book.Bids[i-1].Price {
+			return fmt.Errorf("%v book error: bids out of order at %d", book.Exg, i)
+		}
+	}
+	for i, item := range book.Asks {
+		if item.Price <= 0 || item.Amount <= 0 {
+			return fmt.Errorf("%v book error: non-positive ask %d", book.Exg, i)
+		}
+		if i > 0 && item.Price < book.Asks[i-1].Price {
+			return fmt.Errorf("%v book error: asks out of order at %d", book.Exg, i)
+		}
+	}
+	if len(book.Bids) > 0 && len(book.Asks) > 0 && book.Bids[0].Price >= book.Asks[0].Price {
+		return fmt.Errorf("%v book error: crossed at bid %f, ask %f", book.Exg, book.Bids[0].Price, book.Asks[0].Price)
+	}
+	return nil
+}
+
+// MidPrice returns the average of the best bid and ask, or 0 if either side is empty
+func (book Book) MidPrice() float64 {
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return 0
+	}
+	return (book.Bids[0].Price + book.Asks[0].Price) / 2
+}
+
+// MicroPrice returns the mid weighted by top of book amounts, or 0 if either side is empty
+// A larger bid amount moves the price toward the ask, and vice versa
+func (book Book) MicroPrice() float64 {
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return 0
+	}
+	bid, ask := book.Bids[0], book.Asks[0]
+	if bid.Amount+ask.Amount <= 0 {
+		return book.MidPrice()
+	}
+	return (bid.Price*ask.Amount + ask.Price*bid.Amount) / (bid.Amount + ask.Amount)
+}
+
+// BidVWAP returns the amount-weighted average price to sell size into the bids
+// Available is less than size if the book is too shallow, and price is 0 if the side is empty
+func (book Book) BidVWAP(size float64) (price, available float64) {
+	var aggPrice float64
+	for _, bid := range book.Bids {
+		if available >= size {
+			break
+		}
+		amount := math.Min(size-available, bid.Amount)
+		aggPrice += bid.Price * amount
+		available += amount
+	}
+	if available <= 0 {
+		return 0, 0
+	}
+	return aggPrice / available, available
+}
+
+// AskVWAP returns the amount-weighted average price to buy size from the asks
+// Available is less than size if the book is too shallow, and price is 0 if the side is empty
+func (book Book) AskVWAP(size float64) (price, available float64) {
+	var aggPrice float64
+	for _, ask := range book.Asks {
+		if available >= size {
+			break
+		}
+		amount := math.Min(size-available, ask.Amount)
+		aggPrice += ask.Price * amount
+		available += amount
+	}
+	if available <= 0 {
+		return 0, 0
+	}
+	return aggPrice / available, available
+}
+
+// TrimDepth returns the book with at most n levels on each side, or unchanged if n <= 0
+// Trimmed sides are copied so the full depth from the exchange can be freed
+func (book Book) TrimDepth(n int) Book {
+	if n <= 0 {
+		return book
+	}
+	if len(book.Bids) > n {
+		book.Bids = append(BidItems(nil), book.Bids[:n]...)
+	}
+	if len(book.Asks) > n {
+		book.Asks = append(AskItems(nil), book.Asks[:n]...)
+	}
+	return book
+}
+
 // BidItems defines the inner book data format
 type BidItems []struct {
 	Price  float64
@@ -0,0 +1,145 @@
+package exchange
+
+import (
+	"math"
+	"testing"
+)
+
+// Returns a well formed book for modification
+func testBook() Book {
+	return Book{
+		Bids: BidItems{{Price: 1.6391, Amount: 10}, {Price: 1.639, Amount: 5}, {Price: 1.638, Amount: 1}},
+		Asks: AskItems{{Price: 1.649, Amount: 8}, {Price: 1.65, Amount: 2}, {Price: 1.651, Amount: 3}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := testBook().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Equal adjacent prices are allowed
+	book := testBook()
+	book.Bids[1].Price = book.Bids[0].Price
+	if err := book.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	malformed := map[string]func(*Book){
+		"crossed":           func(b *Book) { b.Bids[0].Price = 1.66 },
+		"locked":            func(b *Book) { b.Asks[0].Price = b.Bids[0].Price },
+		"bids out of order": func(b *Book) { b.Bids[2].Price = 1.6395 },
+		"asks out of order": func(b *Book) { b.Asks[1].Price = 1.648 },
+		"zero bid price":    func(b *Book) { b.Bids[2].Price = 0 },
+		"negative ask":      func(b *Book) { b.Asks[2].Price = -1 },
+		"zero bid amount":   func(b *Book) { b.Bids[1].Amount = 0 },
+		"negative amount":   func(b *Book) { b.Asks[0].Amount = -2 },
+	}
+	for name, modify := range malformed {
+		book := testBook()
+		modify(&book)
+		if book.Validate() == nil {
+			t.Errorf("Expected error for %s book", name)
+		}
+	}
+}
+
+// Top of book from the Bitfinex sample data
+func TestMidPrice(t *testing.T) {
+	book := Book{
+		Bids: BidItems{{Price: 1.6391, Amount: 53.08276864}, {Price: 1.639, Amount: 13.62}},
+		Asks: AskItems{{Price: 1.649, Amount: 8.225777}, {Price: 1.65, Amount: 118.35905692}},
+	}
+	if mid := book.MidPrice(); math.Abs(mid-1.64405) > .000001 {
+		t.Errorf("Expected mid 1.64405, got %v", mid)
+	}
+	// Heavy bids put the micro price near the ask
+	micro := (1.6391*8.225777 + 1.649*53.08276864) / (53.08276864 + 8.225777)
+	if got := book.MicroPrice(); math.Abs(got-micro) > .000001 || got <= book.MidPrice() {
+		t.Errorf("Expected micro price %v, got %v", micro, got)
+	}
+
+	empty := Book{Bids: book.Bids}
+	if empty.MidPrice() != 0 || empty.MicroPrice() != 0 {
+		t.Error("Expected 0 for empty book side")
+	}
+}
+
+func TestVWAP(t *testing.T) {
+	book := testBook()
+	// Within the top level
+	if price, available := book.BidVWAP(4); price != 1.6391 || available != 4 {
+		t.Errorf("Expected 4 at 1.6391, got %v at %v", available, price)
+	}
+	// Across levels
+	price, available := book.AskVWAP(9)
+	if math.Abs(price-(1.649*8+1.65)/9) > .000001 || available != 9 {
+		t.Errorf("Expected 9 at %v, got %v at %v", (1.649*8+1.65)/9, available, price)
+	}
+	// Partial fill when the book is too shallow
+	price, available = book.BidVWAP(20)
+	if math.Abs(price-(1.6391*10+1.639*5+1.638)/16) > .000001 || available != 16 {
+		t.Errorf("Expected partial fill of 16, got %v at %v", available, price)
+	}
+	if price, available := (Book{}).AskVWAP(1); price != 0 || available != 0 {
+		t.Error("Expected 0 for empty book side")
+	}
+}
+
+func TestTrimDepth(t *testing.T) {
+	book := testBook()
+	trimmed := book.TrimDepth(2)
+	if len(trimmed.Bids) != 2 || len(trimmed.Asks) != 2 {
+		t.Fatal("Should keep 2 levels on each side")
+	}
+	if trimmed.Bids[0] != book.Bids[0] || trimmed.Asks[1] != book.Asks[1] {
+		t.Fatal("Should keep the top levels")
+	}
+	// Trimmed sides don't share the original arrays
+	trimmed.Bids[0].Amount = 0
+	if book.Bids[0].Amount == 0 {
+		t.Fatal("Trimmed book should be a copy")
+	}
+	if full := book.TrimDepth(0); len(full.Bids) != 3 || len(full.Asks) != 3 {
+		t.Fatal("Zero depth should leave the book unchanged")
+	}
+	if full := book.TrimDepth(10); len(full.Bids) != 3 || len(full.Asks) != 3 {
+		t.Fatal("Shallow book should be unchanged")
+	}
+}
+
+// Returns a book with depth levels on each side, as sent by exchanges with deep books
+func deepBook(depth int) Book {
+	book := Book{Bids: make(BidItems, depth), Asks: make(AskItems, depth)}
+	for i := 0; i < depth; i++ {
+		book.Bids[i].Price, book.Bids[i].Amount = 250-float64(i)*.01, 1
+		book.Asks[i].Price, book.Asks[i].Amount = 250.01+float64(i)*.01, 1
+	}
+	return book
+}
+
+// Copy a book as a consumer retaining it would
+func copyBook(book Book) Book {
+	book.Bids = append(BidItems(nil), book.Bids...)
+	book.Asks = append(AskItems(nil), book.Asks...)
+	return book
+}
+
+// Retaining full depth books
+func BenchmarkRetainFullDepth(b *testing.B) {
+	book := deepBook(200)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = copyBook(book)
+	}
+}
+
+// Retaining books trimmed at conversion time
+func BenchmarkRetainTrimmedDepth(b *testing.B) {
+	book := deepBook(200).TrimDepth(20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = copyBook(book)
+	}
+}
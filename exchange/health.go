@@ -0,0 +1,38 @@
+// Book feed health shared by exchange clients
+
+package exchange
+
+import (
+	"sync"
+	"time"
+)
+
+// Health tracks the books an exchange client sends
+// The zero value has sent nothing, and is safe to use concurrently
+type Health struct {
+	last  time.Time // Time the last book was sent
+	good  bool      // Whether the last book had no error
+	mutex sync.Mutex
+}
+
+// Update records a book as sent now
+func (health *Health) Update(book Book) {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	health.last = time.Now()
+	health.good = book.Error == nil
+}
+
+// Connected returns true if a book has been sent and the last one had no error
+func (health *Health) Connected() bool {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.good
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (health *Health) LastUpdate() time.Time {
+	health.mutex.Lock()
+	defer health.mutex.Unlock()
+	return health.last
+}
@@ -0,0 +1,21 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	var health Health
+	if health.Connected() || !health.LastUpdate().IsZero() {
+		t.Fatal("Nothing should be recorded before a book is sent")
+	}
+	health.Update(Book{})
+	if !health.Connected() || health.LastUpdate().IsZero() {
+		t.Fatal("Good book should be recorded")
+	}
+	health.Update(Book{Error: errors.New("book error")})
+	if health.Connected() {
+		t.Fatal("Book with an error should not be connected")
+	}
+}
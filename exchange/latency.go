@@ -0,0 +1,30 @@
+// Order request latency shared by exchange clients
+
+package exchange
+
+import (
+	"sync"
+	"time"
+)
+
+// Latency tracks the round trip time of order requests
+// The zero value has recorded nothing, and is safe to use concurrently
+type Latency struct {
+	last  time.Duration // Round trip of the last request
+	mutex sync.Mutex
+}
+
+// Observe records a request sent at start as answered now
+// Deferred as latency.Observe(time.Now()) just before the request goes out
+func (latency *Latency) Observe(start time.Time) {
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	latency.last = time.Since(start)
+}
+
+// Last returns the round trip of the last request, zero if none has been sent
+func (latency *Latency) Last() time.Duration {
+	latency.mutex.Lock()
+	defer latency.mutex.Unlock()
+	return latency.last
+}
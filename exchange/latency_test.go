@@ -0,0 +1,21 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatency(t *testing.T) {
+	var latency Latency
+	if latency.Last() != 0 {
+		t.Fatal("Nothing should be recorded before a request")
+	}
+	latency.Observe(time.Now().Add(-time.Second))
+	if last := latency.Last(); last < time.Second || last > 2*time.Second {
+		t.Fatalf("Wrong round trip recorded: %v", last)
+	}
+	latency.Observe(time.Now())
+	if latency.Last() >= time.Second {
+		t.Fatal("Last request should replace the earlier one")
+	}
+}
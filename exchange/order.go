@@ -0,0 +1,85 @@
+// Order execution shared by exchange users
+
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Time between status checks in FillOrKill, replaced in tests
+var fillPollDelay = 200 * time.Millisecond
+
+// Max wait for the cancel sent when PollOrder gives up on a live order
+var finalCancelTimeout = 5 * time.Second
+
+// FillOrKill sends a limit order, cancels any remainder, and returns the amount filled
+// Gives up after timeout, zero for no limit, returning the last known fill with an error
+func FillOrKill(exg Interface, action string, amount, price float64, timeout time.Duration) (float64, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	id, err := exg.SendOrder(ctx, action, "limit", amount, price)
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("%s SendOrder error: no order ID returned", exg)
+	}
+	order, err := PollOrder(ctx, SystemClock{}, exg, id, 0, fillPollDelay, nil)
+	return order.FilledAmount, err
+}
+
+// PollOrder checks the status of order id every pollDelay on clock until it's dead
+// The order is cancelled once if still live after resting for rest
+// Status and cancel errors are passed to onError, if not nil, and retried at the next check, after any RetryAfter wait
+// Returns the last status retrieved, with an error if ctx is done before the order dies
+// Unless already cancelled, the order is then cancelled within finalCancelTimeout so it isn't left resting
+func PollOrder(ctx context.Context, clock Clock, exg Interface, id int64, rest, pollDelay time.Duration, onError func(error)) (Order, error) {
+	var last Order // Last successfully retrieved status
+	restUntil := clock.Now().Add(rest)
+	cancelled := false
+	for {
+		order, err := exg.GetOrderStatus(ctx, id)
+		if err == nil {
+			last = order
+			if order.Status == "dead" {
+				return last, nil
+			}
+			if order.Status == "live" && !cancelled && !clock.Now().Before(restUntil) {
+				if _, err = exg.CancelOrder(ctx, id); err == nil {
+					cancelled = true
+				} else if onError != nil {
+					onError(err)
+				}
+			}
+		} else if onError != nil {
+			onError(err)
+		}
+		// Continues while order status is empty or live, waiting longer if rate limited
+		delay := pollDelay
+		if wait, limited := RetryAfter(err); limited && wait > delay {
+			delay = wait
+		}
+		select {
+		case <-clock.After(delay):
+		case <-ctx.Done():
+			if cancelled {
+				return last, fmt.Errorf("%s order %d abandoned: %s", exg, id, ctx.Err())
+			}
+			cancelCtx, cancel := context.WithTimeout(context.Background(), finalCancelTimeout)
+			defer cancel()
+			if _, err := exg.CancelOrder(cancelCtx, id); err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				return last, fmt.Errorf("%s order %d abandoned and may still be live: %s, cancel failed: %w", exg, id, ctx.Err(), err)
+			}
+			return last, fmt.Errorf("%s order %d abandoned and cancelled: %s", exg, id, ctx.Err())
+		}
+	}
+}
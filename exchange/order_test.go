@@ -0,0 +1,162 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// Exchange returning a scripted status for each check, repeating the last
+// Methods other than the order methods are left to the nil Interface
+type scriptedExchange struct {
+	Interface
+	statuses   []Order
+	sendErr    error
+	cancelErrs int // Cancels that fail before one succeeds
+	polls      int
+	cancels    int
+}
+
+func (exg *scriptedExchange) String() string {
+	return "Scripted"
+}
+
+func (exg *scriptedExchange) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if exg.sendErr != nil {
+		return 0, exg.sendErr
+	}
+	return 1, nil
+}
+
+func (exg *scriptedExchange) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	exg.cancels++
+	if exg.cancels <= exg.cancelErrs {
+		return false, errors.New("CancelOrder error: unavailable")
+	}
+	return true, nil
+}
+
+func (exg *scriptedExchange) GetOrderStatus(ctx context.Context, id int64) (Order, error) {
+	status := exg.statuses[int(math.Min(float64(exg.polls), float64(len(exg.statuses)-1)))]
+	exg.polls++
+	if status.Status == "error" {
+		return Order{}, errors.New("GetOrderStatus error: unavailable")
+	}
+	return status, nil
+}
+
+func TestFillOrKill(t *testing.T) {
+	defer func(delay time.Duration) { fillPollDelay = delay }(fillPollDelay)
+	fillPollDelay = time.Millisecond
+
+	// Partial fill is cancelled, with status errors retried
+	exg := &scriptedExchange{statuses: []Order{
+		{Status: "error"},
+		{FilledAmount: .25, Status: "live"},
+		{Status: "error"},
+		{FilledAmount: .5, Status: "live"},
+		{FilledAmount: .75, Status: "dead"},
+	}}
+	filled, err := FillOrKill(exg, "buy", 1, 250, time.Second)
+	if err != nil || math.Abs(filled-.75) > .000001 {
+		t.Fatalf("Expected .75 filled, got %f and %v", filled, err)
+	}
+	if exg.polls != 5 || exg.cancels != 1 {
+		t.Fatalf("Expected 5 polls and 1 cancel, got %d and %d", exg.polls, exg.cancels)
+	}
+
+	// Full fill isn't cancelled
+	exg = &scriptedExchange{statuses: []Order{{FilledAmount: 1, Status: "dead"}}}
+	if filled, err = FillOrKill(exg, "sell", 1, 250, 0); err != nil || filled != 1 || exg.cancels != 0 {
+		t.Fatalf("Expected a full fill without cancel, got %f, %v, and %d cancels", filled, err, exg.cancels)
+	}
+
+	// Order never dies, last known fill is returned at the timeout
+	exg = &scriptedExchange{statuses: []Order{{FilledAmount: .25, Status: "live"}}}
+	if filled, err = FillOrKill(exg, "buy", 1, 250, 20*time.Millisecond); err == nil || math.Abs(filled-.25) > .000001 {
+		t.Fatalf("Expected an error with .25 filled, got %f and %v", filled, err)
+	}
+	if exg.cancels != 1 {
+		t.Fatalf("Expected 1 cancel, got %d", exg.cancels)
+	}
+
+	// Failed send reports nothing filled
+	exg = &scriptedExchange{sendErr: errors.New("SendOrder error: unavailable")}
+	if filled, err = FillOrKill(exg, "buy", 1, 250, time.Second); err == nil || filled != 0 || exg.polls != 0 {
+		t.Fatal("Failed send should report nothing filled")
+	}
+}
+
+func TestPollOrderRest(t *testing.T) {
+	exg := &scriptedExchange{statuses: []Order{{Status: "live"}, {Status: "live"}, {Status: "live"}, {Status: "live"}, {Status: "dead"}}}
+	start := time.Now()
+	if _, err := PollOrder(context.Background(), SystemClock{}, exg, 1, 30*time.Millisecond, 10*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exg.cancels != 1 || exg.polls != 5 {
+		t.Fatalf("Expected 5 polls and 1 cancel, got %d and %d", exg.polls, exg.cancels)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Fatal("Should rest before cancelling")
+	}
+}
+
+func TestPollOrderClock(t *testing.T) {
+	// Rest and poll delays of a minute pass on a manual clock without waiting
+	exg := &scriptedExchange{statuses: []Order{{Status: "live"}, {Status: "live"}, {Status: "live"}, {Status: "dead"}}}
+	clock := NewManualClock(time.Now())
+	done := make(chan error)
+	go func() {
+		_, err := PollOrder(context.Background(), clock, exg, 1, 2*time.Minute, time.Minute, nil)
+		done <- err
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exg.cancels != 1 || exg.polls != 4 {
+				t.Fatalf("Expected 4 polls and 1 cancel, got %d and %d", exg.polls, exg.cancels)
+			}
+			return
+		case <-time.After(time.Millisecond):
+			clock.Advance(time.Minute)
+		}
+	}
+}
+
+func TestPollOrderCancelRetry(t *testing.T) {
+	// A failed cancel is retried at the next check
+	exg := &scriptedExchange{cancelErrs: 1, statuses: []Order{{Status: "live"}, {Status: "live"}, {Status: "live"}, {Status: "dead"}}}
+	var errs int
+	if _, err := PollOrder(context.Background(), SystemClock{}, exg, 1, 0, time.Millisecond, func(error) { errs++ }); err != nil {
+		t.Fatal(err)
+	}
+	if exg.cancels != 2 || errs != 1 {
+		t.Fatalf("Expected 2 cancels and 1 error, got %d and %d", exg.cancels, errs)
+	}
+
+	// An order still resting at the deadline is cancelled before giving up
+	exg = &scriptedExchange{statuses: []Order{{FilledAmount: .25, Status: "live"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	order, err := PollOrder(ctx, SystemClock{}, exg, 1, time.Second, time.Millisecond, nil)
+	if err == nil || math.Abs(order.FilledAmount-.25) > .000001 {
+		t.Fatalf("Expected an error with .25 filled, got %v and %v", order, err)
+	}
+	if exg.cancels != 1 {
+		t.Fatalf("Expected a final cancel, got %d cancels", exg.cancels)
+	}
+
+	// A final cancel that fails is reported
+	exg = &scriptedExchange{cancelErrs: 1, statuses: []Order{{Status: "live"}}}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	errs = 0
+	if _, err = PollOrder(ctx, SystemClock{}, exg, 1, time.Second, time.Millisecond, func(error) { errs++ }); err == nil || errs != 1 {
+		t.Fatalf("Expected the failed cancel reported, got %v and %d errors", err, errs)
+	}
+}
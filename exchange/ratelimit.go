@@ -0,0 +1,75 @@
+// Order rate limiting shared by exchange clients
+
+package exchange
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting requests per second
+// The zero value doesn't limit, and is safe to use concurrently
+type RateLimiter struct {
+	rate   float64          // Tokens added per second, zero for no limit
+	tokens float64          // Tokens available, at most the burst of max(rate, 1)
+	last   time.Time        // Time tokens were last added
+	now    func() time.Time // Current time, replaced in tests
+	mutex  sync.Mutex
+}
+
+// SetRate sets the requests allowed per second, zero for no limit
+// Up to one second of requests can be sent at once, starting with a full bucket
+func (limiter *RateLimiter) SetRate(perSecond float64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.rate = math.Max(perSecond, 0)
+	limiter.tokens = limiter.burst()
+	limiter.last = limiter.clock()
+}
+
+// Wait blocks until a request is allowed, returning an error if ctx is done first
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := limiter.reserve()
+		if delay <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Take a token if one is available, else return the time until one is
+func (limiter *RateLimiter) reserve() time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.rate <= 0 {
+		return 0
+	}
+	now := limiter.clock()
+	limiter.tokens = math.Min(limiter.burst(), limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate)
+	limiter.last = now
+	if limiter.tokens >= 1 {
+		limiter.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - limiter.tokens) / limiter.rate * float64(time.Second)))
+}
+
+// Most tokens held, one second of requests but at least one
+func (limiter *RateLimiter) burst() float64 {
+	return math.Max(limiter.rate, 1)
+}
+
+// Current time, from now if set
+func (limiter *RateLimiter) clock() time.Time {
+	if limiter.now == nil {
+		return time.Now()
+	}
+	return limiter.now()
+}
@@ -0,0 +1,50 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := RateLimiter{now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	// The zero value doesn't limit
+	for i := 0; i < 100; i++ {
+		if delay := limiter.reserve(); delay != 0 {
+			t.Fatalf("Unlimited request delayed by %s", delay)
+		}
+	}
+
+	// A second of requests is allowed at once, then the next waits
+	limiter.SetRate(4)
+	for i := 0; i < 4; i++ {
+		if err := limiter.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if delay := limiter.reserve(); delay != 250*time.Millisecond {
+		t.Fatalf("Expected a delay of 250ms, got %s", delay)
+	}
+
+	// Tokens are added with time up to the burst
+	now = now.Add(100 * time.Millisecond)
+	if delay := limiter.reserve(); delay != 150*time.Millisecond {
+		t.Fatalf("Expected a delay of 150ms, got %s", delay)
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 4; i++ {
+		if delay := limiter.reserve(); delay != 0 {
+			t.Fatalf("Request %d after refill delayed by %s", i, delay)
+		}
+	}
+
+	// Waiting stops when the context is done
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := limiter.Wait(ctx); err != context.Canceled {
+		t.Fatalf("Expected cancelled wait, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Credential redaction for errors returned by exchange clients
+
+package exchange
+
+import (
+	"errors"
+	"strings"
+)
+
+// Placeholder for credentials removed from errors
+const Redacted = "[redacted]"
+
+// Redact returns err with every occurrence of the non-empty secrets replaced by Redacted
+// Keys, secrets, and signatures can reach errors through request URLs and response bodies,
+// and errors are logged, so clients pass request errors through Redact before returning them
+func Redact(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	for _, secret := range secrets {
+		if secret != "" {
+			message = strings.Replace(message, secret, Redacted, -1)
+		}
+	}
+	if message == err.Error() {
+		return err
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,53 @@
+// Order rounding shared by exchange clients
+
+package exchange
+
+import (
+	"math"
+	"strconv"
+)
+
+// Tolerance for values already on a step that float division puts just below it
+const stepEpsilon = 1e-9
+
+// RoundDown rounds value down to a multiple of step
+// A step of zero or less leaves value unchanged
+func RoundDown(value, step float64) float64 {
+	if step <= 0 {
+		return value
+	}
+	return clean(math.Floor(value/step+stepEpsilon)*step, step)
+}
+
+// RoundNearest rounds value to the nearest multiple of step
+// A step of zero or less leaves value unchanged
+func RoundNearest(value, step float64) float64 {
+	if step <= 0 {
+		return value
+	}
+	return clean(math.Round(value/step)*step, step)
+}
+
+// RoundSignificant rounds value to a number of significant figures
+func RoundSignificant(value float64, figures int) float64 {
+	return RoundNearest(value, SignificantStep(value, figures))
+}
+
+// SignificantStep returns the value of the last of a number of significant figures
+// Zero if value is zero or figures is zero or less
+func SignificantStep(value float64, figures int) float64 {
+	if value == 0 || figures <= 0 {
+		return 0
+	}
+	return math.Pow(10, math.Floor(math.Log10(math.Abs(value)))-float64(figures-1))
+}
+
+// Remove float error left by multiplying, so 0.1*3 formats as 0.3
+func clean(value, step float64) float64 {
+	decimals := int(math.Ceil(-math.Log10(step) - stepEpsilon))
+	if decimals < 0 {
+		decimals = 0
+	}
+	cleaned, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', decimals, 64), 64)
+	return cleaned
+}
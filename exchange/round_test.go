@@ -0,0 +1,28 @@
+package exchange
+
+import "testing"
+
+func TestRound(t *testing.T) {
+	cases := []struct {
+		name          string
+		got, expected float64
+	}{
+		{"down", RoundDown(1.23456, .001), 1.234},
+		{"down on step", RoundDown(0.3, .1), 0.3},
+		{"down lot", RoundDown(0.0299, .01), 0.02},
+		{"down no step", RoundDown(1.23456, 0), 1.23456},
+		{"nearest", RoundNearest(250.125001, .01), 250.13},
+		{"nearest tick", RoundNearest(37499.96, .1), 37500},
+		{"nearest integer step", RoundNearest(1234, 5), 1235},
+		{"significant", RoundSignificant(1.639149, 5), 1.6391},
+		{"significant large", RoundSignificant(37512.6, 5), 37513},
+		{"significant small", RoundSignificant(0.000123456, 3), 0.000123},
+		{"significant step", SignificantStep(37512.6, 5), 1},
+		{"significant step zero", SignificantStep(0, 5), 0},
+	}
+	for _, c := range cases {
+		if c.got != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, c.got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// HTTP status errors for exchange REST requests
+// Rate limit responses carry the wait asked for, so callers can back off by it
+
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitError is returned for an HTTP 429 response
+type RateLimitError struct {
+	Status     string
+	RetryAfter time.Duration // Wait asked for by the exchange, zero if not given
+}
+
+// Error implements the error interface
+func (err *RateLimitError) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry after %s", err.Status, err.RetryAfter)
+	}
+	return err.Status
+}
+
+// Is matches ErrRateLimited
+func (err *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// StatusError returns nil for a 200 response, a *RateLimitError for a 429, and the status as an error otherwise
+// Gateway and unavailable responses are connection errors, as the exchange couldn't be reached
+func StatusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusTooManyRequests:
+		return &RateLimitError{Status: resp.Status, RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ConnectionError(errors.New(resp.Status))
+	}
+	return errors.New(resp.Status)
+}
+
+// RetryAfter returns the wait asked for by a RateLimitError in err's chain
+// Returns false if err isn't from a rate limit response
+func RetryAfter(err error) (time.Duration, bool) {
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		return limited.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Wait from a Retry-After header of seconds or an HTTP date, zero if missing, bad, or past
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(header)
+	if err != nil || !date.After(now) {
+		return 0
+	}
+	return date.Sub(now)
+}
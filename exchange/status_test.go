@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusError(t *testing.T) {
+	var status int
+	var retry string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retry != "" {
+			w.Header().Set("Retry-After", retry)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	get := func() error {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return StatusError(resp)
+	}
+
+	status = http.StatusOK
+	if err := get(); err != nil {
+		t.Fatalf("Expected no error for 200, got %s", err)
+	}
+	status = http.StatusInternalServerError
+	err := get()
+	if err == nil || err.Error() != "500 Internal Server Error" {
+		t.Fatalf("Expected the status as the error, got %v", err)
+	}
+	if _, limited := RetryAfter(err); limited {
+		t.Fatal("500 should not be a rate limit error")
+	}
+
+	// Rate limit errors are found when wrapped
+	status, retry = http.StatusTooManyRequests, "3"
+	err = fmt.Errorf("Test SendOrder error: %w", get())
+	if wait, limited := RetryAfter(err); !limited || wait != 3*time.Second {
+		t.Fatalf("Expected a wait of 3s, got %s and %v", wait, limited)
+	}
+	if err.Error() != "Test SendOrder error: 429 Too Many Requests, retry after 3s" {
+		t.Fatalf("Wrong message %q", err)
+	}
+	retry = ""
+	if wait, limited := RetryAfter(get()); !limited || wait != 0 {
+		t.Fatalf("Expected a rate limit without a wait, got %s and %v", wait, limited)
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatal("429 should be ErrRateLimited")
+	}
+
+	// Unavailable exchanges are connection errors
+	status = http.StatusServiceUnavailable
+	if err := get(); !errors.Is(err, ErrConnection) || err.Error() != "503 Service Unavailable" {
+		t.Fatalf("Expected a connection error, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC)
+	headers := []struct {
+		header string
+		wait   time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"", 0},
+		{"soon", 0},
+		{"Wed, 01 Apr 2015 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 Apr 2015 11:59:00 GMT", 0},
+	}
+	for _, h := range headers {
+		if wait := retryAfter(h.header, now); wait != h.wait {
+			t.Errorf("Retry-After %q: expected %s, got %s", h.header, h.wait, wait)
+		}
+	}
+}
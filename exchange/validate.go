@@ -0,0 +1,69 @@
+// Constructor and order argument checks shared by exchange clients
+
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CheckCurrency returns an error unless currency is one of supported, ignoring case
+func CheckCurrency(currency string, supported ...string) error {
+	for _, s := range supported {
+		if strings.EqualFold(currency, s) {
+			return nil
+		}
+	}
+	names := make([]string, len(supported))
+	for i, s := range supported {
+		names[i] = strings.ToUpper(s)
+	}
+	return fmt.Errorf("currency must be %s", strings.Join(names, " or "))
+}
+
+// CheckKeys returns an error if some but not all credentials are supplied
+// Supplying none is allowed for public data only
+func CheckKeys(credentials ...string) error {
+	var supplied int
+	for _, c := range credentials {
+		if c != "" {
+			supplied++
+		}
+	}
+	if supplied > 0 && supplied < len(credentials) {
+		return errors.New("incomplete API credentials")
+	}
+	return nil
+}
+
+// CheckURL returns an error unless rawURL is absolute with one of schemes and has a host
+func CheckURL(rawURL string, schemes ...string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("no host in URL %q", rawURL)
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return nil
+		}
+	}
+	return fmt.Errorf("URL %q must use %s", rawURL, strings.Join(schemes, " or "))
+}
+
+// CheckOrder returns an ErrInvalidOrder error unless amount is positive, which NaN is not
+// Limit and post-only orders also need a positive price, while market orders take any price and may leave it out
+// Clients check orders with it before anything is sent to the exchange
+func CheckOrder(otype string, amount, price float64) error {
+	if !(amount > 0) {
+		return &Error{Kind: ErrInvalidOrder, Err: fmt.Errorf("amount %v must be positive", amount)}
+	}
+	if otype != "market" && !(price > 0) {
+		return &Error{Kind: ErrInvalidOrder, Err: fmt.Errorf("price %v must be positive", price)}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package exchange
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCheckCurrency(t *testing.T) {
+	if err := CheckCurrency("USD", "usd", "cny"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCurrency("eur", "usd", "cny"); err == nil || err.Error() != "currency must be USD or CNY" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	if CheckKeys("", "") != nil || CheckKeys("key", "secret") != nil {
+		t.Fatal("No credentials or all credentials should be allowed")
+	}
+	if CheckKeys("key", "") == nil || CheckKeys("key", "secret", "") == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	if err := CheckURL("wss://api.bitfinex.com/ws/2", "wss"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"api.bitfinex.com", "http://api.bitfinex.com", "https://", "%zz"} {
+		if CheckURL(bad, "https", "wss") == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	if err := CheckOrder("limit", .5, 250); err != nil {
+		t.Fatal(err)
+	}
+	for _, otype := range []string{"limit", "post_only"} {
+		for _, bad := range [][2]float64{{0, 250}, {-1, 250}, {math.NaN(), 250}, {.5, 0}, {.5, -250}} {
+			if err := CheckOrder(otype, bad[0], bad[1]); !errors.Is(err, ErrInvalidOrder) {
+				t.Errorf("Expected invalid %s order for %v, got %v", otype, bad, err)
+			}
+		}
+	}
+
+	// Market orders need only an amount
+	if err := CheckOrder("market", .5, 0); err != nil {
+		t.Fatalf("Market sell without a price should be accepted, got %v", err)
+	}
+	if err := CheckOrder("market", 0, 250); !errors.Is(err, ErrInvalidOrder) {
+		t.Fatalf("Market order without an amount should be invalid, got %v", err)
+	}
+	if err := CheckOrder("limit", 0, 250); err.Error() != "amount 0 must be positive" {
+		t.Fatalf("Wrong message %q", err)
+	}
+}
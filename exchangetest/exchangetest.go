@@ -0,0 +1,145 @@
+// Configurable fake exchange for testing order flow deterministically
+// Order and book methods are driven by callbacks, the rest by an embedded sim.Client
+
+package exchangetest
+
+import (
+	"bitfx/exchange"
+	"bitfx/sim"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SentOrder records an order passed to SendOrder
+type SentOrder struct {
+	Exchange      exchange.Interface
+	ID            int64
+	Action, Otype string
+	Amount, Price float64
+}
+
+// Journal records orders in the sequence they were sent, and can be shared by exchanges
+// The zero value is ready to use and safe to use concurrently
+type Journal struct {
+	orders []SentOrder
+	mutex  sync.Mutex
+}
+
+// Orders returns the orders recorded so far
+func (journal *Journal) Orders() []SentOrder {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return append([]SentOrder(nil), journal.orders...)
+}
+
+// Record an order
+func (journal *Journal) add(order SentOrder) {
+	journal.mutex.Lock()
+	journal.orders = append(journal.orders, order)
+	journal.mutex.Unlock()
+}
+
+// Exchange is a fake exchange.Interface whose order methods are set by its fields
+// Nil callbacks give an exchange filling every order in full on its first status check
+type Exchange struct {
+	*sim.Client
+	// Replaces SendOrder once the order is recorded, returning the order ID
+	SendOrderFunc func(ctx context.Context, order SentOrder) (int64, error)
+	// Replaces GetOrderStatus
+	GetOrderStatusFunc func(ctx context.Context, id int64) (exchange.Order, error)
+	// Replaces CancelOrder
+	CancelOrderFunc func(ctx context.Context, id int64) (bool, error)
+	// Replaces CommunicateBook, which otherwise replays the sim.Client books
+	CommunicateBookFunc func(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book
+	// Replaces PriceStep, which is otherwise zero
+	PriceStepFunc func(price float64) float64
+	// Amount reported filled for an order by the default GetOrderStatus, nil for a full fill
+	Fill func(order SentOrder) float64
+	// Also records orders sent, if not nil
+	Journal *Journal
+
+	journal Journal // Orders sent to this exchange
+	cancels []int64
+	mutex   sync.Mutex
+}
+
+// New returns a pointer to an Exchange trading btc in usd with no fees
+// Funds and short capacity are ample for any test order
+func New(name string, priority int) *Exchange {
+	return &Exchange{Client: sim.New(name, "btc", "usd", priority, 0, 1000000, 1000000, nil)}
+}
+
+// Orders returns the orders sent to this exchange
+func (exg *Exchange) Orders() []SentOrder {
+	return exg.journal.Orders()
+}
+
+// Cancels returns the IDs of orders cancelled on this exchange
+func (exg *Exchange) Cancels() []int64 {
+	exg.mutex.Lock()
+	defer exg.mutex.Unlock()
+	return append([]int64(nil), exg.cancels...)
+}
+
+// SendOrder records the order and sends it with SendOrderFunc if set
+// Order IDs otherwise count up from one
+func (exg *Exchange) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	exg.mutex.Lock()
+	order := SentOrder{exg, int64(len(exg.journal.Orders()) + 1), action, otype, amount, price}
+	exg.journal.add(order)
+	exg.mutex.Unlock()
+	if exg.Journal != nil {
+		exg.Journal.add(order)
+	}
+	if exg.SendOrderFunc != nil {
+		return exg.SendOrderFunc(ctx, order)
+	}
+	return order.ID, nil
+}
+
+// GetOrderStatus returns the status from GetOrderStatusFunc if set
+// Orders are otherwise dead with the amount from Fill
+func (exg *Exchange) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	if exg.GetOrderStatusFunc != nil {
+		return exg.GetOrderStatusFunc(ctx, id)
+	}
+	orders := exg.Orders()
+	if id < 1 || id > int64(len(orders)) {
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: unknown order %d", exg, id)
+	}
+	order := orders[id-1]
+	filled := order.Amount
+	if exg.Fill != nil {
+		filled = exg.Fill(order)
+	}
+	return exchange.Order{ID: id, Side: order.Action, Price: order.Price, Amount: order.Amount,
+		FilledAmount: filled, Status: "dead"}, nil
+}
+
+// CancelOrder records the cancel and sends it with CancelOrderFunc if set
+func (exg *Exchange) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	exg.mutex.Lock()
+	exg.cancels = append(exg.cancels, id)
+	exg.mutex.Unlock()
+	if exg.CancelOrderFunc != nil {
+		return exg.CancelOrderFunc(ctx, id)
+	}
+	return true, nil
+}
+
+// CommunicateBook sends books with CommunicateBookFunc if set, else replays the sim.Client books
+func (exg *Exchange) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	if exg.CommunicateBookFunc != nil {
+		return exg.CommunicateBookFunc(bookChan, doneChan)
+	}
+	return exg.Client.CommunicateBook(bookChan, doneChan)
+}
+
+// PriceStep returns the step from PriceStepFunc if set, else the sim.Client step
+func (exg *Exchange) PriceStep(price float64) float64 {
+	if exg.PriceStepFunc != nil {
+		return exg.PriceStepFunc(price)
+	}
+	return exg.Client.PriceStep(price)
+}
@@ -0,0 +1,55 @@
+package exchangetest
+
+import (
+	"bitfx/exchange"
+	"context"
+	"errors"
+	"testing"
+)
+
+var _ exchange.Interface = (*Exchange)(nil)
+
+func TestExchange(t *testing.T) {
+	var journal Journal
+	first, second := New("First", 1), New("Second", 2)
+	first.Journal, second.Journal = &journal, &journal
+	second.Fill = func(order SentOrder) float64 { return order.Amount / 2 }
+
+	ctx := context.Background()
+	if id, err := second.SendOrder(ctx, "sell", "limit", 2, 251); err != nil || id != 1 {
+		t.Fatalf("Expected order 1, got %d and %v", id, err)
+	}
+	if id, err := first.SendOrder(ctx, "buy", "limit", 1, 249); err != nil || id != 1 {
+		t.Fatalf("Expected order 1, got %d and %v", id, err)
+	}
+	orders := journal.Orders()
+	if len(orders) != 2 || orders[0].Exchange != second || orders[1].Exchange != first || orders[1].Amount != 1 {
+		t.Fatalf("Expected the orders in sequence, got %v", orders)
+	}
+
+	// Default status fills in full or by Fill
+	if order, err := first.GetOrderStatus(ctx, 1); err != nil || order.Status != "dead" || order.FilledAmount != 1 {
+		t.Fatalf("Expected a full fill, got %v and %v", order, err)
+	}
+	if order, _ := second.GetOrderStatus(ctx, 1); order.FilledAmount != 1 {
+		t.Fatalf("Expected half filled, got %v", order)
+	}
+	if _, err := first.GetOrderStatus(ctx, 2); err == nil {
+		t.Fatal("Expected an error for an unknown order")
+	}
+
+	// Callbacks replace the defaults
+	first.SendOrderFunc = func(ctx context.Context, order SentOrder) (int64, error) {
+		return 0, errors.New("SendOrder error: unavailable")
+	}
+	if _, err := first.SendOrder(ctx, "buy", "limit", 1, 249); err == nil || len(first.Orders()) != 2 {
+		t.Fatal("Expected the failed order to be recorded with its error")
+	}
+	if ok, err := first.CancelOrder(ctx, 2); !ok || err != nil || len(first.Cancels()) != 1 {
+		t.Fatal("Expected the cancel to be recorded")
+	}
+	first.PriceStepFunc = func(price float64) float64 { return .01 }
+	if first.PriceStep(249) != .01 || second.PriceStep(251) != 0 {
+		t.Fatal("Expected the price step from PriceStepFunc, else zero")
+	}
+}
@@ -1,27 +1,72 @@
 // Forex data API
-// Currently using yahoo finance
+// Defaults to yahoo finance, other sources can be set with SetProvider
 // http://finance.yahoo.com/webservice/v1/symbols/CNY=X/quote?format=json
 
 package forex
 
 import (
+	"bitfx/exchange"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // Forex data API URL
 const DATAURL = "http://finance.yahoo.com/webservice/v1/symbols/"
 
+// Provider defines a source of forex prices
+// Price returns units of the currency per USD
+type Provider interface {
+	Price(symbol string) (float64, error)
+}
+
+// Source of forex prices
+var provider Provider = yahoo{}
+
+// SetProvider sets the source of forex prices
+func SetProvider(p Provider) {
+	provider = p
+}
+
+// Clock for polling and cached quote ages
+var clock exchange.Clock = exchange.SystemClock{}
+
+// SetClock sets the clock quotes are polled and aged by, for tests
+func SetClock(c exchange.Clock) {
+	clock = c
+}
+
 // Quote contains forex quote information
 type Quote struct {
 	Price  float64
 	Symbol string
+	Age    time.Duration // Time since the price was fetched, nonzero if served from cache
 	Error  error
 }
 
+// Last good quote for each symbol
+type cachedQuote struct {
+	quote Quote
+	time  time.Time
+}
+
+var (
+	cacheTTL   = 5 * time.Minute
+	cache      = make(map[string]cachedQuote)
+	cacheMutex sync.Mutex
+)
+
+// SetCacheTTL sets how long the last good quote is served when a fetch fails
+func SetCacheTTL(ttl time.Duration) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	cacheTTL = ttl
+}
+
 // CommunicateFX sends the latest FX quote to the supplied channel
 func CommunicateFX(symbol string, fxChan chan<- Quote, doneChan <-chan bool) Quote {
 	// Initial quote to return
@@ -35,26 +80,65 @@ func CommunicateFX(symbol string, fxChan chan<- Quote, doneChan <-chan bool) Quo
 
 // HTTP read loop
 func runLoop(symbol string, fxChan chan<- Quote, doneChan <-chan bool) {
-	ticker := time.NewTicker(15 * time.Second)
+	ticker := clock.NewTicker(15 * time.Second)
 
 	for {
 		select {
 		case <-doneChan:
 			ticker.Stop()
 			return
-		case <-ticker.C:
+		case <-ticker.Chan():
 			fxChan <- getQuote(symbol)
 		}
 	}
 }
 
 // Returns quote for requested currency
+// Falls back to the last good quote within the cache TTL on errors
 func getQuote(symbol string) Quote {
+	quote := fetchQuote(symbol)
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	if quote.Error == nil {
+		cache[symbol] = cachedQuote{quote: quote, time: clock.Now()}
+		return quote
+	}
+	if cached, ok := cache[symbol]; ok && clock.Now().Sub(cached.time) <= cacheTTL {
+		cached.quote.Age = clock.Now().Sub(cached.time)
+		return cached.quote
+	}
+
+	return quote
+}
+
+// Returns a fresh quote from the provider
+func fetchQuote(symbol string) Quote {
+	price, err := provider.Price(symbol)
+	if err != nil {
+		return Quote{Symbol: symbol, Error: fmt.Errorf("Forex error %s", err)}
+	}
+	if price < .000001 {
+		return Quote{Symbol: symbol, Error: fmt.Errorf("Forex zero price error")}
+	}
+
+	return Quote{
+		Price:  price,
+		Symbol: symbol,
+		Error:  nil,
+	}
+}
+
+// Yahoo finance provider
+type yahoo struct{}
+
+// Price returns the yahoo finance price for the requested currency
+func (yahoo) Price(symbol string) (float64, error) {
 	// Get data
 	url := fmt.Sprintf("%s%s=x/quote?format=json", DATAURL, symbol)
 	data, err := get(url)
 	if err != nil {
-		return Quote{Error: fmt.Errorf("Forex error %s", err)}
+		return 0, err
 	}
 
 	// Unmarshal
@@ -70,20 +154,14 @@ func getQuote(symbol string) Quote {
 		} `json:"list"`
 	}{}
 	if err = json.Unmarshal(data, &response); err != nil {
-		return Quote{Error: fmt.Errorf("Forex error %s", err)}
+		return 0, err
 	}
-
-	// Pull out price
-	price := response.List.Resources[0].Resource.Fields.Price
-	if price < .000001 {
-		return Quote{Error: fmt.Errorf("Forex zero price error")}
+	if len(response.List.Resources) == 0 {
+		return 0, errors.New("no quote returned")
 	}
 
-	return Quote{
-		Price:  price,
-		Symbol: symbol,
-		Error:  nil,
-	}
+	// Pull out price
+	return response.List.Resources[0].Resource.Fields.Price, nil
 }
 
 // Unauthenticated GET
@@ -93,7 +171,7 @@ func get(url string) ([]byte, error) {
 		return []byte{}, err
 	}
 	if resp.StatusCode != 200 {
-		return []byte{}, fmt.Errorf(resp.Status)
+		return []byte{}, errors.New(resp.Status)
 	}
 	defer resp.Body.Close()
 
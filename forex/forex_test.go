@@ -1,9 +1,27 @@
 package forex
 
 import (
+	"bitfx/exchange"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+// Returns a mock HTTP server replaying a recorded response
+func testServer(t *testing.T, file string) *httptest.Server {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+}
+
 func TestGetQuote(t *testing.T) {
 	quote := getQuote("cny")
 	if quote.Error != nil {
@@ -25,3 +43,150 @@ func TestCommunicateFX(t *testing.T) {
 	t.Logf("Received quote")
 	// spew.Dump(quote)
 }
+
+// Test parsing a recorded OpenExchangeRates response
+func TestOpenExchangeRates(t *testing.T) {
+	server := testServer(t, "testdata/latest.json")
+	defer server.Close()
+	oxr := NewOpenExchangeRates("key")
+	oxr.baseURL = server.URL + "/"
+
+	price, err := oxr.Price("cny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(price-6.201372) > .000001 {
+		t.Fatal("CNY price should be 6.201372")
+	}
+	if _, err := oxr.Price("xyz"); err == nil {
+		t.Fatal("Expected error for missing currency")
+	}
+
+	// Zero prices are rejected by getQuote
+	defer SetProvider(provider)
+	SetProvider(oxr)
+	if quote := getQuote("cny"); quote.Error != nil || quote.Symbol != "cny" {
+		t.Fatal("Should return a CNY quote")
+	}
+	if quote := getQuote("xau"); quote.Error == nil {
+		t.Fatal("Expected zero price error")
+	}
+}
+
+// Test converting rates quoted against a base other than USD
+func TestOpenExchangeRatesBase(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"base":"EUR","rates":{"CNY":6.66,"EUR":1,"USD":1.074}}`))
+	}))
+	defer server.Close()
+	oxr := NewOpenExchangeRates("key")
+	oxr.baseURL = server.URL + "/"
+
+	price, err := oxr.Price("cny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(price-6.66/1.074) > .000001 {
+		t.Fatal("CNY price should be converted to units per USD")
+	}
+}
+
+// Provider returning a fixed price or an error
+type testProvider struct {
+	price float64
+	err   error
+}
+
+func (p *testProvider) Price(symbol string) (float64, error) {
+	return p.price, p.err
+}
+
+// Test serving cached quotes when the provider fails
+func TestCache(t *testing.T) {
+	defer SetProvider(provider)
+	defer SetCacheTTL(cacheTTL)
+	p := &testProvider{price: 6.2}
+	SetProvider(p)
+	SetCacheTTL(time.Minute)
+
+	if quote := getQuote("tst"); quote.Error != nil || quote.Age != 0 {
+		t.Fatal("Fresh quote should have zero age")
+	}
+	p.err = errors.New("provider down")
+	quote := getQuote("tst")
+	if quote.Error != nil || math.Abs(quote.Price-6.2) > .000001 {
+		t.Fatal("Should serve the cached quote")
+	}
+	if quote.Age <= 0 {
+		t.Fatal("Cached quote should report its age")
+	}
+
+	// Expired cache returns the error
+	SetCacheTTL(0)
+	if quote := getQuote("tst"); quote.Error == nil || quote.Symbol != "tst" {
+		t.Fatal("Expected error after TTL")
+	}
+}
+
+// Test cached quote ages and expiry on a manual clock
+func TestCacheAge(t *testing.T) {
+	defer SetProvider(provider)
+	defer SetCacheTTL(cacheTTL)
+	defer SetClock(clock)
+	p := &testProvider{price: 6.2}
+	SetProvider(p)
+	SetCacheTTL(time.Minute)
+	manual := exchange.NewManualClock(time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC))
+	SetClock(manual)
+
+	getQuote("age")
+	p.err = errors.New("provider down")
+	manual.Advance(30 * time.Second)
+	if quote := getQuote("age"); quote.Error != nil || quote.Age != 30*time.Second {
+		t.Fatalf("Expected the cached quote 30s old, got %+v", quote)
+	}
+	manual.Advance(30 * time.Second)
+	if quote := getQuote("age"); quote.Error != nil || quote.Age != time.Minute {
+		t.Fatalf("Expected the cached quote at the TTL, got %+v", quote)
+	}
+	manual.Advance(time.Second)
+	if quote := getQuote("age"); quote.Error == nil {
+		t.Fatal("Expected error past the TTL")
+	}
+
+	// A good quote is cached afresh
+	p.err = nil
+	getQuote("age")
+	p.err = errors.New("provider down")
+	manual.Advance(10 * time.Second)
+	if quote := getQuote("age"); quote.Error != nil || quote.Age != 10*time.Second {
+		t.Fatalf("Expected the new quote cached, got %+v", quote)
+	}
+}
+
+// Test the polling loop ticking on a manual clock
+func TestPollingClock(t *testing.T) {
+	defer SetProvider(provider)
+	defer SetClock(clock)
+	SetProvider(&testProvider{price: 6.2})
+	manual := exchange.NewManualClock(time.Date(2015, 4, 1, 12, 0, 0, 0, time.UTC))
+	SetClock(manual)
+
+	fxChan := make(chan Quote)
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	CommunicateFX("tst", fxChan, doneChan)
+	// The loop starts its ticker in its own goroutine, so the clock is moved until it fires
+	for i := 0; i < 100; i++ {
+		manual.Advance(15 * time.Second)
+		select {
+		case quote := <-fxChan:
+			if quote.Error != nil || quote.Price != 6.2 {
+				t.Fatalf("Wrong polled quote %+v", quote)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("No quote polled")
+}
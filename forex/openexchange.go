@@ -0,0 +1,59 @@
+// OpenExchangeRates forex provider
+// https://openexchangerates.org/api/latest.json?app_id=KEY
+
+package forex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OpenExchangeRates provides forex prices from openexchangerates.org
+type OpenExchangeRates struct {
+	key, baseURL string
+}
+
+// NewOpenExchangeRates returns a provider using the supplied app ID
+func NewOpenExchangeRates(key string) *OpenExchangeRates {
+	return &OpenExchangeRates{
+		key:     key,
+		baseURL: "https://openexchangerates.org/api/",
+	}
+}
+
+// Price returns the units of the requested currency per USD
+func (oxr *OpenExchangeRates) Price(symbol string) (float64, error) {
+	// Get data
+	url := fmt.Sprintf("%slatest.json?app_id=%s", oxr.baseURL, oxr.key)
+	data, err := get(url)
+	if err != nil {
+		return 0, err
+	}
+
+	// Unmarshal
+	var response struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err = json.Unmarshal(data, &response); err != nil {
+		return 0, err
+	}
+
+	// Rates are units of currency per base currency
+	rate, ok := response.Rates[strings.ToUpper(symbol)]
+	if !ok {
+		return 0, fmt.Errorf("no rate for %s", symbol)
+	}
+
+	// Convert to units per USD if quoted against another base
+	if strings.ToUpper(response.Base) != "USD" {
+		usd := response.Rates["USD"]
+		if usd < .000001 {
+			return 0, fmt.Errorf("no USD rate for base %s", response.Base)
+		}
+		rate /= usd
+	}
+
+	return rate, nil
+}
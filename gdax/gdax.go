@@ -0,0 +1,908 @@
+// Coinbase Exchange (GDAX) API
+
+package gdax
+
+import (
+	"bitfx/exchange"
+	"bitfx/logger"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Returned by the REST API for unknown orders
+var errNotFound = errors.New("404 Not Found")
+
+// Client contains all exchange information
+type Client struct {
+	key, secret, passphrase, symbol, currency                    string
+	name, product, baseURL, websocketURL                         string
+	priority, depth                                              int
+	position, makerFee, takerFee, maxPos, availShort, availFunds float64
+	currencyCode                                                 exchange.Currency
+	maxBackoff                                                   time.Duration
+	clock                                                        exchange.Clock // Paces reconnection attempts
+	httpClient                                                   *http.Client
+	orderIDs                                                     map[int64]string // GDAX order IDs by local ID
+	lastID                                                       int64
+	mutex                                                        sync.Mutex
+	logger                                                       *logger.Logger
+	feeMutex                                                     sync.Mutex
+	orderLimit                                                   exchange.RateLimiter // Limits orders and cancels per second
+	health                                                       exchange.Health      // Books sent
+	latency                                                      exchange.Latency     // Round trip of the last order request
+	done                                                         chan bool
+}
+
+// Local level2 book maintained from the feed
+type level struct {
+	price, amount float64
+}
+type localBook struct {
+	bids []level // Sorted by price high to low
+	asks []level // Sorted by price low to high
+}
+
+// WebSocket feed message format
+type message struct {
+	Type    string      `json:"type"`
+	Product string      `json:"product_id"`
+	Bids    [][2]string `json:"bids"`    // Snapshot price and amount
+	Asks    [][2]string `json:"asks"`    // Snapshot price and amount
+	Changes [][3]string `json:"changes"` // Update side, price, and amount
+	Message string      `json:"message"` // Error message
+}
+
+// New returns a pointer to a Client instance
+func New(key, secret, passphrase, symbol, currency string, priority int, fee, availShort, availFunds float64) (*Client, error) {
+	client := &Client{
+		key:          key,
+		secret:       secret,
+		passphrase:   passphrase,
+		symbol:       symbol,
+		currency:     currency,
+		name:         fmt.Sprintf("GDAX(%s)", currency),
+		product:      fmt.Sprintf("%s-%s", strings.ToUpper(symbol), strings.ToUpper(currency)),
+		baseURL:      "https://api.exchange.coinbase.com",
+		websocketURL: "wss://ws-feed.exchange.coinbase.com",
+		priority:     priority,
+		depth:        20,
+		makerFee:     fee,
+		takerFee:     fee,
+		logger:       logger.Default(),
+		availShort:   availShort,
+		availFunds:   availFunds,
+		currencyCode: exchange.USD,
+		maxBackoff:   60 * time.Second,
+		clock:        exchange.SystemClock{},
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		orderIDs:     make(map[int64]string),
+		done:         make(chan bool, 1),
+	}
+	if err := client.validate(); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", client, err)
+	}
+	return client, nil
+}
+
+// Check constructor arguments
+func (client *Client) validate() error {
+	if err := exchange.CheckCurrency(client.currency, "usd"); err != nil {
+		return err
+	}
+	if err := exchange.CheckKeys(client.key, client.secret, client.passphrase); err != nil {
+		return err
+	}
+	if err := exchange.CheckURL(client.baseURL, "https"); err != nil {
+		return err
+	}
+	return exchange.CheckURL(client.websocketURL, "wss")
+}
+
+// Done closes all connections
+func (client *Client) Done() {
+	client.done <- true
+}
+
+// String implements the Stringer interface
+func (client *Client) String() string {
+	return client.name
+}
+
+// Priority returns the exchange priority for order execution
+func (client *Client) Priority() int {
+	return client.priority
+}
+
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, safe to call while books are filtered
+func (client *Client) SetFees(maker, taker float64) {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetDepth sets the number of book levels sent to the user
+func (client *Client) SetDepth(depth int) {
+	client.depth = depth
+}
+
+// SetMaxBackoff sets the maximum delay between reconnection attempts
+func (client *Client) SetMaxBackoff(maxBackoff time.Duration) {
+	client.maxBackoff = maxBackoff
+}
+
+// SetClock sets the clock reconnection attempts are paced by, call before CommunicateBook
+func (client *Client) SetClock(clock exchange.Clock) {
+	client.clock = clock
+}
+
+// SetHTTPTimeout sets the time limit for HTTP requests
+func (client *Client) SetHTTPTimeout(timeout time.Duration) {
+	client.httpClient.Timeout = timeout
+}
+
+// SetPosition sets the exchange position
+func (client *Client) SetPosition(pos float64) {
+	client.position = pos
+}
+
+// Position returns the exchange position
+func (client *Client) Position() float64 {
+	return client.position
+}
+
+// Currency returns the exchange currency
+func (client *Client) Currency() string {
+	return client.currency
+}
+
+// CurrencyCode returns the exchange currency code
+func (client *Client) CurrencyCode() exchange.Currency {
+	return client.currencyCode
+}
+
+// SetMaxPos sets the exchange max position
+func (client *Client) SetMaxPos(maxPos float64) {
+	client.maxPos = maxPos
+}
+
+// MaxPos returns the exchange max position
+func (client *Client) MaxPos() float64 {
+	return client.maxPos
+}
+
+// AvailFunds returns the exchange available funds
+func (client *Client) AvailFunds() float64 {
+	return client.availFunds
+}
+
+// AvailShort returns the exchange quantity available for short selling
+func (client *Client) AvailShort() float64 {
+	return client.availShort
+}
+
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// LastLatency returns the round trip of the last order request, zero if none has been sent
+func (client *Client) LastLatency() time.Duration {
+	return client.latency.Last()
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity available for short selling
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill, fees are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	return filled
+}
+
+// CommunicateBook sends the latest available book data on the supplied channel
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	// Connect and subscribe to the level2 channel
+	ws, err := client.connect()
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: %s", client, err)}
+	}
+
+	// Build the local book from the snapshot
+	var local localBook
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			ws.Close()
+			return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: %s", client, err)}
+		}
+		if changed, err := local.apply(data); err != nil {
+			ws.Close()
+			return exchange.Book{Error: fmt.Errorf("%s CommunicateBook error: %s", client, err)}
+		} else if changed {
+			break
+		}
+	}
+	book := client.convertToBook(local)
+
+	// Run a read loop in new goroutine
+	go client.runLoop(ws, &local, bookChan, doneChan)
+
+	return book
+}
+
+// Connect to the WebSocket feed
+func (client *Client) connect() (*websocket.Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(client.websocketURL, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe to book updates, with heartbeats to detect a stale connection
+	subMsg := struct {
+		Type     string   `json:"type"`
+		Products []string `json:"product_ids"`
+		Channels []string `json:"channels"`
+	}{"subscribe", []string{client.product}, []string{"level2", "heartbeat"}}
+	if err := client.subscribe(ws, subMsg); err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// Send a subscription message, with a timeout for the write
+func (client *Client) subscribe(ws *websocket.Conn, subMsg interface{}) error {
+	if err := ws.SetWriteDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		return err
+	}
+	if err := ws.WriteJSON(subMsg); err != nil {
+		return err
+	}
+	return ws.SetWriteDeadline(time.Time{})
+}
+
+// Websocket read loop
+func (client *Client) runLoop(ws *websocket.Conn, local *localBook, bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	// Syncronize access to *websocket.Conn
+	receiveWS := make(chan *websocket.Conn)
+	reconnectWS := make(chan bool)
+	closeWS := make(chan bool)
+	go func() {
+	LOOP:
+		for {
+			select {
+			// Request to use websocket
+			case receiveWS <- ws:
+			// Request to reconnect websocket
+			case <-reconnectWS:
+				ws.Close()
+				// Keep trying on error with increasing delay
+				backoff := exchange.Backoff{Min: time.Second, Max: client.maxBackoff, Sleep: exchange.SleepOn(client.clock)}
+				backoff.Retry(func() (err error) {
+					ws, err = client.connect()
+					return err
+				}, func(err error) {
+					client.logger.Warnf("%s WebSocket error: %s", client, err)
+				})
+			// Request to close websocket
+			case <-closeWS:
+				ws.Close()
+				break LOOP
+			}
+		}
+	}()
+
+	// Read from websocket
+	dataChan := make(chan []byte)
+	go func() {
+		for {
+			// Heartbeats arrive every second
+			(<-receiveWS).SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := (<-receiveWS).ReadMessage()
+			if err != nil {
+				// Reconnect on error, a new snapshot replaces the local book
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
+				reconnectWS <- true
+			} else {
+				dataChan <- data
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-doneChan:
+			// End if notified
+			closeWS <- true
+			return
+		case <-client.done:
+			// End if notified
+			closeWS <- true
+			return
+		case data := <-dataChan:
+			changed, err := local.apply(data)
+			if err != nil {
+				client.logger.Errorf("%s WebSocket error: %s", client, err)
+				reconnectWS <- true
+				continue
+			}
+			if !changed {
+				continue
+			}
+			// Process data and send out to user
+			book := client.convertToBook(*local)
+			select {
+			case bookChan <- book:
+				client.health.Update(book)
+			case <-doneChan:
+				closeWS <- true
+				return
+			}
+		}
+	}
+}
+
+// Apply a feed message to the local book
+// Returns true if the book changed
+func (local *localBook) apply(data []byte) (bool, error) {
+	var msg message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return false, err
+	}
+
+	switch msg.Type {
+	case "snapshot":
+		// Replace the book
+		local.bids = local.bids[:0]
+		local.asks = local.asks[:0]
+		for _, item := range msg.Bids {
+			price, amount, err := parseLevel(item[0], item[1])
+			if err != nil {
+				return false, err
+			}
+			local.bids = append(local.bids, level{price, amount})
+		}
+		for _, item := range msg.Asks {
+			price, amount, err := parseLevel(item[0], item[1])
+			if err != nil {
+				return false, err
+			}
+			local.asks = append(local.asks, level{price, amount})
+		}
+		sort.Slice(local.bids, func(i, j int) bool { return local.bids[i].price > local.bids[j].price })
+		sort.Slice(local.asks, func(i, j int) bool { return local.asks[i].price < local.asks[j].price })
+		return true, nil
+	case "l2update":
+		for _, change := range msg.Changes {
+			price, amount, err := parseLevel(change[1], change[2])
+			if err != nil {
+				return false, err
+			}
+			if change[0] == "buy" {
+				local.bids = update(local.bids, price, amount, func(p float64) bool { return p <= price })
+			} else {
+				local.asks = update(local.asks, price, amount, func(p float64) bool { return p >= price })
+			}
+		}
+		return len(msg.Changes) > 0, nil
+	case "error":
+		return false, errors.New(msg.Message)
+	}
+
+	// Subscriptions and heartbeats don't change the book
+	return false, nil
+}
+
+// Set the amount at a price level, removing the level if amount is zero
+// atOrBeyond reports whether a price sorts at or after the updated price
+func update(levels []level, price, amount float64, atOrBeyond func(float64) bool) []level {
+	i := sort.Search(len(levels), func(i int) bool { return atOrBeyond(levels[i].price) })
+	found := i < len(levels) && levels[i].price == price
+	switch {
+	case found && amount == 0:
+		return append(levels[:i], levels[i+1:]...)
+	case found:
+		levels[i].amount = amount
+	case amount != 0:
+		levels = append(levels, level{})
+		copy(levels[i+1:], levels[i:])
+		levels[i] = level{price, amount}
+	}
+	return levels
+}
+
+// Parse a price and amount pair
+func parseLevel(priceStr, amountStr string) (float64, float64, error) {
+	price, err := strconv.ParseFloat(priceStr, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return price, amount, nil
+}
+
+// Convert the local book to an exchange.Book
+func (client *Client) convertToBook(local localBook) exchange.Book {
+	if len(local.bids) == 0 || len(local.asks) == 0 {
+		return exchange.Book{Error: fmt.Errorf("%s ConvertToBook error: empty book side", client)}
+	}
+
+	// Depth is bounded by the local book
+	numBids, numAsks := client.depth, client.depth
+	if len(local.bids) < numBids {
+		numBids = len(local.bids)
+	}
+	if len(local.asks) < numAsks {
+		numAsks = len(local.asks)
+	}
+
+	// Local book is already sorted
+	bids := make(exchange.BidItems, numBids)
+	for i := range bids {
+		bids[i].Price = local.bids[i].price
+		bids[i].Amount = local.bids[i].amount
+	}
+	asks := make(exchange.AskItems, numAsks)
+	for i := range asks {
+		asks[i].Price = local.asks[i].price
+		asks[i].Amount = local.asks[i].amount
+	}
+
+	return exchange.Book{
+		Exg:   client,
+		Time:  time.Now(),
+		Bids:  bids,
+		Asks:  asks,
+		Error: nil,
+	}
+}
+
+// Ticker returns the latest ticker data
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	// Send GET request
+	data, err := client.request(context.Background(), "GET", fmt.Sprintf("/products/%s/ticker", client.product), nil)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response struct {
+		Price  float64   `json:"price,string"`
+		Bid    float64   `json:"bid,string"`
+		Ask    float64   `json:"ask,string"`
+		Volume float64   `json:"volume,string"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+
+	return exchange.Ticker{
+		Last:   response.Price,
+		Bid:    response.Bid,
+		Ask:    response.Ask,
+		Volume: response.Volume,
+		Time:   response.Time,
+	}, nil
+}
+
+// Balances returns the available fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	// Send GET request
+	data, err := client.request(context.Background(), "GET", "/accounts", nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response []struct {
+		Currency  string  `json:"currency"`
+		Available float64 `json:"available,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err.Error())
+	}
+
+	var fiat, crypto float64
+	for _, account := range response {
+		switch strings.ToLower(account.Currency) {
+		case strings.ToLower(client.currency):
+			fiat = account.Available
+		case strings.ToLower(client.symbol):
+			crypto = account.Available
+		}
+	}
+
+	return fiat, crypto, nil
+}
+
+// AccountFees returns the maker and taker fee rates at the account's 30-day volume tier
+func (client *Client) AccountFees() (float64, float64, error) {
+	// Send GET request
+	data, err := client.request(context.Background(), "GET", "/fees", nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response struct {
+		MakerFeeRate float64 `json:"maker_fee_rate,string"`
+		TakerFeeRate float64 `json:"taker_fee_rate,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+
+	return response.MakerFeeRate, response.TakerFeeRate, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort is a flat position
+func (client *Client) ActualPosition() (float64, error) {
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort, nil
+}
+
+// MinOrderSize returns the smallest order amount the exchange accepts
+func (client *Client) MinOrderSize() float64 {
+	return .01
+}
+
+// RoundOrder rounds an order to what the exchange accepts
+// Prices have 2 decimals and amounts 8 decimals
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, 1e-8), exchange.RoundNearest(price, .01)
+}
+
+// PriceStep returns the tick size of .01
+func (client *Client) PriceStep(price float64) float64 {
+	return .01
+}
+
+// SupportsMarket returns true
+func (client *Client) SupportsMarket() bool {
+	return true
+}
+
+// SupportsPostOnly returns true, post-only orders are limit orders with the post_only flag
+func (client *Client) SupportsPostOnly() bool {
+	return true
+}
+
+// SetRateLimit limits orders and cancels sent per second, zero for no limit
+// Requests over the limit wait for their turn or their context
+func (client *Client) SetRateLimit(perSecond float64) {
+	client.orderLimit.SetRate(perSecond)
+}
+
+// SendOrder sends an order to the exchange
+// Post-only orders that would take liquidity are rejected by the exchange
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: rate limited, %s", client, err)
+	}
+	if action != "buy" && action != "sell" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"buy\" and \"sell\" actions supported", client)
+	}
+	if otype != "limit" && otype != "market" && otype != "post_only" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"limit\", \"market\", and \"post_only\" order types supported", client)
+	}
+	postOnly := otype == "post_only"
+	if postOnly {
+		otype = "limit"
+	}
+
+	// Create request struct
+	request := struct {
+		Product  string `json:"product_id"`
+		Side     string `json:"side"`
+		Type     string `json:"type"`
+		Size     string `json:"size"`
+		Price    string `json:"price,omitempty"`
+		PostOnly bool   `json:"post_only,omitempty"`
+	}{
+		client.product,
+		action,
+		otype,
+		strconv.FormatFloat(amount, 'f', -1, 64),
+		"",
+		postOnly,
+	}
+	if otype == "limit" {
+		request.Price = strconv.FormatFloat(price, 'f', -1, 64)
+	}
+
+	// Send POST request
+	defer client.latency.Observe(time.Now())
+	data, err := client.request(ctx, "POST", "/orders", request)
+	if err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.SentError(err))
+	}
+
+	// Unmarshal response
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %s", client, err.Error())
+	}
+	if response.ID == "" {
+		return 0, fmt.Errorf("%s SendOrder error: no order ID returned", client)
+	}
+
+	// GDAX order IDs are strings, map to a local ID
+	return client.localID(response.ID), nil
+}
+
+// Return the local ID for a GDAX order ID, assigning one if new
+func (client *Client) localID(orderID string) int64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for id, known := range client.orderIDs {
+		if known == orderID {
+			return id
+		}
+	}
+	client.lastID++
+	client.orderIDs[client.lastID] = orderID
+	return client.lastID
+}
+
+// Return the GDAX order ID for a local ID
+func (client *Client) orderID(id int64) (string, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	orderID, ok := client.orderIDs[id]
+	if !ok {
+		return "", fmt.Errorf("unknown order ID %d", id)
+	}
+	return orderID, nil
+}
+
+// Forget the GDAX order ID for a local ID, once the order is dead
+func (client *Client) forgetID(id int64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.orderIDs, id)
+}
+
+// CancelOrder cancels an order on the exchange
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: rate limited, %s", client, err)
+	}
+	orderID, err := client.orderID(id)
+	if err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %s", client, err.Error())
+	}
+
+	// Send DELETE request
+	defer client.latency.Observe(time.Now())
+	if _, err := client.request(ctx, "DELETE", "/orders/"+orderID, nil); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
+	}
+
+	return true, nil
+}
+
+// GetOrderStatus gets the status of an order on the exchange
+// Dead orders are forgotten, so later requests for them fail
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	// Create order to be returned
+	var order exchange.Order
+
+	orderID, err := client.orderID(id)
+	if err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
+	}
+
+	// Send GET request
+	defer client.latency.Observe(time.Now())
+	data, err := client.request(ctx, "GET", "/orders/"+orderID, nil)
+	if err == errNotFound {
+		// Orders cancelled without any fills are removed
+		order.Status = "dead"
+		client.forgetID(id)
+		return order, nil
+	}
+	if err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response struct {
+		Status     string  `json:"status"`
+		FilledSize float64 `json:"filled_size,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
+	}
+
+	// Status from exchange can be "pending", "open", "active", "done", or "rejected"
+	if response.Status == "done" || response.Status == "rejected" {
+		order.Status = "dead"
+		client.forgetID(id)
+	} else if response.Status == "open" || response.Status == "active" {
+		order.Status = "live"
+	} // else empty string is returned
+	order.FilledAmount = math.Abs(response.FilledSize)
+
+	return order, nil
+}
+
+// OpenOrders returns all live orders for the product in use
+// Orders not sent by this client are assigned local IDs
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	// Send GET request
+	data, err := client.request(ctx, "GET", "/orders?status=open&status=pending&product_id="+client.product, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response []struct {
+		ID         string  `json:"id"`
+		Side       string  `json:"side"`
+		Price      float64 `json:"price,string"`
+		Size       float64 `json:"size,string"`
+		FilledSize float64 `json:"filled_size,string"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, err.Error())
+	}
+
+	var orders []exchange.Order
+	for _, order := range response {
+		orders = append(orders, exchange.Order{
+			ID:           client.localID(order.ID),
+			Side:         order.Side,
+			Price:        order.Price,
+			Amount:       order.Size,
+			FilledAmount: order.FilledSize,
+			Status:       "live",
+		})
+	}
+
+	return orders, nil
+}
+
+// Construct signature for authentication
+// Signature = HMAC-SHA256(timestamp + method + path + body, base64 decoded secret) as base64
+func (client *Client) sign(timestamp, method, path string, body []byte) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(client.secret)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp + method + path))
+	h.Write(body)
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Authenticated request, payload is sent as JSON if not nil
+func (client *Client) request(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return []byte{}, err
+		}
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := client.sign(timestamp, method, path, body)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, client.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// HTTP headers:
+	// CB-ACCESS-KEY
+	// CB-ACCESS-SIGN
+	// CB-ACCESS-TIMESTAMP
+	// CB-ACCESS-PASSPHRASE
+	req.Header.Add("CB-ACCESS-KEY", client.key)
+	req.Header.Add("CB-ACCESS-SIGN", signature)
+	req.Header.Add("CB-ACCESS-TIMESTAMP", timestamp)
+	req.Header.Add("CB-ACCESS-PASSPHRASE", client.passphrase)
+	req.Header.Add("Content-Type", "application/json")
+
+	// Send request
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return []byte{}, exchange.ConnectionError(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode == 404 {
+		return []byte{}, errNotFound
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return []byte{}, exchange.StatusError(resp)
+	}
+	if resp.StatusCode != 200 {
+		// Include the exchange message if available
+		var response struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &response) == nil && response.Message != "" {
+			return []byte{}, messageError(resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, response.Message))
+		}
+		return []byte{}, exchange.StatusError(resp)
+	}
+
+	return data, nil
+}
+
+// Error for a GDAX error response, of a known kind where the status and message have one
+// Orders the exchange won't accept get a 400, as in "400 Bad Request: size is too small. Minimum size is 0.01"
+func messageError(status int, message string) error {
+	switch {
+	case strings.Contains(message, "Insufficient funds"):
+		return exchange.NewError(exchange.ErrInsufficientFunds, message)
+	case status == http.StatusBadRequest:
+		return exchange.NewError(exchange.ErrOrderRejected, message)
+	case status >= 500:
+		return exchange.NewError(exchange.ErrConnection, message)
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,369 @@
+package gdax
+
+import (
+	"bitfx/exchange"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
+var client = mustNew(New("", "", "", "btc", "usd", 2, 0.0025, 2, .1))
+
+// Returns the client, panicking on constructor errors
+func mustNew(client *Client, err error) *Client {
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Test constructor argument errors
+func TestNew(t *testing.T) {
+	if _, err := New("", "", "", "btc", "eur", 1, 0, 0, 0); err == nil || err.Error() != "GDAX(eur) New error: currency must be USD" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+	if _, err := New("key", "c2VjcmV0a2V5c2VjcmV0a2V5", "", "btc", "usd", 1, 0, 0, 0); err == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+}
+
+// Returns a mock HTTP server
+func testServer(code int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		fmt.Fprintln(w, body)
+	}))
+}
+
+// Returns a client pointed at the mock server
+func testClient(server *httptest.Server) *Client {
+	client := mustNew(New("key", "c2VjcmV0a2V5c2VjcmV0a2V5", "pass", "btc", "usd", 1, 0.0025, 0, 0))
+	client.baseURL = server.URL
+	return client
+}
+
+// Used for float equality
+func notEqual(f1, f2 float64) bool {
+	if math.Abs(f1-f2) > 0.000001 {
+		return true
+	}
+	return false
+}
+
+// Test maintaining the local book from a snapshot and updates
+func TestApply(t *testing.T) {
+	var local localBook
+	snapshot := `{"type":"snapshot","product_id":"BTC-USD","bids":[["250.10","1.5"],["250.30","2"],["250.20","0.5"]],"asks":[["250.50","1"],["250.40","3"]]}`
+	if changed, err := local.apply([]byte(snapshot)); err != nil || !changed {
+		t.Fatal("Snapshot should change the book")
+	}
+	if len(local.bids) != 3 || notEqual(local.bids[0].price, 250.30) || notEqual(local.bids[2].price, 250.10) {
+		t.Fatal("Bids not sorted properly")
+	}
+	if len(local.asks) != 2 || notEqual(local.asks[0].price, 250.40) {
+		t.Fatal("Asks not sorted properly")
+	}
+
+	// Insert, modify, and remove levels
+	update := `{"type":"l2update","product_id":"BTC-USD","changes":[["buy","250.25","4"],["buy","250.10","0"],["sell","250.40","1.25"],["sell","250.35","2"]]}`
+	if changed, err := local.apply([]byte(update)); err != nil || !changed {
+		t.Fatal("Update should change the book")
+	}
+	bids := []float64{250.30, 250.25, 250.20}
+	if len(local.bids) != len(bids) {
+		t.Fatal("Wrong number of bids")
+	}
+	for i := range bids {
+		if notEqual(local.bids[i].price, bids[i]) {
+			t.Fatal("Bids not updated properly")
+		}
+	}
+	asks := []float64{250.35, 250.40, 250.50}
+	if len(local.asks) != len(asks) {
+		t.Fatal("Wrong number of asks")
+	}
+	for i := range asks {
+		if notEqual(local.asks[i].price, asks[i]) {
+			t.Fatal("Asks not updated properly")
+		}
+	}
+	if notEqual(local.asks[1].amount, 1.25) {
+		t.Fatal("Ask amount not updated")
+	}
+
+	// Heartbeats don't change the book
+	if changed, err := local.apply([]byte(`{"type":"heartbeat"}`)); err != nil || changed {
+		t.Fatal("Heartbeat should not change the book")
+	}
+	if _, err := local.apply([]byte(`{"type":"error","message":"Failed to subscribe"}`)); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+// Test converting the local book with limited depth
+func TestConvertToBook(t *testing.T) {
+	client := mustNew(New("", "", "", "btc", "usd", 1, 0, 0, 0))
+	client.SetDepth(2)
+	local := localBook{
+		bids: []level{{250.3, 1}, {250.2, 1}, {250.1, 1}},
+		asks: []level{{250.4, 1}},
+	}
+	book := client.convertToBook(local)
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(book.Bids) != 2 || len(book.Asks) != 1 {
+		t.Fatal("Depth should be bounded")
+	}
+	if notEqual(book.Bids[0].Price, 250.3) || notEqual(book.Asks[0].Price, 250.4) {
+		t.Fatal("Wrong book prices")
+	}
+	if book := client.convertToBook(localBook{bids: local.bids}); book.Error == nil {
+		t.Fatal("Expected error for empty side")
+	}
+}
+
+// Test signing against an independent HMAC-SHA256 computation
+func TestSign(t *testing.T) {
+	client := Client{secret: "c2VjcmV0a2V5c2VjcmV0a2V5"}
+	signature, err := client.sign("1427811013", "POST", "/orders", []byte(`{"size":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != "TOjkivlmuF2pMysrh2J46cX+sp2FrjCypaN6QCztF7M=" {
+		t.Fatal("Wrong signature")
+	}
+}
+
+// Test retrieving ticker data with mock server
+func TestTicker(t *testing.T) {
+	body := `{"trade_id":4729088,"price":"333.99","size":"0.193","bid":"333.98","ask":"333.99","volume":"5957.11914015","time":"2015-11-14T20:46:03.511254Z"}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	ticker, err := client.Ticker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(ticker.Bid, 333.98) || notEqual(ticker.Ask, 333.99) || notEqual(ticker.Last, 333.99) {
+		t.Fatal("Wrong ticker prices")
+	}
+	if notEqual(ticker.Volume, 5957.11914015) {
+		t.Fatal("Wrong ticker volume")
+	}
+	if ticker.Time.Unix() != 1447533963 {
+		t.Fatal("Wrong ticker time")
+	}
+}
+
+// Test retrieving balances with mock server
+func TestBalances(t *testing.T) {
+	body := `[{"id":"a","currency":"USD","balance":"120.5","available":"100.5","hold":"20"},{"id":"b","currency":"BTC","balance":"3.0","available":"2.5","hold":"0.5"}]`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	fiat, crypto, err := client.Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(fiat, 100.5) {
+		t.Fatal("Fiat balance should be 100.5")
+	}
+	if notEqual(crypto, 2.5) {
+		t.Fatal("Crypto balance should be 2.5")
+	}
+}
+
+// Test retrieving the fee tier with mock server
+func TestAccountFees(t *testing.T) {
+	body := `{"maker_fee_rate":"0.0015","taker_fee_rate":"0.0025","usd_volume":"25000.00"}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	maker, taker, err := client.AccountFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(maker, .0015) || notEqual(taker, .0025) {
+		t.Fatalf("Expected fees of .0015 and .0025, got %v and %v", maker, taker)
+	}
+}
+
+// Test sending, checking, and cancelling an order with mock server
+func TestOrder(t *testing.T) {
+	orderID := "d0c5340b-6d6c-49d9-b567-48c4bfca13d2"
+	var sent struct {
+		Type     string `json:"type"`
+		PostOnly bool   `json:"post_only"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CB-ACCESS-KEY") != "key" || r.Header.Get("CB-ACCESS-PASSPHRASE") != "pass" || r.Header.Get("CB-ACCESS-SIGN") == "" {
+			w.WriteHeader(401)
+			fmt.Fprintln(w, `{"message":"invalid signature"}`)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/orders":
+			json.NewDecoder(r.Body).Decode(&sent)
+			fmt.Fprintf(w, `{"id":"%s","status":"pending"}`, orderID)
+		case r.Method == "GET" && r.URL.Path == "/orders/"+orderID:
+			fmt.Fprintln(w, `{"status":"open","filled_size":"0.5"}`)
+		case r.Method == "DELETE" && r.URL.Path == "/orders/"+orderID:
+			fmt.Fprintf(w, `["%s"]`, orderID)
+		case r.Method == "GET" && r.URL.Path == "/orders" && r.URL.Query().Get("product_id") == "BTC-USD":
+			fmt.Fprintf(w, `[{"id":"%s","side":"buy","price":"250","size":"2","filled_size":"0.5"},{"id":"b2e3a1c2-7f4e-4a43-9a5b-1c9d2c7e06e1","side":"sell","price":"260","size":"1","filled_size":"0"}]`, orderID)
+		default:
+			w.WriteHeader(404)
+			fmt.Fprintln(w, `{"message":"NotFound"}`)
+		}
+	}))
+	defer server.Close()
+	client := testClient(server)
+	ctx := context.Background()
+
+	id, err := client.SendOrder(ctx, "buy", "limit", 2, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.GetOrderStatus(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "live" || notEqual(order.FilledAmount, 0.5) {
+		t.Fatal("Order should be live with 0.5 filled")
+	}
+	if success, err := client.CancelOrder(ctx, id); err != nil || !success {
+		t.Fatal("Order should have been cancelled")
+	}
+	if _, err := client.GetOrderStatus(ctx, id+1); err == nil {
+		t.Fatal("Expected error for unknown order")
+	}
+
+	// Known orders keep their ID and others get new ones
+	orders, err := client.OpenOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].ID != id || orders[1].ID != id+1 {
+		t.Fatalf("Expected open orders %d and %d, got %v", id, id+1, orders)
+	}
+	if orders[1].Side != "sell" || notEqual(orders[1].Price, 260) || notEqual(orders[0].FilledAmount, .5) {
+		t.Fatal("Wrong open order details")
+	}
+
+	// Cancelled orders without fills are not found
+	client.orderIDs[id] = "removed"
+	order, err = client.GetOrderStatus(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "dead" || notEqual(order.FilledAmount, 0) {
+		t.Fatal("Removed order should be dead with nothing filled")
+	}
+	// And forgotten once dead
+	if _, known := client.orderIDs[id]; known {
+		t.Fatal("Dead order should be forgotten")
+	}
+
+	// Post-only orders are limit orders with the post_only flag
+	if _, err := client.SendOrder(ctx, "buy", "post_only", 2, 250); err != nil || sent.Type != "limit" || !sent.PostOnly {
+		t.Fatal("Post-only order should be sent as a post_only limit order")
+	}
+}
+
+// Test that GDAX error responses map to their kinds
+func TestMessageError(t *testing.T) {
+	var code int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		fmt.Fprintln(w, body)
+	}))
+	defer server.Close()
+	client := testClient(server)
+	responses := []struct {
+		code       int
+		body, want string
+		kind       error
+	}{
+		{400, `{"message":"Insufficient funds"}`, "400 Bad Request: Insufficient funds", exchange.ErrInsufficientFunds},
+		{400, `{"message":"size is too small. Minimum size is 0.01"}`, "400 Bad Request: size is too small. Minimum size is 0.01", exchange.ErrOrderRejected},
+		{503, `{"message":"service unavailable"}`, "503 Service Unavailable: service unavailable", exchange.ErrConnection},
+		{502, ``, "502 Bad Gateway", exchange.ErrConnection},
+		{401, `{"message":"invalid signature"}`, "401 Unauthorized: invalid signature", nil},
+	}
+	kinds := []error{exchange.ErrInsufficientFunds, exchange.ErrOrderRejected, exchange.ErrRateLimited, exchange.ErrConnection}
+	for _, test := range responses {
+		code, body = test.code, test.body
+		_, err := client.SendOrder(context.Background(), "buy", "limit", .001, 250)
+		if err == nil || !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("%d: expected error ending %q, got %v", test.code, test.want, err)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.kind) {
+				t.Errorf("%d: expected kind %v, got %v", test.code, test.kind, err)
+			}
+		}
+	}
+}
+
+// Test that invalid orders are rejected without a request
+func TestSendOrderInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Invalid order sent to %s", r.URL.Path)
+	}))
+	defer server.Close()
+	client := testClient(server)
+	for _, order := range [][2]float64{{0, 250}, {-1, 250}, {1, -250}} {
+		if _, err := client.SendOrder(context.Background(), "buy", "limit", order[0], order[1]); !errors.Is(err, exchange.ErrInvalidOrder) {
+			t.Errorf("Expected invalid order for %v, got %v", order, err)
+		}
+	}
+}
+
+func TestRoundOrder(t *testing.T) {
+	amount, price := client.RoundOrder(0.123456789, 333.985)
+	if amount != 0.12345678 || price != 333.99 {
+		t.Fatalf("Expected 0.12345678 at 333.99, got %v at %v", amount, price)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	if client.Priority() != 2 {
+		t.Fatal("Priority should be 2")
+	}
+}
+
+func TestFee(t *testing.T) {
+	// Both fees default to the fee supplied to New
+	if notEqual(client.TakerFee(), 0.0025) || notEqual(client.MakerFee(), 0.0025) {
+		t.Fatal("Maker and taker fees should be 0.0025")
+	}
+}
+
+func TestFeeAdjustedFill(t *testing.T) {
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2) || notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Fees in fiat should leave fills unchanged")
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	if client.Currency() != "usd" || client.product != "BTC-USD" {
+		t.Fatal("Currency should be usd")
+	}
+}
+
+func TestAvailShort(t *testing.T) {
+	if notEqual(client.AvailShort(), 2) {
+		t.Fatal("Available short should be 2")
+	}
+}
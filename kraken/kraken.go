@@ -0,0 +1,887 @@
+// Kraken exchange API
+
+package kraken
+
+import (
+	"bitfx/exchange"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client contains all exchange information
+type Client struct {
+	key, secret, symbol, currency, name, baseURL, pair           string
+	asset, fiat                                                  string // Kraken asset codes used in balances
+	priority, depth                                              int
+	position, makerFee, takerFee, maxPos, availShort, availFunds float64
+	currencyCode                                                 exchange.Currency
+	pollInterval                                                 time.Duration
+	clock                                                        exchange.Clock // Paces book polling
+	httpClient                                                   *http.Client
+	httpOnce                                                     sync.Once        // Creates httpClient on first use if not set
+	txids                                                        map[int64]string // Kraken order IDs by local ID
+	lastID                                                       int64
+	mutex                                                        sync.Mutex
+	feeMutex                                                     sync.Mutex
+	orderLimit                                                   exchange.RateLimiter // Limits orders and cancels per second
+	health                                                       exchange.Health      // Books sent
+	latency                                                      exchange.Latency     // Round trip of the last order request
+	lastNonce                                                    int64                // Last nonce, read and written atomically
+	done                                                         chan bool
+}
+
+// New returns a pointer to a Client instance
+func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64) (*Client, error) {
+	// Kraken uses XBT for bitcoin and prefixes crypto with X and fiat with Z
+	asset := strings.ToUpper(symbol)
+	if asset == "BTC" {
+		asset = "XBT"
+	}
+	asset = "X" + asset
+	fiat := "Z" + strings.ToUpper(currency)
+
+	client := &Client{
+		key:          key,
+		secret:       secret,
+		symbol:       symbol,
+		currency:     currency,
+		asset:        asset,
+		fiat:         fiat,
+		pair:         asset + fiat,
+		priority:     priority,
+		depth:        20,
+		makerFee:     fee,
+		takerFee:     fee,
+		availShort:   availShort,
+		availFunds:   availFunds,
+		currencyCode: exchange.USD,
+		name:         fmt.Sprintf("Kraken(%s)", currency),
+		baseURL:      "https://api.kraken.com",
+		pollInterval: time.Second,
+		clock:        exchange.SystemClock{},
+		txids:        make(map[int64]string),
+		done:         make(chan bool, 1),
+	}
+	if err := client.validate(); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", client, err)
+	}
+	return client, nil
+}
+
+// Check constructor arguments
+func (client *Client) validate() error {
+	if err := exchange.CheckCurrency(client.currency, "usd"); err != nil {
+		return err
+	}
+	if err := exchange.CheckKeys(client.key, client.secret); err != nil {
+		return err
+	}
+	return exchange.CheckURL(client.baseURL, "https")
+}
+
+// Done closes all connections
+func (client *Client) Done() {
+	client.done <- true
+}
+
+// String implements the Stringer interface
+func (client *Client) String() string {
+	return client.name
+}
+
+// Priority returns the exchange priority for order execution
+func (client *Client) Priority() int {
+	return client.priority
+}
+
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, safe to call while books are filtered
+func (client *Client) SetFees(maker, taker float64) {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetDepth sets the number of book levels requested from the exchange
+func (client *Client) SetDepth(depth int) {
+	client.depth = depth
+}
+
+// SetPollInterval sets the time between book requests
+// Kraken limits the rate of public requests
+func (client *Client) SetPollInterval(interval time.Duration) {
+	client.pollInterval = interval
+}
+
+// SetClock sets the clock book polling is paced by, call before CommunicateBook
+func (client *Client) SetClock(clock exchange.Clock) {
+	client.clock = clock
+}
+
+// SetHTTPTimeout sets the time limit for HTTP requests
+func (client *Client) SetHTTPTimeout(timeout time.Duration) {
+	client.getHTTPClient().Timeout = timeout
+}
+
+// Default time limit for HTTP requests
+const httpTimeout = 10 * time.Second
+
+// Return the client for HTTP requests, created with httpTimeout on first use if not set
+func (client *Client) getHTTPClient() *http.Client {
+	client.httpOnce.Do(func() {
+		if client.httpClient == nil {
+			client.httpClient = &http.Client{Timeout: httpTimeout}
+		}
+	})
+	return client.httpClient
+}
+
+// SetPosition sets the exchange position
+func (client *Client) SetPosition(pos float64) {
+	client.position = pos
+}
+
+// Position returns the exchange position
+func (client *Client) Position() float64 {
+	return client.position
+}
+
+// Currency returns the exchange currency
+func (client *Client) Currency() string {
+	return client.currency
+}
+
+// CurrencyCode returns the exchange currency code
+func (client *Client) CurrencyCode() exchange.Currency {
+	return client.currencyCode
+}
+
+// SetMaxPos sets the exchange max position
+func (client *Client) SetMaxPos(maxPos float64) {
+	client.maxPos = maxPos
+}
+
+// MaxPos returns the exchange max position
+func (client *Client) MaxPos() float64 {
+	return client.maxPos
+}
+
+// AvailFunds returns the exchange available funds
+func (client *Client) AvailFunds() float64 {
+	return client.availFunds
+}
+
+// AvailShort returns the exchange quantity available for short selling
+func (client *Client) AvailShort() float64 {
+	return client.availShort
+}
+
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// LastLatency returns the round trip of the last order request, zero if none has been sent
+func (client *Client) LastLatency() time.Duration {
+	return client.latency.Last()
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity available for short selling
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill, fees are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	return filled
+}
+
+// CommunicateBook sends the latest available book data on the supplied channel
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	// Initial book to return
+	book, _ := client.getBook()
+
+	// Run read loop in new goroutine
+	go client.runLoop(bookChan, doneChan)
+
+	return book
+}
+
+// HTTP read loop
+func (client *Client) runLoop(bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	// Used to compare timestamps
+	var oldTimestamps []float64
+	// Polls are skipped until then after a rate limit response
+	var resume time.Time
+	ticker := client.clock.NewTicker(client.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneChan:
+			return
+		case <-client.done:
+			return
+		case <-ticker.Chan():
+			if client.clock.Now().Before(resume) {
+				continue
+			}
+			book, newTimestamps := client.getBook()
+			if wait, limited := exchange.RetryAfter(book.Error); limited {
+				resume = client.clock.Now().Add(wait)
+			}
+			// Send out only if changed
+			if bookChanged(oldTimestamps, newTimestamps) {
+				select {
+				case bookChan <- book:
+					client.health.Update(book)
+				case <-doneChan:
+					return
+				}
+			}
+			oldTimestamps = newTimestamps
+		}
+	}
+}
+
+// Get book data with an HTTP request
+func (client *Client) getBook() (exchange.Book, []float64) {
+	// Send GET request
+	url := fmt.Sprintf("%s/0/public/Depth?pair=%s&count=%d", client.baseURL, client.pair, client.depth)
+	data, err := client.get(context.Background(), url)
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %w", client, err)}, nil
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %w", client, err)}, nil
+	}
+
+	// Unmarshal, items are price, amount, and timestamp
+	var response map[string]struct {
+		Bids [][3]json.Number `json:"bids"`
+		Asks [][3]json.Number `json:"asks"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, nil
+	}
+	if len(response) != 1 {
+		return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: expected one pair in response", client)}, nil
+	}
+
+	// Only one pair was requested
+	for _, pairData := range response {
+		// Depth is bounded by what the exchange returned
+		numBids, numAsks := client.depth, client.depth
+		if len(pairData.Bids) < numBids {
+			numBids = len(pairData.Bids)
+		}
+		if len(pairData.Asks) < numAsks {
+			numAsks = len(pairData.Asks)
+		}
+
+		// Translate into an exchange.Book
+		// Timestamps are bids followed by asks, used to compare books
+		timestamps := make([]float64, numBids+numAsks)
+		bids := make(exchange.BidItems, numBids)
+		asks := make(exchange.AskItems, numAsks)
+		for i := 0; i < numBids; i++ {
+			if bids[i].Price, bids[i].Amount, timestamps[i], err = parseItem(pairData.Bids[i]); err != nil {
+				return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, nil
+			}
+		}
+		for i := 0; i < numAsks; i++ {
+			if asks[i].Price, asks[i].Amount, timestamps[i+numBids], err = parseItem(pairData.Asks[i]); err != nil {
+				return exchange.Book{Error: fmt.Errorf("%s UpdateBook error: %s", client, err.Error())}, nil
+			}
+		}
+		sort.Sort(bids)
+		sort.Sort(asks)
+
+		// Return book and timestamps
+		return exchange.Book{
+			Exg:   client,
+			Time:  time.Now(),
+			Bids:  bids,
+			Asks:  asks,
+			Error: nil,
+		}, timestamps
+	}
+
+	return exchange.Book{}, nil
+}
+
+// Parse a book item of price, amount, and timestamp
+func parseItem(item [3]json.Number) (price, amount, timestamp float64, err error) {
+	if price, err = item[0].Float64(); err != nil {
+		return
+	}
+	if amount, err = item[1].Float64(); err != nil {
+		return
+	}
+	timestamp, err = item[2].Float64()
+	return
+}
+
+// Returns true if the book has changed
+func bookChanged(timestamps1, timestamps2 []float64) bool {
+	if len(timestamps1) != len(timestamps2) {
+		return true
+	}
+	for i := range timestamps1 {
+		if math.Abs(timestamps1[i]-timestamps2[i]) > .5 {
+			return true
+		}
+	}
+	return false
+}
+
+// Ticker returns the latest ticker data
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	// Send GET request
+	url := fmt.Sprintf("%s/0/public/Ticker?pair=%s", client.baseURL, client.pair)
+	data, err := client.get(context.Background(), url)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %w", client, err)
+	}
+
+	// Unmarshal, first item of each is the price or today's volume
+	var response map[string]struct {
+		Ask    []json.Number `json:"a"`
+		Bid    []json.Number `json:"b"`
+		Last   []json.Number `json:"c"`
+		Volume []json.Number `json:"v"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+	}
+	pairData, ok := response[client.pair]
+	if !ok || len(pairData.Ask) == 0 || len(pairData.Bid) == 0 || len(pairData.Last) == 0 || len(pairData.Volume) < 2 {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: incomplete response", client)
+	}
+
+	var ticker exchange.Ticker
+	for _, field := range []struct {
+		value *float64
+		item  json.Number
+	}{
+		{&ticker.Ask, pairData.Ask[0]},
+		{&ticker.Bid, pairData.Bid[0]},
+		{&ticker.Last, pairData.Last[0]},
+		{&ticker.Volume, pairData.Volume[1]}, // Trailing 24 hours
+	} {
+		if *field.value, err = field.item.Float64(); err != nil {
+			return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err.Error())
+		}
+	}
+	// Kraken doesn't provide a ticker time
+	ticker.Time = time.Now()
+
+	return ticker, nil
+}
+
+// Balances returns the available fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	// Send POST request
+	data, err := client.post(context.Background(), "/0/private/Balance", url.Values{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err.Error())
+	}
+
+	// Unmarshal response
+	var response map[string]float64
+	if err := unmarshalStrings(data, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %w", client, err)
+	}
+
+	return response[client.fiat], response[client.asset], nil
+}
+
+// AccountFees returns the maker and taker fees for the pair at the account's 30-day volume tier
+func (client *Client) AccountFees() (float64, float64, error) {
+	// Send POST request
+	values := url.Values{}
+	values.Set("pair", client.pair)
+	data, err := client.post(context.Background(), "/0/private/TradeVolume", values)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %w", client, err)
+	}
+
+	// Unmarshal response, fees are in percent
+	type fee struct {
+		Fee float64 `json:"fee,string"`
+	}
+	var response struct {
+		Fees      map[string]fee `json:"fees"`
+		FeesMaker map[string]fee `json:"fees_maker"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return 0, 0, fmt.Errorf("%s AccountFees error: %s", client, err.Error())
+	}
+	taker, ok := response.Fees[client.pair]
+	if !ok {
+		return 0, 0, fmt.Errorf("%s AccountFees error: no fee for %s", client, client.pair)
+	}
+	// Pairs without a maker schedule charge the taker fee
+	maker, ok := response.FeesMaker[client.pair]
+	if !ok {
+		maker = taker
+	}
+
+	return maker.Fee / 100, taker.Fee / 100, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort is a flat position
+func (client *Client) ActualPosition() (float64, error) {
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort, nil
+}
+
+// MinOrderSize returns the smallest order amount the exchange accepts
+func (client *Client) MinOrderSize() float64 {
+	if client.asset == "XXBT" {
+		return .01
+	}
+	return .1
+}
+
+// RoundOrder rounds an order to what the exchange accepts
+// Bitcoin prices have 1 decimal, other prices 2 decimals, and amounts 8 decimals
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, 1e-8), exchange.RoundNearest(price, client.PriceStep(price))
+}
+
+// PriceStep returns the tick size, .1 for bitcoin and .01 for others
+func (client *Client) PriceStep(price float64) float64 {
+	if client.asset == "XXBT" {
+		return .1
+	}
+	return .01
+}
+
+// SupportsMarket returns true
+func (client *Client) SupportsMarket() bool {
+	return true
+}
+
+// SupportsPostOnly returns true, post-only orders are limit orders with the post flag
+func (client *Client) SupportsPostOnly() bool {
+	return true
+}
+
+// SetRateLimit limits orders and cancels sent per second, zero for no limit
+// Requests over the limit wait for their turn or their context
+func (client *Client) SetRateLimit(perSecond float64) {
+	client.orderLimit.SetRate(perSecond)
+}
+
+// SendOrder sends an order to the exchange
+// Post-only orders that would take liquidity are rejected by the exchange
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: rate limited, %s", client, err)
+	}
+	if action != "buy" && action != "sell" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"buy\" and \"sell\" actions supported", client)
+	}
+	if otype != "limit" && otype != "market" && otype != "post_only" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"limit\", \"market\", and \"post_only\" order types supported", client)
+	}
+
+	// Construct parameters
+	values := url.Values{}
+	values.Set("pair", client.pair)
+	values.Set("type", action)
+	values.Set("volume", strconv.FormatFloat(amount, 'f', -1, 64))
+	if otype == "post_only" {
+		otype = "limit"
+		values.Set("oflags", "post")
+	}
+	values.Set("ordertype", otype)
+	if otype == "limit" {
+		values.Set("price", strconv.FormatFloat(price, 'f', -1, 64))
+	}
+
+	// Send POST request
+	defer client.latency.Observe(time.Now())
+	data, err := client.post(ctx, "/0/private/AddOrder", values)
+	if err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.SentError(err))
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.SentError(err))
+	}
+
+	// Unmarshal response
+	var response struct {
+		TxID []string `json:"txid"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %s", client, err.Error())
+	}
+	if len(response.TxID) == 0 {
+		return 0, fmt.Errorf("%s SendOrder error: no order ID returned", client)
+	}
+
+	// Kraken order IDs are strings, map to a local ID
+	return client.localID(response.TxID[0]), nil
+}
+
+// Return the local ID for a Kraken order ID, assigning one if new
+func (client *Client) localID(txid string) int64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for id, known := range client.txids {
+		if known == txid {
+			return id
+		}
+	}
+	client.lastID++
+	client.txids[client.lastID] = txid
+	return client.lastID
+}
+
+// Return the Kraken order ID for a local ID
+func (client *Client) txid(id int64) (string, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	txid, ok := client.txids[id]
+	if !ok {
+		return "", fmt.Errorf("unknown order ID %d", id)
+	}
+	return txid, nil
+}
+
+// Forget the Kraken order ID for a local ID, once the order is dead
+func (client *Client) forgetID(id int64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.txids, id)
+}
+
+// CancelOrder cancels an order on the exchange
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: rate limited, %s", client, err)
+	}
+	txid, err := client.txid(id)
+	if err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %s", client, err.Error())
+	}
+
+	// Send POST request
+	defer client.latency.Observe(time.Now())
+	values := url.Values{}
+	values.Set("txid", txid)
+	data, err := client.post(ctx, "/0/private/CancelOrder", values)
+	if err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: %s", client, err.Error())
+	}
+
+	return response.Count > 0, nil
+}
+
+// GetOrderStatus gets the status of an order on the exchange
+// Dead orders are forgotten, so later requests for them fail
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	// Create order to be returned
+	var order exchange.Order
+
+	txid, err := client.txid(id)
+	if err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
+	}
+
+	// Send POST request
+	defer client.latency.Observe(time.Now())
+	values := url.Values{}
+	values.Set("txid", txid)
+	data, err := client.post(ctx, "/0/private/QueryOrders", values)
+	if err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %w", client, err)
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response map[string]struct {
+		Status  string  `json:"status"`
+		VolExec float64 `json:"vol_exec,string"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, err.Error())
+	}
+	orderData, ok := response[txid]
+	if !ok {
+		return order, fmt.Errorf("%s GetOrderStatus error: order %s not found", client, txid)
+	}
+
+	// Status from exchange can be "pending", "open", "closed", "canceled", or "expired"
+	if orderData.Status == "closed" || orderData.Status == "canceled" || orderData.Status == "expired" {
+		order.Status = "dead"
+		client.forgetID(id)
+	} else if orderData.Status == "open" {
+		order.Status = "live"
+	} // else empty string is returned
+	order.FilledAmount = math.Abs(orderData.VolExec)
+
+	return order, nil
+}
+
+// OpenOrders returns all live orders for the pair in use
+// Orders not sent by this client are assigned local IDs
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	// Send POST request
+	data, err := client.post(ctx, "/0/private/OpenOrders", url.Values{})
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %w", client, err)
+	}
+	result, err := parseResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %w", client, err)
+	}
+
+	// Unmarshal response
+	var response struct {
+		Open map[string]struct {
+			Descr struct {
+				Pair  string  `json:"pair"`
+				Type  string  `json:"type"`
+				Price float64 `json:"price,string"`
+			} `json:"descr"`
+			Vol     float64 `json:"vol,string"`
+			VolExec float64 `json:"vol_exec,string"`
+		} `json:"open"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, err.Error())
+	}
+
+	// Order descriptions use the short pair name, e.g. XBTUSD
+	altname := client.asset[1:] + client.fiat[1:]
+	txids := make([]string, 0, len(response.Open))
+	for txid, orderData := range response.Open {
+		if orderData.Descr.Pair == altname {
+			txids = append(txids, txid)
+		}
+	}
+	sort.Strings(txids)
+	var orders []exchange.Order
+	for _, txid := range txids {
+		orderData := response.Open[txid]
+		orders = append(orders, exchange.Order{
+			ID:           client.localID(txid),
+			Side:         orderData.Descr.Type,
+			Price:        orderData.Descr.Price,
+			Amount:       orderData.Vol,
+			FilledAmount: orderData.VolExec,
+			Status:       "live",
+		})
+	}
+
+	return orders, nil
+}
+
+// Return the result from a Kraken response, or an error if any are reported
+func parseResponse(data []byte) (json.RawMessage, error) {
+	var response struct {
+		Error  []string        `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Error) > 0 {
+		return nil, responseError(response.Error)
+	}
+	return response.Result, nil
+}
+
+// Error for the errors in a Kraken response, of the kind of the first one with a known kind
+// Kraken errors are a severity and category before the message, as in "EOrder:Insufficient funds"
+func responseError(errs []string) error {
+	message := strings.Join(errs, ", ")
+	for _, e := range errs {
+		switch {
+		case strings.HasSuffix(e, ":Rate limit exceeded") || e == "EGeneral:Temporary lockout":
+			return exchange.NewError(exchange.ErrRateLimited, message)
+		case e == "EOrder:Insufficient funds" || e == "EOrder:Insufficient margin":
+			return exchange.NewError(exchange.ErrInsufficientFunds, message)
+		case strings.HasPrefix(e, "EOrder:") || e == "EGeneral:Invalid arguments":
+			return exchange.NewError(exchange.ErrOrderRejected, message)
+		case strings.HasPrefix(e, "EService:"):
+			return exchange.NewError(exchange.ErrConnection, message)
+		}
+	}
+	return errors.New(message)
+}
+
+// Unmarshal a Kraken result of string-encoded numbers into a map
+func unmarshalStrings(data []byte, values *map[string]float64) error {
+	result, err := parseResponse(data)
+	if err != nil {
+		return err
+	}
+	var strValues map[string]string
+	if err := json.Unmarshal(result, &strValues); err != nil {
+		return err
+	}
+	*values = make(map[string]float64)
+	for key, str := range strValues {
+		if (*values)[key], err = strconv.ParseFloat(str, 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Construct signature for authentication
+// Signature = HMAC-SHA512(path + SHA256(nonce + postdata), base64 decoded secret) as base64
+func (client *Client) sign(path, nonce, postData string) (string, error) {
+	secret, err := base64.StdEncoding.DecodeString(client.secret)
+	if err != nil {
+		return "", err
+	}
+	sha := sha256.Sum256([]byte(nonce + postData))
+	h := hmac.New(sha512.New, secret)
+	h.Write(append([]byte(path), sha[:]...))
+
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Nonce for a signed request, which Kraken requires to increase with each request
+// Nanoseconds on the local clock, or one past the last nonce so concurrent requests never share one
+func (client *Client) nonce() string {
+	for {
+		last := atomic.LoadInt64(&client.lastNonce)
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&client.lastNonce, last, next) {
+			return strconv.FormatInt(next, 10)
+		}
+	}
+}
+
+// Authenticated POST
+func (client *Client) post(ctx context.Context, path string, values url.Values) ([]byte, error) {
+	nonce := client.nonce()
+	values.Set("nonce", nonce)
+	postData := values.Encode()
+	signature, err := client.sign(path, nonce, postData)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", client.baseURL+path, strings.NewReader(postData))
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// HTTP headers:
+	// API-Key
+	// API-Sign
+	req.Header.Add("API-Key", client.key)
+	req.Header.Add("API-Sign", signature)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Send POST
+	resp, err := client.getHTTPClient().Do(req)
+	if err != nil {
+		return []byte{}, exchange.ConnectionError(err)
+	}
+	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
+		return []byte{}, err
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Unauthenticated GET
+func (client *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return []byte{}, err
+	}
+	resp, err := client.getHTTPClient().Do(req)
+	if err != nil {
+		return []byte{}, exchange.ConnectionError(err)
+	}
+	defer resp.Body.Close()
+	if err := exchange.StatusError(resp); err != nil {
+		return []byte{}, err
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,467 @@
+package kraken
+
+import (
+	"bitfx/exchange"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
+var client = mustNew(New("", "", "ltc", "usd", 2, 0.0026, 2, .1))
+
+// Returns the client, panicking on constructor errors
+func mustNew(client *Client, err error) *Client {
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Test constructor argument errors
+func TestNew(t *testing.T) {
+	if _, err := New("", "", "btc", "eur", 1, 0, 0, 0); err == nil || err.Error() != "Kraken(eur) New error: currency must be USD" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+	if _, err := New("key", "", "btc", "usd", 1, 0, 0, 0); err == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+}
+
+// Returns a mock HTTP server
+func testServer(code int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		fmt.Fprintln(w, body)
+	}))
+}
+
+// Returns a client pointed at the mock server
+func testClient(server *httptest.Server) *Client {
+	client := mustNew(New("key", "a2V5", "ltc", "usd", 1, 0.0026, 0, 0))
+	client.baseURL = server.URL
+	return client
+}
+
+// Used for float equality
+func notEqual(f1, f2 float64) bool {
+	if math.Abs(f1-f2) > 0.000001 {
+		return true
+	}
+	return false
+}
+
+// Test the pair and asset codes used by Kraken
+func TestPair(t *testing.T) {
+	if client.pair != "XLTCZUSD" {
+		t.Fatal("Pair should be XLTCZUSD")
+	}
+	btc := mustNew(New("", "", "btc", "usd", 1, 0, 0, 0))
+	if btc.pair != "XXBTZUSD" || btc.asset != "XXBT" || btc.fiat != "ZUSD" {
+		t.Fatal("Bitcoin should use the XBT asset code")
+	}
+}
+
+// Test signing against the example in the Kraken API documentation
+func TestSign(t *testing.T) {
+	client := Client{secret: "kQH5HW/8p1uGOVjbgWA7FunAmGO8lsSUXNsu3eow76sz84Q18fWxnyRzBHCd3pd5nE9qa99HAZtuZuj6F1huXg=="}
+	signature, err := client.sign("/0/private/AddOrder", "1616492376594", "nonce=1616492376594&ordertype=limit&pair=XBTUSD&price=37500&type=buy&volume=1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != "4/dpxb3iT4tp/ZCVEwSnEsLxx0bqyhLpdfOpc6fn7OR8+UClSV5n9E6aSS8MPtnRfp32bAb0nmbRn6H8ndwLUQ==" {
+		t.Fatal("Wrong signature")
+	}
+}
+
+// Test retrieving book data with mock server
+func TestGetBook(t *testing.T) {
+	body := `{"error":[],"result":{"XLTCZUSD":{"asks":[["1.650","118.359",1427807969],["1.649","8.225",1427811011],["1.651","56.309",1427810969]],"bids":[["1.639","13.62",1427810280],["1.6391","53.08",1427811013]]}}}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	book, timestamps := client.getBook()
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(timestamps) != 5 || len(book.Bids) != 2 || len(book.Asks) != 3 {
+		t.Fatal("Should have returned only the available items")
+	}
+	if notEqual(book.Bids[0].Price, 1.6391) || notEqual(book.Bids[1].Price, 1.639) {
+		t.Fatal("Bids not sorted properly")
+	}
+	if notEqual(book.Asks[0].Price, 1.649) || notEqual(book.Asks[2].Price, 1.651) {
+		t.Fatal("Asks not sorted properly")
+	}
+	if bookChanged(timestamps, timestamps) || !bookChanged(timestamps, timestamps[:4]) {
+		t.Fatal("Book change detection failed")
+	}
+}
+
+// Test that exchange errors are reported
+func TestGetBookError(t *testing.T) {
+	server := testServer(200, `{"error":["EQuery:Unknown asset pair"]}`)
+	defer server.Close()
+	client := testClient(server)
+	if book, _ := client.getBook(); book.Error == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+// Test that books are polled on the client's clock
+func TestPollClock(t *testing.T) {
+	body := `{"error":[],"result":{"XLTCZUSD":{"asks":[["1.650","118.359",1427807969]],"bids":[["1.639","13.62",1427810280]]}}}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	clock := exchange.NewManualClock(time.Now())
+	client.SetClock(clock)
+	bookChan := make(chan exchange.Book)
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	if book := client.CommunicateBook(bookChan, doneChan); book.Error != nil {
+		t.Fatal(book.Error)
+	}
+
+	select {
+	case <-bookChan:
+		t.Fatal("No book should be polled before the interval")
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	select {
+	case book := <-bookChan:
+		if book.Error != nil {
+			t.Fatal(book.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a book polled once the clock passes the interval")
+	}
+}
+
+// Test that Kraken errors map to their kinds
+func TestResponseError(t *testing.T) {
+	responses := []struct {
+		errs []string
+		kind error
+	}{
+		{[]string{"EOrder:Insufficient funds"}, exchange.ErrInsufficientFunds},
+		{[]string{"EOrder:Order minimum not met"}, exchange.ErrOrderRejected},
+		{[]string{"EGeneral:Invalid arguments"}, exchange.ErrOrderRejected},
+		{[]string{"EAPI:Rate limit exceeded"}, exchange.ErrRateLimited},
+		{[]string{"EGeneral:Temporary lockout"}, exchange.ErrRateLimited},
+		{[]string{"EService:Unavailable"}, exchange.ErrConnection},
+		{[]string{"EQuery:Unknown asset pair"}, nil},
+		{[]string{"EQuery:Unknown asset pair", "EOrder:Insufficient funds"}, exchange.ErrInsufficientFunds},
+	}
+	kinds := []error{exchange.ErrInsufficientFunds, exchange.ErrOrderRejected, exchange.ErrRateLimited, exchange.ErrConnection}
+	for _, test := range responses {
+		err := responseError(test.errs)
+		if err.Error() != strings.Join(test.errs, ", ") {
+			t.Errorf("%v: wrong message %q", test.errs, err)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.kind) {
+				t.Errorf("%v: expected kind %v, got %v", test.errs, test.kind, err)
+			}
+		}
+	}
+
+	// Kinds are kept through order errors
+	server := testServer(200, `{"error":["EOrder:Insufficient funds"]}`)
+	defer server.Close()
+	if _, err := testClient(server).SendOrder(context.Background(), "buy", "limit", 2, 1.5); !errors.Is(err, exchange.ErrInsufficientFunds) {
+		t.Fatalf("Expected insufficient funds, got %v", err)
+	}
+}
+
+// Test retrieving ticker data with mock server
+func TestTicker(t *testing.T) {
+	body := `{"error":[],"result":{"XLTCZUSD":{"a":["1.649","1","1.000"],"b":["1.6391","2","2.000"],"c":["1.645","0.5"],"v":["1000.5","52114.9"]}}}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	ticker, err := client.Ticker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(ticker.Bid, 1.6391) || notEqual(ticker.Ask, 1.649) || notEqual(ticker.Last, 1.645) {
+		t.Fatal("Wrong ticker prices")
+	}
+	if notEqual(ticker.Volume, 52114.9) {
+		t.Fatal("Wrong ticker volume")
+	}
+}
+
+// Test retrieving balances with mock server
+func TestBalances(t *testing.T) {
+	body := `{"error":[],"result":{"ZUSD":"100.5","XLTC":"2.5","XXBT":"1.0"}}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	fiat, crypto, err := client.Balances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(fiat, 100.5) {
+		t.Fatal("Fiat balance should be 100.5")
+	}
+	if notEqual(crypto, 2.5) {
+		t.Fatal("Crypto balance should be 2.5")
+	}
+}
+
+// Test nonces of concurrent requests with mock server
+func TestConcurrentNonces(t *testing.T) {
+	var (
+		seen  = make(map[int64]bool)
+		mutex sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := strconv.ParseInt(r.FormValue("nonce"), 10, 64)
+		if err != nil {
+			t.Error(err)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if seen[nonce] {
+			t.Errorf("Nonce %d sent twice", nonce)
+		}
+		seen[nonce] = true
+		fmt.Fprintln(w, `{"error":[],"result":{"ZUSD":"100.5","XLTC":"2.5"}}`)
+	}))
+	defer server.Close()
+	client := testClient(server)
+
+	// Each sender's nonces increase, and no two senders share one
+	const senders, sends = 20, 10
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for j := 0; j < sends; j++ {
+				nonce, _ := strconv.ParseInt(client.nonce(), 10, 64)
+				if nonce <= last {
+					t.Errorf("Nonce %d not above the previous %d", nonce, last)
+				}
+				last = nonce
+				if _, _, err := client.Balances(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != senders*sends {
+		t.Fatalf("Expected %d requests, got %d", senders*sends, len(seen))
+	}
+}
+
+// Test retrieving the fee tier with mock server
+func TestAccountFees(t *testing.T) {
+	body := `{"error":[],"result":{"currency":"ZUSD","volume":"51234.5","fees":{"XLTCZUSD":{"fee":"0.2400","minfee":"0.1000","maxfee":"0.2600"}},"fees_maker":{"XLTCZUSD":{"fee":"0.1400","minfee":"0.0000","maxfee":"0.1600"}}}}`
+	server := testServer(200, body)
+	defer server.Close()
+	client := testClient(server)
+	maker, taker, err := client.AccountFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(maker, .0014) || notEqual(taker, .0024) {
+		t.Fatalf("Expected fees of .0014 and .0024, got %v and %v", maker, taker)
+	}
+	client.pair = "XXBTZUSD"
+	if _, _, err := client.AccountFees(); err == nil {
+		t.Fatal("Expected error for missing pair")
+	}
+}
+
+// Test sending, checking, and cancelling an order with mock server
+func TestOrder(t *testing.T) {
+	var oflags string
+	status := "open"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("API-Key") != "key" || r.Header.Get("API-Sign") == "" {
+			fmt.Fprintln(w, `{"error":["EAPI:Invalid key"]}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/0/private/AddOrder":
+			if r.FormValue("pair") != "XLTCZUSD" || r.FormValue("price") != "1.5" || r.FormValue("nonce") == "" {
+				fmt.Fprintln(w, `{"error":["EGeneral:Invalid arguments"]}`)
+				return
+			}
+			oflags = r.FormValue("oflags")
+			fmt.Fprintln(w, `{"error":[],"result":{"descr":{"order":"buy 2 LTCUSD @ limit 1.5"},"txid":["OAVY7T-MV5VK-KHDF5X"]}}`)
+		case "/0/private/QueryOrders":
+			fmt.Fprintf(w, `{"error":[],"result":{"OAVY7T-MV5VK-KHDF5X":{"status":"%s","vol":"2","vol_exec":"0.5"}}}`, status)
+		case "/0/private/CancelOrder":
+			fmt.Fprintln(w, `{"error":[],"result":{"count":1}}`)
+		case "/0/private/OpenOrders":
+			fmt.Fprintln(w, `{"error":[],"result":{"open":{"OAVY7T-MV5VK-KHDF5X":{"descr":{"pair":"LTCUSD","type":"buy","price":"1.5"},"vol":"2","vol_exec":"0.5"},"OB5VMB-B4U2U-DK2WRW":{"descr":{"pair":"LTCUSD","type":"sell","price":"1.7"},"vol":"1","vol_exec":"0"},"OQCLML-BW3P3-BUCMWZ":{"descr":{"pair":"XBTUSD","type":"buy","price":"250"},"vol":"1","vol_exec":"0"}}}}`)
+		}
+	}))
+	defer server.Close()
+	client := testClient(server)
+	ctx := context.Background()
+
+	id, err := client.SendOrder(ctx, "buy", "limit", 2, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.GetOrderStatus(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "live" || notEqual(order.FilledAmount, 0.5) {
+		t.Fatal("Order should be live with 0.5 filled")
+	}
+	if success, err := client.CancelOrder(ctx, id); err != nil || !success {
+		t.Fatal("Order should have been cancelled")
+	}
+	if _, err := client.GetOrderStatus(ctx, id+1); err == nil {
+		t.Fatal("Expected error for unknown order")
+	}
+
+	// Known orders keep their ID and others for the pair get new ones
+	orders, err := client.OpenOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].ID != id || orders[1].ID != id+1 {
+		t.Fatalf("Expected open orders %d and %d, got %v", id, id+1, orders)
+	}
+	if orders[1].Side != "sell" || notEqual(orders[1].Price, 1.7) || notEqual(orders[0].FilledAmount, .5) {
+		t.Fatal("Wrong open order details")
+	}
+
+	// Post-only orders are limit orders with the post flag
+	if oflags != "" {
+		t.Fatal("Limit order should not be post-only")
+	}
+	if _, err := client.SendOrder(ctx, "buy", "post_only", 2, 1.5); err != nil || oflags != "post" {
+		t.Fatal("Post-only order should be sent with the post flag")
+	}
+
+	// Dead orders are forgotten
+	status = "canceled"
+	if order, err := client.GetOrderStatus(ctx, id); err != nil || order.Status != "dead" {
+		t.Fatalf("Order should be dead, got %v, %v", order, err)
+	}
+	if _, known := client.txids[id]; known {
+		t.Fatal("Dead order should be forgotten")
+	}
+}
+
+// Test that rate limit responses report how long to wait
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	client := testClient(server)
+
+	_, err := client.SendOrder(context.Background(), "buy", "limit", 2, 1.5)
+	if wait, limited := exchange.RetryAfter(err); !limited || wait != 5*time.Second {
+		t.Fatalf("Expected a 5s rate limit, got %v", err)
+	}
+	if book, _ := client.getBook(); book.Error == nil {
+		t.Fatal("Expected book error")
+	} else if _, limited := exchange.RetryAfter(book.Error); !limited {
+		t.Fatalf("Book error should keep the rate limit, got %v", book.Error)
+	}
+}
+
+// Test that invalid orders are rejected without a request
+func TestSendOrderInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Invalid order sent to %s", r.URL.Path)
+	}))
+	defer server.Close()
+	client := testClient(server)
+	for _, order := range [][2]float64{{0, 1.5}, {-2, 1.5}, {2, 0}} {
+		if _, err := client.SendOrder(context.Background(), "buy", "limit", order[0], order[1]); !errors.Is(err, exchange.ErrInvalidOrder) {
+			t.Errorf("Expected invalid order for %v, got %v", order, err)
+		}
+	}
+}
+
+func TestRoundOrder(t *testing.T) {
+	amount, price := client.RoundOrder(1.123456789, 1.6391)
+	if amount != 1.12345678 || price != 1.64 {
+		t.Fatalf("Expected 1.12345678 at 1.64, got %v at %v", amount, price)
+	}
+	btc := mustNew(New("", "", "btc", "usd", 1, 0, 0, 0))
+	if _, price := btc.RoundOrder(1, 37499.96); price != 37500 {
+		t.Fatalf("Expected bitcoin price of 37500, got %v", price)
+	}
+	if client.PriceStep(1.6391) != .01 || btc.PriceStep(37500) != .1 {
+		t.Fatal("Wrong price steps")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	if client.Priority() != 2 {
+		t.Fatal("Priority should be 2")
+	}
+}
+
+func TestFee(t *testing.T) {
+	// Both fees default to the fee supplied to New
+	if notEqual(client.TakerFee(), 0.0026) || notEqual(client.MakerFee(), 0.0026) {
+		t.Fatal("Maker and taker fees should be 0.0026")
+	}
+}
+
+func TestFeeAdjustedFill(t *testing.T) {
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2) || notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Fees in fiat should leave fills unchanged")
+	}
+}
+
+func TestUpdatePositon(t *testing.T) {
+	if notEqual(client.Position(), 0) {
+		t.Fatal("Should start with zero position")
+	}
+	client.SetPosition(10)
+	if notEqual(client.Position(), 10) {
+		t.Fatal("Position should have updated to 10")
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	if client.Currency() != "usd" {
+		t.Fatal("Currency should be usd")
+	}
+}
+
+func TestCurrencyCode(t *testing.T) {
+	if client.CurrencyCode() != 0 {
+		t.Fatal("Currency code should be 0")
+	}
+}
+
+func TestAvailFunds(t *testing.T) {
+	if notEqual(client.AvailFunds(), 0.1) {
+		t.Fatal("Available funds should be 0.1")
+	}
+}
+
+func TestAvailShort(t *testing.T) {
+	if notEqual(client.AvailShort(), 2) {
+		t.Fatal("Available short should be 2")
+	}
+}
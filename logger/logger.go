@@ -0,0 +1,136 @@
+// Leveled logging for exchange clients and the trading system
+// Messages below the minimum level are dropped, the rest are prefixed with their level
+
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Level is a message severity
+type Level int
+
+// Levels in increasing severity
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// String implements the Stringer interface
+func (level Level) String() string {
+	if level < Debug || level > Error {
+		return fmt.Sprintf("Level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the level named by s, ignoring case
+// An empty name is Info
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return Info, nil
+	}
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes messages at or above its level
+type Logger struct {
+	out   *log.Logger // Nil writes through the standard log package
+	level Level
+	mutex sync.Mutex
+}
+
+// New returns a pointer to a Logger writing to w with standard flags
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{out: log.New(w, "", log.LstdFlags), level: level}
+}
+
+// Shared by clients and callers that aren't given their own Logger
+var std = &Logger{level: Info}
+
+// Default returns the shared Logger, which writes through the standard log package
+// Its output follows log.SetOutput
+func Default() *Logger {
+	return std
+}
+
+// SetLevel sets the minimum level written
+func (l *Logger) SetLevel(level Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+}
+
+// Level returns the minimum level written
+func (l *Logger) Level() Level {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.level
+}
+
+// Write a message if at or above the minimum level
+func (l *Logger) output(level Level, format string, v ...interface{}) {
+	if level < l.Level() {
+		return
+	}
+	msg := level.String() + " " + fmt.Sprintf(format, v...)
+	// Call depth skips output and the level method
+	if l.out == nil {
+		log.Output(3, msg)
+		return
+	}
+	l.out.Output(3, msg)
+}
+
+// Debugf logs a message at Debug level
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.output(Debug, format, v...)
+}
+
+// Infof logs a message at Info level
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.output(Info, format, v...)
+}
+
+// Warnf logs a message at Warn level
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.output(Warn, format, v...)
+}
+
+// Errorf logs a message at Error level
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.output(Error, format, v...)
+}
+
+// Debugf logs a message at Debug level to the default Logger
+func Debugf(format string, v ...interface{}) {
+	std.output(Debug, format, v...)
+}
+
+// Infof logs a message at Info level to the default Logger
+func Infof(format string, v ...interface{}) {
+	std.output(Info, format, v...)
+}
+
+// Warnf logs a message at Warn level to the default Logger
+func Warnf(format string, v ...interface{}) {
+	std.output(Warn, format, v...)
+}
+
+// Errorf logs a message at Error level to the default Logger
+func Errorf(format string, v ...interface{}) {
+	std.output(Error, format, v...)
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Warn)
+	l.Debugf("debug %d", 1)
+	l.Infof("info %d", 2)
+	l.Warnf("reconnect %d", 3)
+	l.Errorf("unmarshal %d", 4)
+	out := buf.String()
+	if strings.Contains(out, "debug") || strings.Contains(out, "info") {
+		t.Fatal("Messages below the level should be dropped")
+	}
+	if !strings.Contains(out, "WARN reconnect 3") || !strings.Contains(out, "ERROR unmarshal 4") {
+		t.Fatalf("Expected leveled messages, got %q", out)
+	}
+
+	buf.Reset()
+	l.SetLevel(Debug)
+	l.Debugf("debug")
+	if !strings.Contains(buf.String(), "DEBUG debug") {
+		t.Fatal("Lowered level should write debug messages")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]Level{"": Info, "debug": Debug, "WARN": Warn, "Error": Error} {
+		if level, err := ParseLevel(s); err != nil || level != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", s, level, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("Expected error for unknown level")
+	}
+}
+
+// The default Logger follows the standard log package output
+func TestDefault(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer Default().SetLevel(Default().Level())
+	Default().SetLevel(Info)
+	Infof("trade %s", "buy")
+	Debugf("hidden")
+	if out := buf.String(); !strings.Contains(out, "INFO trade buy") || strings.Contains(out, "hidden") {
+		t.Fatalf("Expected only the info message, got %q", out)
+	}
+}
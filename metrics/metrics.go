@@ -0,0 +1,234 @@
+// Metrics exposed in the Prometheus text format
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registered metrics, written in registration order
+var (
+	registry []collector
+	regMutex sync.Mutex
+)
+
+// Implemented by every metric type
+type collector interface {
+	write(w io.Writer)
+}
+
+// Add a metric to the registry
+func register(c collector) {
+	regMutex.Lock()
+	defer regMutex.Unlock()
+	registry = append(registry, c)
+}
+
+// Handler returns an HTTP handler serving all registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(Bytes())
+	})
+}
+
+// Bytes returns all registered metrics in the text format
+func Bytes() []byte {
+	regMutex.Lock()
+	defer regMutex.Unlock()
+	var buf bytes.Buffer
+	for _, c := range registry {
+		c.write(&buf)
+	}
+	return buf.Bytes()
+}
+
+// Write the HELP and TYPE lines
+func writeHeader(w io.Writer, name, help, mtype string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, mtype)
+}
+
+// Format a value as Prometheus expects
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Escape a label value
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name, help string
+	value      float64
+	mutex      sync.Mutex
+}
+
+// NewGauge returns a registered Gauge
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+// Set sets the gauge value
+func (g *Gauge) Set(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value = value
+}
+
+// Value returns the gauge value
+func (g *Gauge) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.Value()))
+}
+
+// GaugeVec is a set of gauges distinguished by one label
+type GaugeVec struct {
+	name, help, label string
+	values            map[string]float64
+	mutex             sync.Mutex
+}
+
+// NewGaugeVec returns a registered GaugeVec
+func NewGaugeVec(name, help, label string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+// Set sets the gauge value for a label value
+func (g *GaugeVec) Set(labelValue string, value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[labelValue] = value
+}
+
+// Value returns the gauge value for a label value
+func (g *GaugeVec) Value(labelValue string) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.values[labelValue]
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	labels := make([]string, 0, len(g.values))
+	for label := range g.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", g.name, g.label, escape(label), formatValue(g.values[label]))
+	}
+}
+
+// Counter is a value that only increases
+type Counter struct {
+	name, help string
+	value      float64
+	mutex      sync.Mutex
+}
+
+// NewCounter returns a registered Counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds a non-negative amount to the counter
+func (c *Counter) Add(value float64) {
+	if value < 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value += value
+}
+
+// Value returns the counter value
+func (c *Counter) Value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.Value()))
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	name, help string
+	buckets    []float64 // Upper bounds, sorted
+	counts     []uint64  // Per bucket, not cumulative
+	sum        float64
+	count      uint64
+	mutex      sync.Mutex
+}
+
+// NewHistogram returns a registered Histogram with the supplied bucket upper bounds
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{name: name, help: help, buckets: bounds, counts: make([]uint64, len(bounds))}
+	register(h)
+	return h
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatValue(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "A test gauge")
+	g.Set(2.5)
+	if g.Value() != 2.5 {
+		t.Fatal("Gauge should be 2.5")
+	}
+	if !strings.Contains(string(Bytes()), "# TYPE test_gauge gauge\ntest_gauge 2.5\n") {
+		t.Fatal("Gauge not written properly")
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := NewGaugeVec("test_position", "A test gauge vector", "exchange")
+	g.Set("OKCoin(usd)", -1)
+	g.Set(`Bad"Name`, 3)
+	out := string(Bytes())
+	if !strings.Contains(out, "test_position{exchange=\"OKCoin(usd)\"} -1\n") {
+		t.Fatal("Labeled gauge not written properly")
+	}
+	if !strings.Contains(out, `test_position{exchange="Bad\"Name"} 3`) {
+		t.Fatal("Label value not escaped")
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_total", "A test counter")
+	c.Inc()
+	c.Add(2)
+	c.Add(-5)
+	if c.Value() != 3 {
+		t.Fatal("Counter should be 3")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_spread", "A test histogram", []float64{1, 0, .5})
+	for _, value := range []float64{-1, .25, .5, .75, 2} {
+		h.Observe(value)
+	}
+	want := `test_spread_bucket{le="0"} 1
+test_spread_bucket{le="0.5"} 3
+test_spread_bucket{le="1"} 4
+test_spread_bucket{le="+Inf"} 5
+test_spread_sum 2.5
+test_spread_count 5
+`
+	if !strings.Contains(string(Bytes()), want) {
+		t.Fatal("Histogram not written properly")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGauge("test_handler", "A gauge served over HTTP").Set(1)
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "test_handler 1\n") {
+		t.Fatal("Handler should serve registered metrics")
+	}
+}
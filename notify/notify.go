@@ -0,0 +1,129 @@
+// Notifications of trading events for unattended operation
+// Webhook posts JSON compatible with Slack incoming webhooks
+
+package notify
+
+import (
+	"bitfx/logger"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Notifier sends messages about trading events
+// Notify must not block
+type Notifier interface {
+	Notify(message string)
+}
+
+// Discard is a Notifier dropping every message
+type Discard struct{}
+
+// Notify drops the message
+func (Discard) Notify(message string) {}
+
+// Webhook posts messages as {"text": message} to a URL
+// Messages are queued for a background sender and dropped if the queue is full
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+	queue      chan string
+	stop       context.CancelFunc // Abandons sending
+	done       sync.WaitGroup
+	logger     *logger.Logger
+}
+
+// Messages held while the sender is busy
+const queueSize = 100
+
+// Max wait for Close to send the queued messages
+var closeTimeout = 10 * time.Second
+
+// NewWebhook returns a pointer to a Webhook posting to rawURL
+func NewWebhook(rawURL string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Webhook error: %s", err)
+	}
+	if u.Host == "" || u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("Webhook error: URL %q must be http or https with a host", rawURL)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	webhook := &Webhook{
+		url:        rawURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		queue:      make(chan string, queueSize),
+		stop:       stop,
+		logger:     logger.Default(),
+	}
+	webhook.done.Add(1)
+	go webhook.run(ctx)
+	return webhook, nil
+}
+
+// Notify queues a message, dropping it if the queue is full
+func (webhook *Webhook) Notify(message string) {
+	select {
+	case webhook.queue <- message:
+	default:
+		webhook.logger.Warnf("Webhook queue full, notification dropped: %s", message)
+	}
+}
+
+// Close sends any queued messages and stops the sender
+// Messages not sent within closeTimeout in total are dropped
+// Notify must not be called after Close
+func (webhook *Webhook) Close() {
+	deadline := time.AfterFunc(closeTimeout, webhook.stop)
+	defer deadline.Stop()
+	close(webhook.queue)
+	webhook.done.Wait()
+	webhook.stop()
+}
+
+// Send queued messages until closed, dropping them once ctx is done
+func (webhook *Webhook) run(ctx context.Context) {
+	defer webhook.done.Done()
+	var dropped int
+	for message := range webhook.queue {
+		if ctx.Err() != nil {
+			dropped++
+			continue
+		}
+		if err := webhook.post(ctx, message); err != nil {
+			webhook.logger.Errorf("Webhook error: %s", err)
+		}
+	}
+	if dropped > 0 {
+		webhook.logger.Warnf("Webhook closed before sending, %d notifications dropped", dropped)
+	}
+}
+
+// Post a message
+func (webhook *Webhook) post(ctx context.Context, message string) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhook.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error(err)
+		}
+		received = append(received, msg.Text)
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhook(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.Notify("first")
+	webhook.Notify("second")
+	webhook.Close()
+	if len(received) != 2 || received[0] != "first" || received[1] != "second" {
+		t.Fatalf("Expected both messages in order, got %v", received)
+	}
+}
+
+func TestWebhookCloseTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { closeTimeout = timeout }(closeTimeout)
+	closeTimeout = 100 * time.Millisecond
+
+	// A stalled endpoint holds up Close for closeTimeout in all, not per message
+	stalled := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	webhook, err := NewWebhook(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < queueSize; i++ {
+		webhook.Notify("stalled")
+	}
+	start := time.Now()
+	webhook.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close took %v, expected about %v", elapsed, closeTimeout)
+	}
+}
+
+func TestNewWebhook(t *testing.T) {
+	for _, bad := range []string{"hooks.slack.com/services/x", "ftp://hooks.slack.com", "%zz"} {
+		if _, err := NewWebhook(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
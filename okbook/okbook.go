@@ -6,15 +6,24 @@ import (
 	"bitfx/exchange"
 	"bitfx/forex"
 	"bitfx/okcoin"
+	"bitfx/terminal"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"time"
 )
 
 var (
-	ok  = okcoin.New("", "", "ltc", "cny", 0, 0, 0, 0)
-	cny float64
+	ok         *okcoin.Client
+	cny        float64
+	book       exchange.Book // Last good book, trimmed for display
+	status     = "waiting"   // Feed status shown in the header
+	lastUpdate time.Time     // Arrival time of the last good book
+)
+
+const (
+	displayDepth = 10               // Levels shown on each side
+	staleAfter   = 10 * time.Second // Time without a book before the feed shows as stale
 )
 
 func main() {
@@ -25,6 +34,9 @@ func main() {
 	}
 	log.SetOutput(logFile)
 	log.Println("Starting new run")
+	if ok, err = okcoin.New("", "", "ltc", "cny", 0, 0, 0, 0); err != nil {
+		log.Fatal(err)
+	}
 	fxChan := make(chan forex.Quote)
 	fxDoneChan := make(chan bool, 1)
 	quote := forex.CommunicateFX("cny", fxChan, fxDoneChan)
@@ -34,18 +46,40 @@ func main() {
 	cny = quote.Price
 
 	bookChan := make(chan exchange.Book)
-	if book := ok.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book := ok.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		log.Fatal(book.Error)
 	}
 	inputChan := make(chan rune)
 	go checkStdin(inputChan)
+	// Refresh the update age while the feed is quiet
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
 Loop:
 	for {
 		select {
-		case book := <-bookChan:
-			printBook(book)
+		case newBook := <-bookChan:
+			if newBook.Error != nil {
+				log.Println(newBook.Error)
+				status = fmt.Sprintf("error: %s", newBook.Error)
+			} else {
+				book = newBook.TrimDepth(displayDepth)
+				status = "connected"
+				lastUpdate = time.Now()
+			}
+			printBook()
+		case quote := <-fxChan:
+			if quote.Error != nil {
+				log.Println(quote.Error)
+			} else {
+				cny = quote.Price
+			}
+			printBook()
+		case <-ticker.C:
+			printBook()
 		case <-inputChan:
+			doneChan <- true
 			ok.Done()
 			fxDoneChan <- true
 			break Loop
@@ -61,29 +95,32 @@ func checkStdin(inputChan chan<- rune) {
 	inputChan <- ch
 }
 
-// Print book data from each exchange
-func printBook(book exchange.Book) {
-	clearScreen()
-	if book.Error != nil {
-		log.Println(book.Error)
-	} else {
-		fmt.Println("----------------------------")
-		fmt.Printf("%-10s%-10s%8s\n", " Bid", "  Ask", "Size ")
-		fmt.Println("----------------------------")
-		for i := range book.Asks {
-			item := book.Asks[len(book.Asks)-1-i]
-			fmt.Printf("%-10s%-10.4f%8.2f\n", "", item.Price/cny, item.Amount)
-		}
-		for _, item := range book.Bids {
-			fmt.Printf("%-10.4f%-10.2s%8.2f\n", item.Price/cny, "", item.Amount)
-		}
-		fmt.Println("----------------------------")
+// Print the feed status header and the last good book, in CNY and converted to USD
+func printBook() {
+	terminal.Clear()
+	printHeader()
+	fmt.Println("------------------------------------------------")
+	fmt.Printf("%-10s%-10s%-10s%-10s%8s\n", " Bid CNY", " Bid USD", "  Ask CNY", "  Ask USD", "Size ")
+	fmt.Println("------------------------------------------------")
+	for i := range book.Asks {
+		item := book.Asks[len(book.Asks)-1-i]
+		fmt.Printf("%-20s%-10.4f%-10.4f%8.2f\n", "", item.Price, item.Price/cny, item.Amount)
+	}
+	for _, item := range book.Bids {
+		fmt.Printf("%-10.4f%-10.4f%-20s%8.2f\n", item.Price, item.Price/cny, "", item.Amount)
 	}
+	fmt.Println("------------------------------------------------")
 }
 
-// Clear the terminal between prints
-func clearScreen() {
-	c := exec.Command("clear")
-	c.Stdout = os.Stdout
-	c.Run()
+// Print connection status, last update age, and the forex rate in use
+func printHeader() {
+	age := "no book yet"
+	if !lastUpdate.IsZero() {
+		elapsed := time.Since(lastUpdate)
+		age = fmt.Sprintf("updated %.1fs ago", elapsed.Seconds())
+		if elapsed > staleAfter && status == "connected" {
+			age += ", stale"
+		}
+	}
+	fmt.Printf("%s | %s | %s | CNY/USD %.4f\n", ok, status, age, cny)
 }
@@ -0,0 +1,74 @@
+// OKCoin error codes and their meanings
+
+package okcoin
+
+import (
+	"bitfx/exchange"
+	"errors"
+)
+
+// Reasons for OKCoin's documented error codes
+// Codes -1 and -2 are set here for WebSocket write and unmarshal failures
+var codeMessages = map[int64]string{
+	-2:    "Bad WebSocket message",
+	-1:    "WebSocket write failed",
+	10000: "Required field can not be null",
+	10001: "Request frequency too high",
+	10002: "System error",
+	10003: "Not in request list, please try again later",
+	10004: "IP not allowed to access the resource",
+	10005: "SecretKey does not exist",
+	10006: "Partner does not exist",
+	10007: "Signature does not match",
+	10008: "Illegal parameter",
+	10009: "Order does not exist",
+	10010: "Insufficient funds",
+	10011: "Amount too low",
+	10012: "Only btc_usd and ltc_usd supported",
+	10013: "Only https requests supported",
+	10014: "Order price must be between 0 and 1,000,000",
+	10015: "Order price differs from current market price too much",
+	10016: "Insufficient coins balance",
+	10017: "API authorization error",
+	10018: "Borrow amount less than lower limit",
+	10019: "Loan agreement not checked",
+	10020: "Rate cannot exceed 1%",
+	10021: "Rate cannot be less than 0.01%",
+	10023: "Failed to get latest ticker",
+	10024: "Balance not sufficient",
+	10025: "Quota is full, cannot borrow temporarily",
+	10026: "Loan and margin cannot be withdrawn",
+	10035: "Insufficient BTC/LTC",
+	10049: "No more than 50 unfilled small orders allowed",
+	10050: "Order cannot be cancelled more than once",
+	10100: "User account frozen",
+	10216: "Non-available API",
+}
+
+// Kinds of the codes callers can react to
+var codeKinds = map[int64]error{
+	-1:    exchange.ErrConnection,
+	10001: exchange.ErrRateLimited,
+	10009: exchange.ErrOrderNotFound,
+	10010: exchange.ErrInsufficientFunds,
+	10016: exchange.ErrInsufficientFunds,
+	10024: exchange.ErrInsufficientFunds,
+	10035: exchange.ErrInsufficientFunds,
+	10008: exchange.ErrOrderRejected,
+	10011: exchange.ErrOrderRejected,
+	10014: exchange.ErrOrderRejected,
+	10015: exchange.ErrOrderRejected,
+	10049: exchange.ErrOrderRejected,
+}
+
+// Error with the reason for an OKCoin error code, of a known kind where the code has one
+func codeError(code int64) error {
+	message, ok := codeMessages[code]
+	if !ok {
+		message = "Unknown error"
+	}
+	if kind, ok := codeKinds[code]; ok {
+		return exchange.NewError(kind, message)
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,49 @@
+package okcoin
+
+import (
+	"bitfx/exchange"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Test that OKCoin error codes get their reasons and kinds
+func TestCodeError(t *testing.T) {
+	codes := []struct {
+		code    int64
+		message string
+		kind    error
+	}{
+		{10010, "Insufficient funds", exchange.ErrInsufficientFunds},
+		{10016, "Insufficient coins balance", exchange.ErrInsufficientFunds},
+		{10011, "Amount too low", exchange.ErrOrderRejected},
+		{10014, "Order price must be between 0 and 1,000,000", exchange.ErrOrderRejected},
+		{10009, "Order does not exist", exchange.ErrOrderNotFound},
+		{10001, "Request frequency too high", exchange.ErrRateLimited},
+		{-1, "WebSocket write failed", exchange.ErrConnection},
+		{10007, "Signature does not match", nil},
+		{99999, "Unknown error", nil},
+	}
+	kinds := []error{exchange.ErrInsufficientFunds, exchange.ErrOrderRejected, exchange.ErrOrderNotFound, exchange.ErrRateLimited, exchange.ErrConnection}
+	for _, test := range codes {
+		err := codeError(test.code)
+		if err.Error() != test.message {
+			t.Errorf("%d: expected %q, got %q", test.code, test.message, err)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.kind) {
+				t.Errorf("%d: expected kind %v, got %v", test.code, test.kind, err)
+			}
+		}
+	}
+
+	// Reasons and kinds are kept through the order error
+	channels := make(chan string, 1)
+	client := mockOrderClient(t, `[{"channel":"ok_spotusd_trade","errorcode":"10010"}]`, channels)
+	_, err := client.SendOrder(context.Background(), "buy", "limit", 1, 1.5)
+	close(client.writeOrderMsg)
+	<-channels
+	if !errors.Is(err, exchange.ErrInsufficientFunds) || err.Error() != "OKCoin(usd) SendOrder error code 10010: Insufficient funds" {
+		t.Fatalf("Expected insufficient funds, got %v", err)
+	}
+}
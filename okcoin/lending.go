@@ -0,0 +1,148 @@
+// OKCoin cryptocurrency lending for short sales beyond the coins held
+
+package okcoin
+
+import (
+	"bitfx/exchange"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Open loan
+type loan struct {
+	id     int64
+	amount float64
+}
+
+// SetBorrowLimit sets the most cryptocurrency borrowed for short sales, zero to disable borrowing
+// Loans are offered at rate per day
+func (client *Client) SetBorrowLimit(limit, rate float64) {
+	client.borrowLimit = limit
+	client.borrowRate = rate
+}
+
+// Borrowed returns the cryptocurrency currently borrowed by the client
+func (client *Client) Borrowed() float64 {
+	client.loanMutex.Lock()
+	defer client.loanMutex.Unlock()
+	return client.borrowed()
+}
+
+// Total of open loans, must hold loanMutex
+func (client *Client) borrowed() float64 {
+	var total float64
+	for _, l := range client.loans {
+		total += l.amount
+	}
+	return total
+}
+
+// EnsureBorrowed borrows what a sell of amount needs beyond the coins held, if borrowing is enabled
+// Coins held are AvailShort less the borrow limit, plus the position and any loans
+func (client *Client) EnsureBorrowed(ctx context.Context, amount float64) error {
+	if client.borrowLimit <= 0 {
+		return nil
+	}
+	client.loanMutex.Lock()
+	defer client.loanMutex.Unlock()
+	borrowed := client.borrowed()
+	need := amount - (client.availShort + client.position + borrowed)
+	if need <= .000001 {
+		return nil
+	}
+	// Round up to the lot size so the sell is covered
+	need = math.Ceil(need*100-.000001) / 100
+	if borrowed+need > client.borrowLimit+.000001 {
+		return fmt.Errorf("%s Borrow error: %.4f more needed, %.4f of %.4f already borrowed", client, need, borrowed, client.borrowLimit)
+	}
+
+	var response struct {
+		ID int64 `json:"borrow_id"`
+	}
+	params := map[string]string{
+		"symbol": fmt.Sprintf("%s_%s", client.symbol, client.currency),
+		"days":   "fifteen",
+		"amount": fmt.Sprintf("%.2f", need),
+		"rate":   fmt.Sprintf("%f", client.borrowRate),
+	}
+	if err := client.postREST(ctx, "borrow_money.do", params, &response); err != nil {
+		return fmt.Errorf("%s Borrow error: %s", client, err)
+	}
+	client.loans = append(client.loans, loan{response.ID, need})
+	client.logger.Infof("%s borrowed %.2f %s, loan %d", client, need, client.symbol, response.ID)
+	return nil
+}
+
+// RepayExcess repays loans no longer needed at the current position, newest first
+// Loans are repaid whole, so one is only repaid if all of it is spare
+func (client *Client) RepayExcess(ctx context.Context) error {
+	client.loanMutex.Lock()
+	defer client.loanMutex.Unlock()
+	// Coins short beyond those held must stay borrowed
+	needed := math.Max(-client.position-client.availShort, 0)
+	spare := client.borrowed() - needed
+	for i := len(client.loans) - 1; i >= 0; i-- {
+		l := client.loans[i]
+		if l.amount > spare+.000001 {
+			continue
+		}
+		params := map[string]string{"borrow_id": fmt.Sprintf("%d", l.id)}
+		if err := client.postREST(ctx, "repayment.do", params, nil); err != nil {
+			return fmt.Errorf("%s Repay error: %s", client, err)
+		}
+		client.loans = append(client.loans[:i], client.loans[i+1:]...)
+		spare -= l.amount
+		client.logger.Infof("%s repaid %.2f %s, loan %d", client, l.amount, client.symbol, l.id)
+	}
+	return nil
+}
+
+// Signed POST to the REST API, unmarshalling a successful response into v if not nil
+func (client *Client) postREST(ctx context.Context, method string, params map[string]string, v interface{}) error {
+	params["api_key"] = client.key
+	params["sign"] = client.constructSign(params)
+	values := url.Values{}
+	for param, value := range params {
+		values.Set(param, value)
+	}
+
+	req, err := http.NewRequest("POST", client.restURL+"/"+method, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return exchange.ConnectionError(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+
+	var result struct {
+		Result    bool  `json:"result"`
+		ErrorCode int64 `json:"error_code"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	if !result.Result {
+		return fmt.Errorf("error code %d: %w", result.ErrorCode, codeError(result.ErrorCode))
+	}
+	if v != nil {
+		return json.Unmarshal(data, v)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package okcoin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test borrowing for short sales and repaying spare loans with mock server
+func TestLending(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/borrow_money.do":
+			requests = append(requests, "borrow "+r.Form.Get("amount"))
+			fmt.Fprintf(w, `{"borrow_id":%d,"result":true}`, len(requests))
+		case "/repayment.do":
+			requests = append(requests, "repay "+r.Form.Get("borrow_id"))
+			fmt.Fprintln(w, `{"result":true}`)
+		default:
+			fmt.Fprintln(w, `{"result":false,"error_code":10000}`)
+		}
+	}))
+	defer server.Close()
+	client := &Client{name: "OKCoin(usd)", symbol: "ltc", currency: "usd", availShort: 2, logger: client.logger,
+		restURL: server.URL, httpClient: &http.Client{}}
+	ctx := context.Background()
+
+	// Disabled without a borrow limit
+	if err := client.EnsureBorrowed(ctx, 5); err != nil || len(requests) != 0 {
+		t.Fatal("Should not borrow without a borrow limit")
+	}
+	client.SetBorrowLimit(3, .001)
+	if notEqual(client.AvailShort(), 5) {
+		t.Fatal("AvailShort should include the borrow limit")
+	}
+
+	// Sells within the coins held need no loan
+	client.SetPosition(-1)
+	if err := client.EnsureBorrowed(ctx, 1); err != nil || len(requests) != 0 {
+		t.Fatal("Should not borrow within the coins held")
+	}
+
+	// Shortfalls are borrowed, up to the limit
+	if err := client.EnsureBorrowed(ctx, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	client.SetPosition(-3.5)
+	if err := client.EnsureBorrowed(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0] != "borrow 1.50" || requests[1] != "borrow 1.00" || notEqual(client.Borrowed(), 2.5) {
+		t.Fatalf("Expected loans of 1.5 and 1, got %v", requests)
+	}
+	client.SetPosition(-4.5)
+	if err := client.EnsureBorrowed(ctx, 1); err == nil {
+		t.Fatal("Should not borrow past the limit")
+	}
+
+	// Loans are repaid whole once spare
+	client.SetPosition(-4)
+	if err := client.RepayExcess(ctx); err != nil || len(requests) != 2 {
+		t.Fatal("No loan should be spare")
+	}
+	client.SetPosition(-2.5)
+	if err := client.RepayExcess(ctx); err != nil || len(requests) != 3 || requests[2] != "repay 2" {
+		t.Fatalf("Expected the newest loan repaid, got %v", requests)
+	}
+	client.SetPosition(0)
+	if err := client.RepayExcess(ctx); err != nil || len(requests) != 4 || requests[3] != "repay 1" || client.Borrowed() != 0 {
+		t.Fatalf("Expected all loans repaid, got %v", requests)
+	}
+
+	// Failures are returned
+	client.restURL = server.URL + "/unknown"
+	client.SetPosition(-2)
+	if err := client.EnsureBorrowed(ctx, 1); err == nil {
+		t.Fatal("Expected an error from a failed loan")
+	}
+}
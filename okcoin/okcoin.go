@@ -4,15 +4,19 @@ package okcoin
 
 import (
 	"bitfx/exchange"
+	"bitfx/logger"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,15 +24,29 @@ import (
 
 // Client contains all exchange information
 type Client struct {
-	key, secret, symbol, currency, websocketURL, name string
-	priority                                          int
-	position, fee, maxPos, availShort, availFunds     float64
-	currencyCode                                      byte
-	done                                              chan bool
-	writeBookMsg                                      chan request
-	readBookMsg                                       chan response
-	writeOrderMsg                                     chan request
-	readOrderMsg                                      chan response
+	key, secret, symbol, currency, websocketURL, name            string
+	priority, depth                                              int
+	position, makerFee, takerFee, maxPos, availShort, availFunds float64
+	currencyCode                                                 exchange.Currency
+	maxBackoff                                                   time.Duration
+	staleBook                                                    time.Duration  // Reconnect if no book data within this window
+	clock                                                        exchange.Clock // Paces reconnection attempts and REST book polling
+	logger                                                       *logger.Logger
+	feeMutex                                                     sync.Mutex
+	orderLimit                                                   exchange.RateLimiter // Limits orders and cancels per second
+	health                                                       exchange.Health      // Books sent
+	latency                                                      exchange.Latency     // Round trip of the last order request
+	restURL                                                      string               // REST API used for lending and book polling
+	restFallback, restPoll                                       time.Duration        // WebSocket silence before polling REST books, and the poll interval
+	httpClient                                                   *http.Client
+	borrowLimit, borrowRate                                      float64    // Most cryptocurrency borrowed and the daily rate offered
+	loans                                                        []loan     // Open loans, oldest first
+	loanMutex                                                    sync.Mutex // Guards loans
+	done                                                         chan bool
+	writeBookMsg                                                 chan request
+	readBookMsg                                                  chan response
+	writeOrderMsg                                                chan request
+	readOrderMsg                                                 chan response
 }
 
 // Exchange request format
@@ -45,22 +63,62 @@ type response []struct {
 	Data      json.RawMessage `json:"data"`             // Data specific to channel
 }
 
-// New returns a pointer to a Client instance
-func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64) *Client {
-	// URL depends on currency
-	var websocketURL string
-	var currencyCode byte
-	if strings.ToLower(currency) == "usd" {
-		websocketURL = "wss://real.okcoin.com:10440/websocket/okcoinapi"
-		currencyCode = 0
-	} else if strings.ToLower(currency) == "cny" {
-		websocketURL = "wss://real.okcoin.cn:10440/websocket/okcoinapi"
-		currencyCode = 1
-	} else {
-		log.Fatal("Currency must be USD or CNY")
+// Max wait for the reply to an order request
+var orderReadTimeout = 3 * time.Second
+
+// Option changes a Client setting before New validates it and connects
+type Option func(*Client)
+
+// WithURLs replaces the REST API and WebSocket URLs, for mock servers or proxies
+// Plain http and ws URLs are allowed for local testing
+func WithURLs(restURL, websocketURL string) Option {
+	return func(client *Client) {
+		client.restURL = restURL
+		client.websocketURL = websocketURL
 	}
+}
+
+// WithMaxBackoff sets the maximum delay between reconnection attempts, 60 seconds by default
+// Set at construction, as the connections New starts read it
+func WithMaxBackoff(maxBackoff time.Duration) Option {
+	return func(client *Client) {
+		client.maxBackoff = maxBackoff
+	}
+}
+
+// WithStaleBook sets how long without book data before reconnecting, zero to disable, 30 seconds by default
+func WithStaleBook(window time.Duration) Option {
+	return func(client *Client) {
+		client.staleBook = window
+	}
+}
+
+// WithClock replaces the clock reconnection attempts and REST book polling are paced by, the system clock by default
+func WithClock(clock exchange.Clock) Option {
+	return func(client *Client) {
+		client.clock = clock
+	}
+}
+
+// New returns a pointer to a Client instance
+func New(key, secret, symbol, currency string, priority int, fee, availShort, availFunds float64, options ...Option) (*Client, error) {
 	name := fmt.Sprintf("OKCoin(%s)", currency)
+	if err := exchange.CheckCurrency(currency, "usd", "cny"); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", name, err)
+	}
+	if err := exchange.CheckKeys(key, secret); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", name, err)
+	}
 
+	// URLs depend on currency
+	websocketURL := "wss://real.okcoin.com:10440/websocket/okcoinapi"
+	restURL := "https://www.okcoin.com/api/v1"
+	currencyCode := exchange.USD
+	if strings.ToLower(currency) == "cny" {
+		websocketURL = "wss://real.okcoin.cn:10440/websocket/okcoinapi"
+		restURL = "https://www.okcoin.cn/api/v1"
+		currencyCode = exchange.CNY
+	}
 	// Channels for WebSocket connections
 	done := make(chan bool, 2)
 	writeBookMsg := make(chan request)
@@ -74,25 +132,44 @@ func New(key, secret, symbol, currency string, priority int, fee, availShort, av
 		symbol:        symbol,
 		currency:      currency,
 		websocketURL:  websocketURL,
+		restURL:       restURL,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		borrowRate:    .001,
 		priority:      priority,
-		fee:           fee,
+		depth:         20,
+		makerFee:      fee,
+		takerFee:      fee,
+		logger:        logger.Default(),
 		availShort:    availShort,
 		availFunds:    availFunds,
 		currencyCode:  currencyCode,
 		name:          name,
+		maxBackoff:    60 * time.Second,
+		staleBook:     30 * time.Second,
+		restFallback:  10 * time.Second,
+		restPoll:      2 * time.Second,
+		clock:         exchange.SystemClock{},
 		done:          done,
 		writeOrderMsg: writeOrderMsg,
 		readOrderMsg:  readOrderMsg,
 		writeBookMsg:  writeBookMsg,
 		readBookMsg:   readBookMsg,
 	}
+	for _, option := range options {
+		option(client)
+	}
+	if err := exchange.CheckURL(client.websocketURL, "wss", "ws"); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", name, err)
+	}
+	if err := exchange.CheckURL(client.restURL, "https", "http"); err != nil {
+		return nil, fmt.Errorf("%s New error: %s", name, err)
+	}
 
 	// Run WebSocket connections
-	initMsg := request{Event: "addChannel", Channel: fmt.Sprintf("ok_%s%s_depth", symbol, currency)}
-	go client.maintainWS(initMsg, writeBookMsg, readBookMsg)
-	go client.maintainWS(request{}, writeOrderMsg, readOrderMsg)
+	go client.maintainWS(client.depthRequest("addChannel"), writeBookMsg, readBookMsg, true)
+	go client.maintainWS(request{}, writeOrderMsg, readOrderMsg, false)
 
-	return client
+	return client, nil
 }
 
 // Done closes all connections
@@ -113,9 +190,30 @@ func (client *Client) Priority() int {
 	return client.priority
 }
 
-// Fee returns the exchange order fee
-func (client *Client) Fee() float64 {
-	return client.fee
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, safe to call while books are filtered
+func (client *Client) SetFees(maker, taker float64) {
+	client.feeMutex.Lock()
+	defer client.feeMutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetDepth sets the maximum number of book levels used from the exchange
+func (client *Client) SetDepth(depth int) {
+	client.depth = depth
 }
 
 // SetPosition sets the exchange position
@@ -134,7 +232,7 @@ func (client *Client) Currency() string {
 }
 
 // CurrencyCode returns the exchange currency code
-func (client *Client) CurrencyCode() byte {
+func (client *Client) CurrencyCode() exchange.Currency {
 	return client.currencyCode
 }
 
@@ -153,37 +251,208 @@ func (client *Client) AvailFunds() float64 {
 	return client.availFunds
 }
 
-// AvailShort returns the exchange quantity available for short selling
+// AvailShort returns the exchange quantity available for short selling, including the borrow limit
 func (client *Client) AvailShort() float64 {
-	return client.availShort
+	return client.availShort + client.borrowLimit
 }
 
-// HasCrytpoFee returns true if fee is taken in cryptocurrency on buys
-func (client *Client) HasCryptoFee() bool {
-	return true
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// LastLatency returns the round trip of the last order request, zero if none has been sent
+func (client *Client) LastLatency() time.Duration {
+	return client.latency.Last()
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity held for short selling, excluding the borrow limit
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill
+// The fee on buys is taken from the cryptocurrency received, sells are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	if action == "buy" {
+		return filled * (1 - client.TakerFee())
+	}
+	return filled
 }
 
 // CommunicateBook sends the latest available book data on the supplied channel
-func (client *Client) CommunicateBook(bookChan chan<- exchange.Book) exchange.Book {
-	// Get an initial book to return
-	book := client.convertToBook(<-client.readBookMsg)
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	// Get an initial book to return, from REST if the WebSocket sends nothing within restFallback
+	var initial <-chan time.Time
+	if client.restFallback > 0 {
+		initial = client.clock.After(client.restFallback)
+	}
+	var (
+		book       exchange.Book
+		consistent bool
+	)
+	select {
+	case resp := <-client.readBookMsg:
+		book, consistent = client.consistentBook(resp)
+	case <-initial:
+		client.logger.Warnf("%s no WebSocket book for %s, initial book from REST", client, client.restFallback)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		book, consistent = client.validBook(client.restBook(ctx))
+		cancel()
+	}
+	if !consistent {
+		go client.resubscribeBook(doneChan)
+	}
 
 	// Run a read loop in new goroutine
-	go client.runBookLoop(bookChan)
+	go client.runBookLoop(bookChan, doneChan)
 
 	return book
 }
 
 // Book WebSocket read loop
-func (client *Client) runBookLoop(bookChan chan<- exchange.Book) {
-	for resp := range client.readBookMsg {
-		// Process data and send out to user
-		bookChan <- client.convertToBook(resp)
+// Inconsistent books are dropped and a fresh snapshot requested
+// Books are polled over REST while the WebSocket sends nothing for restFallback, as during reconnects
+func (client *Client) runBookLoop(bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	// Fires on WebSocket silence, nil if the fallback is disabled
+	var (
+		silence      <-chan time.Time
+		resetSilence = func() {}
+	)
+	if client.restFallback > 0 {
+		timer := time.NewTimer(client.restFallback)
+		defer timer.Stop()
+		silence = timer.C
+		resetSilence = func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(client.restFallback)
+		}
 	}
+	// Ticks while polling REST, nil otherwise
+	var (
+		poll       <-chan time.Time
+		pollTicker exchange.ClockTicker
+	)
+	defer func() {
+		if pollTicker != nil {
+			pollTicker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-doneChan:
+			return
+		case <-silence:
+			client.logger.Warnf("%s no WebSocket book for %s, polling REST every %s", client, client.restFallback, client.restPoll)
+			pollTicker = client.clock.NewTicker(client.restPoll)
+			poll = pollTicker.Chan()
+			if !client.pollRESTBook(bookChan, doneChan) {
+				return
+			}
+		case <-poll:
+			if !client.pollRESTBook(bookChan, doneChan) {
+				return
+			}
+		case resp, ok := <-client.readBookMsg:
+			if !ok {
+				return
+			}
+			if pollTicker != nil {
+				client.logger.Infof("%s WebSocket book data resumed, REST polling stopped", client)
+				pollTicker.Stop()
+				pollTicker, poll = nil, nil
+			}
+			resetSilence()
+			// Process data and send out to user
+			book, consistent := client.consistentBook(resp)
+			if !consistent {
+				client.logger.Warnf("%s, resubscribing", book.Error)
+				if !client.resubscribeBook(doneChan) {
+					return
+				}
+				continue
+			}
+			if !client.sendBook(bookChan, doneChan, book) {
+				return
+			}
+		}
+	}
+}
+
+// Send a book out, recording it for health
+// Returns false if doneChan receives a value first
+func (client *Client) sendBook(bookChan chan<- exchange.Book, doneChan <-chan bool, book exchange.Book) bool {
+	select {
+	case bookChan <- book:
+		client.health.Update(book)
+		return true
+	case <-doneChan:
+		return false
+	}
+}
+
+// Convert websocket data to an exchange.Book, returning false if it converted but is inconsistent
+// The feed has no checksum, so corrupted frames are caught by a crossed book or bad prices and amounts
+func (client *Client) consistentBook(resp response) (exchange.Book, bool) {
+	return client.validBook(client.convertToBook(resp))
+}
+
+// Check a converted book, returning false with the error in its place if it's inconsistent
+// Books that failed to convert are returned as they are
+func (client *Client) validBook(book exchange.Book) (exchange.Book, bool) {
+	if book.Error != nil {
+		return book, true
+	}
+	if err := book.Validate(); err != nil {
+		return exchange.Book{Error: err}, false
+	}
+	return book, true
+}
+
+// Request a fresh book snapshot by subscribing to the depth channel again
+// Returns false if doneChan receives a value first
+func (client *Client) resubscribeBook(doneChan <-chan bool) bool {
+	for _, event := range []string{"removeChannel", "addChannel"} {
+		select {
+		case client.writeBookMsg <- client.depthRequest(event):
+		case <-doneChan:
+			return false
+		}
+	}
+	return true
+}
+
+// Return a request for event on the depth channel
+func (client *Client) depthRequest(event string) request {
+	return request{Event: event, Channel: fmt.Sprintf("ok_%s%s_depth", client.symbol, client.currency)}
 }
 
 // Convert websocket data to an exchange.Book
 func (client *Client) convertToBook(resp response) exchange.Book {
+	if len(resp) == 0 {
+		return exchange.Book{Error: fmt.Errorf("%s book error: bad message", client)}
+	}
+	return client.parseBook(resp[0].Data)
+}
+
+// Convert depth data, from the WebSocket or REST, to an exchange.Book
+func (client *Client) parseBook(data []byte) exchange.Book {
 	// Unmarshal
 	var bookData struct {
 		Bids       [][2]float64 `json:"bids"`             // Slice of bid data items
@@ -192,16 +461,30 @@ func (client *Client) convertToBook(resp response) exchange.Book {
 		UnitAmount int          `json:"unit_amount"`      // Unit amount for futures
 
 	}
-	if err := json.Unmarshal(resp[0].Data, &bookData); err != nil {
+	if err := json.Unmarshal(data, &bookData); err != nil {
 		return exchange.Book{Error: fmt.Errorf("%s book error: %s", client, err)}
 	}
 
+	// Depth is bounded by what the exchange returned
+	numBids, numAsks := client.depth, client.depth
+	if len(bookData.Bids) < numBids {
+		numBids = len(bookData.Bids)
+	}
+	if len(bookData.Asks) < numAsks {
+		numAsks = len(bookData.Asks)
+	}
+	if numBids == 0 || numAsks == 0 {
+		return exchange.Book{Error: fmt.Errorf("%s book error: received %d bid and %d ask levels", client, numBids, numAsks)}
+	}
+
 	// Translate into exchange.Book structure
-	bids := make(exchange.BidItems, 20)
-	asks := make(exchange.AskItems, 20)
-	for i := 0; i < 20; i++ {
+	bids := make(exchange.BidItems, numBids)
+	asks := make(exchange.AskItems, numAsks)
+	for i := 0; i < numBids; i++ {
 		bids[i].Price = bookData.Bids[i][0]
 		bids[i].Amount = bookData.Bids[i][1]
+	}
+	for i := 0; i < numAsks; i++ {
 		asks[i].Price = bookData.Asks[i][0]
 		asks[i].Amount = bookData.Asks[i][1]
 	}
@@ -218,34 +501,127 @@ func (client *Client) convertToBook(resp response) exchange.Book {
 	}
 }
 
+// Ticker returns the latest ticker data
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	// Use a short-lived connection so the order connection isn't subscribed
+	initMsg := request{Event: "addChannel", Channel: fmt.Sprintf("ok_%s%s_ticker", client.symbol, client.currency)}
+	ws, err := client.newWS(initMsg)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+	defer ws.Close()
+
+	// Read the first ticker message
+	var resp response
+	if err := ws.SetReadDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+	if err := ws.ReadJSON(&resp); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+
+	return client.convertToTicker(resp)
+}
+
+// Convert websocket data to an exchange.Ticker
+func (client *Client) convertToTicker(resp response) (exchange.Ticker, error) {
+	if len(resp) == 0 {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker bad message", client)
+	}
+	if resp[0].ErrorCode != 0 {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
+	}
+
+	// Unmarshal
+	var tickerData struct {
+		Buy       float64 `json:"buy"`
+		Sell      float64 `json:"sell"`
+		Last      float64 `json:"last,string"`
+		Vol       string  `json:"vol"`       // Formatted with thousands separators
+		Timestamp int64   `json:"timestamp"` // Milliseconds
+	}
+	if err := json.Unmarshal(resp[0].Data, &tickerData); err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+	volume, err := strconv.ParseFloat(strings.Replace(tickerData.Vol, ",", "", -1), 64)
+	if err != nil {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: %s", client, err)
+	}
+
+	return exchange.Ticker{
+		Last:   tickerData.Last,
+		Bid:    tickerData.Buy,
+		Ask:    tickerData.Sell,
+		Volume: volume,
+		Time:   time.Unix(0, tickerData.Timestamp*int64(time.Millisecond)),
+	}, nil
+}
+
+// MinOrderSize returns the smallest order amount the exchange accepts
+func (client *Client) MinOrderSize() float64 {
+	if client.symbol == "btc" {
+		return .01
+	}
+	return .1
+}
+
+// RoundOrder rounds an order to what the exchange accepts
+// Prices have 2 decimals and amounts 3 decimals
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return exchange.RoundDown(amount, .001), exchange.RoundNearest(price, .01)
+}
+
+// SupportsMarket returns true, market buys spend the amount's value at the reference price
+func (client *Client) SupportsMarket() bool {
+	return true
+}
+
+// PriceStep returns the tick size of .01
+func (client *Client) PriceStep(price float64) float64 {
+	return .01
+}
+
+// SetRateLimit limits orders and cancels sent per second, zero for no limit
+// Requests over the limit wait for their turn or their context
+func (client *Client) SetRateLimit(perSecond float64) {
+	client.orderLimit.SetRate(perSecond)
+}
+
 // SendOrder sends an order to the exchange
-func (client *Client) SendOrder(action, otype string, amount, price float64) (int64, error) {
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: rate limited, %s", client, err)
+	}
 	// Construct parameters
-	params := make(map[string]string)
-	params["api_key"] = client.key
-	params["symbol"] = fmt.Sprintf("%s_%s", client.symbol, client.currency)
-	if otype == "limit" {
-		params["type"] = action
-	} else if otype == "market" {
-		params["type"] = fmt.Sprintf("%s_%s", action, otype)
+	params, err := client.orderParams(action, otype, amount, price)
+	if err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %s", client, err)
 	}
-	params["price"] = fmt.Sprintf("%f", price)
-	params["amount"] = fmt.Sprintf("%f", amount)
-	params["sign"] = client.constructSign(params)
 
 	// Construct request
 	channel := fmt.Sprintf("ok_spot%s_trade", client.currency)
 	req := request{Event: "addChannel", Channel: channel, Parameters: params}
 
 	// Write to WebSocket
-	client.writeOrderMsg <- req
+	defer client.latency.Observe(time.Now())
+	select {
+	case client.writeOrderMsg <- req:
+	case <-ctx.Done():
+		return 0, fmt.Errorf("%s SendOrder error: %s", client, ctx.Err())
+	}
 
 	// Read response
+	// The order is written by now, so it may be placed even without a reply
 	var resp response
 	select {
 	case resp = <-client.readOrderMsg:
-	case <-time.After(3 * time.Second):
-		return 0, fmt.Errorf("%s SendOrder read timeout", client)
+	case <-time.After(orderReadTimeout):
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.NewError(exchange.ErrUnknownOutcome, "read timeout"))
+	case <-ctx.Done():
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, exchange.NewError(exchange.ErrUnknownOutcome, ctx.Err().Error()))
 	}
 
 	if len(resp) == 0 {
@@ -253,7 +629,7 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 	}
 
 	if resp[0].ErrorCode != 0 {
-		return 0, fmt.Errorf("%s SendOrder error code: %d", client, resp[0].ErrorCode)
+		return 0, fmt.Errorf("%s SendOrder error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
 	}
 
 	// Unmarshal
@@ -271,8 +647,46 @@ func (client *Client) SendOrder(action, otype string, amount, price float64) (in
 	return orderData.ID, nil
 }
 
+// Construct signed parameters for a new order
+// Market buys carry the fiat amount to spend in price and omit amount
+// Market sells carry the amount and omit price
+func (client *Client) orderParams(action, otype string, amount, price float64) (map[string]string, error) {
+	if action != "buy" && action != "sell" {
+		return nil, fmt.Errorf("only \"buy\" and \"sell\" actions supported")
+	}
+
+	params := make(map[string]string)
+	params["api_key"] = client.key
+	params["symbol"] = fmt.Sprintf("%s_%s", client.symbol, client.currency)
+	if otype == "limit" {
+		params["type"] = action
+		params["price"] = fmt.Sprintf("%f", price)
+		params["amount"] = fmt.Sprintf("%f", amount)
+	} else if otype == "market" && action == "buy" {
+		// Price is needed to estimate the amount to spend
+		if price <= 0 {
+			return nil, fmt.Errorf("market buy requires a reference price")
+		}
+		params["type"] = fmt.Sprintf("%s_%s", action, otype)
+		params["price"] = fmt.Sprintf("%f", amount*price)
+	} else if otype == "market" {
+		params["type"] = fmt.Sprintf("%s_%s", action, otype)
+		params["amount"] = fmt.Sprintf("%f", amount)
+	} else if otype == "post_only" {
+		return nil, fmt.Errorf("post-only orders not supported")
+	} else {
+		return nil, fmt.Errorf("only \"limit\" and \"market\" order types supported")
+	}
+	params["sign"] = client.constructSign(params)
+
+	return params, nil
+}
+
 // CancelOrder cancels an order on the exchange
-func (client *Client) CancelOrder(id int64) (bool, error) {
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	if err := client.orderLimit.Wait(ctx); err != nil {
+		return false, fmt.Errorf("%s CancelOrder error: rate limited, %s", client, err)
+	}
 	// Construct parameters
 	params := make(map[string]string)
 	params["api_key"] = client.key
@@ -285,7 +699,12 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 	req := request{Event: "addChannel", Channel: channel, Parameters: params}
 
 	// Write to WebSocket
-	client.writeOrderMsg <- req
+	defer client.latency.Observe(time.Now())
+	select {
+	case client.writeOrderMsg <- req:
+	case <-ctx.Done():
+		return false, fmt.Errorf("%s CancelOrder error: %s", client, ctx.Err())
+	}
 
 	// Read response
 	var resp response
@@ -293,6 +712,8 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 	case resp = <-client.readOrderMsg:
 	case <-time.After(3 * time.Second):
 		return false, fmt.Errorf("%s CancelOrder read timeout", client)
+	case <-ctx.Done():
+		return false, fmt.Errorf("%s CancelOrder error: %s", client, ctx.Err())
 	}
 
 	if len(resp) == 0 {
@@ -300,7 +721,7 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 	}
 
 	if resp[0].ErrorCode != 0 {
-		return false, fmt.Errorf("%s CancelOrder error code: %d", client, resp[0].ErrorCode)
+		return false, fmt.Errorf("%s CancelOrder error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
 	}
 
 	// Unmarshal
@@ -316,7 +737,7 @@ func (client *Client) CancelOrder(id int64) (bool, error) {
 }
 
 // GetOrderStatus gets the status of an order on the exchange
-func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
 	// Construct parameters
 	params := make(map[string]string)
 	params["api_key"] = client.key
@@ -329,7 +750,12 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	req := request{Event: "addChannel", Channel: channel, Parameters: params}
 
 	// Write to WebSocket
-	client.writeOrderMsg <- req
+	defer client.latency.Observe(time.Now())
+	select {
+	case client.writeOrderMsg <- req:
+	case <-ctx.Done():
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: %s", client, ctx.Err())
+	}
 
 	// Create order to be returned
 	var order exchange.Order
@@ -340,6 +766,8 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	case resp = <-client.readOrderMsg:
 	case <-time.After(3 * time.Second):
 		return order, fmt.Errorf("%s GetOrderStatus read timeout", client)
+	case <-ctx.Done():
+		return order, fmt.Errorf("%s GetOrderStatus error: %s", client, ctx.Err())
 	}
 
 	if len(resp) == 0 {
@@ -347,7 +775,7 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 	}
 
 	if resp[0].ErrorCode != 0 {
-		return order, fmt.Errorf("%s GetOrderStatus error code: %d", client, resp[0].ErrorCode)
+		return order, fmt.Errorf("%s GetOrderStatus error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
 	}
 
 	// Unmarshal
@@ -379,6 +807,146 @@ func (client *Client) GetOrderStatus(id int64) (exchange.Order, error) {
 
 }
 
+// OpenOrders returns all live orders for the symbol and currency in use
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	// Construct parameters, an order_id of -1 requests all unfilled orders
+	params := make(map[string]string)
+	params["api_key"] = client.key
+	params["symbol"] = fmt.Sprintf("%s_%s", client.symbol, client.currency)
+	params["order_id"] = "-1"
+	params["sign"] = client.constructSign(params)
+
+	// Construct request
+	channel := fmt.Sprintf("ok_spot%s_order_info", client.currency)
+	req := request{Event: "addChannel", Channel: channel, Parameters: params}
+
+	// Write to WebSocket
+	select {
+	case client.writeOrderMsg <- req:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, ctx.Err())
+	}
+
+	// Read response
+	var resp response
+	select {
+	case resp = <-client.readOrderMsg:
+	case <-time.After(3 * time.Second):
+		return nil, fmt.Errorf("%s OpenOrders read timeout", client)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, ctx.Err())
+	}
+
+	if len(resp) == 0 {
+		return nil, fmt.Errorf("%s OpenOrders bad message", client)
+	}
+
+	if resp[0].ErrorCode != 0 {
+		return nil, fmt.Errorf("%s OpenOrders error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
+	}
+
+	// Unmarshal
+	var orderData struct {
+		Orders []struct {
+			ID         int64   `json:"order_id"`
+			Status     int     `json:"status"`
+			Type       string  `json:"type"`
+			Price      float64 `json:"price"`
+			Amount     float64 `json:"amount"`
+			DealAmount float64 `json:"deal_amount"`
+		} `json:"orders"`
+	}
+	if err := json.Unmarshal(resp[0].Data, &orderData); err != nil {
+		return nil, fmt.Errorf("%s OpenOrders error: %s", client, err)
+	}
+
+	// Unfilled or partially filled
+	var orders []exchange.Order
+	for _, order := range orderData.Orders {
+		if order.Status == 0 || order.Status == 1 {
+			orders = append(orders, exchange.Order{
+				ID:           order.ID,
+				Side:         strings.TrimSuffix(order.Type, "_market"),
+				Price:        order.Price,
+				Amount:       math.Abs(order.Amount),
+				FilledAmount: math.Abs(order.DealAmount),
+				Status:       "live",
+			})
+		}
+	}
+
+	return orders, nil
+}
+
+// Balances returns the available fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	// Construct parameters
+	params := make(map[string]string)
+	params["api_key"] = client.key
+	params["sign"] = client.constructSign(params)
+
+	// Construct request
+	channel := fmt.Sprintf("ok_spot%s_userinfo", client.currency)
+	req := request{Event: "addChannel", Channel: channel, Parameters: params}
+
+	// Write to WebSocket
+	client.writeOrderMsg <- req
+
+	// Read response
+	var resp response
+	select {
+	case resp = <-client.readOrderMsg:
+	case <-time.After(3 * time.Second):
+		return 0, 0, fmt.Errorf("%s Balances read timeout", client)
+	}
+
+	if len(resp) == 0 {
+		return 0, 0, fmt.Errorf("%s Balances bad message", client)
+	}
+
+	if resp[0].ErrorCode != 0 {
+		return 0, 0, fmt.Errorf("%s Balances error code %d: %w", client, resp[0].ErrorCode, codeError(resp[0].ErrorCode))
+	}
+
+	// Unmarshal
+	var userData struct {
+		Info struct {
+			Funds struct {
+				Free map[string]json.Number `json:"free"`
+			} `json:"funds"`
+		} `json:"info"`
+		Result bool `json:"result"`
+	}
+	if err := json.Unmarshal(resp[0].Data, &userData); err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err)
+	}
+	if !userData.Result {
+		return 0, 0, fmt.Errorf("%s Balances failure", client)
+	}
+
+	fiat, err := userData.Info.Funds.Free[client.currency].Float64()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err)
+	}
+	crypto, err := userData.Info.Funds.Free[client.symbol].Float64()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s Balances error: %s", client, err)
+	}
+
+	return fiat, crypto, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort, less the borrow limit, is a flat position
+// Coins borrowed by this client are not part of the position
+func (client *Client) ActualPosition() (float64, error) {
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort - client.Borrowed(), nil
+}
+
 // Construct sign for authentication
 func (client *Client) constructSign(params map[string]string) string {
 	// Make url.Values from params
@@ -399,9 +967,12 @@ func (client *Client) constructSign(params map[string]string) string {
 }
 
 // Maintain a WebSocket connection
-func (client *Client) maintainWS(initMsg request, writeMsg <-chan request, readMsg chan<- response) {
+// If checkStale is set, reconnects when no data arrives within the stale book window
+func (client *Client) maintainWS(initMsg request, writeMsg <-chan request, readMsg chan<- response, checkStale bool) {
 	// Get a WebSocket connection
 	ws := client.persistentNewWS(initMsg)
+	// Time of last data message as Unix nanoseconds, read and written atomically
+	lastData := time.Now().UnixNano()
 
 	// Syncronize access to *websocket.Conn
 	receiveWS := make(chan *websocket.Conn)
@@ -417,6 +988,7 @@ func (client *Client) maintainWS(initMsg request, writeMsg <-chan request, readM
 			case <-reconnectWS:
 				ws.Close()
 				ws = client.persistentNewWS(initMsg)
+				atomic.StoreInt64(&lastData, time.Now().UnixNano())
 			// Request to close websocket
 			case <-closeWS:
 				ws.Close()
@@ -430,20 +1002,35 @@ func (client *Client) maintainWS(initMsg request, writeMsg <-chan request, readM
 	ticker := time.NewTicker(pingInterval)
 	ping := []byte(`{"event":"ping"}`)
 
+	// Setup stale data check, independent of the heartbeat
+	var staleCheck <-chan time.Time
+	if checkStale {
+		staleTicker := time.NewTicker(time.Second)
+		defer staleTicker.Stop()
+		staleCheck = staleTicker.C
+	}
+
 	// Read from connection
 	go func() {
 		for {
-			(<-receiveWS).SetReadDeadline(time.Now().Add(pingInterval + 3*time.Second))
-			_, data, err := (<-receiveWS).ReadMessage()
+			conn := <-receiveWS
+			conn.SetReadDeadline(time.Now().Add(pingInterval + 3*time.Second))
+			_, data, err := conn.ReadMessage()
 			if err != nil {
+				// Skip if already replaced, as after a stale data reconnect
+				if conn != <-receiveWS {
+					continue
+				}
 				// Reconnect on error
-				log.Printf("%s WebSocket error: %s", client, err)
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
 				reconnectWS <- true
 			} else if string(data) != `{"event":"pong"}` {
+				atomic.StoreInt64(&lastData, time.Now().UnixNano())
 				// Send out if not a pong and a receiver is ready
 				var resp response
 				if err := json.Unmarshal(data, &resp); err != nil {
 					// Send response with error code on unmarshal errors
+					client.logger.Errorf("%s WebSocket error: %s", client, err)
 					resp = response{{ErrorCode: -2}}
 				}
 				select {
@@ -463,18 +1050,24 @@ func (client *Client) maintainWS(initMsg request, writeMsg <-chan request, readM
 			ticker.Stop()
 			closeWS <- true
 			return
+		case <-staleCheck:
+			// Reconnect if data has stopped while the connection is otherwise healthy
+			if staleFor := time.Since(time.Unix(0, atomic.LoadInt64(&lastData))); client.staleBook > 0 && staleFor > client.staleBook {
+				client.logger.Warnf("%s WebSocket stale: no data for %s, reconnecting", client, staleFor)
+				reconnectWS <- true
+			}
 		case <-ticker.C:
 			// Send ping (true type-9 pings not supported by server)
 			if err := (<-receiveWS).WriteMessage(1, ping); err != nil {
 				// Reconnect on error
-				log.Printf("%s WebSocket error: %s", client, err)
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
 				reconnectWS <- true
 			}
 		case msg := <-writeMsg:
 			// Write received message to WebSocket
 			if err := (<-receiveWS).WriteJSON(msg); err != nil {
 				// Notify sender and reconnect on error
-				log.Printf("%s WebSocket error: %s", client, err)
+				client.logger.Warnf("%s WebSocket error: %s", client, err)
 				readMsg <- response{{ErrorCode: -1}}
 				reconnectWS <- true
 			}
@@ -506,21 +1099,28 @@ func (client *Client) newWS(initMsg request) (*websocket.Conn, error) {
 		return nil, err
 	}
 
-	log.Println("Successful Connect")
+	client.logger.Debugf("%s WebSocket connected", client)
 	return ws, nil
 }
 
 // Connect WebSocket with repeated tries on failure
 func (client *Client) persistentNewWS(initMsg request) *websocket.Conn {
-	// Try connecting
-	ws, err := client.newWS(initMsg)
+	return client.redial(func() (*websocket.Conn, error) { return client.newWS(initMsg) }, exchange.SleepOn(client.clock))
+}
 
-	// Keep trying on error
-	for err != nil {
-		log.Printf("%s WebSocket error: %s", client, err)
-		time.Sleep(1 * time.Second)
-		ws, err = client.newWS(initMsg)
-	}
+// Call dial until it connects, waiting with increasing delay between tries
+// sleep waits out each delay
+func (client *Client) redial(dial func() (*websocket.Conn, error), sleep func(time.Duration)) *websocket.Conn {
+	var ws *websocket.Conn
+
+	// Keep trying on error with increasing delay
+	backoff := exchange.Backoff{Min: time.Second, Max: client.maxBackoff, Sleep: sleep}
+	backoff.Retry(func() (err error) {
+		ws, err = dial()
+		return err
+	}, func(err error) {
+		client.logger.Warnf("%s WebSocket error: %s", client, err)
+	})
 
 	return ws
 }
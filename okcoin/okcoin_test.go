@@ -2,16 +2,48 @@ package okcoin
 
 import (
 	"bitfx/exchange"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
 var (
 	book   exchange.Book
-	client = New(os.Getenv("OKUSD_KEY"), os.Getenv("OKUSD_SECRET"), "ltc", "usd", 1, 0.002, 2, .1)
+	client = mustNew(New(os.Getenv("OKUSD_KEY"), os.Getenv("OKUSD_SECRET"), "ltc", "usd", 1, 0.002, 2, .1))
 )
 
+// Returns the client, panicking on constructor errors
+func mustNew(client *Client, err error) *Client {
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// Test constructor argument errors
+func TestNew(t *testing.T) {
+	if _, err := New("", "", "ltc", "eur", 1, 0, 0, 0); err == nil || err.Error() != "OKCoin(eur) New error: currency must be USD or CNY" {
+		t.Fatalf("Expected currency error, got %v", err)
+	}
+	if _, err := New("key", "", "ltc", "usd", 1, 0, 0, 0); err == nil {
+		t.Fatal("Expected error for incomplete credentials")
+	}
+	if _, err := New("", "", "ltc", "usd", 1, 0, 0, 0, WithURLs("https://www.example.com/api/v1", "https://ws.example.com")); err == nil {
+		t.Fatal("Expected error for WebSocket URL with an HTTP scheme")
+	}
+}
+
 // Used for float equality
 func notEqual(f1, f2 float64) bool {
 	if math.Abs(f1-f2) > 0.000001 {
@@ -20,6 +52,13 @@ func notEqual(f1, f2 float64) bool {
 	return false
 }
 
+func TestRoundOrder(t *testing.T) {
+	amount, price := client.RoundOrder(0.12345, 1.6391)
+	if amount != 0.123 || price != 1.64 {
+		t.Fatalf("Expected 0.123 at 1.64, got %v at %v", amount, price)
+	}
+}
+
 func TestPriority(t *testing.T) {
 	if client.Priority() != 1 {
 		t.Fatal("Priority should be 1")
@@ -27,8 +66,9 @@ func TestPriority(t *testing.T) {
 }
 
 func TestFee(t *testing.T) {
-	if notEqual(client.Fee(), 0.002) {
-		t.Fatal("Fee should be 0.002")
+	// Both fees default to the fee supplied to New
+	if notEqual(client.TakerFee(), 0.002) || notEqual(client.MakerFee(), 0.002) {
+		t.Fatal("Maker and taker fees should be 0.002")
 	}
 }
 
@@ -76,9 +116,161 @@ func TestAvailShort(t *testing.T) {
 	}
 }
 
-func TestHasCryptoFee(t *testing.T) {
-	if !client.HasCryptoFee() {
-		t.Fatal("Should have cryptocurrency fee")
+func TestFeeAdjustedFill(t *testing.T) {
+	// Buys pay the fee in cryptocurrency
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2*(1-0.002)) {
+		t.Fatal("Buy fill should be net of the fee")
+	}
+	if notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Sell fill should be unchanged")
+	}
+}
+
+func TestConvertToBookShort(t *testing.T) {
+	data := []byte(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.64,10],[1.63,20]],"asks":[[1.65,5]],"timestamp":"1427811013000"}}]`)
+	var resp response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	book := client.convertToBook(resp)
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(book.Bids) != 2 || len(book.Asks) != 1 {
+		t.Fatal("Should have returned only the available items")
+	}
+}
+
+func TestConvertToBookThin(t *testing.T) {
+	data := []byte(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.64,10],[1.62,20],[1.63,5]],"asks":[[1.66,5],[1.65,8],[1.67,2]],"timestamp":"1427811013000"}}]`)
+	var resp response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	book := client.convertToBook(resp)
+	if book.Error != nil {
+		t.Fatal(book.Error)
+	}
+	if len(book.Bids) != 3 || len(book.Asks) != 3 {
+		t.Fatal("Expected 3 book entries")
+	}
+	if notEqual(book.Bids[0].Price, 1.64) || notEqual(book.Bids[2].Price, 1.62) {
+		t.Fatal("Bids not sorted properly")
+	}
+	if notEqual(book.Asks[0].Price, 1.65) || notEqual(book.Asks[2].Price, 1.67) {
+		t.Fatal("Asks not sorted properly")
+	}
+
+	// Zero levels should return an error
+	data = []byte(`[{"channel":"ok_ltcusd_depth","data":{"bids":[],"asks":[],"timestamp":"1427811013000"}}]`)
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if book = client.convertToBook(resp); book.Error == nil {
+		t.Fatal("Expected error on empty book")
+	}
+}
+
+func TestInconsistentBook(t *testing.T) {
+	// Client without connections, fed messages directly
+	client := &Client{symbol: "ltc", currency: "usd", name: "OKCoin(usd)", depth: 20, logger: client.logger,
+		readBookMsg: make(chan response), writeBookMsg: make(chan request, 2)}
+	bookChan := make(chan exchange.Book)
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	go client.runBookLoop(bookChan, doneChan)
+
+	feed := func(data string) {
+		var resp response
+		if err := json.Unmarshal([]byte(data), &resp); err != nil {
+			t.Fatal(err)
+		}
+		client.readBookMsg <- resp
+	}
+
+	// Crossed book is dropped and the depth channel resubscribed
+	feed(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.66,10]],"asks":[[1.65,5]],"timestamp":"1427811013000"}}]`)
+	for _, event := range []string{"removeChannel", "addChannel"} {
+		if msg := <-client.writeBookMsg; msg.Event != event || msg.Channel != "ok_ltcusd_depth" {
+			t.Fatalf("Expected %s request, got %v", event, msg)
+		}
+	}
+
+	// As is a book with a zero amount
+	feed(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.64,0]],"asks":[[1.65,5]],"timestamp":"1427811013000"}}]`)
+	if msg := <-client.writeBookMsg; msg.Event != "removeChannel" {
+		t.Fatalf("Expected removeChannel request, got %v", msg)
+	}
+	<-client.writeBookMsg
+
+	// A consistent book is the first sent out
+	feed(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.64,10]],"asks":[[1.65,5]],"timestamp":"1427811013000"}}]`)
+	if book := <-bookChan; book.Error != nil || notEqual(book.Bids[0].Price, 1.64) {
+		t.Fatalf("Expected the consistent book, got %v", book)
+	}
+	if !client.Connected() {
+		t.Fatal("Should be connected after a consistent book")
+	}
+}
+
+func TestConvertToTicker(t *testing.T) {
+	data := []byte(`[{"channel":"ok_ltcusd_ticker","data":{"buy":1.640,"high":1.71,"last":"1.645","low":1.62,"sell":1.649,"timestamp":1427811013000,"vol":"41,279.26"}}]`)
+	var resp response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	ticker, err := client.convertToTicker(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notEqual(ticker.Bid, 1.640) || notEqual(ticker.Ask, 1.649) || notEqual(ticker.Last, 1.645) {
+		t.Fatal("Wrong ticker prices")
+	}
+	if notEqual(ticker.Volume, 41279.26) {
+		t.Fatal("Wrong ticker volume")
+	}
+	if ticker.Time.Unix() != 1427811013 {
+		t.Fatal("Wrong ticker time")
+	}
+}
+
+func TestOrderParams(t *testing.T) {
+	// Limit orders carry price and amount
+	params, err := client.orderParams("buy", "limit", 2, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["type"] != "buy" || params["price"] != "1.500000" || params["amount"] != "2.000000" {
+		t.Fatalf("Wrong limit params: %v", params)
+	}
+	// Market buys carry the amount to spend as price
+	params, err = client.orderParams("buy", "market", 2, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params["amount"]; ok || params["type"] != "buy_market" || params["price"] != "3.000000" {
+		t.Fatalf("Wrong market buy params: %v", params)
+	}
+	// Market sells carry amount only
+	params, err = client.orderParams("sell", "market", 2, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params["price"]; ok || params["type"] != "sell_market" || params["amount"] != "2.000000" {
+		t.Fatalf("Wrong market sell params: %v", params)
+	}
+	// Unsupported combinations
+	if _, err = client.orderParams("buy", "market", 2, 0); err == nil {
+		t.Fatal("Expected error on market buy without price")
+	}
+	if _, err = client.orderParams("buy", "post_only", 2, 1.5); err == nil {
+		t.Fatal("Expected error on post-only order")
+	}
+	if _, err = client.orderParams("kill", "limit", 2, 1.5); err == nil {
+		t.Fatal("Expected error on bad action")
+	}
+	if _, err = client.orderParams("buy", "stop", 2, 1.5); err == nil {
+		t.Fatal("Expected error on bad order type")
 	}
 }
 
@@ -87,9 +279,211 @@ func TestHasCryptoFee(t *testing.T) {
 
 // USD tesing
 
+// Returns a client without connections whose order WebSocket answers with reply
+// The channel of each request is sent on channels
+func mockOrderClient(t *testing.T, reply string, channels chan<- string) *Client {
+	client := &Client{name: "OKCoin(usd)", key: "key", secret: "secret", symbol: "ltc", currency: "usd",
+		writeOrderMsg: make(chan request), readOrderMsg: make(chan response)}
+	go func() {
+		for req := range client.writeOrderMsg {
+			var resp response
+			if err := json.Unmarshal([]byte(reply), &resp); err != nil {
+				t.Error(err)
+			}
+			channels <- req.Channel
+			client.readOrderMsg <- resp
+		}
+	}()
+	return client
+}
+
+// Test reconnection delays with a failing dialer stub
+func TestRedialBackoff(t *testing.T) {
+	// Client without connections
+	client := &Client{name: "OKCoin(usd)", logger: client.logger, maxBackoff: 8 * time.Second}
+
+	// Fail 6 times then connect
+	var delays []time.Duration
+	dials := 0
+	client.redial(func() (*websocket.Conn, error) {
+		dials++
+		if dials <= 6 {
+			return nil, errors.New("dial error")
+		}
+		return nil, nil
+	}, func(d time.Duration) { delays = append(delays, d) })
+
+	// Each delay is within [base/2, base] for base = 1s, 2s, 4s, 8s, 8s, 8s
+	bases := []time.Duration{1, 2, 4, 8, 8, 8}
+	if dials != 7 || len(delays) != len(bases) {
+		t.Fatalf("Expected 7 dials and 6 delays, got %d dials and %v", dials, delays)
+	}
+	for i, delay := range delays {
+		base := bases[i] * time.Second
+		if delay < base/2 || delay > base {
+			t.Errorf("Delay %d of %s outside of [%s, %s]", i, delay, base/2, base)
+		}
+	}
+}
+
+// Test order responses with a mock order WebSocket
+func TestSendOrderMock(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		id          int64
+		err         string
+	}{
+		{"placed", `[{"channel":"ok_spotusd_trade","data":{"order_id":"125433","result":"true"}}]`, 125433, ""},
+		{"error code", `[{"channel":"ok_spotusd_trade","errorcode":"10009"}]`, 0, "OKCoin(usd) SendOrder error code 10009: Order does not exist"},
+		{"failed", `[{"channel":"ok_spotusd_trade","data":{"order_id":"0","result":"false"}}]`, 0, "OKCoin(usd) SendOrder failure"},
+		{"empty", `[]`, 0, "OKCoin(usd) SendOrder bad message"},
+	}
+	for _, test := range tests {
+		channels := make(chan string, 1)
+		client := mockOrderClient(t, test.reply, channels)
+		id, err := client.SendOrder(context.Background(), "buy", "limit", 1, 1.5)
+		close(client.writeOrderMsg)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+		if id != test.id {
+			t.Errorf("%s: expected ID %d, got %d", test.name, test.id, id)
+		}
+		if channel := <-channels; channel != "ok_spotusd_trade" {
+			t.Errorf("%s: sent on channel %s", test.name, channel)
+		}
+	}
+}
+
+// Test that a market sell is sent without a price
+func TestSendOrderMarket(t *testing.T) {
+	channels := make(chan string, 1)
+	client := mockOrderClient(t, `[{"channel":"ok_spotusd_trade","data":{"order_id":"125434","result":"true"}}]`, channels)
+	defer close(client.writeOrderMsg)
+	if id, err := client.SendOrder(context.Background(), "sell", "market", 1, 0); err != nil || id != 125434 {
+		t.Fatalf("Market sell with price 0 should be sent, got %d, %v", id, err)
+	}
+	<-channels
+}
+
+// Test that an order without a reply has an unknown outcome
+func TestSendOrderNoReply(t *testing.T) {
+	defer func(timeout time.Duration) { orderReadTimeout = timeout }(orderReadTimeout)
+	orderReadTimeout = 50 * time.Millisecond
+
+	// The order is written but never answered
+	client := &Client{name: "OKCoin(usd)", key: "key", secret: "secret", symbol: "ltc", currency: "usd",
+		writeOrderMsg: make(chan request), readOrderMsg: make(chan response)}
+	go func() {
+		for range client.writeOrderMsg {
+		}
+	}()
+	defer close(client.writeOrderMsg)
+
+	if _, err := client.SendOrder(context.Background(), "buy", "limit", 1, 1.5); !errors.Is(err, exchange.ErrUnknownOutcome) {
+		t.Errorf("Expected an unknown outcome after the read timeout, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.SendOrder(ctx, "buy", "limit", 1, 1.5); !errors.Is(err, exchange.ErrUnknownOutcome) {
+		t.Errorf("Expected an unknown outcome at the deadline, got %v", err)
+	}
+}
+
+// Test that invalid orders are rejected without a request
+func TestSendOrderInvalid(t *testing.T) {
+	channels := make(chan string, 1)
+	client := mockOrderClient(t, `[]`, channels)
+	defer close(client.writeOrderMsg)
+	for _, amount := range []float64{0, -1} {
+		if _, err := client.SendOrder(context.Background(), "buy", "limit", amount, 1.5); !errors.Is(err, exchange.ErrInvalidOrder) {
+			t.Errorf("Expected invalid order for amount %v, got %v", amount, err)
+		}
+	}
+	select {
+	case channel := <-channels:
+		t.Fatalf("Invalid order sent on %s", channel)
+	default:
+	}
+}
+
+func TestCancelOrderMock(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		success     bool
+		err         string
+	}{
+		{"cancelled", `[{"channel":"ok_spotusd_cancel_order","data":{"order_id":"125433","result":"true"}}]`, true, ""},
+		{"not cancelled", `[{"channel":"ok_spotusd_cancel_order","data":{"order_id":"125433","result":"false"}}]`, false, ""},
+		{"error code", `[{"channel":"ok_spotusd_cancel_order","errorcode":"10050"}]`, false, "OKCoin(usd) CancelOrder error code 10050: Order cannot be cancelled more than once"},
+		{"empty", `[]`, false, "OKCoin(usd) CancelOrder bad message"},
+	}
+	for _, test := range tests {
+		channels := make(chan string, 1)
+		client := mockOrderClient(t, test.reply, channels)
+		success, err := client.CancelOrder(context.Background(), 125433)
+		close(client.writeOrderMsg)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+		if success != test.success {
+			t.Errorf("%s: expected success %t, got %t", test.name, test.success, success)
+		}
+		if channel := <-channels; channel != "ok_spotusd_cancel_order" {
+			t.Errorf("%s: sent on channel %s", test.name, channel)
+		}
+	}
+}
+
+func TestGetOrderStatusMock(t *testing.T) {
+	// Status codes -1 cancelled, 0 unfilled, 1 partially filled, 2 filled, 4 cancel in process, 5 unknown
+	tests := []struct {
+		code   int
+		status string
+	}{
+		{-1, "dead"},
+		{0, "live"},
+		{1, "live"},
+		{2, "dead"},
+		{4, ""},
+		{5, ""},
+	}
+	for _, test := range tests {
+		channels := make(chan string, 1)
+		reply := fmt.Sprintf(`[{"channel":"ok_spotusd_order_info","data":{"orders":[{"status":%d,"deal_amount":-0.5}],"result":true}}]`, test.code)
+		client := mockOrderClient(t, reply, channels)
+		order, err := client.GetOrderStatus(context.Background(), 125433)
+		close(client.writeOrderMsg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Status != test.status || notEqual(order.FilledAmount, .5) {
+			t.Errorf("Status code %d: expected %q with .5 filled, got %q with %v filled", test.code, test.status, order.Status, order.FilledAmount)
+		}
+		if channel := <-channels; channel != "ok_spotusd_order_info" {
+			t.Errorf("Status code %d: sent on channel %s", test.code, channel)
+		}
+	}
+
+	// Errors
+	for reply, expected := range map[string]string{
+		`[{"channel":"ok_spotusd_order_info","errorcode":"10002"}]`:                "OKCoin(usd) GetOrderStatus error code 10002: System error",
+		`[{"channel":"ok_spotusd_order_info","data":{"orders":[],"result":true}}]`: "OKCoin(usd) GetOrderStatus no orders",
+		`[]`: "OKCoin(usd) GetOrderStatus bad message",
+	} {
+		client := mockOrderClient(t, reply, make(chan string, 1))
+		_, err := client.GetOrderStatus(context.Background(), 125433)
+		close(client.writeOrderMsg)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
+
 func TestCommunicateBookUSD(t *testing.T) {
 	bookChan := make(chan exchange.Book)
-	if book = client.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book = client.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		t.Fatal(book.Error)
 	}
 
@@ -114,14 +508,14 @@ func TestNewOrderUSD(t *testing.T) {
 	price := book.Bids[0].Price - 0.20
 
 	// Test submitting a new order
-	id, err := client.SendOrder(action, otype, amount, price)
+	id, err := client.SendOrder(context.Background(), action, otype, amount, price)
 	if err != nil || id == 0 {
 		t.Fatal(err)
 	}
 	t.Logf("Placed a new buy order of 0.1 ltc_usd @ %v limit with ID: %d", price, id)
 
 	// Check status
-	order, err := client.GetOrderStatus(id)
+	order, err := client.GetOrderStatus(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -135,7 +529,7 @@ func TestNewOrderUSD(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test cancelling the order
-	success, err := client.CancelOrder(id)
+	success, err := client.CancelOrder(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -148,7 +542,7 @@ func TestNewOrderUSD(t *testing.T) {
 	tryAgain := true
 	for tryAgain {
 		t.Logf("checking status...")
-		order, err = client.GetOrderStatus(id)
+		order, err = client.GetOrderStatus(context.Background(), id)
 		tryAgain = order.Status == ""
 	}
 	if err != nil {
@@ -164,7 +558,7 @@ func TestNewOrderUSD(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test bad order
-	id, err = client.SendOrder("kill", otype, amount, price)
+	id, err = client.SendOrder(context.Background(), "kill", otype, amount, price)
 	if id != 0 {
 		t.Fatal("Expected id = 0")
 	}
@@ -180,7 +574,7 @@ func TestNewOrderUSD(t *testing.T) {
 func TestCurrencyCodeCNY(t *testing.T) {
 	// Reset global variables
 	book = exchange.Book{}
-	client = New(os.Getenv("OKCNY_KEY"), os.Getenv("OKCNY_SECRET"), "ltc", "cny", 1, 0.002, 2, .1)
+	client = mustNew(New(os.Getenv("OKCNY_KEY"), os.Getenv("OKCNY_SECRET"), "ltc", "cny", 1, 0.002, 2, .1))
 
 	if client.CurrencyCode() != 1 {
 		t.Fatal("Currency code should be 1")
@@ -189,7 +583,8 @@ func TestCurrencyCodeCNY(t *testing.T) {
 
 func TestCommunicateBookCNY(t *testing.T) {
 	bookChan := make(chan exchange.Book)
-	if book = client.CommunicateBook(bookChan); book.Error != nil {
+	doneChan := make(chan bool, 1)
+	if book = client.CommunicateBook(bookChan, doneChan); book.Error != nil {
 		t.Fatal(book.Error)
 	}
 
@@ -214,14 +609,14 @@ func TestNewOrderCNY(t *testing.T) {
 	price := book.Bids[0].Price - 1
 
 	// Test submitting a new order
-	id, err := client.SendOrder(action, otype, amount, price)
+	id, err := client.SendOrder(context.Background(), action, otype, amount, price)
 	if err != nil || id == 0 {
 		t.Fatal(err)
 	}
 	t.Logf("Placed a new buy order of 0.1 ltc_cny @ %v limit with ID: %d", price, id)
 
 	// Check status
-	order, err := client.GetOrderStatus(id)
+	order, err := client.GetOrderStatus(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -235,7 +630,7 @@ func TestNewOrderCNY(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test cancelling the order
-	success, err := client.CancelOrder(id)
+	success, err := client.CancelOrder(context.Background(), id)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -248,7 +643,7 @@ func TestNewOrderCNY(t *testing.T) {
 	tryAgain := true
 	for tryAgain {
 		t.Logf("checking status...")
-		order, err = client.GetOrderStatus(id)
+		order, err = client.GetOrderStatus(context.Background(), id)
 		tryAgain = order.Status == ""
 	}
 	if err != nil {
@@ -264,7 +659,7 @@ func TestNewOrderCNY(t *testing.T) {
 	t.Logf("Order confirmed unfilled")
 
 	// Test bad order
-	id, err = client.SendOrder("kill", otype, amount, price)
+	id, err = client.SendOrder(context.Background(), "kill", otype, amount, price)
 	if id != 0 {
 		t.Fatal("Expected id = 0")
 	}
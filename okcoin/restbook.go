@@ -0,0 +1,61 @@
+// OKCoin REST book polling while the book WebSocket is down
+
+package okcoin
+
+import (
+	"bitfx/exchange"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WithRESTFallback sets how long without WebSocket book data before polling REST instead, zero to disable,
+// and the interval between polls, 10 and 2 seconds by default
+func WithRESTFallback(after, interval time.Duration) Option {
+	return func(client *Client) {
+		client.restFallback = after
+		client.restPoll = interval
+	}
+}
+
+// Get a book from the REST depth endpoint
+func (client *Client) restBook(ctx context.Context) exchange.Book {
+	values := url.Values{}
+	values.Set("symbol", fmt.Sprintf("%s_%s", client.symbol, client.currency))
+	values.Set("size", strconv.Itoa(client.depth))
+	req, err := http.NewRequest("GET", client.restURL+"/depth.do?"+values.Encode(), nil)
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s REST book error: %s", client, err)}
+	}
+	resp, err := client.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s REST book error: %w", client, exchange.ConnectionError(err))}
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s REST book error: %s", client, err)}
+	}
+	if err := exchange.StatusError(resp); err != nil {
+		return exchange.Book{Error: fmt.Errorf("%s REST book error: status %w", client, err)}
+	}
+	// Same format as the WebSocket depth data, with asks highest first
+	return client.parseBook(data)
+}
+
+// Poll a REST book and send it out, dropping inconsistent books
+// Returns false if doneChan receives a value first
+func (client *Client) pollRESTBook(bookChan chan<- exchange.Book, doneChan <-chan bool) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), client.restPoll)
+	defer cancel()
+	book, consistent := client.validBook(client.restBook(ctx))
+	if !consistent {
+		client.logger.Warnf("%s, dropped", book.Error)
+		return true
+	}
+	return client.sendBook(bookChan, doneChan, book)
+}
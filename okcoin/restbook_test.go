@@ -0,0 +1,102 @@
+package okcoin
+
+import (
+	"bitfx/exchange"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Mock depth.do endpoint, asks highest first as OKCoin sends them
+func depthServer(t *testing.T, requests *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		if r.URL.Path != "/depth.do" || r.URL.Query().Get("symbol") != "ltc_usd" || r.URL.Query().Get("size") != "20" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		fmt.Fprintln(w, `{"asks":[[1.67,3],[1.66,2],[1.65,5]],"bids":[[1.64,10],[1.63,4]]}`)
+	}))
+}
+
+func TestRESTBook(t *testing.T) {
+	var requests int64
+	server := depthServer(t, &requests)
+	defer server.Close()
+	client := &Client{name: "OKCoin(usd)", symbol: "ltc", currency: "usd", depth: 20, logger: client.logger,
+		restURL: server.URL, httpClient: &http.Client{}}
+
+	book := client.restBook(context.Background())
+	if err := book.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if book.Exg != client || len(book.Bids) != 2 || len(book.Asks) != 3 {
+		t.Fatalf("Wrong book levels: %v", book)
+	}
+	if notEqual(book.Bids[0].Price, 1.64) || notEqual(book.Asks[0].Price, 1.65) || notEqual(book.Asks[0].Amount, 5) {
+		t.Fatalf("Book should be sorted best first: %v", book)
+	}
+}
+
+// Test that REST books come from an overridden URL
+func TestRESTBookWithURLs(t *testing.T) {
+	var requests int64
+	server := depthServer(t, &requests)
+	defer server.Close()
+	// The book WebSocket is left retrying an unused local address
+	client, err := New("", "", "ltc", "usd", 1, 0, 0, 0, WithURLs(server.URL, "ws://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book := client.restBook(context.Background()); book.Error != nil || len(book.Bids) != 2 {
+		t.Fatalf("Expected book from overridden URL, got %v", book)
+	}
+	if atomic.LoadInt64(&requests) != 1 {
+		t.Fatalf("Expected 1 request to the mock server, got %d", requests)
+	}
+}
+
+func TestRESTFallback(t *testing.T) {
+	var requests int64
+	server := depthServer(t, &requests)
+	defer server.Close()
+	// Client without connections, fed WebSocket messages directly
+	client := &Client{name: "OKCoin(usd)", symbol: "ltc", currency: "usd", depth: 20, logger: client.logger,
+		restURL: server.URL, httpClient: &http.Client{}, restFallback: 100 * time.Millisecond, restPoll: 10 * time.Millisecond,
+		clock: exchange.SystemClock{}, readBookMsg: make(chan response), writeBookMsg: make(chan request, 2)}
+	bookChan := make(chan exchange.Book)
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	go client.runBookLoop(bookChan, doneChan)
+
+	// Without WebSocket data, books come from REST
+	for i := 0; i < 2; i++ {
+		if book := <-bookChan; book.Error != nil || notEqual(book.Bids[0].Price, 1.64) {
+			t.Fatalf("Expected a REST book, got %v", book)
+		}
+	}
+
+	// WebSocket data stops the polling
+	var resp response
+	if err := json.Unmarshal([]byte(`[{"channel":"ok_ltcusd_depth","data":{"bids":[[1.60,10]],"asks":[[1.61,5]],"timestamp":"1427811013000"}}]`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	go func() { client.readBookMsg <- resp }()
+	for book := range bookChan {
+		if notEqual(book.Bids[0].Price, 1.64) {
+			if notEqual(book.Bids[0].Price, 1.60) {
+				t.Fatalf("Expected the WebSocket book, got %v", book)
+			}
+			break
+		}
+	}
+	polled := atomic.LoadInt64(&requests)
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt64(&requests) != polled {
+		t.Fatal("REST polling should stop once WebSocket data resumes")
+	}
+}
@@ -0,0 +1,522 @@
+// Simulated exchange for paper trading and backtesting
+// Replays scripted book data and fills orders against it without network access
+
+package sim
+
+import (
+	"bitfx/exchange"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client contains all exchange information
+type Client struct {
+	symbol, currency, name                                       string
+	priority                                                     int
+	position, makerFee, takerFee, maxPos, availShort, availFunds float64
+	fiat, crypto                                                 float64 // Simulated balances
+	currencyCode                                                 exchange.Currency
+	books                                                        []exchange.Book
+	index                                                        int            // Current book
+	interval                                                     time.Duration  // Time between books
+	clock                                                        exchange.Clock // Paces and stamps books
+	orders                                                       map[int64]*order
+	lastID                                                       int64
+	mutex                                                        sync.Mutex
+	health                                                       exchange.Health // Books sent
+	done                                                         chan bool
+}
+
+// Simulated order
+type order struct {
+	action                string
+	amount, price, filled float64
+	live                  bool
+}
+
+// New returns a pointer to a Client instance replaying the supplied books
+// Simulated fiat balance starts at availFunds and crypto balance at availShort
+func New(name, symbol, currency string, priority int, fee, availShort, availFunds float64, books []exchange.Book) *Client {
+	// Unknown currencies get the USD code
+	currencyCode, _ := exchange.ParseCurrency(currency)
+
+	return &Client{
+		symbol:       symbol,
+		currency:     currency,
+		name:         fmt.Sprintf("Sim%s(%s)", name, currency),
+		priority:     priority,
+		makerFee:     fee,
+		takerFee:     fee,
+		availShort:   availShort,
+		availFunds:   availFunds,
+		fiat:         availFunds,
+		crypto:       availShort,
+		currencyCode: currencyCode,
+		books:        books,
+		interval:     time.Second,
+		clock:        exchange.SystemClock{},
+		orders:       make(map[int64]*order),
+		done:         make(chan bool, 1),
+	}
+}
+
+// ReadBooks reads book snapshots from CSV data
+// Each row is: unix time, "bid" or "ask", price, amount
+// Consecutive rows with the same time make up one book
+func ReadBooks(r io.Reader) ([]exchange.Book, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 4
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var books []exchange.Book
+	var lastTime int64
+	for i, record := range records {
+		unixTime, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		price, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		amount, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		// Start a new book on a new time
+		if len(books) == 0 || unixTime != lastTime {
+			books = append(books, exchange.Book{Time: time.Unix(unixTime, 0)})
+			lastTime = unixTime
+		}
+		book := &books[len(books)-1]
+		switch strings.ToLower(record[1]) {
+		case "bid":
+			book.Bids = append(book.Bids, exchange.BidItems{{Price: price, Amount: amount}}...)
+		case "ask":
+			book.Asks = append(book.Asks, exchange.AskItems{{Price: price, Amount: amount}}...)
+		default:
+			return nil, fmt.Errorf("line %d: side must be bid or ask", i+1)
+		}
+	}
+
+	for i := range books {
+		sort.Sort(books[i].Bids)
+		sort.Sort(books[i].Asks)
+	}
+
+	return books, nil
+}
+
+// SetInterval sets the time between books sent by CommunicateBook
+func (client *Client) SetInterval(interval time.Duration) {
+	client.interval = interval
+}
+
+// SetClock sets the clock books are paced and stamped by, call before CommunicateBook
+func (client *Client) SetClock(clock exchange.Clock) {
+	client.clock = clock
+}
+
+// Done closes all connections
+func (client *Client) Done() {
+	client.done <- true
+}
+
+// String implements the Stringer interface
+func (client *Client) String() string {
+	return client.name
+}
+
+// Priority returns the exchange priority for order execution
+func (client *Client) Priority() int {
+	return client.priority
+}
+
+// TakerFee returns the exchange fee for orders taking liquidity
+func (client *Client) TakerFee() float64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.takerFee
+}
+
+// MakerFee returns the exchange fee for resting orders filled by others
+func (client *Client) MakerFee() float64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.makerFee
+}
+
+// SetFees sets the exchange maker and taker fees, charged on later fills
+func (client *Client) SetFees(maker, taker float64) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.makerFee, client.takerFee = maker, taker
+}
+
+// SetPosition sets the exchange position
+func (client *Client) SetPosition(pos float64) {
+	client.position = pos
+}
+
+// Position returns the exchange position
+func (client *Client) Position() float64 {
+	return client.position
+}
+
+// Currency returns the exchange currency
+func (client *Client) Currency() string {
+	return client.currency
+}
+
+// CurrencyCode returns the exchange currency code
+func (client *Client) CurrencyCode() exchange.Currency {
+	return client.currencyCode
+}
+
+// SetMaxPos sets the exchange max position
+func (client *Client) SetMaxPos(maxPos float64) {
+	client.maxPos = maxPos
+}
+
+// MaxPos returns the exchange max position
+func (client *Client) MaxPos() float64 {
+	return client.maxPos
+}
+
+// AvailFunds returns the exchange available funds
+func (client *Client) AvailFunds() float64 {
+	return client.availFunds
+}
+
+// AvailShort returns the exchange quantity available for short selling
+func (client *Client) AvailShort() float64 {
+	return client.availShort
+}
+
+// Connected returns true if the last book sent had no error
+func (client *Client) Connected() bool {
+	return client.health.Connected()
+}
+
+// LastUpdate returns the time the last book was sent, zero if none has been
+func (client *Client) LastUpdate() time.Time {
+	return client.health.LastUpdate()
+}
+
+// SetAvailFunds sets the exchange available funds
+func (client *Client) SetAvailFunds(availFunds float64) {
+	client.availFunds = availFunds
+}
+
+// SetAvailShort sets the exchange quantity available for short selling
+func (client *Client) SetAvailShort(availShort float64) {
+	client.availShort = availShort
+}
+
+// Balances returns the simulated fiat and cryptocurrency balances
+func (client *Client) Balances() (float64, float64, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.fiat, client.crypto, nil
+}
+
+// ActualPosition returns the position implied by the cryptocurrency balance
+// A balance of AvailShort is a flat position
+func (client *Client) ActualPosition() (float64, error) {
+	_, crypto, err := client.Balances()
+	if err != nil {
+		return 0, err
+	}
+	return crypto - client.availShort, nil
+}
+
+// FeeAdjustedFill returns the cryptocurrency bought or sold in a fill, fees are charged in fiat
+func (client *Client) FeeAdjustedFill(action string, filled float64) float64 {
+	return filled
+}
+
+// CommunicateBook sends the latest available book data on the supplied channel
+// A new scripted book is sent every interval until the script is exhausted
+func (client *Client) CommunicateBook(bookChan chan<- exchange.Book, doneChan <-chan bool) exchange.Book {
+	client.mutex.Lock()
+	book := client.currentBook()
+	client.mutex.Unlock()
+	if book.Error != nil {
+		return book
+	}
+
+	// Run replay loop in new goroutine
+	go client.runLoop(bookChan, doneChan)
+
+	return book
+}
+
+// Replay loop
+func (client *Client) runLoop(bookChan chan<- exchange.Book, doneChan <-chan bool) {
+	ticker := client.clock.NewTicker(client.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-doneChan:
+			return
+		case <-client.done:
+			return
+		case <-ticker.Chan():
+			if !client.Advance() {
+				return
+			}
+			client.mutex.Lock()
+			book := client.currentBook()
+			client.mutex.Unlock()
+			select {
+			case bookChan <- book:
+				client.health.Update(book)
+			case <-doneChan:
+				return
+			}
+		}
+	}
+}
+
+// Advance moves to the next scripted book and fills any crossing live orders
+// Returns false if there are no more books
+func (client *Client) Advance() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if client.index+1 >= len(client.books) {
+		return false
+	}
+	client.index++
+	for _, o := range client.orders {
+		if o.live {
+			client.match(o, client.makerFee)
+		}
+	}
+
+	return true
+}
+
+// Return the current book stamped with the current time, must hold mutex
+func (client *Client) currentBook() exchange.Book {
+	if len(client.books) == 0 {
+		return exchange.Book{Error: fmt.Errorf("%s book error: no scripted books", client)}
+	}
+	book := client.books[client.index]
+	book.Exg = client
+	book.Time = client.clock.Now()
+	return book
+}
+
+// Returns true if an order at price would fill against the current book, must hold mutex
+func (client *Client) crosses(action string, price float64) bool {
+	book := client.books[client.index]
+	if action == "buy" {
+		return len(book.Asks) > 0 && book.Asks[0].Price <= price
+	}
+	return len(book.Bids) > 0 && book.Bids[0].Price >= price
+}
+
+// Fill an order against the current book charging fee, must hold mutex
+// Orders take at the taker fee when sent and fill at the maker fee once resting
+// Scripted book amounts are not depleted by fills
+func (client *Client) match(o *order, fee float64) {
+	book := client.books[client.index]
+	remaining := o.amount - o.filled
+	if o.action == "buy" {
+		for _, ask := range book.Asks {
+			if remaining <= 0 || ask.Price > o.price {
+				break
+			}
+			client.fill(o, ask.Price, math.Min(remaining, ask.Amount), fee)
+			remaining = o.amount - o.filled
+		}
+	} else {
+		for _, bid := range book.Bids {
+			if remaining <= 0 || bid.Price < o.price {
+				break
+			}
+			client.fill(o, bid.Price, math.Min(remaining, bid.Amount), fee)
+			remaining = o.amount - o.filled
+		}
+	}
+	if o.amount-o.filled < .00000001 {
+		o.live = false
+	}
+}
+
+// Record a fill and update balances, must hold mutex
+// Fees are charged in fiat
+func (client *Client) fill(o *order, price, amount, fee float64) {
+	o.filled += amount
+	if o.action == "buy" {
+		client.crypto += amount
+		client.fiat -= price * amount * (1 + fee)
+	} else {
+		client.crypto -= amount
+		client.fiat += price * amount * (1 - fee)
+	}
+}
+
+// Ticker returns ticker data from the current book
+func (client *Client) Ticker() (exchange.Ticker, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	book := client.currentBook()
+	if book.Error != nil {
+		return exchange.Ticker{}, book.Error
+	}
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return exchange.Ticker{}, fmt.Errorf("%s Ticker error: empty book", client)
+	}
+
+	return exchange.Ticker{
+		Last: (book.Bids[0].Price + book.Asks[0].Price) / 2,
+		Bid:  book.Bids[0].Price,
+		Ask:  book.Asks[0].Price,
+		Time: book.Time,
+	}, nil
+}
+
+// MinOrderSize returns zero, the simulated exchange accepts any amount
+func (client *Client) MinOrderSize() float64 {
+	return 0
+}
+
+// RoundOrder returns the order unchanged, the simulated exchange takes any precision
+func (client *Client) RoundOrder(amount, price float64) (float64, float64) {
+	return amount, price
+}
+
+// PriceStep returns zero, the simulated exchange takes any price
+func (client *Client) PriceStep(price float64) float64 {
+	return 0
+}
+
+// SupportsMarket returns true, market orders take the simulated book
+func (client *Client) SupportsMarket() bool {
+	return true
+}
+
+// SupportsPostOnly returns true, resting orders are simulated
+func (client *Client) SupportsPostOnly() bool {
+	return true
+}
+
+// SendOrder sends an order to the simulated exchange
+// Orders fill immediately against the current book where prices cross
+// Unfilled limit order amounts rest until cancelled or crossed by a later book
+// Post-only orders that would cross the current book are rejected
+func (client *Client) SendOrder(ctx context.Context, action, otype string, amount, price float64) (int64, error) {
+	if action != "buy" && action != "sell" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"buy\" and \"sell\" actions supported", client)
+	}
+	if otype != "limit" && otype != "market" && otype != "post_only" {
+		return 0, fmt.Errorf("%s SendOrder error: only \"limit\", \"market\", and \"post_only\" order types supported", client)
+	}
+	if err := exchange.CheckOrder(otype, amount, price); err != nil {
+		return 0, fmt.Errorf("%s SendOrder error: %w", client, err)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	if len(client.books) == 0 {
+		return 0, fmt.Errorf("%s SendOrder error: no scripted books", client)
+	}
+
+	// Post-only orders must not take liquidity
+	if otype == "post_only" && client.crosses(action, price) {
+		return 0, fmt.Errorf("%s SendOrder error: post-only %s at %v would take liquidity", client, action, price)
+	}
+
+	// Market orders take any price and never rest
+	if otype == "market" {
+		if action == "buy" {
+			price = math.MaxFloat64
+		} else {
+			price = 0
+		}
+	}
+
+	client.lastID++
+	o := &order{action: action, amount: amount, price: price, live: true}
+	client.orders[client.lastID] = o
+	client.match(o, client.takerFee)
+	if otype == "market" {
+		o.live = false
+	}
+
+	return client.lastID, nil
+}
+
+// CancelOrder cancels an order on the simulated exchange
+func (client *Client) CancelOrder(ctx context.Context, id int64) (bool, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	o, ok := client.orders[id]
+	if !ok {
+		return false, fmt.Errorf("%s CancelOrder error: unknown order %d", client, id)
+	}
+	o.live = false
+
+	return true, nil
+}
+
+// GetOrderStatus gets the status of an order on the simulated exchange
+// Dead orders are forgotten once reported, so later requests for them fail
+func (client *Client) GetOrderStatus(ctx context.Context, id int64) (exchange.Order, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	o, ok := client.orders[id]
+	if !ok {
+		return exchange.Order{}, fmt.Errorf("%s GetOrderStatus error: unknown order %d", client, id)
+	}
+	status := "dead"
+	if o.live {
+		status = "live"
+	} else {
+		delete(client.orders, id)
+	}
+
+	return exchange.Order{FilledAmount: o.filled, Status: status}, nil
+}
+
+// OpenOrders returns all live orders on the simulated exchange
+func (client *Client) OpenOrders(ctx context.Context) ([]exchange.Order, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	var orders []exchange.Order
+	for id, o := range client.orders {
+		if o.live {
+			orders = append(orders, exchange.Order{
+				ID:           id,
+				Side:         o.action,
+				Price:        o.price,
+				Amount:       o.amount,
+				FilledAmount: o.filled,
+				Status:       "live",
+			})
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+
+	return orders, nil
+}
@@ -0,0 +1,202 @@
+package sim
+
+import (
+	"bitfx/exchange"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Compile-time check that Client implements exchange.Interface
+var _ exchange.Interface = (*Client)(nil)
+
+// Used for float equality
+func notEqual(f1, f2 float64) bool {
+	if math.Abs(f1-f2) > 0.000001 {
+		return true
+	}
+	return false
+}
+
+const testCSV = `1427811000,bid,99,5
+1427811000,ask,101,5
+1427811000,bid,98,10
+1427811001,bid,99,5
+1427811001,ask,100,0.5
+1427811001,ask,100.5,5
+1427811002,bid,103,5
+1427811002,ask,104,5
+`
+
+func TestReadBooks(t *testing.T) {
+	books, err := ReadBooks(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 3 {
+		t.Fatal("Expected 3 books")
+	}
+	if len(books[0].Bids) != 2 || notEqual(books[0].Bids[0].Price, 99) {
+		t.Fatal("Bids not read and sorted properly")
+	}
+	if len(books[1].Asks) != 2 || notEqual(books[1].Asks[0].Price, 100) {
+		t.Fatal("Asks not read and sorted properly")
+	}
+	if _, err := ReadBooks(strings.NewReader("1427811000,mid,99,5\n")); err == nil {
+		t.Fatal("Expected error on bad side")
+	}
+}
+
+// Test replaying books paced and stamped by a manual clock
+func TestCommunicateBookClock(t *testing.T) {
+	books, err := ReadBooks(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New("Test", "btc", "usd", 1, 0, 10, 1000, books)
+	clock := exchange.NewManualClock(time.Unix(1427811000, 0))
+	client.SetClock(clock)
+	bookChan := make(chan exchange.Book)
+	doneChan := make(chan bool)
+	defer close(doneChan)
+	if book := client.CommunicateBook(bookChan, doneChan); !book.Time.Equal(clock.Now()) {
+		t.Fatalf("Expected the initial book at %s, got %s", clock.Now(), book.Time)
+	}
+
+	// The next book is sent once the clock passes the interval
+	select {
+	case <-bookChan:
+		t.Fatal("No book should be sent before the interval")
+	case <-time.After(10 * time.Millisecond):
+	}
+	now := clock.Advance(time.Second)
+	if book := <-bookChan; !book.Time.Equal(now) || notEqual(book.Bids[0].Price, 99) || notEqual(book.Asks[0].Price, 100) {
+		t.Fatalf("Expected the second book at %s, got %v", now, book)
+	}
+}
+
+func TestTradingPL(t *testing.T) {
+	books, err := ReadBooks(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New("Test", "btc", "usd", 1, 0.001, 0, 1000, books)
+
+	// Buy below the market rests without a fill
+	id, err := client.SendOrder(context.Background(), "buy", "limit", 1, 100.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := client.GetOrderStatus(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != "live" || notEqual(order.FilledAmount, 0) {
+		t.Fatal("Order should be live and unfilled")
+	}
+
+	// Next book crosses the limit price over two levels
+	if !client.Advance() {
+		t.Fatal("Should advance to second book")
+	}
+	order, _ = client.GetOrderStatus(context.Background(), id)
+	if order.Status != "dead" || notEqual(order.FilledAmount, 1) {
+		t.Fatal("Order should be filled")
+	}
+
+	// Sell rests until the bid rises
+	id, err = client.SendOrder(context.Background(), "sell", "limit", 1, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !client.Advance() {
+		t.Fatal("Should advance to third book")
+	}
+	order, _ = client.GetOrderStatus(context.Background(), id)
+	if order.Status != "dead" || notEqual(order.FilledAmount, 1) {
+		t.Fatal("Order should be filled")
+	}
+	if client.Advance() {
+		t.Fatal("Should be no more books")
+	}
+
+	// Bought 0.5 at 100 and 0.5 at 100.5, sold 1 at 103
+	fiat, crypto, _ := client.Balances()
+	pl := -(0.5*100+0.5*100.5)*(1+0.001) + 103*(1-0.001)
+	if notEqual(fiat-1000, pl) {
+		t.Fatalf("Expected P&L of %f, got %f", pl, fiat-1000)
+	}
+	if notEqual(crypto, 0) {
+		t.Fatal("Should be flat")
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	books, _ := ReadBooks(strings.NewReader(testCSV))
+	client := New("Test", "btc", "usd", 1, 0, 0, 1000, books)
+	id, _ := client.SendOrder(context.Background(), "buy", "limit", 1, 95)
+	if success, err := client.CancelOrder(context.Background(), id); !success || err != nil {
+		t.Fatal("Order should be cancelled")
+	}
+	client.Advance()
+	client.Advance()
+	if order, _ := client.GetOrderStatus(context.Background(), id); order.Status != "dead" || notEqual(order.FilledAmount, 0) {
+		t.Fatal("Cancelled order should not fill")
+	}
+	// Forgotten once reported dead
+	if _, err := client.GetOrderStatus(context.Background(), id); err == nil || len(client.orders) != 0 {
+		t.Fatal("Dead order should be forgotten")
+	}
+}
+
+func TestOpenOrders(t *testing.T) {
+	books, _ := ReadBooks(strings.NewReader(testCSV))
+	client := New("Test", "btc", "usd", 1, 0, 0, 1000, books)
+	id1, _ := client.SendOrder(context.Background(), "buy", "limit", 1, 95)
+	id2, _ := client.SendOrder(context.Background(), "buy", "limit", 1, 94)
+	client.CancelOrder(context.Background(), id1)
+	orders, err := client.OpenOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != id2 {
+		t.Fatalf("Expected only order %d open, got %v", id2, orders)
+	}
+	if orders[0].Side != "buy" || notEqual(orders[0].Price, 94) || notEqual(orders[0].Amount, 1) {
+		t.Fatal("Wrong open order details")
+	}
+}
+
+func TestPostOnly(t *testing.T) {
+	books, _ := ReadBooks(strings.NewReader(testCSV))
+	client := New("Test", "btc", "usd", 1, .002, 0, 1000, books)
+	client.SetFees(.001, .002)
+	if _, err := client.SendOrder(context.Background(), "buy", "post_only", 1, 101); err == nil {
+		t.Fatal("Crossing post-only order should be rejected")
+	}
+	// Rests below the ask and fills as the market comes down
+	id, err := client.SendOrder(context.Background(), "buy", "post_only", 1, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, _ := client.GetOrderStatus(context.Background(), id); order.Status != "live" || notEqual(order.FilledAmount, 0) {
+		t.Fatal("Post-only order should rest unfilled")
+	}
+	client.Advance()
+	if order, _ := client.GetOrderStatus(context.Background(), id); notEqual(order.FilledAmount, .5) {
+		t.Fatalf("Expected .5 filled, got %v", order.FilledAmount)
+	}
+	// Resting fills are charged the maker fee
+	if fiat, _, _ := client.Balances(); notEqual(fiat, 1000-.5*100*1.001) {
+		t.Fatalf("Expected maker fee charged, got fiat of %v", fiat)
+	}
+}
+
+func TestFeeAdjustedFill(t *testing.T) {
+	client := New("Test", "btc", "usd", 1, 0.002, 0, 1000, nil)
+	if notEqual(client.FeeAdjustedFill("buy", 2), 2) || notEqual(client.FeeAdjustedFill("sell", 2), 2) {
+		t.Fatal("Fees in fiat should leave fills unchanged")
+	}
+}
@@ -0,0 +1,26 @@
+// Terminal output for the trading display and book testers
+// Output redirected to a file or a process supervisor is left free of screen control
+
+package terminal
+
+import (
+	"os"
+	"os/exec"
+)
+
+// IsTerminal reports whether f is a terminal rather than a file, pipe, or socket
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Clear clears the terminal on stdout between prints
+// Does nothing if stdout isn't a terminal, so redirected prints are appended
+func Clear() {
+	if !IsTerminal(os.Stdout) {
+		return
+	}
+	c := exec.Command("clear")
+	c.Stdout = os.Stdout
+	c.Run()
+}